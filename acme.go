@@ -0,0 +1,569 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	MsgTypeSetACMEChallenge = "set_acme_challenge"
+	MsgTypeACMEChallengeSet = "acme_challenge_set"
+)
+
+// CapACMEChallenge is advertised in tunnel_opened by servers that publish
+// DNS-01 challenge records for tunnel hostnames.
+const CapACMEChallenge = "acme_dns01"
+
+const (
+	LetsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+	acmeRenewBefore = 30 * 24 * time.Hour
+	acmeTimeout     = 5 * time.Minute
+)
+
+// acmePollInterval is how often pending authorizations and orders are
+// re-fetched.
+var acmePollInterval = 2 * time.Second
+
+var errNoCertificate = errors.New("acme: no certificate obtained yet")
+
+// ACME configures certificates for TLS termination obtained from an ACME
+// CA with the DNS-01 challenge.
+type ACME struct {
+	DirectoryURL string // LetsEncryptDirectory if empty
+	Email        string
+	CacheDir     string       // keeps the account key and certificates across runs if set
+	DNS          DNSProvider  // publishes _acme-challenge records; the tunnel server if nil
+	HTTPClient   *http.Client // http.DefaultClient if nil
+}
+
+// WithACME obtains a certificate for the tunnel hostname once the tunnel
+// opens, renewing it on later opens within 30 days of expiry, and serves it
+// from WithTLSTermination (which it turns on if needed). Without a DNS
+// provider the challenge record is set by the server, which requires
+// CapACMEChallenge.
+func WithACME(a ACME) Option {
+	return func(c *Client) {
+		c.config.ACME = &a
+	}
+}
+
+type acmeState struct {
+	mu   sync.Mutex
+	cert *tls.Certificate
+	busy bool
+}
+
+func (c *Client) acmeCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.acme.mu.Lock()
+	defer c.acme.mu.Unlock()
+	if c.acme.cert == nil {
+		return nil, errNoCertificate
+	}
+	return c.acme.cert, nil
+}
+
+// ensureCertificate loads or obtains a certificate for the hostname of
+// publicURL unless the current one is still fresh.
+func (c *Client) ensureCertificate(publicURL string, connDone <-chan struct{}) {
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Hostname() == "" {
+		c.logf("ACME: no hostname in %q", publicURL)
+		return
+	}
+	host := u.Hostname()
+
+	c.acme.mu.Lock()
+	if c.acme.busy || certFresh(c.acme.cert, host) {
+		c.acme.mu.Unlock()
+		return
+	}
+	c.acme.busy = true
+	c.acme.mu.Unlock()
+	defer func() {
+		c.acme.mu.Lock()
+		c.acme.busy = false
+		c.acme.mu.Unlock()
+	}()
+
+	a := c.conf().ACME
+	cert, err := loadCachedCert(a.CacheDir, host)
+	if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), acmeTimeout)
+		defer cancel()
+		go func() {
+			select {
+			case <-connDone:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		var pemData []byte
+		if pemData, err = c.obtainCertificate(ctx, host); err != nil {
+			c.logf("ACME: failed to obtain certificate for %s: %v", host, err)
+			return
+		}
+		if cert, err = parseCertPEM(pemData); err != nil {
+			c.logf("ACME: %v", err)
+			return
+		}
+		if a.CacheDir != "" {
+			if err := os.WriteFile(filepath.Join(a.CacheDir, host+".pem"), pemData, 0o600); err != nil {
+				c.logf("ACME: failed to cache certificate: %v", err)
+			}
+		}
+		c.logf("ACME: obtained certificate for %s", host)
+	}
+
+	c.acme.mu.Lock()
+	c.acme.cert = cert
+	c.acme.mu.Unlock()
+}
+
+func certFresh(cert *tls.Certificate, host string) bool {
+	return cert != nil && cert.Leaf != nil && cert.Leaf.VerifyHostname(host) == nil &&
+		time.Until(cert.Leaf.NotAfter) > acmeRenewBefore
+}
+
+func loadCachedCert(dir, host string) (*tls.Certificate, error) {
+	if dir == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(dir, host+".pem"))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseCertPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	if !certFresh(cert, host) {
+		return nil, errors.New("cached certificate is due for renewal")
+	}
+	return cert, nil
+}
+
+// parseCertPEM reads a chain and its private key from one PEM file.
+func parseCertPEM(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// obtainCertificate runs an ACME order for host and returns the issued
+// chain followed by its private key, PEM-encoded.
+func (c *Client) obtainCertificate(ctx context.Context, host string) ([]byte, error) {
+	a := c.conf().ACME
+	dns := a.DNS
+	if dns == nil {
+		c.mu.Lock()
+		supported := c.hasCapability(CapACMEChallenge)
+		c.mu.Unlock()
+		if !supported {
+			return nil, errors.New("server can't publish DNS-01 challenges; set ACME.DNS")
+		}
+		dns = tunnelDNS{c}
+	}
+
+	key, err := acmeAccountKey(a.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	ac := &acmeClient{http: httpClientOr(a.HTTPClient), key: key}
+	dir := a.DirectoryURL
+	if dir == "" {
+		dir = LetsEncryptDirectory
+	}
+	if err := ac.discover(ctx, dir); err != nil {
+		return nil, err
+	}
+	if err := ac.register(ctx, a.Email); err != nil {
+		return nil, err
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ac.order(ctx, host, certKey, dns)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...), nil
+}
+
+func acmeAccountKey(dir string) (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(dir, "acme-account.pem")
+	if dir != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			if block, _ := pem.Decode(data); block != nil {
+				return x509.ParseECPrivateKey(block.Bytes)
+			}
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// tunnelDNS publishes challenge records through the tunnel server.
+type tunnelDNS struct{ c *Client }
+
+type setACMEChallenge struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId"`
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+}
+
+func (d tunnelDNS) UpsertRecord(ctx context.Context, r DNSRecord) error {
+	id := newRequestID()
+	data, err := d.c.roundTrip(ctx, id, setACMEChallenge{Type: MsgTypeSetACMEChallenge, RequestID: id, Name: r.Name, Value: r.Value})
+	if err != nil {
+		return err
+	}
+	var reply struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(data, &reply)
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return nil
+}
+
+// acmeClient speaks just enough RFC 8555 to order one certificate with
+// DNS-01.
+type acmeClient struct {
+	http  *http.Client
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+	dir   struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+}
+
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+func (p *acmeProblem) Error() string {
+	return "acme: " + p.Type + ": " + p.Detail
+}
+
+type acmeOrder struct {
+	Status         string       `json:"status"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *acmeProblem `json:"error"`
+}
+
+type acmeAuthz struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []struct {
+		Type  string `json:"type"`
+		URL   string `json:"url"`
+		Token string `json:"token"`
+	} `json:"challenges"`
+}
+
+func (ac *acmeClient) discover(ctx context.Context, dirURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dirURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ac.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme: directory returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(&ac.dir)
+}
+
+func (ac *acmeClient) register(ctx context.Context, email string) error {
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		payload["contact"] = []string{"mailto:" + email}
+	}
+	resp, _, err := ac.post(ctx, ac.dir.NewAccount, payload)
+	if err != nil {
+		return err
+	}
+	ac.kid = resp.Header.Get("Location")
+	if ac.kid == "" {
+		return errors.New("acme: account has no location")
+	}
+	return nil
+}
+
+func (ac *acmeClient) order(ctx context.Context, host string, certKey *ecdsa.PrivateKey, dns DNSProvider) ([]byte, error) {
+	var order acmeOrder
+	resp, body, err := ac.post(ctx, ac.dir.NewOrder, map[string]interface{}{
+		"identifiers": []map[string]string{{"type": "dns", "value": host}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &order); err != nil {
+		return nil, err
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range order.Authorizations {
+		if err := ac.authorize(ctx, authzURL, dns); err != nil {
+			return nil, err
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: []string{host},
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, body, err := ac.post(ctx, order.Finalize, map[string]string{"csr": b64(csr)}); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(body, &order); err != nil {
+		return nil, err
+	}
+	for order.Status != "valid" {
+		if order.Status == "invalid" {
+			if order.Error != nil {
+				return nil, order.Error
+			}
+			return nil, errors.New("acme: order failed")
+		}
+		if err := ac.poll(ctx, orderURL, &order); err != nil {
+			return nil, err
+		}
+	}
+
+	_, chain, err := ac.post(ctx, order.Certificate, nil)
+	return chain, err
+}
+
+func (ac *acmeClient) authorize(ctx context.Context, authzURL string, dns DNSProvider) error {
+	var authz acmeAuthz
+	if _, body, err := ac.post(ctx, authzURL, nil); err != nil {
+		return err
+	} else if err := json.Unmarshal(body, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	i := -1
+	for j, ch := range authz.Challenges {
+		if ch.Type == "dns-01" {
+			i = j
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("acme: no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+	ch := authz.Challenges[i]
+	digest := sha256.Sum256([]byte(ch.Token + "." + jwkThumbprint(&ac.key.PublicKey)))
+	record := DNSRecord{Type: "TXT", Name: "_acme-challenge." + authz.Identifier.Value, Value: b64(digest[:]), TTL: 60}
+	if err := dns.UpsertRecord(ctx, record); err != nil {
+		return fmt.Errorf("acme: publishing %s: %w", record.Name, err)
+	}
+	if _, _, err := ac.post(ctx, ch.URL, struct{}{}); err != nil {
+		return err
+	}
+
+	for authz.Status != "valid" {
+		if authz.Status == "invalid" {
+			return fmt.Errorf("acme: authorization for %s failed", authz.Identifier.Value)
+		}
+		if err := ac.poll(ctx, authzURL, &authz); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ac *acmeClient) poll(ctx context.Context, url string, v interface{}) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(acmePollInterval):
+	}
+	_, body, err := ac.post(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// post sends a JWS-signed request; a nil payload is a POST-as-GET. A
+// badNonce rejection is retried once with the fresh nonce.
+func (ac *acmeClient) post(ctx context.Context, url string, payload interface{}) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, body, err := ac.postOnce(ctx, url, payload)
+		var p *acmeProblem
+		if errors.As(err, &p) && p.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+			continue
+		}
+		return resp, body, err
+	}
+}
+
+func (ac *acmeClient) postOnce(ctx context.Context, url string, payload interface{}) (*http.Response, []byte, error) {
+	if ac.nonce == "" {
+		if err := ac.fetchNonce(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	protected := map[string]interface{}{"alg": "ES256", "nonce": ac.nonce, "url": url}
+	if ac.kid != "" {
+		protected["kid"] = ac.kid
+	} else {
+		protected["jwk"] = jwk(&ac.key.PublicKey)
+	}
+	ac.nonce = ""
+
+	var payloadB64 string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		payloadB64 = b64(data)
+	}
+	header, _ := json.Marshal(protected)
+	signingInput := b64(header) + "." + payloadB64
+	sig, err := signES256(ac.key, []byte(signingInput))
+	if err != nil {
+		return nil, nil, err
+	}
+	jws, _ := json.Marshal(map[string]string{"protected": b64(header), "payload": payloadB64, "signature": b64(sig)})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jws))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	resp, err := ac.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	ac.nonce = resp.Header.Get("Replay-Nonce")
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		p := &acmeProblem{}
+		if json.Unmarshal(body, p) != nil || p.Type == "" {
+			return nil, nil, fmt.Errorf("acme: %s returned %s", url, resp.Status)
+		}
+		return nil, nil, p
+	}
+	return resp, body, nil
+}
+
+func (ac *acmeClient) fetchNonce(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ac.dir.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ac.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if ac.nonce = resp.Header.Get("Replay-Nonce"); ac.nonce == "" {
+		return errors.New("acme: no nonce")
+	}
+	return nil
+}
+
+func signES256(key *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
+
+func jwk(pub *ecdsa.PublicKey) map[string]string {
+	x, y := make([]byte, 32), make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": b64(x), "y": b64(y)}
+}
+
+// jwkThumbprint is the RFC 7638 thumbprint; json.Marshal sorts map keys,
+// which gives the required member order.
+func jwkThumbprint(pub *ecdsa.PublicKey) string {
+	data, _ := json.Marshal(jwk(pub))
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
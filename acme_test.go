@@ -0,0 +1,223 @@
+package outray
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACME is a minimal ACME CA that checks JWS signatures and the DNS-01
+// record before issuing.
+type fakeACME struct {
+	t        *testing.T
+	srv      *httptest.Server
+	dns      *fakeDNSProvider
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+	mu       sync.Mutex
+	account  *ecdsa.PublicKey
+	host     string
+	authzOK  bool
+	certPEM  []byte
+	requests int
+}
+
+func newFakeACME(t *testing.T, dns *fakeDNSProvider) *fakeACME {
+	f := &fakeACME{t: t, dns: dns}
+	f.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(24 * time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &f.caKey.PublicKey, f.caKey)
+	f.caCert, _ = x509.ParseCertificate(der)
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeACME) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	base := f.srv.URL
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", f.requests))
+	if r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(map[string]string{"newNonce": base + "/nonce", "newAccount": base + "/account", "newOrder": base + "/order"})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload, ok := f.verify(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"type":"urn:ietf:params:acme:error:unauthorized","detail":"bad signature"}`)
+		return
+	}
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", base+"/acct/1")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"status":"valid"}`)
+	case "/order":
+		var req struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		json.Unmarshal(payload, &req)
+		f.host = req.Identifiers[0].Value
+		w.Header().Set("Location", base+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"status":"pending","authorizations":[%q],"finalize":%q}`, base+"/authz/1", base+"/finalize/1")
+	case "/authz/1":
+		status := "pending"
+		if f.authzOK {
+			status = "valid"
+		}
+		fmt.Fprintf(w, `{"status":%q,"identifier":{"type":"dns","value":%q},"challenges":[{"type":"http-01","url":"x","token":"t"},{"type":"dns-01","url":%q,"token":"tok"}]}`, status, f.host, base+"/chall/1")
+	case "/chall/1":
+		digest := sha256.Sum256([]byte("tok." + jwkThumbprint(f.account)))
+		want := DNSRecord{Type: "TXT", Name: "_acme-challenge." + f.host, Value: base64.RawURLEncoding.EncodeToString(digest[:]), TTL: 60}
+		f.dns.mu.Lock()
+		for _, rec := range f.dns.records {
+			f.authzOK = f.authzOK || rec == want
+		}
+		f.dns.mu.Unlock()
+		io.WriteString(w, `{"status":"processing"}`)
+	case "/finalize/1":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || !f.authzOK || len(csr.DNSNames) != 1 || csr.DNSNames[0] != f.host {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"type":"urn:ietf:params:acme:error:unauthorized","detail":"not authorized"}`)
+			return
+		}
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(2), DNSNames: csr.DNSNames, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(90 * 24 * time.Hour)}
+		cert, _ := x509.CreateCertificate(rand.Reader, tmpl, f.caCert, csr.PublicKey, f.caKey)
+		f.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+		io.WriteString(w, `{"status":"processing"}`)
+	case "/order/1":
+		fmt.Fprintf(w, `{"status":"valid","certificate":%q}`, base+"/cert/1")
+	case "/cert/1":
+		w.Write(f.certPEM)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// verify checks the JWS against the account key and returns its payload.
+func (f *fakeACME) verify(r *http.Request) ([]byte, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	json.Unmarshal(header, &protected)
+	if protected.Alg != "ES256" || protected.Nonce == "" || protected.URL != f.srv.URL+r.URL.Path {
+		return nil, false
+	}
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		f.account = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if protected.Kid != f.srv.URL+"/acct/1" {
+		return nil, false
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if f.account == nil || len(sig) != 64 || !ecdsa.Verify(f.account, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, true
+}
+
+func TestACMECertificate(t *testing.T) {
+	prev := acmePollInterval
+	acmePollInterval = 10 * time.Millisecond
+	defer func() { acmePollInterval = prev }()
+
+	dns := &fakeDNSProvider{}
+	ca := newFakeACME(t, dns)
+	cacheDir := t.TempDir()
+	acme := ACME{DirectoryURL: ca.srv.URL + "/dir", Email: "dev@example.com", CacheDir: cacheDir, DNS: dns}
+
+	c := NewClient(WithACME(acme))
+	c.closed = true
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://db.outray.dev"}`), 0)
+	c.Wait()
+
+	cert, err := c.acmeCertificate(nil)
+	if err != nil {
+		t.Fatalf("Expected a certificate, got %v", err)
+	}
+	if err := cert.Leaf.VerifyHostname("db.outray.dev"); err != nil {
+		t.Error(err)
+	}
+	if cfg := c.tlsTermination(); cfg == nil || cfg.GetCertificate == nil {
+		t.Error("Expected WithACME to turn on TLS termination")
+	}
+
+	// A second client reuses the cached certificate without a new order.
+	requests := ca.requests
+	c2 := NewClient(WithACME(acme))
+	c2.closed = true
+	c2.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://db.outray.dev"}`), 0)
+	c2.Wait()
+	if _, err := c2.acmeCertificate(nil); err != nil || ca.requests != requests {
+		t.Errorf("Expected cached certificate (err %v, %d new requests)", err, ca.requests-requests)
+	}
+}
+
+func TestACMEEndToEnd(t *testing.T) {
+	prev := acmePollInterval
+	acmePollInterval = 10 * time.Millisecond
+	defer func() { acmePollInterval = prev }()
+
+	dns := &fakeDNSProvider{}
+	ca := newFakeACME(t, dns)
+	upstream := ackServer(t)
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithUpstreamFallback(upstream.Addr().String()),
+		WithACME(ACME{DirectoryURL: ca.srv.URL + "/dir", DNS: dns}),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"tcp://db.outray.dev:20000"}`), 0)
+	c.Wait()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.caCert)
+	conn := tls.Client(relay.open(c, "conn-1"), &tls.Config{ServerName: "db.outray.dev", RootCAs: roots})
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	if got, _ := io.ReadAll(conn); string(got) != "ack:ping" {
+		t.Errorf("Expected TLS terminated with the ACME certificate, got %q", got)
+	}
+}
+
+func TestACMEServerChallengeNeedsCapability(t *testing.T) {
+	c := NewClient(WithACME(ACME{DirectoryURL: "http://127.0.0.1:1/dir"}))
+	_, err := c.obtainCertificate(t.Context(), "db.outray.dev")
+	if err == nil || !strings.Contains(err.Error(), "ACME.DNS") {
+		t.Errorf("Expected missing capability error, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package outray
+
+// TLSInfo describes the TLS handshake of a TCP tunnel stream.
+type TLSInfo struct {
+	ConnectionID string
+	ServerName   string   // SNI, empty if the client sent none
+	ALPN         []string // protocols offered by the client, in its order of preference
+	Negotiated   string   // protocol chosen; only known when the client terminates TLS
+}
+
+// WithALPNRoute sends TLS streams that use the ALPN protocol proto (such
+// as "h2" or "http/1.1") to the local port, so gRPC and REST services can
+// share one tunnel. SNI routes take precedence. When passing TLS through,
+// only the client's offer is known, so the first offered protocol with a
+// route wins; with WithTLSTermination the negotiated protocol decides.
+func WithALPNRoute(proto string, port int) Option {
+	return func(c *Client) {
+		if c.config.ALPNRoutes == nil {
+			c.config.ALPNRoutes = make(map[string]int)
+		}
+		c.config.ALPNRoutes[proto] = port
+	}
+}
+
+// WithOnTLSStream is called with the server name and ALPN protocols of
+// each TLS stream on a TCP tunnel.
+func WithOnTLSStream(fn func(info TLSInfo)) Option {
+	return func(c *Client) {
+		c.config.OnTLSStream = fn
+	}
+}
+
+// inspectsTLS reports whether passed-through streams need their
+// ClientHello read before they are relayed.
+func (c *Client) inspectsTLS() bool {
+	return len(c.conf().SNIRoutes) > 0 || len(c.conf().ALPNRoutes) > 0 || c.conf().OnTLSStream != nil
+}
+
+func (c *Client) tlsRoute(info TLSInfo) (int, bool) {
+	if port, ok := c.sniPort(info.ServerName); ok {
+		return port, true
+	}
+	if info.Negotiated != "" {
+		port, ok := c.conf().ALPNRoutes[info.Negotiated]
+		return port, ok
+	}
+	for _, proto := range info.ALPN {
+		if port, ok := c.conf().ALPNRoutes[proto]; ok {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Client) reportTLS(info TLSInfo) {
+	if c.conf().OnTLSStream != nil {
+		c.safeCallback(func() { c.conf().OnTLSStream(info) })
+	}
+}
@@ -0,0 +1,101 @@
+package outray
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"slices"
+	"testing"
+)
+
+// alpnServer answers each TLS connection with name and the protocol it
+// negotiated.
+func alpnServer(t *testing.T, name string, cert tls.Certificate, protos []string) int {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: protos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tc := conn.(*tls.Conn)
+				tc.Handshake()
+				tc.Write([]byte(name + ":" + tc.ConnectionState().NegotiatedProtocol))
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestALPNRoutingPassthrough(t *testing.T) {
+	cert, pool := testCertificate(t, "app.dev.local")
+	grpcPort := alpnServer(t, "grpc", cert, []string{"h2"})
+	restPort := alpnServer(t, "rest", cert, []string{"http/1.1"})
+
+	infos := make(chan TLSInfo, 2)
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithIPFamily(IPv4Only),
+		WithPort(restPort),
+		WithALPNRoute("h2", grpcPort),
+		WithOnTLSStream(func(info TLSInfo) { infos <- info }),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	for i, tt := range []struct {
+		protos []string
+		want   string
+	}{
+		{[]string{"h2"}, "grpc:h2"},
+		{[]string{"http/1.1"}, "rest:http/1.1"},
+	} {
+		conn := tls.Client(relay.open(c, tt.want), &tls.Config{ServerName: "app.dev.local", RootCAs: pool, NextProtos: tt.protos})
+		got, err := io.ReadAll(conn)
+		if string(got) != tt.want {
+			t.Errorf("Stream %d: expected %s, got %q (%v)", i, tt.want, got, err)
+		}
+		conn.Close()
+
+		info := <-infos
+		if info.ConnectionID != tt.want || info.ServerName != "app.dev.local" || !slices.Equal(info.ALPN, tt.protos) {
+			t.Errorf("Unexpected TLSInfo: %+v", info)
+		}
+	}
+}
+
+func TestALPNRoutingTerminated(t *testing.T) {
+	cert, pool := testCertificate(t, "app.dev.local")
+	grpc := ackServer(t)
+
+	infos := make(chan TLSInfo, 1)
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithIPFamily(IPv4Only),
+		WithPort(1), // nothing listens here; only the h2 route works
+		WithTLSTermination(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		WithALPNRoute("h2", grpc.Addr().(*net.TCPAddr).Port),
+		WithOnTLSStream(func(info TLSInfo) { infos <- info }),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	conn := tls.Client(relay.open(c, "conn-1"), &tls.Config{ServerName: "app.dev.local", RootCAs: pool, NextProtos: []string{"h2", "http/1.1"}})
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	if got, _ := io.ReadAll(conn); string(got) != "ack:ping" {
+		t.Errorf("Expected h2 stream on the routed port, got %q", got)
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		t.Errorf("Expected client to negotiate h2 from the routed protocols, got %q", p)
+	}
+	if info := <-infos; info.Negotiated != "h2" || !slices.Equal(info.ALPN, []string{"h2", "http/1.1"}) {
+		t.Errorf("Unexpected TLSInfo: %+v", info)
+	}
+}
@@ -0,0 +1,168 @@
+package outray
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	WarnTrafficSpike  = "TRAFFIC_SPIKE"
+	WarnNotFoundBurst = "NOT_FOUND_BURST"
+	WarnNewCountry    = "NEW_COUNTRY"
+)
+
+// anomalyCooldown stops one ongoing incident from raising an alert per
+// request.
+const anomalyCooldown = time.Minute
+
+// AnomalyThresholds tunes WithAnomalyDetection. Zero fields take the
+// defaults noted on each.
+type AnomalyThresholds struct {
+	// SpikeFactor is how many times the recent average requests per second
+	// the current second must reach (default 5), and MinRPS the floor below
+	// which no spike is reported (default 20).
+	SpikeFactor float64
+	MinRPS      int
+	// NotFoundBurst is how many 404s one client IP may cause within
+	// NotFoundWindow (defaults 20 and 10s) before it looks like a scan.
+	NotFoundBurst  int
+	NotFoundWindow time.Duration
+	// LearnPeriod is how long countries are learned silently before a
+	// first request from a new one is reported (default 10m).
+	LearnPeriod time.Duration
+	// AutoBan, if set, bans an IP for this long when it triggers
+	// NOT_FOUND_BURST.
+	AutoBan time.Duration
+}
+
+// WithAnomalyDetection raises TRAFFIC_SPIKE, NOT_FOUND_BURST and
+// NEW_COUNTRY warnings through OnWarning when traffic departs from what the
+// tunnel has seen so far.
+func WithAnomalyDetection(t AnomalyThresholds) Option {
+	return func(c *Client) {
+		if t.SpikeFactor <= 0 {
+			t.SpikeFactor = 5
+		}
+		if t.MinRPS <= 0 {
+			t.MinRPS = 20
+		}
+		if t.NotFoundBurst <= 0 {
+			t.NotFoundBurst = 20
+		}
+		if t.NotFoundWindow <= 0 {
+			t.NotFoundWindow = 10 * time.Second
+		}
+		if t.LearnPeriod <= 0 {
+			t.LearnPeriod = 10 * time.Minute
+		}
+		c.anomalies = &anomalyDetector{t: t}
+	}
+}
+
+type anomalyDetector struct {
+	t AnomalyThresholds
+
+	mu       sync.Mutex
+	started  time.Time
+	second   int64
+	count    int
+	baseline float64
+
+	windowStart time.Time
+	notFound    map[string]int
+	countries   map[string]bool
+	lastAlert   map[string]time.Time
+	pruned      time.Time // last sweep of lastAlert
+}
+
+// observe folds one exchange into the detector and returns any alerts it
+// triggers.
+func (d *anomalyDetector) observe(now time.Time, ip, country string, status int) []Warning {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started.IsZero() {
+		d.started, d.second = now, now.Unix()
+		d.countries = make(map[string]bool)
+		d.lastAlert = make(map[string]time.Time)
+	}
+
+	// Alerts past their cooldown no longer suppress anything; sweep them
+	// out so a scan from many IPs doesn't grow the map for good.
+	if now.Sub(d.pruned) >= anomalyCooldown {
+		for key, at := range d.lastAlert {
+			if now.Sub(at) >= anomalyCooldown {
+				delete(d.lastAlert, key)
+			}
+		}
+		d.pruned = now
+	}
+
+	var alerts []Warning
+	alert := func(key, code, msg string, details map[string]interface{}) {
+		if now.Sub(d.lastAlert[key]) < anomalyCooldown {
+			return
+		}
+		d.lastAlert[key] = now
+		alerts = append(alerts, Warning{Type: MsgTypeWarning, Code: code, Message: msg, Details: details})
+	}
+
+	// Decay the per-second average over every second since the last
+	// request, counting idle seconds as zero.
+	if sec := now.Unix(); sec > d.second {
+		for i := int64(0); i < min(sec-d.second, 60); i++ {
+			d.baseline = 0.9*d.baseline + 0.1*float64(d.count)
+			d.count = 0
+		}
+		d.second, d.count = sec, 0
+	}
+	d.count++
+	if limit := max(float64(d.t.MinRPS), d.t.SpikeFactor*d.baseline); float64(d.count) > limit {
+		alert(WarnTrafficSpike, WarnTrafficSpike,
+			fmt.Sprintf("traffic spike: %d requests this second vs %.1f/s average", d.count, d.baseline),
+			map[string]interface{}{"rps": d.count, "baseline": d.baseline})
+	}
+
+	if now.Sub(d.windowStart) > d.t.NotFoundWindow {
+		d.windowStart, d.notFound = now, make(map[string]int)
+	}
+	if status == http.StatusNotFound && ip != "" {
+		d.notFound[ip]++
+		if n := d.notFound[ip]; n >= d.t.NotFoundBurst {
+			alert(WarnNotFoundBurst+ip, WarnNotFoundBurst,
+				fmt.Sprintf("%s caused %d not-found responses in %s; possible scan", ip, n, d.t.NotFoundWindow),
+				map[string]interface{}{"ip": ip, "count": n})
+		}
+	}
+
+	if country != "" && !d.countries[country] {
+		d.countries[country] = true
+		if now.Sub(d.started) > d.t.LearnPeriod {
+			alert(WarnNewCountry+country, WarnNewCountry,
+				fmt.Sprintf("first request from country %s", country),
+				map[string]interface{}{"country": country, "ip": ip})
+		}
+	}
+	return alerts
+}
+
+func (c *Client) detectAnomalies(req IncomingRequest, resp IncomingResponse) {
+	if c.anomalies == nil {
+		return
+	}
+	var ip string
+	addr := c.clientIP(req)
+	if addr != nil {
+		ip = addr.String()
+	}
+	// admit resolved the country already; an empty one stays unknown.
+	for _, w := range c.anomalies.observe(time.Now(), ip, req.Country, resp.StatusCode) {
+		// clientIP only uses the server-supplied address and hops added by
+		// trusted proxies, so a forged header can't get a victim banned.
+		if w.Code == WarnNotFoundBurst && c.anomalies.t.AutoBan > 0 && addr != nil && !c.trustedProxy(addr) {
+			c.Ban(ip, c.anomalies.t.AutoBan)
+		}
+		c.handleWarning(w)
+	}
+}
@@ -0,0 +1,84 @@
+package outray
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	c := NewClient(WithAnomalyDetection(AnomalyThresholds{MinRPS: 5, NotFoundBurst: 3, LearnPeriod: time.Minute}))
+	d := c.anomalies
+	start := time.Unix(1700000000, 0)
+	codes := func(ws []Warning) (out []string) {
+		for _, w := range ws {
+			out = append(out, w.Code)
+		}
+		return out
+	}
+
+	// A steady 2 requests per second for a while builds the baseline.
+	for s := 0; s < 30; s++ {
+		for i := 0; i < 2; i++ {
+			if got := d.observe(start.Add(time.Duration(s)*time.Second), "192.0.2.1", "US", 200); got != nil {
+				t.Fatalf("Unexpected alerts during baseline: %v", codes(got))
+			}
+		}
+	}
+
+	var spikes int
+	burst := start.Add(40 * time.Second)
+	for i := 0; i < 30; i++ {
+		for _, code := range codes(d.observe(burst, "192.0.2.1", "US", 200)) {
+			if code == WarnTrafficSpike {
+				spikes++
+			}
+		}
+	}
+	if spikes != 1 {
+		t.Errorf("Expected one spike alert (cooldown), got %d", spikes)
+	}
+
+	scan := start.Add(100 * time.Second)
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = codes(d.observe(scan, "203.0.113.5", "US", 404))
+	}
+	if len(got) != 1 || got[0] != WarnNotFoundBurst {
+		t.Errorf("Expected NOT_FOUND_BURST on the third 404, got %v", got)
+	}
+
+	if got := codes(d.observe(scan.Add(time.Second), "198.51.100.9", "BR", 200)); len(got) != 1 || got[0] != WarnNewCountry {
+		t.Errorf("Expected NEW_COUNTRY after the learning period, got %v", got)
+	}
+}
+
+func TestAutoBanIgnoresForwardedFor(t *testing.T) {
+	c := NewClient(WithAnomalyDetection(AnomalyThresholds{NotFoundBurst: 3, AutoBan: time.Hour}))
+	c.closed = true
+	req := IncomingRequest{RemoteAddr: "203.0.113.5:4000", Headers: map[string]string{"X-Forwarded-For": "192.0.2.1"}}
+	for range 3 {
+		c.detectAnomalies(req, IncomingResponse{StatusCode: 404})
+	}
+	bans := c.Bans()
+	if len(bans) != 1 || bans[0].Prefix != "203.0.113.5/32" {
+		t.Errorf("Expected the scanner's own address to be banned, got %+v", bans)
+	}
+}
+
+func TestAnomalyAlertsPruned(t *testing.T) {
+	c := NewClient(WithAnomalyDetection(AnomalyThresholds{MinRPS: 1000, NotFoundBurst: 1}))
+	d := c.anomalies
+	start := time.Unix(1700000000, 0)
+	for i := range 50 {
+		d.observe(start, fmt.Sprintf("203.0.113.%d", i), "", 404)
+	}
+	if n := len(d.lastAlert); n != 50 {
+		t.Fatalf("Expected an alert per scanning IP, got %d", n)
+	}
+
+	d.observe(start.Add(anomalyCooldown), "192.0.2.1", "", 200)
+	if n := len(d.lastAlert); n != 0 {
+		t.Errorf("Expected alerts past their cooldown to be pruned, got %d", n)
+	}
+}
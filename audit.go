@@ -0,0 +1,162 @@
+package outray
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	AuditTunnelOpen        = "tunnel_open"
+	AuditTunnelClose       = "tunnel_close"
+	AuditConnectionLost    = "connection_lost"
+	AuditReconfigure       = "reconfigure"
+	AuditSchedulePause     = "schedule_pause"
+	AuditDataCapPause      = "data_cap_pause"
+	AuditAuthFailure       = "auth_failure"
+	AuditRemoteTermination = "remote_termination"
+	AuditKeyRotation       = "key_rotation"
+)
+
+// AuditRecord is one line of the audit log. Hash is the hex SHA-256 of Prev
+// followed by the record's JSON with Hash empty, chaining every record to
+// the one before it.
+type AuditRecord struct {
+	Seq     uint64            `json:"seq"`
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Details map[string]string `json:"details,omitempty"`
+	Prev    string            `json:"prev"`
+	Hash    string            `json:"hash"`
+}
+
+type auditLog struct {
+	path string
+
+	mu     sync.Mutex
+	f      *os.File
+	seq    uint64
+	prev   string
+	broken bool
+}
+
+// WithAuditLog appends administrative events (tunnel opens and closes,
+// reconfiguration, key rotations, scheduled pauses, auth failures, remote
+// terminations) to a hash-chained JSON-lines file. Use VerifyAuditLog to
+// check it.
+func WithAuditLog(path string) Option {
+	return func(c *Client) {
+		c.audit = &auditLog{path: path}
+	}
+}
+
+func (c *Client) auditf(action string, details map[string]string) {
+	if c.audit == nil {
+		return
+	}
+	if err := c.audit.append(action, details); err != nil {
+		c.logf("Failed to write audit log: %v", err)
+	}
+}
+
+// auditDisconnect distinguishes the server closing the tunnel from the
+// connection simply dropping.
+func (c *Client) auditDisconnect(err error) {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		c.auditf(AuditRemoteTermination, map[string]string{"code": strconv.Itoa(closeErr.Code), "reason": closeErr.Text})
+		return
+	}
+	c.auditf(AuditConnectionLost, map[string]string{"error": err.Error()})
+}
+
+func (a *auditLog) append(action string, details map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.broken {
+		return fmt.Errorf("audit log %s is unusable", a.path)
+	}
+	if a.f == nil {
+		if err := a.open(); err != nil {
+			a.broken = true
+			return err
+		}
+	}
+
+	rec := AuditRecord{Seq: a.seq + 1, Time: time.Now().UTC(), Action: action, Details: details, Prev: a.prev}
+	rec.Hash = auditHash(rec)
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.seq, a.prev = rec.Seq, rec.Hash
+	return nil
+}
+
+// open continues the chain from the last record already in the file.
+func (a *auditLog) open() error {
+	records, err := readAuditLog(a.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if n := len(records); n > 0 {
+		a.seq, a.prev = records[n-1].Seq, records[n-1].Hash
+	}
+	a.f, err = os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	return err
+}
+
+func auditHash(rec AuditRecord) string {
+	rec.Hash = ""
+	body, _ := json.Marshal(rec)
+	sum := sha256.Sum256(append([]byte(rec.Prev), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+func readAuditLog(path string) ([]AuditRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("audit log line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// VerifyAuditLog checks that every record's hash matches its contents and
+// links to the previous record, reporting the first line that doesn't.
+func VerifyAuditLog(path string) error {
+	records, err := readAuditLog(path)
+	if err != nil {
+		return err
+	}
+	prev := ""
+	for i, rec := range records {
+		if rec.Prev != prev || rec.Seq != uint64(i+1) || auditHash(rec) != rec.Hash {
+			return fmt.Errorf("audit log line %d: chain broken", i+1)
+		}
+		prev = rec.Hash
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package outray
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	c := NewClient(WithAuditLog(path))
+	c.closed = true
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://a.outray.dev"}`), 0)
+	c.handleMessage([]byte(`{"type":"error","code":"AUTH_EXPIRED","message":"token expired"}`), 0)
+
+	// A second client continues the chain from the existing file.
+	c2 := NewClient(WithAuditLog(path))
+	c2.Reconfigure(WithSubdomain("b"))
+
+	records, err := readAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, r := range records {
+		actions = append(actions, r.Action)
+	}
+	if got := strings.Join(actions, ","); got != "tunnel_open,auth_failure,reconfigure" {
+		t.Errorf("Unexpected audit actions: %s", got)
+	}
+	if err := VerifyAuditLog(path); err != nil {
+		t.Fatalf("Expected intact chain, got %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), "a.outray.dev", "evil.example", 1)), 0o600)
+	if err := VerifyAuditLog(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected tampering to be detected on line 1, got %v", err)
+	}
+}
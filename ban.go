@@ -0,0 +1,125 @@
+package outray
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ban is an entry in the client's ban list.
+type Ban struct {
+	Prefix  string
+	Expires time.Time // zero for a permanent ban
+	Hits    uint64
+}
+
+type banList struct {
+	mu   sync.Mutex
+	bans map[netip.Prefix]*Ban
+}
+
+// Ban refuses HTTP requests, rejects TCP connections and drops UDP packets
+// from ip, which may be a single address or a CIDR range, for ttl (forever
+// if ttl is zero). Banning an already banned prefix replaces its expiry.
+func (c *Client) Ban(ip string, ttl time.Duration) error {
+	prefix, err := parseBanPrefix(ip)
+	if err != nil {
+		return err
+	}
+	b := &Ban{Prefix: prefix.String()}
+	if ttl > 0 {
+		b.Expires = time.Now().Add(ttl)
+	}
+
+	c.bans.mu.Lock()
+	defer c.bans.mu.Unlock()
+	if c.bans.bans == nil {
+		c.bans.bans = make(map[netip.Prefix]*Ban)
+	}
+	if old, ok := c.bans.bans[prefix]; ok {
+		b.Hits = old.Hits
+	}
+	c.bans.bans[prefix] = b
+	return nil
+}
+
+// Unban lifts a ban added with the same address or range, reporting whether
+// one existed.
+func (c *Client) Unban(ip string) bool {
+	prefix, err := parseBanPrefix(ip)
+	if err != nil {
+		return false
+	}
+	c.bans.mu.Lock()
+	defer c.bans.mu.Unlock()
+	_, ok := c.bans.bans[prefix]
+	delete(c.bans.bans, prefix)
+	return ok
+}
+
+// Bans lists the active bans sorted by prefix.
+func (c *Client) Bans() []Ban {
+	c.bans.mu.Lock()
+	defer c.bans.mu.Unlock()
+	c.bans.purge(time.Now())
+	out := make([]Ban, 0, len(c.bans.bans))
+	for _, b := range c.bans.bans {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out
+}
+
+func (l *banList) purge(now time.Time) {
+	for p, b := range l.bans {
+		if !b.Expires.IsZero() && now.After(b.Expires) {
+			delete(l.bans, p)
+		}
+	}
+}
+
+// banned reports whether ip is covered by an active ban, counting the hit.
+func (c *Client) banned(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+
+	c.bans.mu.Lock()
+	defer c.bans.mu.Unlock()
+	if len(c.bans.bans) == 0 {
+		return false
+	}
+	c.bans.purge(time.Now())
+	for p, b := range c.bans.bans {
+		if p.Contains(addr) {
+			b.Hits++
+			atomic.AddUint64(&c.stats.banned, 1)
+			return true
+		}
+	}
+	return false
+}
+
+func parseBanPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (c *Client) rejectBanned(req IncomingRequest) {
+	c.reject(req, IncomingResponse{StatusCode: http.StatusForbidden, Body: []byte("Forbidden")}, "banned")
+}
@@ -0,0 +1,83 @@
+package outray
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBan(t *testing.T) {
+	ts := newTestServer(t)
+	c := connectTestClient(t, ts, WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		return IncomingResponse{StatusCode: 200}
+	}))
+	conn := ts.accept(t)
+
+	if err := c.Ban("198.51.100.0/24", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ban("not-an-ip", 0); err == nil {
+		t.Error("Expected an error for an invalid address")
+	}
+
+	send := func(id, addr string) IncomingResponse {
+		conn.WriteJSON(map[string]interface{}{
+			"type": "request", "requestId": id, "method": "GET", "path": "/", "remoteAddr": addr,
+		})
+		var resp IncomingResponse
+		readFrame(t, conn, &resp)
+		return resp
+	}
+
+	if resp := send("r1", "198.51.100.7:5000"); resp.StatusCode != 403 {
+		t.Errorf("Expected 403 for banned IP, got %d", resp.StatusCode)
+	}
+	if resp := send("r2", "192.0.2.1:5000"); resp.StatusCode != 200 {
+		t.Errorf("Expected 200 for other IP, got %d", resp.StatusCode)
+	}
+
+	bans := c.Bans()
+	if len(bans) != 1 || bans[0].Prefix != "198.51.100.0/24" || bans[0].Hits != 1 {
+		t.Errorf("Unexpected bans: %+v", bans)
+	}
+	if got := c.Stats().Banned; got != 1 {
+		t.Errorf("Expected Banned=1, got %d", got)
+	}
+
+	if !c.Unban("198.51.100.0/24") {
+		t.Error("Expected Unban to report an existing ban")
+	}
+	if resp := send("r3", "198.51.100.7:5000"); resp.StatusCode != 200 {
+		t.Errorf("Expected 200 after unban, got %d", resp.StatusCode)
+	}
+}
+
+func TestBanExpires(t *testing.T) {
+	c := NewClient()
+	c.Ban("192.0.2.1", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if c.banned(net.ParseIP("192.0.2.1")) || len(c.Bans()) != 0 {
+		t.Error("Expected ban to expire")
+	}
+}
+
+func TestBanTCP(t *testing.T) {
+	var rejected []StreamRejected
+	c := NewClient(WithProtocol("tcp"), WithMessageTap(func(dir Direction, msgType string, data []byte) {
+		var msg StreamRejected
+		if msgType == MsgTypeStreamRejected && json.Unmarshal(data, &msg) == nil {
+			rejected = append(rejected, msg)
+		}
+	}))
+	c.closed = true
+	c.Ban("198.51.100.0/24", 0)
+
+	c.handleTCPConnection(TCPConnection{ID: "conn-1", RemoteAddr: "198.51.100.7:5000"}, 0)
+	if len(rejected) != 1 || rejected[0].ConnectionID != "conn-1" || rejected[0].Reason != "banned" {
+		t.Errorf("Expected the banned connection to be rejected, got %+v", rejected)
+	}
+	if got := c.Stats().Banned; got != 1 {
+		t.Errorf("Expected Banned=1, got %d", got)
+	}
+}
@@ -0,0 +1,257 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	outray "github.com/sodiqscript111/outray-go"
+)
+
+var payloadSizes = []int{1 << 10, 64 << 10, 1 << 20}
+
+func sizeName(n int) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%dMiB", n>>20)
+	}
+	return fmt.Sprintf("%dKiB", n>>10)
+}
+
+// connect starts a client against a fresh fake server and returns the
+// server side of its tunnel.
+func connect(b *testing.B, opts ...outray.Option) *Tunnel {
+	b.Helper()
+	srv := NewServer()
+	b.Cleanup(srv.Close)
+
+	c := outray.NewClient(append([]outray.Option{outray.WithServerURL(srv.URL())}, opts...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Connect(ctx)
+	b.Cleanup(func() {
+		cancel()
+		c.Wait()
+	})
+
+	t, err := srv.Accept(5 * time.Second)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { t.Close() })
+	return t
+}
+
+func BenchmarkHTTPProxy(b *testing.B) {
+	for _, size := range payloadSizes {
+		body := bytes.Repeat([]byte("x"), size)
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		}))
+		b.Cleanup(upstream.Close)
+		addr := strings.TrimPrefix(upstream.URL, "http://")
+
+		b.Run(sizeName(size), func(b *testing.B) {
+			t := connect(b, outray.WithUpstreamFallback(addr))
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := t.Request(outray.IncomingRequest{Method: "GET", Path: "/"})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if resp.StatusCode != 200 || len(resp.Body) != size {
+					b.Fatalf("Unexpected response: %d with %d bytes", resp.StatusCode, len(resp.Body))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkHTTPProxyParallel(b *testing.B) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	b.Cleanup(upstream.Close)
+	t := connect(b, outray.WithUpstreamFallback(strings.TrimPrefix(upstream.URL, "http://")))
+	body := bytes.Repeat([]byte("x"), 4<<10)
+
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := t.Request(outray.IncomingRequest{Method: "POST", Path: "/echo", Body: body})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if len(resp.Body) != len(body) {
+				b.Errorf("Expected %d bytes echoed, got %d", len(body), len(resp.Body))
+				return
+			}
+		}
+	})
+}
+
+// echoServer greets each connection with one byte, which OpenTCP waits
+// for, then echoes everything back.
+func echoServer(b *testing.B) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte{'+'})
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func BenchmarkTCPStream(b *testing.B) {
+	addr := echoServer(b)
+	for _, size := range payloadSizes[:2] {
+		b.Run(sizeName(size), func(b *testing.B) {
+			t := connect(b, outray.WithProtocol("tcp"), outray.WithUpstreamFallback(addr))
+			s, err := t.OpenTCP()
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+			chunk := bytes.Repeat([]byte("x"), size)
+
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := s.Write(chunk); err != nil {
+					b.Fatal(err)
+				}
+				for got := 0; got < size; {
+					data, err := s.Read()
+					if err != nil {
+						b.Fatal(err)
+					}
+					got += len(data)
+				}
+			}
+		})
+	}
+}
+
+func benchRequest(size int) outray.IncomingRequest {
+	return outray.IncomingRequest{
+		ID:     "req-1",
+		Method: "POST",
+		Path:   "/api/items?page=2",
+		Headers: map[string]string{
+			"Content-Type":    "application/json",
+			"User-Agent":      "bench/1.0",
+			"X-Forwarded-For": "203.0.113.9",
+		},
+		Body: bytes.Repeat([]byte("x"), size),
+	}
+}
+
+func BenchmarkEncodeResponse(b *testing.B) {
+	for _, size := range payloadSizes {
+		resp := outray.IncomingResponse{
+			Type:       outray.MsgTypeResponse,
+			ID:         "req-1",
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       bytes.Repeat([]byte("x"), size),
+		}
+		b.Run(sizeName(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := json.Marshal(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeRequest(b *testing.B) {
+	for _, size := range payloadSizes {
+		data, err := json.Marshal(benchRequest(size))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(sizeName(size), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var req outray.IncomingRequest
+				if err := json.Unmarshal(data, &req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeTCPData(b *testing.B) {
+	chunk := bytes.Repeat([]byte("x"), 32<<10)
+	data, _ := json.Marshal(outray.TCPData{
+		Type:         outray.MsgTypeTCPData,
+		ConnectionID: "tcp-1",
+		Data:         base64.StdEncoding.EncodeToString(chunk),
+	})
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var msg outray.TCPData
+		if err := json.Unmarshal(data, &msg); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := base64.StdEncoding.DecodeString(msg.Data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestServer checks the fake server end to end so the benchmarks can't
+// silently measure a broken path.
+func TestServer(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	c := outray.NewClient(outray.WithServerURL(srv.URL()), outray.WithOnRequest(func(req outray.IncomingRequest) outray.IncomingResponse {
+		return outray.IncomingResponse{StatusCode: 201, Body: []byte(req.Path)}
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.Wait()
+	defer cancel()
+	go c.Connect(ctx)
+
+	tun, err := srv.Accept(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+	resp, err := tun.Request(outray.IncomingRequest{Method: "GET", Path: "/hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 201 || string(resp.Body) != "/hello" {
+		t.Errorf("Unexpected response: %d %q", resp.StatusCode, resp.Body)
+	}
+}
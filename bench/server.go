@@ -0,0 +1,242 @@
+// Package bench holds reproducible benchmarks for the client's data path,
+// run against a loopback fake server:
+//
+//	go test ./bench -run '^$' -bench . -benchmem
+//
+// The fake server is exported so other benchmarks and load tests can drive a
+// real Client without a tunnel server.
+package bench
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	outray "github.com/sodiqscript111/outray-go"
+)
+
+var ErrTunnelClosed = errors.New("tunnel closed")
+
+// Server is a minimal in-process tunnel server. It accepts clients over
+// WebSocket on a loopback port and lets the caller send them requests and
+// TCP streams as the real server would.
+type Server struct {
+	*httptest.Server
+	conns chan *websocket.Conn
+}
+
+func NewServer() *Server {
+	s := &Server{conns: make(chan *websocket.Conn, 4)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		s.conns <- conn
+	}))
+	return s
+}
+
+// URL is the address to pass to outray.WithServerURL.
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.Server.URL, "http")
+}
+
+// Accept waits for a client to connect, reads its handshake and replies
+// with tunnel_opened.
+func (s *Server) Accept(timeout time.Duration) (*Tunnel, error) {
+	var conn *websocket.Conn
+	select {
+	case conn = <-s.conns:
+	case <-time.After(timeout):
+		return nil, errors.New("client never connected")
+	}
+
+	var handshake outray.OpenTunnelRequest
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	if err := conn.ReadJSON(&handshake); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read handshake: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	opened := outray.TunnelOpened{Type: outray.MsgTypeTunnelOpened, URL: "https://bench.outray.test"}
+	if err := conn.WriteJSON(opened); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t := &Tunnel{
+		conn:      conn,
+		responses: make(map[string]chan outray.IncomingResponse),
+		streams:   make(map[string]chan []byte),
+		done:      make(chan struct{}),
+	}
+	go t.readLoop()
+	return t, nil
+}
+
+// Tunnel is the server side of one connected client. Its methods are safe
+// for concurrent use.
+type Tunnel struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	nextID  uint64
+
+	mu        sync.Mutex
+	responses map[string]chan outray.IncomingResponse
+	streams   map[string]chan []byte
+	done      chan struct{}
+}
+
+func (t *Tunnel) Close() error {
+	return t.conn.Close()
+}
+
+// Request sends req to the client and waits for its response. An empty
+// ID is filled in.
+func (t *Tunnel) Request(req outray.IncomingRequest) (outray.IncomingResponse, error) {
+	if req.ID == "" {
+		req.ID = t.newID("req")
+	}
+	ch := make(chan outray.IncomingResponse, 1)
+	t.mu.Lock()
+	t.responses[req.ID] = ch
+	t.mu.Unlock()
+
+	msg := struct {
+		Type string `json:"type"`
+		outray.IncomingRequest
+	}{outray.MsgTypeRequest, req}
+	if err := t.write(msg); err != nil {
+		return outray.IncomingResponse{}, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-t.done:
+		return outray.IncomingResponse{}, ErrTunnelClosed
+	}
+}
+
+// OpenTCP starts a TCP stream through the tunnel. The client's upstream
+// must send at least one byte on accept; OpenTCP consumes it to know the
+// stream is ready.
+func (t *Tunnel) OpenTCP() (*TCPStream, error) {
+	s := &TCPStream{t: t, id: t.newID("tcp"), data: make(chan []byte, 64)}
+	t.mu.Lock()
+	t.streams[s.id] = s.data
+	t.mu.Unlock()
+
+	if err := t.write(outray.TCPConnection{Type: outray.MsgTypeTCPConnection, ID: s.id}); err != nil {
+		return nil, err
+	}
+	if _, err := s.Read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (t *Tunnel) newID(prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, atomic.AddUint64(&t.nextID, 1))
+}
+
+func (t *Tunnel) write(v interface{}) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.conn.WriteJSON(v)
+}
+
+func (t *Tunnel) readLoop() {
+	defer close(t.done)
+	for {
+		_, data, err := t.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var env struct {
+			Type         string `json:"type"`
+			ConnectionID string `json:"connectionId"`
+		}
+		if json.Unmarshal(data, &env) != nil {
+			continue
+		}
+
+		switch env.Type {
+		case outray.MsgTypeResponse:
+			var resp outray.IncomingResponse
+			if json.Unmarshal(data, &resp) != nil {
+				continue
+			}
+			t.mu.Lock()
+			ch := t.responses[resp.ID]
+			delete(t.responses, resp.ID)
+			t.mu.Unlock()
+			if ch != nil {
+				ch <- resp
+			}
+		case outray.MsgTypeTCPData:
+			var msg outray.TCPData
+			if json.Unmarshal(data, &msg) != nil {
+				continue
+			}
+			chunk, err := base64.StdEncoding.DecodeString(msg.Data)
+			if err != nil {
+				continue
+			}
+			t.mu.Lock()
+			ch := t.streams[env.ConnectionID]
+			t.mu.Unlock()
+			if ch != nil {
+				ch <- chunk
+			}
+		case outray.MsgTypeTCPClose, outray.MsgTypeTCPError:
+			t.mu.Lock()
+			if ch := t.streams[env.ConnectionID]; ch != nil {
+				close(ch)
+				delete(t.streams, env.ConnectionID)
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+// TCPStream is the server side of a tunneled TCP connection.
+type TCPStream struct {
+	t    *Tunnel
+	id   string
+	data chan []byte
+}
+
+func (s *TCPStream) Write(p []byte) error {
+	return s.t.write(outray.TCPData{
+		Type:         outray.MsgTypeTCPData,
+		ConnectionID: s.id,
+		Data:         base64.StdEncoding.EncodeToString(p),
+	})
+}
+
+// Read returns the next chunk the client forwarded from its upstream.
+func (s *TCPStream) Read() ([]byte, error) {
+	select {
+	case chunk, ok := <-s.data:
+		if !ok {
+			return nil, ErrTunnelClosed
+		}
+		return chunk, nil
+	case <-s.t.done:
+		return nil, ErrTunnelClosed
+	}
+}
+
+func (s *TCPStream) Close() error {
+	return s.t.write(outray.TCPClose{Type: outray.MsgTypeTCPClose, ConnectionID: s.id})
+}
@@ -0,0 +1,202 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+)
+
+func WithHTTPHandler(h http.Handler) Option {
+	return func(c *Client) {
+		c.config.OnRequestAsync = c.httpHandlerAdapter(h)
+	}
+}
+
+func (c *Client) Handle(h http.Handler) {
+	c.applyOptions(WithHTTPHandler(h))
+}
+
+func (c *Client) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := c.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	})
+}
+
+// RoundTrip runs r through the pipeline a tunneled request takes: request
+// middleware, handlers, or the local upstream. r's context bounds it. The
+// admission checks (bans, country and client certificate filters, access
+// policy) are skipped, since they judge what the server reports about the
+// remote client and r didn't come through the server. Requests without an
+// X-Request-Id header get a random ID.
+func (c *Client) RoundTrip(r *http.Request) (*http.Response, error) {
+	req, err := incomingFromHTTP(r)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := c.processRequest(r.Context(), req)
+
+	header := expandHeaders(resp.Headers)
+	return &http.Response{
+		Status:        strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       r,
+	}, nil
+}
+
+func (c *Client) processRequest(ctx context.Context, req IncomingRequest) IncomingResponse {
+	req.ctx = ctx
+	switch {
+	case c.conf().OnRequestAsync != nil:
+		w := c.newResponseWriter(req)
+		w.capture = make(chan IncomingResponse, 1)
+		c.safeCallback(func() { c.conf().OnRequestAsync(req, w) })
+		select {
+		case resp := <-w.capture:
+			return resp
+		case <-ctx.Done():
+			return IncomingResponse{StatusCode: http.StatusGatewayTimeout, Body: []byte(ctx.Err().Error())}
+		}
+	case c.conf().OnRequest != nil:
+		var resp IncomingResponse
+		c.safeCallback(func() { resp = c.conf().OnRequest(req) })
+		return resp
+	case c.hasUpstream("http"):
+		return c.proxyHTTP(ctx, req)
+	}
+	return IncomingResponse{StatusCode: http.StatusNotImplemented, Body: []byte("no request handler configured")}
+}
+
+func incomingFromHTTP(r *http.Request) (IncomingRequest, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return IncomingRequest{}, err
+		}
+	}
+
+	headers := flattenHeaders(r.Header)
+	if r.Host != "" {
+		headers["Host"] = r.Host
+	}
+	id := r.Header.Get("X-Request-Id")
+	if id == "" {
+		id = newRequestID()
+	}
+	return IncomingRequest{
+		ID:      id,
+		Method:  r.Method,
+		Path:    r.URL.RequestURI(),
+		Headers: headers,
+		Body:    body,
+	}, nil
+}
+
+// httpHandlerAdapter serves each request with h on a tracked goroutine.
+// The request's context ends when the server cancels it or the client
+// closes, and a panicking handler gets a 500 rather than taking the
+// process down.
+func (c *Client) httpHandlerAdapter(h http.Handler) AsyncRequestHandler {
+	return func(req IncomingRequest, w ResponseWriter) {
+		c.spawn(func() {
+			ctx, done := c.trackRequest(req)
+			defer done()
+			hw := &handlerWriter{w: w, header: make(http.Header)}
+			defer func() {
+				if r := recover(); r != nil {
+					c.logf("Panic in HTTP handler: %v", r)
+					c.reportCrash("callback", r, debug.Stack(), true)
+					if !hw.wroteHeader {
+						hw.header = make(http.Header)
+						hw.WriteHeader(http.StatusInternalServerError)
+					}
+					hw.finish()
+				}
+			}()
+
+			r, err := http.NewRequestWithContext(ctx, req.Method, req.Path, bytes.NewReader(req.Body))
+			if err != nil {
+				w.Respond(Text(http.StatusBadRequest, err.Error()))
+				return
+			}
+			r.Header = expandHeaders(req.Headers)
+			r.Host = headerValue(req.Headers, "Host")
+			r.RequestURI = req.Path
+			r.RemoteAddr = req.RemoteAddr
+			if req.stream != nil {
+				r.Body = io.NopCloser(req.stream)
+				r.ContentLength = contentLength(req.Headers)
+			}
+
+			h.ServeHTTP(hw, r)
+			hw.finish()
+		})
+	}
+}
+
+type handlerWriter struct {
+	w           ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (hw *handlerWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *handlerWriter) WriteHeader(statusCode int) {
+	if hw.wroteHeader {
+		return
+	}
+	hw.wroteHeader = true
+	out := hw.w.Header()
+	for k, v := range flattenHeaders(hw.header) {
+		out[k] = v
+	}
+	hw.w.WriteHeader(statusCode)
+}
+
+func (hw *handlerWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		if hw.header.Get("Content-Type") == "" {
+			hw.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.w.Write(p)
+}
+
+func (hw *handlerWriter) Flush() {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	hw.w.Flush()
+}
+
+func (hw *handlerWriter) finish() {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	hw.w.Close()
+}
@@ -0,0 +1,203 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPHandlerBridge(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hi " + r.URL.Query().Get("name")))
+	})
+
+	c := NewClient(WithHTTPHandler(mux))
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/hello?name=gopher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusAccepted || string(body) != "hi gopher" || resp.Header.Get("X-Path") != "/hello" {
+		t.Errorf("Unexpected bridged response %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+}
+
+// tapResponses collects the responses c sends.
+func tapResponses() (Option, <-chan IncomingResponse) {
+	ch := make(chan IncomingResponse, 8)
+	return WithMessageTap(func(dir Direction, msgType string, data []byte) {
+		var resp IncomingResponse
+		if msgType == MsgTypeResponse && json.Unmarshal(data, &resp) == nil {
+			ch <- resp
+		}
+	}), ch
+}
+
+func waitResponse(t *testing.T, ch <-chan IncomingResponse) IncomingResponse {
+	t.Helper()
+	select {
+	case resp := <-ch:
+		return resp
+	case <-time.After(2 * time.Second):
+		t.Fatal("No response sent")
+		return IncomingResponse{}
+	}
+}
+
+func TestHTTPHandlerPanic(t *testing.T) {
+	tap, responses := tapResponses()
+	c := NewClient(tap, WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Partial", "1")
+		panic("boom")
+	})))
+	c.closed = true
+
+	c.dispatchRequest(IncomingRequest{ID: "r1", Method: "GET", Path: "/"})
+	resp := waitResponse(t, responses)
+	if resp.ID != "r1" || resp.StatusCode != http.StatusInternalServerError || resp.Headers["X-Partial"] != "" {
+		t.Errorf("Expected a bare 500, got %+v", resp)
+	}
+	c.Wait()
+}
+
+func TestHTTPHandlerRequest(t *testing.T) {
+	tap, responses := tapResponses()
+	remote := make(chan string, 1)
+	c := NewClient(tap, WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})))
+	c.closed = true
+
+	c.dispatchRequest(IncomingRequest{
+		ID: "r1", Method: "GET", Path: "/",
+		RemoteAddr: "198.51.100.7:5000",
+		Headers:    map[string]string{"X-Forwarded-For": "192.0.2.1"},
+	})
+	if got := <-remote; got != "198.51.100.7:5000" {
+		t.Errorf("Expected RemoteAddr from the server, got %q", got)
+	}
+
+	// The handler only returns once the server cancels the request.
+	c.cancelRequest("r1")
+	if resp := waitResponse(t, responses); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	c.Wait()
+}
+
+func TestHTTPHandlerCookies(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+		http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark"})
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Cookie")
+	})
+
+	// Served to the tunnel, each cookie stays on its own line.
+	tap, responses := tapResponses()
+	c := NewClient(tap, WithHTTPHandler(handler))
+	c.closed = true
+	c.dispatchRequest(IncomingRequest{ID: "r1", Method: "GET", Path: "/"})
+	resp := waitResponse(t, responses)
+	if got := resp.Headers["Set-Cookie"]; got != "session=abc; Expires=Tue, 01 Jan 2030 00:00:00 GMT\ntheme=dark" {
+		t.Errorf("Unexpected Set-Cookie %q", got)
+	}
+	if got := resp.Headers["Vary"]; got != "Accept, Cookie" {
+		t.Errorf("Expected other repeated headers joined, got %q", got)
+	}
+	c.Wait()
+
+	// Bridged back to net/http, they are separate headers again.
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+	httpResp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	cookies := httpResp.Cookies()
+	if len(cookies) != 2 || cookies[0].Name != "session" || cookies[1].Name != "theme" {
+		t.Errorf("Expected two cookies, got %v", httpResp.Header.Values("Set-Cookie"))
+	}
+}
+
+func TestRoundTripRequests(t *testing.T) {
+	release := make(chan struct{})
+	c := NewClient(WithHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			w.WriteHeader(499)
+		}
+	})))
+	c.closed = true
+
+	// Concurrent calls without X-Request-Id are tracked apart.
+	const calls = 4
+	cancels := make([]context.CancelFunc, calls)
+	statuses := make(chan int, calls)
+	for i := range calls {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[i] = cancel
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		go func() {
+			resp, err := c.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				statuses <- 0
+				return
+			}
+			statuses <- resp.StatusCode
+		}()
+	}
+	for c.activeRequests() < calls {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Cancelling one caller's request reaches only its handler.
+	cancels[0]()
+	if status := <-statuses; status != 499 && status != http.StatusGatewayTimeout {
+		t.Errorf("Expected the cancelled request to end early, got %d", status)
+	}
+	close(release)
+	for range calls - 1 {
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("Expected the other requests to finish normally, got %d", status)
+		}
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	c.Wait()
+	if n := c.activeRequests(); n != 0 {
+		t.Errorf("Expected no tracked requests left, got %d", n)
+	}
+}
+
+func TestTrackRequestReusedID(t *testing.T) {
+	c := NewClient()
+	ctx1, done1 := c.trackRequest(IncomingRequest{ID: "r1"})
+	ctx2, done2 := c.trackRequest(IncomingRequest{ID: "r1"})
+	defer done2()
+
+	done1()
+	c.cancelRequest("r1")
+	if ctx1.Err() == nil || ctx2.Err() == nil {
+		t.Error("Expected the later request to stay tracked after the earlier one finished")
+	}
+}
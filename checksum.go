@@ -0,0 +1,40 @@
+package outray
+
+import (
+	"errors"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var errChecksumMismatch = errors.New("payload checksum mismatch")
+
+// WithPayloadChecksums adds a CRC32C of the payload to every tcp_data and
+// udp_response frame the client sends, and asks the server to do the same.
+// Inbound frames that carry a checksum are verified whether or not this is
+// set: a TCP frame that fails resets its stream with a checksum_mismatch
+// tcp_error, a UDP packet that fails is dropped.
+func WithPayloadChecksums() Option {
+	return func(c *Client) {
+		c.config.PayloadChecksums = true
+	}
+}
+
+// checksum returns the CRC32C of a frame's payload as carried on the wire
+// (after end-to-end sealing), or nil if checksums are off.
+func (c *Client) checksum(payload []byte) *uint32 {
+	if !c.conf().PayloadChecksums {
+		return nil
+	}
+	sum := crc32.Checksum(payload, castagnoli)
+	return &sum
+}
+
+func (c *Client) verifyChecksum(payload []byte, sum *uint32) error {
+	if sum == nil || crc32.Checksum(payload, castagnoli) == *sum {
+		return nil
+	}
+	atomic.AddUint64(&c.stats.checksumFailures, 1)
+	return errChecksumMismatch
+}
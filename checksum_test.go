@@ -0,0 +1,84 @@
+package outray
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"testing"
+)
+
+func crc(data []byte) *uint32 {
+	sum := crc32.Checksum(data, castagnoli)
+	return &sum
+}
+
+func TestTCPChecksumMismatchResetsStream(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+
+	var codes []string
+	c := NewClient(WithMessageTap(func(d Direction, msgType string, payload []byte) {
+		if msgType == MsgTypeTCPError {
+			var msg TCPError
+			json.Unmarshal(payload, &msg)
+			codes = append(codes, msg.Code)
+		}
+	}))
+	c.closed = true
+	c.tcpConns["conn-1"] = &tcpStream{conn: local, done: make(chan struct{})}
+	c.acquireTCPSlot()
+
+	good := []byte("hello")
+	c.handleTCPData(TCPData{ConnectionID: "conn-1", Data: base64.StdEncoding.EncodeToString(good), CRC32C: crc(good)})
+	if _, ok := c.tcpStream("conn-1"); !ok {
+		t.Fatal("Expected stream to survive a valid checksum")
+	}
+
+	c.handleTCPData(TCPData{ConnectionID: "conn-1", Data: base64.StdEncoding.EncodeToString([]byte("hellp")), CRC32C: crc(good)})
+	if _, ok := c.tcpStream("conn-1"); ok {
+		t.Error("Expected stream to be reset after a checksum mismatch")
+	}
+	if len(codes) != 1 || codes[0] != StreamErrChecksum {
+		t.Errorf("Expected a %s tcp_error, got %v", StreamErrChecksum, codes)
+	}
+	if got := c.Stats().ChecksumFailures; got != 1 {
+		t.Errorf("Expected ChecksumFailures=1, got %d", got)
+	}
+}
+
+func TestUDPChecksums(t *testing.T) {
+	var responses []UDPResponse
+	c := NewClient(
+		WithPayloadChecksums(),
+		WithUpstream(&VirtualBackend{UDP: func(packet []byte) []byte { return append([]byte("re:"), packet...) }}),
+		WithMessageTap(func(d Direction, msgType string, payload []byte) {
+			if msgType == MsgTypeUDPResponse {
+				var msg UDPResponse
+				json.Unmarshal(payload, &msg)
+				responses = append(responses, msg)
+			}
+		}),
+	)
+	c.closed = true
+
+	query := []byte("query")
+	packet := UDPData{PacketID: "p1", Data: base64.StdEncoding.EncodeToString(query), SourceAddress: "192.0.2.1", SourcePort: 53}
+	packet.CRC32C = crc([]byte("other"))
+	c.handleUDPData(packet)
+	if len(responses) != 0 {
+		t.Fatal("Expected packet with a bad checksum to be dropped")
+	}
+
+	packet.CRC32C = crc(query)
+	c.handleUDPData(packet)
+	if len(responses) != 1 {
+		t.Fatalf("Expected one response, got %d", len(responses))
+	}
+	data, _ := base64.StdEncoding.DecodeString(responses[0].Data)
+	if string(data) != "re:query" || responses[0].CRC32C == nil || *responses[0].CRC32C != *crc(data) {
+		t.Errorf("Expected checksummed response, got %+v", responses[0])
+	}
+}
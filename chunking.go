@@ -0,0 +1,51 @@
+package outray
+
+// WithResponseChunking sends buffered responses whose body is larger than
+// threshold bytes as a response_start frame followed by response_chunk
+// frames of at most threshold bytes each, the same frames streaming
+// responses use, so a large body never becomes one WebSocket message big
+// enough to trip the server's read limit. Large request bodies already
+// arrive as request_chunk frames and are reassembled before dispatch.
+func WithResponseChunking(threshold int) Option {
+	return func(c *Client) {
+		c.config.ChunkThreshold = threshold
+	}
+}
+
+func (c *Client) chunked(resp IncomingResponse) bool {
+	return c.conf().ChunkThreshold > 0 && len(resp.Body) > c.conf().ChunkThreshold
+}
+
+// sendChunked sends resp, already compressed but not yet sealed, split
+// into response_chunk frames. Each chunk is sealed on its own.
+func (c *Client) sendChunked(epoch uint64, resp IncomingResponse) error {
+	headers := resp.Headers
+	if c.e2eCipher() != nil {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[e2eHeader] = "aes-256-gcm"
+	}
+	c.pinStream(resp.ID)
+	defer c.unpinStream(resp.ID)
+	start := ResponseStart{Type: MsgTypeResponseStart, ID: resp.ID, StatusCode: resp.StatusCode, Headers: headers}
+	if err := c.writeStreamJSONOn(epoch, resp.ID, PriorityHTTP, start); err != nil {
+		return err
+	}
+
+	size := c.conf().ChunkThreshold
+	for off, seq := 0, uint64(1); off < len(resp.Body); off, seq = off+size, seq+1 {
+		end := min(off+size, len(resp.Body))
+		final := end == len(resp.Body)
+		chunk := ResponseChunk{
+			Type:  MsgTypeResponseChunk,
+			ID:    resp.ID,
+			Data:  c.seal(resp.Body[off:end], e2eFrame{typ: MsgTypeResponseChunk, id: resp.ID, seq: seq, final: final}),
+			Final: final,
+		}
+		if err := c.writeStreamJSONOn(epoch, resp.ID, PriorityHTTP, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package outray
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResponseChunking(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+	ts := newTestServer(t)
+	connectTestClient(t, ts,
+		WithResponseChunking(4096),
+		WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			if req.Path == "/small" {
+				return IncomingResponse{StatusCode: 200, Body: []byte("ok")}
+			}
+			return IncomingResponse{StatusCode: 200, Headers: map[string]string{"Content-Type": "text/plain"}, Body: body}
+		}),
+	)
+	conn := ts.accept(t)
+
+	conn.WriteJSON(map[string]interface{}{"type": "request", "requestId": "r1", "method": "GET", "path": "/big"})
+	var start ResponseStart
+	readFrame(t, conn, &start)
+	if start.Type != MsgTypeResponseStart || start.ID != "r1" || start.Headers["Content-Type"] != "text/plain" {
+		t.Fatalf("Expected response_start, got %+v", start)
+	}
+	var got []byte
+	var frames int
+	for {
+		var chunk ResponseChunk
+		readFrame(t, conn, &chunk)
+		if len(chunk.Data) > 4096 {
+			t.Errorf("Chunk of %d bytes exceeds the threshold", len(chunk.Data))
+		}
+		got = append(got, chunk.Data...)
+		frames++
+		if chunk.Final {
+			break
+		}
+	}
+	if frames != 3 || !bytes.Equal(got, body) {
+		t.Errorf("Expected body in 3 chunks, got %d chunks and %d bytes", frames, len(got))
+	}
+
+	conn.WriteJSON(map[string]interface{}{"type": "request", "requestId": "r2", "method": "GET", "path": "/small"})
+	var resp IncomingResponse
+	readFrame(t, conn, &resp)
+	if resp.Type != MsgTypeResponse || string(resp.Body) != "ok" {
+		t.Errorf("Expected small body in a single response frame, got %+v", resp)
+	}
+}
@@ -2,11 +2,17 @@ package outray
 
 import (
 	"context"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"net/http"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -106,67 +112,387 @@ func WithResponseMiddleware(fn ResponseMiddleware) Option {
 	}
 }
 
+func WithUpstreamRetry(attempts int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.config.UpstreamRetryAttempts = attempts
+		c.config.UpstreamRetryBackoff = backoff
+	}
+}
+
+func WithUpstreamFallback(addrs ...string) Option {
+	return func(c *Client) {
+		c.config.UpstreamFallback = addrs
+	}
+}
+
+func WithUpstreamPool(maxIdleConns, maxIdleConnsPerHost int, idleTimeout time.Duration) Option {
+	return func(c *Client) {
+		c.config.MaxIdleConns = maxIdleConns
+		c.config.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		c.config.IdleConnTimeout = idleTimeout
+	}
+}
+
 type Config struct {
-	ServerURL          string
-	APIKey             string
-	Protocol           string
-	Port               int
-	RemotePort         int
-	Subdomain          string
-	CustomDomain       string
-	ForceTakeover      bool
-	RequestMiddleware  RequestMiddleware
-	ResponseMiddleware ResponseMiddleware
-	OnOpen             func(url string)
-	OnRequest          func(req IncomingRequest) IncomingResponse
-	OnError            func(err error)
+	ServerURL             string
+	APIKey                string
+	Protocol              string
+	Port                  int
+	RemotePort            int
+	Subdomain             string
+	CustomDomain          string
+	ForceTakeover         bool
+	RequestMiddleware     RequestMiddleware
+	ResponseMiddleware    ResponseMiddleware
+	UpstreamRetryAttempts int
+	UpstreamRetryBackoff  time.Duration
+	UpstreamFallback      []string
+	ErrorPages            map[int]string
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+	ConnectionPool        int
+	PriorityWeights       map[Priority]int
+	MaxTCPConnections     int
+	MaxUDPSessions        int
+	UDPSessionTimeout     time.Duration
+	RejectPolicy          RejectPolicy
+	IdleTimeout           time.Duration
+	StatsDAddr            string
+	StatsDTags            map[string]string
+	ClientInfo            ClientInfo
+	OnDeprecation         func(notice DeprecationNotice)
+	OnUpdateAvailable     func(u UpdateNotice)
+	CrashReports          *CrashReports
+	OnWarning             func(w Warning)
+	Quota                 Quota
+	QuotaThresholds       []float64
+	OnQuotaThreshold      func(t QuotaThreshold)
+	Journal               *Journal
+	FanOut                []string
+	Schedule              string
+	HandshakeProof        HandshakeProof
+	Redaction             []RedactionRule
+	GeoIP                 GeoIPResolver
+	TrustedProxies        []*net.IPNet
+	AllowCountries        []string
+	DenyCountries         []string
+	RouteTemplates        []string
+	SlowRequest           time.Duration
+	ResourceLimits        ResourceLimits
+	DrainTimeout          time.Duration
+	PreferredURL          string
+	MDNS                  bool
+	MDNSName              string
+	TokenScope            *TokenScope
+	ResponseKey           ed25519.PrivateKey
+	ClientAuth            *ClientAuth
+	JWTKeys               JWTKeySource
+	JWTRequirements       JWTRequirements
+	Policy                *Policy
+	Honeypot              *Honeypot
+	PayloadChecksums      bool
+	ChunkThreshold        int
+	EventSinks            []EventSink
+	DNSProvider           DNSProvider
+	TLSTermination        *tls.Config
+	ACME                  *ACME
+	SNIRoutes             map[string]int
+	ALPNRoutes            map[string]int
+	OnTLSStream           func(info TLSInfo)
+	ProxyProtocol         int
+	Postgres              *PostgresOptions
+	CommandProtocol       string
+	SSH                   *SSHOptions
+	SSHAuth               *SSHAuth
+	LatencyProfile        LatencyProfile
+	DNSServer             *DNSServerOptions
+	PeerTransport         PeerTransport
+	LargePayload          int
+	MessageTap            MessageTap
+	E2EKey                []byte
+	FrameSecret           []byte
+	Compression           *CompressionRules
+	OnUploadProgress      func(p UploadProgress)
+	StreamChunkSize       int
+	DialHeaders           http.Header
+	Subprotocols          []string
+	ServerFlavor          ServerFlavor
+	PathPrefix            string
+	HandshakeFields       map[string]interface{}
+	IPFamily              IPFamily
+	UpstreamDialer        *net.Dialer
+	Resolver              Resolver
+	DNSCacheTTL           time.Duration
+	StaticHosts           map[string]string
+	CoalesceWindow        time.Duration
+	CoalesceMaxBytes      int
+	Upstream              Upstream
+	KeepAliveInterval     time.Duration
+	KeepAliveTimeout      time.Duration
+	OnOpen                func(url string)
+	OnRequest             func(req IncomingRequest) IncomingResponse
+	OnRequestAsync        AsyncRequestHandler
+	OnError               func(err error)
 }
 
 type Client struct {
-	config Config
-	conn   *websocket.Conn
-	mu     sync.Mutex
-	closed bool
-	logger Logger
-
-	tcpConns   map[string]net.Conn
+	config   Config // staging area for options, see applyOptions
+	live     atomic.Pointer[liveConfig]
+	configMu sync.Mutex
+	conn     *websocket.Conn
+	writer   *frameWriter
+	mu       sync.Mutex
+	closed   bool
+	logger   Logger
+
+	dns dnsCache
+
+	tcpConns   map[string]*tcpStream
 	tcpConnsMu sync.Mutex
+
+	pool   []*poolConn
+	pinned map[string]*poolConn // stream ID to its pool connection, nil for the main one
+	poolMu sync.RWMutex
+
+	stats          stats
+	tcpActive      int64
+	lastActivity   int64
+	idleExpired    int32
+	dataCapReached int32
+	udpSessions    map[string]*udpSource
+	udpSessionsMu  sync.Mutex
+
+	uploads   map[string]*upload
+	uploadsMu sync.Mutex
+
+	inflight   map[string]*inflightRequest
+	inflightMu sync.Mutex
+
+	listener   *tunnelListener
+	listenerMu sync.Mutex
+
+	tunnels   map[string]*TunnelSpec
+	tunnelsMu sync.Mutex
+
+	replies   map[string]chan []byte
+	repliesMu sync.Mutex
+
+	quota   quotaState
+	quotaMu sync.Mutex
+
+	capabilities  []string
+	reconfiguring int32
+	publicURL     string
+	state         int32
+	skew          int64
+	heartbeatAck  uint64 // highest heartbeat seq the server acknowledged
+	audit         *auditLog
+	countries     countryStats
+	routes        routeStats
+	commands      commandStats
+	goroutines    int64
+	pendingWrites int64
+	bufferedBytes int64
+	shuttingDown  int32
+	draining      int32
+	mdns          *mdnsAdvertiser
+	mdnsMu        sync.Mutex
+	configErr     error
+	probes        probeLog
+	anomalies     *anomalyDetector
+	bans          banList
+	epoch         uint64
+	events        eventQueue
+	dnsRecords    []DNSRecord
+	acme          acmeState
+	fairq         *fairQueue
+	gameUDP       *gameUDP
+	dataCap       *dataCapState
+	session       *sessionStore
+	peers         peerLinks
+	update        UpdateNotice
+	crashes       crashState
+	connDone      chan struct{}
+	wg            sync.WaitGroup
+	tui           *tui
+	sshAnnounced  string
 }
 
 func NewClient(opts ...Option) *Client {
 	c := &Client{
 		config: Config{
-			ServerURL: "wss://api.outray.dev",
-			Protocol:  "http",
+			ServerURL:           "wss://api.outray.dev",
+			Protocol:            "http",
+			KeepAliveInterval:   9 * time.Second,
+			KeepAliveTimeout:    30 * time.Second,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+			UDPSessionTimeout:   time.Minute,
 		},
-		tcpConns: make(map[string]net.Conn),
+		tcpConns:    make(map[string]*tcpStream),
+		udpSessions: make(map[string]*udpSource),
+		uploads:     make(map[string]*upload),
+		inflight:    make(map[string]*inflightRequest),
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.restoreSession()
+	c.applyOptions()
+	c.setQuota(c.conf().Quota)
 	return c
 }
 
+// liveConfig is the configuration in effect along with the upstream client
+// and cipher built from it. They are published together so a reader never
+// pairs a new config with an old cipher.
+type liveConfig struct {
+	Config
+	httpClient *http.Client
+	e2e        cipher.AEAD
+}
+
+// conf returns the configuration in effect. Options write to c.config,
+// which is only touched under configMu once NewClient returns; everything
+// else reads the snapshot applyOptions publishes.
+func (c *Client) conf() *Config {
+	return &c.live.Load().Config
+}
+
+func (c *Client) upstreamClient() *http.Client {
+	return c.live.Load().httpClient
+}
+
+func (c *Client) e2eCipher() cipher.AEAD {
+	return c.live.Load().e2e
+}
+
+// applyOptions applies opts to c.config and publishes the result. The
+// upstream client is kept unless its pool settings changed, in which case
+// the old one's idle connections are closed once it has been replaced.
+func (c *Client) applyOptions(opts ...Option) (prev Config) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	old := c.live.Load()
+	if old != nil {
+		prev = old.Config
+		// Options add to these in place; give them copies so the published
+		// maps are never written.
+		c.config.ALPNRoutes = maps.Clone(c.config.ALPNRoutes)
+		c.config.SNIRoutes = maps.Clone(c.config.SNIRoutes)
+		c.config.ErrorPages = maps.Clone(c.config.ErrorPages)
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	next := &liveConfig{Config: c.config, e2e: newE2ECipher(c.config.E2EKey)}
+	if old != nil && samePool(old.Config, next.Config) {
+		next.httpClient = old.httpClient
+	} else {
+		next.httpClient = c.newHTTPClient(&next.Config)
+	}
+	c.live.Store(next)
+	if old != nil && old.httpClient != next.httpClient {
+		old.httpClient.CloseIdleConnections()
+	}
+	return prev
+}
+
 func (c *Client) Connect(ctx context.Context) error {
+	if c.configErr != nil {
+		return c.configErr
+	}
+	if c.conf().CrashReports != nil {
+		c.spawn(c.sendCrashReports)
+	}
+	if c.dataCap != nil {
+		return c.connectCapped(ctx)
+	}
+	return c.connectWindows(ctx)
+}
+
+// connectWindows connects for as long as the schedule, if any, allows.
+func (c *Client) connectWindows(ctx context.Context) error {
+	if c.conf().Schedule != "" {
+		return c.connectScheduled(ctx)
+	}
+	return c.connect(ctx)
+}
+
+func (c *Client) connect(ctx context.Context) (err error) {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
 	backoff := time.Second
 	maxBackoff := 30 * time.Second
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer func() {
+		c.stopAdvertising()
+		c.setState(StateClosed)
+		c.auditf(AuditTunnelClose, map[string]string{"reason": fmt.Sprint(err)})
+		c.notify(Event{Type: EventTunnelClosed, URL: c.Status().URL, Error: errorString(err)})
+	}()
+
+	c.touch()
+	atomic.StoreInt32(&c.idleExpired, 0)
+	atomic.StoreInt32(&c.dataCapReached, 0)
+	atomic.StoreInt32(&c.shuttingDown, 0)
+	if c.conf().IdleTimeout > 0 {
+		c.spawn(func() { c.watchIdle(ctx, cancel) })
+	}
+	if c.conf().StatsDAddr != "" {
+		c.spawn(func() { c.runStatsD(ctx) })
+	}
+	if c.conf().OnQuotaThreshold != nil {
+		c.spawn(func() { c.watchQuota(ctx) })
+	}
+	if c.dataCap != nil {
+		c.spawn(func() { c.watchDataCap(ctx, cancel) })
+	}
+	if c.session != nil {
+		c.spawn(func() { c.watchSession(ctx) })
+	}
+	if c.tui != nil {
+		c.spawn(func() { c.runTUI(ctx) })
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return c.contextErr(ctx)
 		default:
 		}
+		if c.stopping() {
+			return nil
+		}
 
+		c.setState(StateConnecting)
 		if err := c.connectOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return c.contextErr(ctx)
+			}
+			if c.stopping() {
+				return nil
+			}
+			if isLocalScopeError(err) {
+				return err
+			}
+			c.setState(StateReconnecting)
+			c.auditDisconnect(err)
+			c.notify(Event{Type: EventDisconnected, Error: err.Error()})
+			atomic.AddUint64(&c.stats.reconnects, 1)
 			c.logf("Connection error: %v. Retrying in %v...", err, backoff)
-			if c.config.OnError != nil {
+			if c.conf().OnError != nil {
 				c.safeOnError(err)
 			}
 
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
+				return c.contextErr(ctx)
 			case <-time.After(backoff):
 				backoff *= 2
 				if backoff > maxBackoff {
@@ -180,65 +506,86 @@ func (c *Client) Connect(ctx context.Context) error {
 }
 
 func (c *Client) connectOnce(ctx context.Context) error {
-	dialer := websocket.DefaultDialer
-	conn, _, err := dialer.DialContext(ctx, c.config.ServerURL, nil)
+	if err := checkScope(c.conf().TokenScope, c.openTunnelRequest(MsgTypeOpenTunnel)); err != nil {
+		return err
+	}
+
+	conn, err := c.dial(ctx)
 	if err != nil {
 		return err
 	}
 
 	c.mu.Lock()
 	c.conn = conn
+	c.capabilities = nil
+	frames := c.newFrameSession()
+	writer := c.newWriter(conn, frames)
+	c.writer = writer
+	c.epoch++
+	epoch := c.epoch
+	// Pool connections belong to the tunnel the last connection opened.
+	c.closePool()
+	c.spawn(writer.run)
+	done := make(chan struct{})
+	c.connDone = done
 	c.closed = false
 	c.mu.Unlock()
 
 	defer c.Close()
+	defer close(done)
 
-	const (
-		pingPeriod = 9 * time.Second
-	)
-
-	go func() {
-		ticker := time.NewTicker(pingPeriod)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				c.mu.Lock()
-				if c.closed {
-					c.mu.Unlock()
-					return
-				}
-				if err := c.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(5*time.Second)); err != nil {
-					c.mu.Unlock()
-					c.logf("Ping failed: %v", err)
-					return
-				}
-				c.mu.Unlock()
-			}
+	c.spawn(func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
 		}
-	}()
+	})
 
-	handshake := OpenTunnelRequest{
-		Type:          MsgTypeOpenTunnel,
-		APIKey:        c.config.APIKey,
-		Protocol:      c.config.Protocol,
-		Port:          c.config.RemotePort,
-		Subdomain:     c.config.Subdomain,
-		CustomDomain:  c.config.CustomDomain,
-		ForceTakeover: c.config.ForceTakeover,
-	}
+	c.keepAlive(conn, writer, done)
+	c.spawn(func() { c.heartbeat(conn, done) })
 
-	if err := c.conn.WriteJSON(handshake); err != nil {
+	handshake := c.openTunnelRequest(MsgTypeOpenTunnel)
+	handshake.FrameNonce = frames.nonce
+	if err := c.writeJSON(PriorityControl, c.handshakeFrame(handshake)); err != nil {
 		return fmt.Errorf("failed to send handshake: %w", err)
 	}
 
-	return c.readLoop()
+	err = c.readLoop(conn, epoch, frames)
+	if isTimeout(err) && ctx.Err() == nil {
+		c.drain()
+	}
+	if atomic.CompareAndSwapInt32(&c.reconfiguring, 1, 0) {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) openTunnelRequest(msgType string) OpenTunnelRequest {
+	handshake := OpenTunnelRequest{
+		Type:          msgType,
+		APIKey:        c.conf().APIKey,
+		Protocol:      c.conf().Protocol,
+		Port:          c.conf().RemotePort,
+		Subdomain:     c.conf().Subdomain,
+		CustomDomain:  c.conf().CustomDomain,
+		ForceTakeover: c.conf().ForceTakeover,
+		Client:        c.clientInfo(),
+		PreferredURL:  c.conf().PreferredURL,
+		Scope:         c.conf().TokenScope,
+		ClientAuth:    c.conf().ClientAuth,
+		SSHAuth:       c.conf().SSHAuth,
+		WebRTC:        c.conf().PeerTransport != nil,
+		Checksums:     c.conf().PayloadChecksums,
+		Resume:        c.sessionResume(),
+	}
+	c.applyTunnels(&handshake)
+	c.proveHandshake(&handshake)
+	return handshake
 }
 
 func (c *Client) Close() error {
+	c.saveSession(c.Status().URL)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.closed {
@@ -247,11 +594,22 @@ func (c *Client) Close() error {
 	c.closed = true
 
 	c.tcpConnsMu.Lock()
-	for _, conn := range c.tcpConns {
-		conn.Close()
-	}
-	c.tcpConns = make(map[string]net.Conn)
+	streams := c.tcpConns
+	c.tcpConns = make(map[string]*tcpStream)
 	c.tcpConnsMu.Unlock()
+	for id, stream := range streams {
+		c.finishTCP(id, stream)
+	}
+	c.closeGameSessions()
+	c.closePeerLinks()
+
+	c.closePool()
+	c.abortUploads(errClientClosed)
+	c.cancelAllRequests()
+
+	if c.writer != nil {
+		c.writer.close()
+	}
 
 	if c.conn != nil {
 		return c.conn.Close()
@@ -260,97 +618,310 @@ func (c *Client) Close() error {
 }
 
 func (c *Client) SendResponse(resp IncomingResponse) error {
+	return c.sendResponse(0, resp)
+}
+
+func (c *Client) sendResponse(epoch uint64, resp IncomingResponse) error {
+	resp.Type = MsgTypeResponse
+	return c.writeStreamJSONOn(epoch, resp.ID, PriorityHTTP, resp)
+}
+
+// connEpoch numbers the current connection; it increases on every
+// reconnect.
+func (c *Client) connEpoch() uint64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.closed {
-		return errors.New("client is closed")
-	}
-	resp.Type = MsgTypeResponse
-	return c.conn.WriteJSON(resp)
+	return c.epoch
 }
 
 func (c *Client) safeCallback(fn func()) {
 	defer func() {
 		if r := recover(); r != nil {
 			c.logf("Panic in callback: %v", r)
+			c.reportCrash("callback", r, debug.Stack(), true)
 		}
 	}()
 	fn()
 }
 
 func (c *Client) safeOnError(err error) {
-	c.safeCallback(func() { c.config.OnError(err) })
+	c.safeCallback(func() { c.conf().OnError(err) })
 }
 
-func (c *Client) readLoop() error {
+// readLoop handles frames from conn, which is connection number epoch and
+// signs its frames for fs.
+func (c *Client) readLoop(conn *websocket.Conn, epoch uint64, fs *frameSession) error {
 	for {
-		var raw map[string]interface{}
-		if err := c.conn.ReadJSON(&raw); err != nil {
+		_, r, err := conn.NextReader()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(c.readTimeout()))
+
+		buf := getBuffer()
+		if _, err := buf.ReadFrom(r); err != nil {
+			putBuffer(buf)
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 				return nil
 			}
 			return err
 		}
+		c.handleFrame(buf.Bytes(), epoch, fs)
+		putBuffer(buf)
+	}
+}
 
-		msgType, ok := raw["type"].(string)
-		if !ok {
-			continue
+// handleFrame verifies and handles one inbound frame from a connection
+// whose signing state is fs, dropping it if it panics so that malformed
+// input can't take down the read loop.
+func (c *Client) handleFrame(data []byte, epoch uint64, fs *frameSession) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logf("Dropped inbound frame: panic: %v", r)
+			c.reportCrash("inbound frame", r, debug.Stack(), true)
 		}
+	}()
+	data, err := c.verifyFrame(data, fs)
+	if err != nil {
+		c.logf("Dropped inbound frame: %v", err)
+		if c.conf().OnError != nil {
+			c.safeOnError(err)
+		}
+		return
+	}
+	c.handleMessage(data, epoch)
+}
 
-		switch msgType {
-		case MsgTypeTunnelOpened:
-			if c.config.OnOpen != nil {
-				url, _ := raw["url"].(string)
-				c.safeCallback(func() { c.config.OnOpen(url) })
+// handleMessage handles a frame received on connection epoch. Streams and
+// requests it starts are bound to that connection.
+func (c *Client) handleMessage(data []byte, epoch uint64) {
+	data = c.normalizeMessage(data)
+
+	var env messageEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return
+	}
+	c.touch()
+	c.tap(Inbound, env.Type, data)
+
+	switch env.Type {
+	case MsgTypeTunnelOpened:
+		var msg TunnelOpened
+		json.Unmarshal(data, &msg)
+		c.skewFromServerTime(msg.ServerTime, time.Now())
+		if msg.Quota != nil {
+			c.setQuota(*msg.Quota)
+		}
+		c.mu.Lock()
+		c.capabilities = msg.Capabilities
+		c.publicURL = msg.URL
+		c.dnsRecords = msg.DNSRecords
+		connDone := c.connDone
+		c.mu.Unlock()
+		if len(msg.DNSRecords) > 0 {
+			c.spawn(func() { c.provisionDNS(msg.DNSRecords, connDone) })
+		}
+		if c.conf().ACME != nil {
+			c.spawn(func() { c.ensureCertificate(msg.URL, connDone) })
+		}
+		c.setState(StateConnected)
+		c.auditf(AuditTunnelOpen, map[string]string{"url": msg.URL})
+		c.checkPreferredURL(msg.URL)
+		c.sessionOpened(msg)
+		c.checkUpdate(msg.Update)
+		c.checkClientCertSupport()
+		c.checkSSHKeyGating()
+		if c.conf().MDNS {
+			c.advertise(msg.URL)
+		}
+		if c.conf().ConnectionPool > 1 {
+			c.spawn(func() { c.openPool(msg.TunnelID, epoch) })
+		}
+		c.announceSSH(msg.URL)
+		if c.conf().OnOpen != nil {
+			c.safeCallback(func() { c.conf().OnOpen(msg.URL) })
+		}
+		c.notify(Event{Type: EventTunnelOpened, URL: msg.URL})
+	case MsgTypeTCPConnection:
+		var msg TCPConnection
+		if err := json.Unmarshal(data, &msg); err != nil || msg.ID == "" {
+			break
+		}
+		if reason := c.overloaded(); reason != "" {
+			c.shed(reason)
+			c.rejectStream(StreamRejected{Protocol: "tcp", ConnectionID: msg.ID, Reason: reason})
+		} else {
+			c.spawn(func() { c.handleTCPConnection(msg, epoch) })
+		}
+	case MsgTypeTCPData:
+		var msg TCPData
+		if err := json.Unmarshal(data, &msg); err == nil {
+			c.handleTCPData(msg)
+		}
+	case MsgTypeTCPHalfClose, MsgTypeTCPClose:
+		var msg TCPClose
+		if err := json.Unmarshal(data, &msg); err == nil {
+			if env.Type == MsgTypeTCPHalfClose {
+				c.handleTCPHalfClose(msg.ConnectionID, msg.Seq)
+			} else {
+				c.handleTCPClose(msg.ConnectionID)
 			}
-		case MsgTypeTCPConnection:
-			connID, _ := raw["connectionId"].(string)
-			go c.handleTCPConnection(connID)
-		case MsgTypeTCPData:
-			connID, _ := raw["connectionId"].(string)
-			data, _ := raw["data"].(string)
-			c.handleTCPData(connID, data)
-		case MsgTypeUDPData:
-			var packet UDPData
-			bytes, _ := json.Marshal(raw)
-			if err := json.Unmarshal(bytes, &packet); err == nil {
-				go c.handleUDPData(packet)
+		}
+	case MsgTypeUDPData:
+		var packet UDPData
+		if err := json.Unmarshal(data, &packet); err != nil {
+			break
+		}
+		packet.epoch = epoch
+		if reason := c.overloaded(); reason != "" {
+			c.shed(reason)
+			c.rejectStream(StreamRejected{Protocol: "udp", PacketID: packet.PacketID, Reason: reason})
+		} else {
+			c.spawn(func() { c.handleUDPData(packet) })
+		}
+	case MsgTypeRequest:
+		var req IncomingRequest
+		if err := json.Unmarshal(data, &req); err == nil {
+			req.received = time.Now()
+			req.epoch = epoch
+			if reason := c.overloaded(); reason != "" {
+				c.rejectOverloaded(req, reason)
+			} else if req.Streaming {
+				c.startUpload(req)
+			} else {
+				c.handleRequest(req)
 			}
-		case MsgTypeRequest:
-			data, _ := json.Marshal(raw)
-			var req IncomingRequest
-			if err := json.Unmarshal(data, &req); err == nil {
-				if c.config.OnRequest != nil {
-					c.safeCallback(func() {
-						resp := c.config.OnRequest(req)
-						resp.ID = req.ID
-						if err := c.SendResponse(resp); err != nil {
-							if c.config.OnError != nil {
-								c.safeOnError(fmt.Errorf("send response error: %w", err))
-							}
-						}
-					})
-				} else if c.config.Port > 0 && c.config.Protocol == "http" {
-					go func() {
-						resp := c.proxyHTTP(req)
-						resp.ID = req.ID
-						if err := c.SendResponse(resp); err != nil {
-							if c.config.OnError != nil {
-								c.safeOnError(fmt.Errorf("proxy send response error: %w", err))
-							}
-						}
-					}()
-				}
+		}
+	case MsgTypeRequestCancel:
+		var msg RequestCancel
+		if err := json.Unmarshal(data, &msg); err == nil {
+			c.cancelRequest(msg.ID)
+			c.abortUpload(msg.ID, context.Canceled)
+		}
+	case MsgTypeRequestChunk:
+		var chunk RequestChunk
+		if err := json.Unmarshal(data, &chunk); err == nil {
+			c.handleRequestChunk(chunk)
+		}
+	case MsgTypeDeprecation:
+		var notice DeprecationNotice
+		if err := json.Unmarshal(data, &notice); err == nil {
+			c.handleDeprecation(notice)
+		}
+	case MsgTypePeerOffer:
+		var offer PeerOffer
+		if err := json.Unmarshal(data, &offer); err == nil && offer.PeerID != "" {
+			c.spawn(func() { c.handlePeerOffer(offer) })
+		}
+	case MsgTypeShareLink, MsgTypeACMEChallengeSet:
+		c.handleReply(data)
+	case MsgTypeHeartbeatAck:
+		var ack Heartbeat
+		if err := json.Unmarshal(data, &ack); err == nil {
+			c.handleHeartbeatAck(ack.Seq)
+		}
+	case MsgTypeWarning:
+		var w Warning
+		if err := json.Unmarshal(data, &w); err == nil {
+			c.handleWarning(w)
+		}
+	case MsgTypeError:
+		var msg ServerMessage
+		json.Unmarshal(data, &msg)
+		serr := newServerError(msg)
+		if serr.authFailure() {
+			c.auditf(AuditAuthFailure, map[string]string{"code": serr.Code, "message": serr.Message})
+		}
+		c.notify(Event{Type: EventError, Error: serr.Error()})
+		if c.conf().OnError != nil {
+			c.safeOnError(scopeError(serr))
+		}
+	}
+}
+
+func (c *Client) handleRequest(req IncomingRequest) {
+	if err := c.unsealRequest(&req); err != nil {
+		c.logf("Rejected request %s: %v", req.ID, err)
+		c.respond(req, IncomingResponse{StatusCode: 400, Body: []byte(err.Error())}, "send response error")
+		return
+	}
+	c.dispatchRequest(req)
+}
+
+// admit applies the access filters, responding itself when req is turned
+// away.
+func (c *Client) admit(req *IncomingRequest) bool {
+	if c.banned(c.clientIP(*req)) {
+		c.rejectBanned(*req)
+		return false
+	}
+	if !c.filterCountry(req) {
+		c.rejectCountry(*req)
+		return false
+	}
+	if !c.filterClientCert(*req) {
+		c.rejectClientCert(*req)
+		return false
+	}
+	return c.authorize(req)
+}
+
+func (c *Client) dispatchRequest(req IncomingRequest) {
+	if !c.admit(&req) {
+		return
+	}
+	if len(c.conf().FanOut) > 0 {
+		c.fanOut(req)
+	}
+	if c.conf().OnRequestAsync != nil {
+		w := c.newResponseWriter(req)
+		c.safeCallback(func() { c.conf().OnRequestAsync(req, w) })
+	} else if c.conf().OnRequest != nil {
+		c.safeCallback(func() {
+			c.respond(req, c.conf().OnRequest(req), "send response error")
+		})
+	} else if c.serves("http") && c.hasUpstream("http") {
+		c.spawn(func() {
+			ctx, done := c.trackRequest(req)
+			defer done()
+			release, ok := c.throttle(ctx, req)
+			if !ok {
+				return
 			}
-		case MsgTypeError:
-			if c.config.OnError != nil {
-				msg, _ := raw["message"].(string)
-				c.safeOnError(errors.New(msg))
+			defer release()
+			if c.conf().StreamChunkSize > 0 {
+				c.streamHTTP(ctx, req)
+				return
 			}
+			c.respond(req, c.proxyHTTP(ctx, req), "proxy send response error")
+		})
+	}
+}
+
+func (c *Client) respond(req IncomingRequest, resp IncomingResponse, errPrefix string) {
+	if err := c.respondErr(req, resp); err != nil {
+		if c.conf().OnError != nil {
+			c.safeOnError(fmt.Errorf("%s: %w", errPrefix, err))
 		}
 	}
 }
 
+func (c *Client) respondErr(req IncomingRequest, resp IncomingResponse) error {
+	resp.ID = req.ID
+	c.stats.addRequest(resp, len(req.Body))
+	c.signResponse(req, &resp)
+	c.record(req, resp)
+	c.compressResponse(req, &resp)
+	if c.chunked(resp) {
+		return c.sendChunked(req.epoch, resp)
+	}
+	c.sealResponse(&resp)
+	return c.sendResponse(req.epoch, resp)
+}
+
 func (c *Client) logf(format string, v ...interface{}) {
 	if c.logger != nil {
 		c.logger.Printf(format, v...)
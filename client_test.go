@@ -1,8 +1,13 @@
 package outray
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
@@ -10,7 +15,7 @@ func TestConfig(t *testing.T) {
 		WithServerURL("ws://localhost"),
 		WithAPIKey("test"),
 	)
-	if c.config.ServerURL != "ws://localhost" {
+	if c.conf().ServerURL != "ws://localhost" {
 		t.Errorf("Expected ServerURL to be set")
 	}
 }
@@ -37,3 +42,129 @@ func TestJSONMarshaling(t *testing.T) {
 		t.Error("JSON roundtrip failed")
 	}
 }
+
+func TestConnectionCaps(t *testing.T) {
+	c := NewClient(WithMaxTCPConnections(1), WithMaxUDPSessions(1))
+	if !c.acquireTCPSlot() {
+		t.Fatal("Expected first TCP slot")
+	}
+	if c.acquireTCPSlot() {
+		t.Error("Expected second TCP slot to be rejected")
+	}
+	c.releaseTCPSlot()
+	if !c.acquireTCPSlot() {
+		t.Error("Expected slot after release")
+	}
+
+	if !c.acquireUDPSession("1.2.3.4:5", 0) || !c.acquireUDPSession("1.2.3.4:5", 0) {
+		t.Fatal("Expected packets from the same source to share a session")
+	}
+	if c.acquireUDPSession("5.6.7.8:9", 0) {
+		t.Error("Expected new UDP session to be rejected")
+	}
+}
+
+func TestUDPSessionTimeout(t *testing.T) {
+	c := NewClient(WithMaxUDPSessions(1), WithUDPSessionTimeout(50*time.Millisecond))
+	if !c.acquireUDPSession("1.2.3.4:5", 0) {
+		t.Fatal("Expected first UDP session")
+	}
+	// The session outlives its packet, so another source has to wait for
+	// it to go idle.
+	if c.acquireUDPSession("5.6.7.8:9", 0) {
+		t.Error("Expected a second source to be rejected while the first is active")
+	}
+	if n := c.Stats().UDPSessions; n != 1 {
+		t.Errorf("Expected one active session, got %d", n)
+	}
+
+	c.holdUDPSession("1.2.3.4:5")
+	time.Sleep(60 * time.Millisecond)
+	if c.acquireUDPSession("5.6.7.8:9", 0) {
+		t.Error("Expected a held session not to expire")
+	}
+	c.releaseUDPSession("1.2.3.4:5")
+	if !c.acquireUDPSession("5.6.7.8:9", 0) {
+		t.Error("Expected a released session to make room")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if n := c.Stats().UDPSessions; n != 0 {
+		t.Errorf("Expected the idle session to expire, got %d", n)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	c := NewClient(
+		WithServerURL("ws://127.0.0.1:1"),
+		WithIdleTimeout(50*time.Millisecond),
+	)
+	if err := c.Connect(context.Background()); !errors.Is(err, ErrIdleTimeout) {
+		t.Errorf("Expected ErrIdleTimeout, got %v", err)
+	}
+}
+
+func TestSelfHostedFlavor(t *testing.T) {
+	c := NewClient(
+		WithServerURL("https://relay.example.com/base"),
+		WithServerFlavor(SelfHosted),
+		WithPathPrefix("/outray"),
+	)
+	if got := c.serverURL(); got != "wss://relay.example.com/outray/base" {
+		t.Errorf("Unexpected server URL %q", got)
+	}
+
+	var opened TunnelOpened
+	json.Unmarshal(c.normalizeMessage([]byte(`{"type":"tunnel_opened","payload":{"publicUrl":"https://x.example.com"}}`)), &opened)
+	if opened.URL != "https://x.example.com" {
+		t.Errorf("Expected nested publicUrl to be normalized, got %q", opened.URL)
+	}
+}
+
+func TestDialUpstreamIPv6Loopback(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback unavailable")
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	c := NewClient(WithPort(port), WithIPFamily(IPv6Only))
+	conn, err := c.dialUpstream("tcp")
+	if err != nil {
+		t.Fatalf("Expected to reach ::1-only service: %v", err)
+	}
+	conn.Close()
+}
+
+func TestProtocolHandlers(t *testing.T) {
+	web := &VirtualBackend{HTTP: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("web"))
+	})}
+	c := NewClient(WithPort(9999))
+	c.HTTP(0, WithTunnelUpstream(web), WithTunnelSubdomain("app")).TCP(5432, WithTunnelRemotePort(25432))
+
+	if !c.serves("http") || !c.serves("tcp") || c.serves("udp") {
+		t.Error("Expected only registered protocols to be served")
+	}
+	resp := c.proxyHTTP(context.Background(), IncomingRequest{Method: "GET", Path: "/"})
+	if string(resp.Body) != "web" {
+		t.Errorf("Expected HTTP handler upstream, got %d %q", resp.StatusCode, resp.Body)
+	}
+	if lu, ok := c.upstream("tcp").(*localUpstream); !ok || lu.addrs()[0] != "localhost:5432" {
+		t.Errorf("Expected TCP handler to dial its own port, got %#v", c.upstream("tcp"))
+	}
+
+	var handshake OpenTunnelRequest
+	c.applyTunnels(&handshake)
+	if handshake.Protocol != "http" || handshake.Subdomain != "app" || len(handshake.Tunnels) != 2 {
+		t.Errorf("Unexpected handshake: %+v", handshake)
+	}
+	if handshake.Tunnels[1].Protocol != "tcp" || handshake.Tunnels[1].RemotePort != 25432 {
+		t.Errorf("Unexpected TCP tunnel spec: %+v", handshake.Tunnels[1])
+	}
+}
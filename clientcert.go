@@ -0,0 +1,73 @@
+package outray
+
+import (
+	"net/http"
+	"time"
+)
+
+// ClientAuthMode asks the edge to demand TLS client certificates from
+// visitors of the public URL.
+type ClientAuthMode string
+
+const (
+	// ClientCertRequest asks for a certificate but lets visitors without
+	// one through; check IncomingRequest.ClientCert to decide.
+	ClientCertRequest ClientAuthMode = "request"
+	// ClientCertRequire rejects visitors without a certificate that chains
+	// to the configured CAs.
+	ClientCertRequire ClientAuthMode = "require"
+)
+
+// CapClientCertAuth is advertised in tunnel_opened by servers that can
+// verify client certificates at the edge.
+const CapClientCertAuth = "client_cert_auth"
+
+// WarnClientCertUnsupported is raised locally when client certificates were
+// requested but the server did not advertise support for them.
+const WarnClientCertUnsupported = "CLIENT_CERT_UNSUPPORTED"
+
+// ClientCert identifies the certificate a visitor presented at the edge.
+type ClientCert struct {
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serialNumber"`
+	Fingerprint  string    `json:"fingerprint"` // hex SHA-256 of the DER certificate
+	DNSNames     []string  `json:"dnsNames,omitempty"`
+	Emails       []string  `json:"emails,omitempty"`
+	NotAfter     time.Time `json:"notAfter"`
+}
+
+// ClientAuth is the client certificate policy sent in the handshake.
+type ClientAuth struct {
+	Mode ClientAuthMode `json:"mode"`
+	CAs  string         `json:"cas,omitempty"`
+}
+
+// WithClientCertAuth asks the edge to verify visitor certificates against
+// the PEM-encoded caPEM bundle. In ClientCertRequire mode the client also
+// rejects, with 403, any request that arrives without a verified
+// certificate, in case the server cannot enforce it.
+func WithClientCertAuth(mode ClientAuthMode, caPEM []byte) Option {
+	return func(c *Client) {
+		c.config.ClientAuth = &ClientAuth{Mode: mode, CAs: string(caPEM)}
+	}
+}
+
+func (c *Client) checkClientCertSupport() {
+	if c.conf().ClientAuth == nil || c.hasCapability(CapClientCertAuth) {
+		return
+	}
+	c.handleWarning(Warning{
+		Type:    MsgTypeWarning,
+		Code:    WarnClientCertUnsupported,
+		Message: "server did not confirm client certificate verification",
+	})
+}
+
+func (c *Client) filterClientCert(req IncomingRequest) bool {
+	return c.conf().ClientAuth == nil || c.conf().ClientAuth.Mode != ClientCertRequire || req.ClientCert != nil
+}
+
+func (c *Client) rejectClientCert(req IncomingRequest) {
+	c.reject(req, IncomingResponse{StatusCode: http.StatusForbidden, Body: []byte("Client certificate required")}, "client certificate required")
+}
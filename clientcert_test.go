@@ -0,0 +1,38 @@
+package outray
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClientCertAuth(t *testing.T) {
+	var seen []string
+	var warnings []string
+	c := NewClient(
+		WithClientCertAuth(ClientCertRequire, []byte("-----BEGIN CERTIFICATE-----")),
+		WithOnWarning(func(w Warning) { warnings = append(warnings, w.Code) }),
+		WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			seen = append(seen, req.ClientCert.Subject)
+			return IncomingResponse{StatusCode: 200}
+		}),
+	)
+	c.closed = true
+
+	if h := c.openTunnelRequest(MsgTypeOpenTunnel); h.ClientAuth == nil || h.ClientAuth.Mode != ClientCertRequire {
+		t.Errorf("Expected client auth in handshake, got %+v", h.ClientAuth)
+	}
+
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://a.outray.dev"}`), 0)
+	if len(warnings) != 1 || warnings[0] != WarnClientCertUnsupported {
+		t.Errorf("Expected unsupported warning, got %v", warnings)
+	}
+
+	var req IncomingRequest
+	json.Unmarshal([]byte(`{"requestId":"1","clientCert":{"subject":"CN=alice","fingerprint":"ab"}}`), &req)
+	c.dispatchRequest(req)
+	c.dispatchRequest(IncomingRequest{ID: "2"})
+
+	if len(seen) != 1 || seen[0] != "CN=alice" {
+		t.Errorf("Expected only the request with a certificate to pass, got %v", seen)
+	}
+}
@@ -0,0 +1,69 @@
+package outray
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// WarnClockSkew is raised locally when the system clock differs from the
+// server's by more than maxClockSkew.
+const WarnClockSkew = "CLOCK_SKEW"
+
+const maxClockSkew = 30 * time.Second
+
+// now returns the current time corrected by the detected clock skew. Use it
+// for anything the server validates, such as handshake timestamps.
+func (c *Client) now() time.Time {
+	return time.Now().Add(c.clockSkew())
+}
+
+func (c *Client) clockSkew() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.skew))
+}
+
+// skewFromDate estimates skew from the HTTP Date header of the WebSocket
+// upgrade response. It only has second resolution, so it's replaced by
+// serverTime from tunnel_opened when the server sends one.
+func (c *Client) skewFromDate(resp *http.Response, sent, received time.Time) {
+	if resp == nil {
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	c.setSkew(date.Add(500*time.Millisecond).Sub(midpoint), time.Second)
+}
+
+func (c *Client) skewFromServerTime(ms int64, received time.Time) {
+	if ms <= 0 {
+		return
+	}
+	c.setSkew(time.UnixMilli(ms).Sub(received), 0)
+}
+
+// setSkew records a new estimate, ignoring differences within tolerance so
+// a coarse source doesn't add noise when the clocks agree.
+func (c *Client) setSkew(skew, tolerance time.Duration) {
+	if skew > -tolerance && skew < tolerance {
+		skew = 0
+	}
+	prev := time.Duration(atomic.SwapInt64(&c.skew, int64(skew)))
+	if abs(skew) > maxClockSkew && abs(prev) <= maxClockSkew {
+		c.handleWarning(Warning{
+			Type:    MsgTypeWarning,
+			Code:    WarnClockSkew,
+			Message: fmt.Sprintf("system clock is off by %s; compensating", skew.Round(time.Second)),
+		})
+	}
+}
+
+func abs[T int | time.Duration](d T) T {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,31 @@
+package outray
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestClockSkewCompensation(t *testing.T) {
+	var warnings []Warning
+	c := NewClient(WithOnWarning(func(w Warning) { warnings = append(warnings, w) }))
+	c.closed = true
+
+	ahead := time.Now().Add(2 * time.Minute).UnixMilli()
+	c.handleMessage([]byte(fmt.Sprintf(`{"type":"tunnel_opened","url":"https://x.outray.dev","serverTime":%d}`, ahead)), 0)
+
+	if skew := c.Status().ClockSkew; skew < 119*time.Second || skew > 121*time.Second {
+		t.Errorf("Expected ~2m skew, got %v", skew)
+	}
+	if d := c.now().Sub(time.Now()); d < 119*time.Second {
+		t.Errorf("Expected corrected clock to run ahead, got %v", d)
+	}
+	if len(warnings) != 1 || warnings[0].Code != WarnClockSkew {
+		t.Errorf("Expected one clock skew warning, got %+v", warnings)
+	}
+
+	c.setSkew(500*time.Millisecond, time.Second)
+	if c.clockSkew() != 0 {
+		t.Errorf("Expected skew within tolerance to be ignored, got %v", c.clockSkew())
+	}
+}
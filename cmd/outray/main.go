@@ -0,0 +1,209 @@
+// Command outray exposes a local port through an Outray tunnel.
+//
+//	OUTRAY_API_KEY=... outray --port 8080 --qr --open
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/sodiqscript111/outray-go"
+)
+
+// Release builds set these with -ldflags "-X main.releaseKey=... -X
+// main.releaseManifest=...": the base64 Ed25519 key release manifests are
+// signed with, and where the latest one is published.
+var (
+	releaseKey      string
+	releaseManifest string
+)
+
+func main() {
+	var (
+		port       = flag.Int("port", 8080, "local port to expose")
+		protocol   = flag.String("protocol", "http", "tunnel protocol: http, tcp or udp")
+		remotePort = flag.Int("remote-port", 0, "public port for tcp and udp tunnels")
+		subdomain  = flag.String("subdomain", "", "requested subdomain for http tunnels")
+		server     = flag.String("server", "", "tunnel server URL")
+		profile    = flag.String("profile", "", "profile from the profiles file (default $OUTRAY_PROFILE)")
+		ssh        = flag.Bool("ssh", false, "expose the local SSH server (port 22 unless --port is given) and print the ssh command")
+		sshUser    = flag.String("ssh-user", "", "login to show in the printed ssh command")
+		sshKeys    = flag.String("authorized-keys", "", "authorized_keys file the edge checks visitors' SSH keys against")
+		check      = flag.Bool("check-config", false, "validate the profiles file and exit")
+		dryRun     = flag.Bool("dry-run", false, "check credentials, config and the local port, print the tunnel that would open, and exit")
+		doctor     = flag.Bool("doctor", false, "check DNS, connectivity, proxy, clock and MTU, print a report, and exit")
+		update     = flag.Bool("self-update", false, "replace this binary with the latest signed release and exit")
+		manifest   = flag.String("update-manifest", releaseManifest, "release manifest URL for --self-update")
+	)
+	announce := &announcer{out: os.Stdout}
+	announce.register(flag.CommandLine)
+	flag.Parse()
+
+	if *check {
+		checkConfig()
+		return
+	}
+	if *update {
+		selfUpdate(*manifest)
+		return
+	}
+
+	// Flags only override the profile when given explicitly.
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	opts := []outray.Option{outray.WithPort(*port), outray.WithProfile(*profile)}
+	if key := os.Getenv("OUTRAY_API_KEY"); key != "" {
+		opts = append(opts, outray.WithAPIKey(key))
+	}
+	if set["port"] {
+		opts = append(opts, outray.WithPort(*port))
+	}
+	if set["protocol"] {
+		opts = append(opts, outray.WithProtocol(*protocol))
+	}
+	if set["remote-port"] {
+		opts = append(opts, outray.WithRemotePort(*remotePort))
+	}
+	if set["subdomain"] {
+		opts = append(opts, outray.WithSubdomain(*subdomain))
+	}
+	if set["server"] {
+		opts = append(opts, outray.WithServerURL(*server))
+	}
+	if *ssh {
+		sshOpts := outray.SSHOptions{User: *sshUser}
+		if set["port"] {
+			sshOpts.Port = *port
+		}
+		if *sshKeys != "" {
+			keys, err := os.ReadFile(*sshKeys)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sshOpts.AuthorizedKeys = keys
+		}
+		opts = append(opts, outray.WithSSH(sshOpts))
+	}
+	opts = append(opts, outray.WithOnUpdateAvailable(func(u outray.UpdateNotice) {
+		fmt.Fprintf(os.Stderr, "outray %s is available (running %s); run outray --self-update\n", u.Latest, u.Current)
+	}))
+	opts = append(opts, outray.WithOnOpen(announce.onOpen))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *doctor {
+		report := outray.Diagnose(ctx, opts...)
+		fmt.Print(report)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	client := outray.NewClient(opts...)
+	if *dryRun {
+		plan, err := client.DryRun(ctx)
+		fmt.Print(plan)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := client.Connect(ctx); err != nil && ctx.Err() == nil {
+		log.Fatal(err)
+	}
+}
+
+func checkConfig() {
+	path, err := outray.ProfilesPath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := outray.ValidateConfig(data); err != nil {
+		fmt.Fprintf(os.Stderr, "%s:\n%v\n", path, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: ok\n", path)
+}
+
+func selfUpdate(manifest string) {
+	if releaseKey == "" || manifest == "" {
+		log.Fatal("self-update: this build has no release key or manifest; download the latest release instead")
+	}
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil {
+		log.Fatalf("self-update: invalid release key: %v", err)
+	}
+	r, err := outray.SelfUpdate(context.Background(), outray.UpdateOptions{
+		ManifestURL: manifest,
+		PublicKey:   ed25519.PublicKey(key),
+	})
+	if errors.Is(err, outray.ErrUpToDate) {
+		fmt.Printf("outray %s is up to date\n", outray.Version)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Updated outray %s -> %s\n", outray.Version, r.Version)
+}
+
+// announcer prints the public URL when the tunnel opens and acts on the
+// --qr, --copy and --open flags.
+type announcer struct {
+	out            io.Writer
+	qr, copy, open bool
+
+	// OnOpen fires again after every reconnect; only act when the URL is new.
+	last string
+}
+
+func (a *announcer) register(fs *flag.FlagSet) {
+	fs.BoolVar(&a.qr, "qr", false, "print the public URL as a QR code")
+	fs.BoolVar(&a.open, "open", false, "open the public URL in the default browser")
+	fs.BoolVar(&a.copy, "copy", false, "copy the public URL to the clipboard")
+}
+
+func (a *announcer) onOpen(url string) {
+	if url == a.last {
+		return
+	}
+	a.last = url
+	fmt.Fprintf(a.out, "Tunnel online: %s\n", url)
+	if a.qr {
+		printQR(a.out, url)
+	}
+	if a.copy {
+		if err := copyToClipboard(url); err != nil {
+			log.Printf("Copy URL: %v", err)
+		}
+	}
+	if a.open {
+		if err := openBrowser(url); err != nil {
+			log.Printf("Open browser: %v", err)
+		}
+	}
+}
+
+func printQR(w io.Writer, url string) {
+	code, err := outray.QRCodeText(url)
+	if err != nil {
+		log.Printf("QR code: %v", err)
+		return
+	}
+	fmt.Fprint(w, code)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAnnouncerFlags(t *testing.T) {
+	var opened, copied []string
+	openBrowser = func(url string) error { opened = append(opened, url); return nil }
+	copyToClipboard = func(text string) error { copied = append(copied, text); return nil }
+	t.Cleanup(func() { openBrowser, copyToClipboard = startBrowser, runClipboard })
+
+	var out bytes.Buffer
+	a := &announcer{out: &out}
+	fs := flag.NewFlagSet("outray", flag.ContinueOnError)
+	a.register(fs)
+	if err := fs.Parse([]string{"--open", "--copy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.onOpen("https://a.outray.dev")
+	a.onOpen("https://a.outray.dev") // reconnect to the same URL
+	a.onOpen("https://b.outray.dev")
+
+	if got, want := out.String(), "Tunnel online: https://a.outray.dev\nTunnel online: https://b.outray.dev\n"; got != want {
+		t.Errorf("Printed %q, want %q", got, want)
+	}
+	if len(opened) != 2 || opened[0] != "https://a.outray.dev" || opened[1] != "https://b.outray.dev" {
+		t.Errorf("Expected each new URL opened once, got %v", opened)
+	}
+	if len(copied) != 2 || copied[0] != "https://a.outray.dev" || copied[1] != "https://b.outray.dev" {
+		t.Errorf("Expected each new URL copied once, got %v", copied)
+	}
+}
+
+func TestAnnouncerDefaults(t *testing.T) {
+	openBrowser = func(string) error { t.Error("Browser opened without --open"); return nil }
+	copyToClipboard = func(string) error { t.Error("URL copied without --copy"); return nil }
+	t.Cleanup(func() { openBrowser, copyToClipboard = startBrowser, runClipboard })
+
+	a := &announcer{out: io.Discard}
+	fs := flag.NewFlagSet("outray", flag.ContinueOnError)
+	a.register(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	a.onOpen("https://a.outray.dev")
+}
+
+func TestAnnouncerQR(t *testing.T) {
+	var out bytes.Buffer
+	a := &announcer{out: &out}
+	fs := flag.NewFlagSet("outray", flag.ContinueOnError)
+	a.register(fs)
+	if err := fs.Parse([]string{"--qr"}); err != nil {
+		t.Fatal(err)
+	}
+	a.onOpen("https://a.outray.dev")
+
+	line := "Tunnel online: https://a.outray.dev\n"
+	if !strings.HasPrefix(out.String(), line) || out.Len() == len(line) {
+		t.Errorf("Expected the URL followed by a QR code, got %q", out.String())
+	}
+}
@@ -0,0 +1,348 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocols understood by WithCommandInspection.
+const (
+	ProtocolRedis = "redis"
+	ProtocolMySQL = "mysql"
+)
+
+const (
+	maxCommands    = 1000 // distinct commands tracked, like maxRoutes
+	maxCommandName = 32
+	maxCommandLine = 4096 // longest RESP header or inline command read
+)
+
+// CommandStats counts one command seen on inspected TCP streams.
+type CommandStats struct {
+	Protocol string
+	Command  string
+	Count    uint64
+}
+
+type commandStats struct {
+	mu       sync.Mutex
+	commands map[string]*CommandStats
+}
+
+// commandDecoder picks command names out of the bytes a client sends. It
+// is fed in order, one frame at a time, and returns nil once it has lost
+// track of the stream (TLS, or another protocol), after which it stops.
+type commandDecoder interface {
+	feed(data []byte, emit func(command string)) commandDecoder
+}
+
+// WithCommandInspection decodes TCP streams as Redis (ProtocolRedis) or
+// MySQL (ProtocolMySQL) client traffic and records the name of every
+// command or query (GET, HSET, SELECT, STMT_EXECUTE...) in CommandStats
+// and, with WithJournal, the journal. Keys, values and query text are
+// never kept. Encrypted streams are skipped.
+func WithCommandInspection(protocol string) Option {
+	return func(c *Client) {
+		if protocol != ProtocolRedis && protocol != ProtocolMySQL {
+			c.configErr = fmt.Errorf("command inspection supports %q and %q, got %q", ProtocolRedis, ProtocolMySQL, protocol)
+			return
+		}
+		c.config.CommandProtocol = protocol
+	}
+}
+
+func (c *Client) commandDecoder() commandDecoder {
+	switch c.conf().CommandProtocol {
+	case ProtocolRedis:
+		return &redisDecoder{}
+	case ProtocolMySQL:
+		return &mysqlDecoder{}
+	}
+	return nil
+}
+
+// CommandStats returns the n most frequent commands (all when n <= 0).
+func (c *Client) CommandStats(n int) []CommandStats {
+	c.commands.mu.Lock()
+	out := make([]CommandStats, 0, len(c.commands.commands))
+	for _, s := range c.commands.commands {
+		out = append(out, *s)
+	}
+	c.commands.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Command < out[j].Command
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// decodeCommands runs inbound stream data through the stream's decoder.
+// Callers hold stream.orderMu.
+func (c *Client) decodeCommands(connID string, stream *tcpStream, data []byte) {
+	if stream.decoder == nil {
+		return
+	}
+	stream.decoder = stream.decoder.feed(data, func(command string) {
+		c.observeCommand(connID, stream.remoteAddr, command)
+	})
+}
+
+func (c *Client) observeCommand(connID, remoteAddr, command string) {
+	protocol := c.conf().CommandProtocol
+
+	c.commands.mu.Lock()
+	if c.commands.commands == nil {
+		c.commands.commands = make(map[string]*CommandStats)
+	}
+	key := command
+	s := c.commands.commands[key]
+	if s == nil {
+		if len(c.commands.commands) >= maxCommands {
+			key = otherRoute
+			s = c.commands.commands[key]
+		}
+		if s == nil {
+			s = &CommandStats{Protocol: protocol, Command: key}
+			c.commands.commands[key] = s
+		}
+	}
+	s.Count++
+	c.commands.mu.Unlock()
+
+	if c.conf().Journal == nil {
+		return
+	}
+	entry := JournalEntry{
+		Time:     time.Now(),
+		Protocol: protocol,
+		Request:  IncomingRequest{ID: connID, Method: command, RemoteAddr: remoteAddr},
+	}
+	if err := c.conf().Journal.Append(entry); err != nil {
+		c.logf("Failed to journal %s command on %s: %v", protocol, connID, err)
+	}
+}
+
+// commandName upper-cases a command word, or returns "" if it doesn't look
+// like one.
+func commandName(word []byte) string {
+	if len(word) == 0 || len(word) > maxCommandName {
+		return ""
+	}
+	name := make([]byte, len(word))
+	for i, b := range word {
+		switch {
+		case b >= 'a' && b <= 'z':
+			b -= 'a' - 'A'
+		case b >= 'A' && b <= 'Z', b == '_', b == '-', b == '.', i > 0 && b >= '0' && b <= '9':
+		default:
+			return ""
+		}
+		name[i] = b
+	}
+	return string(name)
+}
+
+// redisDecoder reads RESP arrays of bulk strings and inline commands,
+// skipping over argument bodies without buffering them.
+type redisDecoder struct {
+	line  []byte // partial header or inline command
+	args  int    // bulk strings left in the current command
+	first bool   // the next bulk string is the command name
+	skip  int    // bytes of the current bulk string (and CRLF) still to skip
+	name  []byte // command name being read, when first
+}
+
+func (d *redisDecoder) feed(data []byte, emit func(string)) commandDecoder {
+	for len(data) > 0 {
+		if d.skip > 0 {
+			n := min(d.skip, len(data))
+			if d.name != nil {
+				d.name = append(d.name, data[:n]...)
+			}
+			d.skip -= n
+			data = data[n:]
+			if d.skip == 0 && d.name != nil {
+				name := commandName(bytes.TrimSuffix(d.name, []byte("\r\n")))
+				if name == "" {
+					return nil
+				}
+				emit(name)
+				d.name = nil
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if len(d.line)+len(data) > maxCommandLine {
+				return nil
+			}
+			d.line = append(d.line, data...)
+			return d
+		}
+		line := append(d.line, data[:i]...)
+		d.line = nil
+		data = data[i+1:]
+		if !d.header(bytes.TrimSuffix(line, []byte("\r")), emit) {
+			return nil
+		}
+	}
+	return d
+}
+
+// header handles one line outside a bulk string.
+func (d *redisDecoder) header(line []byte, emit func(string)) bool {
+	if d.args > 0 {
+		n, err := strconv.Atoi(string(bytes.TrimPrefix(line, []byte("$"))))
+		if len(line) == 0 || line[0] != '$' || err != nil || n < 0 {
+			return false
+		}
+		d.args--
+		d.skip = n + 2
+		if d.first {
+			if n > maxCommandName {
+				return false
+			}
+			d.name = make([]byte, 0, n+2)
+			d.first = false
+		}
+		return true
+	}
+	if len(line) > 0 && line[0] == '*' {
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return false
+		}
+		d.args, d.first = max(n, 0), n > 0
+		return true
+	}
+	if fields := bytes.Fields(line); len(fields) > 0 {
+		name := commandName(fields[0])
+		if name == "" {
+			return false
+		}
+		emit(name)
+	}
+	return true
+}
+
+// MySQL client commands, the first byte of a packet with sequence 0.
+var mysqlCommands = map[byte]string{
+	0x01: "QUIT",
+	0x02: "INIT_DB",
+	0x03: "QUERY",
+	0x04: "FIELD_LIST",
+	0x07: "REFRESH",
+	0x08: "SHUTDOWN",
+	0x09: "STATISTICS",
+	0x0a: "PROCESS_INFO",
+	0x0c: "PROCESS_KILL",
+	0x0d: "DEBUG",
+	0x0e: "PING",
+	0x11: "CHANGE_USER",
+	0x12: "BINLOG_DUMP",
+	0x16: "STMT_PREPARE",
+	0x17: "STMT_EXECUTE",
+	0x18: "STMT_SEND_LONG_DATA",
+	0x19: "STMT_CLOSE",
+	0x1a: "STMT_RESET",
+	0x1b: "SET_OPTION",
+	0x1c: "STMT_FETCH",
+	0x1f: "RESET_CONNECTION",
+}
+
+const (
+	mysqlClientSSL  = 0x0800
+	mysqlSSLRequest = 32 // payload length of an SSLRequest
+	mysqlMaxKeyword = 64 // bytes of a query read to find its keyword
+)
+
+// mysqlDecoder reads client packets. Queries are named by their first
+// keyword; the rest of each packet is skipped.
+type mysqlDecoder struct {
+	header  []byte
+	payload []byte // start of the current packet, up to mysqlMaxKeyword
+	want    int    // payload bytes of the current packet still to come
+	seq     byte
+	started bool // past the handshake response
+}
+
+func (d *mysqlDecoder) feed(data []byte, emit func(string)) commandDecoder {
+	for len(data) > 0 {
+		if len(d.header) < 4 {
+			n := min(4-len(d.header), len(data))
+			d.header = append(d.header, data[:n]...)
+			data = data[n:]
+			if len(d.header) < 4 {
+				return d
+			}
+			d.want = int(d.header[0]) | int(d.header[1])<<8 | int(d.header[2])<<16
+			d.seq = d.header[3]
+			d.payload = d.payload[:0]
+			if d.want > 0 {
+				continue
+			}
+		}
+
+		n := min(d.want, len(data))
+		if keep := mysqlMaxKeyword - len(d.payload); keep > 0 {
+			d.payload = append(d.payload, data[:min(n, keep)]...)
+		}
+		d.want -= n
+		data = data[n:]
+		if d.want > 0 {
+			return d
+		}
+		length := int(d.header[0]) | int(d.header[1])<<8 | int(d.header[2])<<16
+		d.header = d.header[:0]
+		if !d.packet(length, emit) {
+			return nil
+		}
+	}
+	return d
+}
+
+// packet handles one complete packet of the given length.
+func (d *mysqlDecoder) packet(length int, emit func(string)) bool {
+	if !d.started {
+		// The handshake response, or an SSLRequest ahead of TLS.
+		if d.seq != 1 || len(d.payload) < 4 {
+			return false
+		}
+		if binary.LittleEndian.Uint32(d.payload)&mysqlClientSSL != 0 && length == mysqlSSLRequest {
+			return false
+		}
+		d.started = true
+		return true
+	}
+	if d.seq != 0 || len(d.payload) == 0 {
+		return true // continuations, auth exchanges and LOCAL INFILE data
+	}
+	name, ok := mysqlCommands[d.payload[0]]
+	if !ok {
+		return false
+	}
+	if d.payload[0] == 0x03 {
+		query := bytes.TrimLeft(d.payload[1:], " \t\r\n(")
+		end := bytes.IndexFunc(query, func(r rune) bool { return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') })
+		if end < 0 {
+			end = len(query)
+		}
+		if keyword := commandName(query[:end]); keyword != "" {
+			name = keyword
+		}
+	}
+	emit(name)
+	return true
+}
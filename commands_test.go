@@ -0,0 +1,126 @@
+package outray
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"testing"
+)
+
+func decodeAll(d commandDecoder, data []byte, chunk int) []string {
+	var got []string
+	for len(data) > 0 && d != nil {
+		n := min(chunk, len(data))
+		d = d.feed(data[:n], func(cmd string) { got = append(got, cmd) })
+		data = data[n:]
+	}
+	return got
+}
+
+func TestRedisDecoder(t *testing.T) {
+	data := []byte("*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$11\r\nhello\r\nworld\r\n" +
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" +
+		"PING\r\n" +
+		"*1\r\n$5\r\nMULTI\r\n")
+	want := []string{"SET", "GET", "PING", "MULTI"}
+	for _, chunk := range []int{1, 7, len(data)} {
+		if got := decodeAll(&redisDecoder{}, data, chunk); !slices.Equal(got, want) {
+			t.Errorf("chunk %d: got %v, want %v", chunk, got, want)
+		}
+	}
+
+	if got := decodeAll(&redisDecoder{}, []byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03\r\nGET\r\n"), 64); len(got) != 0 {
+		t.Errorf("Expected TLS to stop the decoder, got %v", got)
+	}
+}
+
+func mysqlPacket(seq byte, payload ...byte) []byte {
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...)
+}
+
+func TestMySQLDecoder(t *testing.T) {
+	handshake := binary.LittleEndian.AppendUint32(nil, 0x000fa685)
+	handshake = append(handshake, make([]byte, 60)...)
+
+	var data []byte
+	data = append(data, mysqlPacket(1, handshake...)...)
+	data = append(data, mysqlPacket(3, 0x01, 0x02)...) // auth switch response
+	data = append(data, mysqlPacket(0, append([]byte{0x03}, "  select * from users where email = 'a@b.c'"...)...)...)
+	data = append(data, mysqlPacket(0, append([]byte{0x03}, "/* hint */ SELECT 1"...)...)...)
+	data = append(data, mysqlPacket(0, 0x0e)...)
+	data = append(data, mysqlPacket(0, 0x17, 1, 0, 0, 0)...)
+	data = append(data, mysqlPacket(0, 0x01)...)
+	want := []string{"SELECT", "QUERY", "PING", "STMT_EXECUTE", "QUIT"}
+	for _, chunk := range []int{1, 5, len(data)} {
+		if got := decodeAll(&mysqlDecoder{}, data, chunk); !slices.Equal(got, want) {
+			t.Errorf("chunk %d: got %v, want %v", chunk, got, want)
+		}
+	}
+
+	ssl := binary.LittleEndian.AppendUint32(nil, 0x000fae85)
+	ssl = append(ssl, make([]byte, 28)...)
+	data = append(mysqlPacket(1, ssl...), mysqlPacket(0, 0x0e)...)
+	if got := decodeAll(&mysqlDecoder{}, data, len(data)); len(got) != 0 {
+		t.Errorf("Expected SSLRequest to stop the decoder, got %v", got)
+	}
+}
+
+func TestCommandInspection(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	j, err := OpenJournal(t.TempDir(), JournalLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithUpstreamFallback(backend.Addr().String()),
+		WithCommandInspection(ProtocolRedis),
+		WithJournal(j),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	conn := relay.open(c, "conn-1")
+	conn.Write([]byte("*2\r\n$3\r\nGET\r\n$6\r\nsecret\r\n*2\r\n$3\r\nGET\r\n$1\r\nx\r\n"))
+	conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	conn.Close()
+	c.Wait()
+
+	stats := c.CommandStats(0)
+	if len(stats) != 2 || stats[0] != (CommandStats{Protocol: ProtocolRedis, Command: "GET", Count: 2}) || stats[1].Command != "PING" {
+		t.Errorf("Unexpected command stats: %+v", stats)
+	}
+
+	found, err := j.Search(JournalQuery{Protocol: "redis", Method: "get"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Request.ID != "conn-1" || found[0].Request.Body != nil {
+		t.Errorf("Expected two journaled GETs without payloads, got %+v", found)
+	}
+
+	if err := NewClient(WithCommandInspection("memcached")).configErr; err == nil {
+		t.Error("Expected an unsupported protocol to be rejected")
+	}
+}
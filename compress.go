@@ -0,0 +1,114 @@
+package outray
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type CompressionRules struct {
+	MinSize          int
+	SkipContentTypes []string
+}
+
+var defaultSkipContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/octet-stream",
+	"application/pdf",
+	"application/wasm",
+}
+
+func WithCompression(rules CompressionRules) Option {
+	return func(c *Client) {
+		if rules.MinSize <= 0 {
+			rules.MinSize = 1024
+		}
+		if rules.SkipContentTypes == nil {
+			rules.SkipContentTypes = defaultSkipContentTypes
+		}
+		c.config.Compression = &rules
+	}
+}
+
+func (c *Client) compressResponse(req IncomingRequest, resp *IncomingResponse) {
+	rules := c.conf().Compression
+	if rules == nil || len(resp.Body) == 0 || resp.StatusCode == 206 || headerValue(resp.Headers, "Content-Range") != "" {
+		return
+	}
+	if !strings.Contains(headerValue(req.Headers, "Accept-Encoding"), "gzip") {
+		return
+	}
+	if headerValue(resp.Headers, "Content-Encoding") != "" || !rules.compressible(headerValue(resp.Headers, "Content-Type"), len(resp.Body)) {
+		atomic.AddUint64(&c.stats.compressionSkipped, 1)
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(resp.Body); err != nil {
+		return
+	}
+	if err := zw.Close(); err != nil {
+		return
+	}
+	if buf.Len() >= len(resp.Body) {
+		atomic.AddUint64(&c.stats.compressionSkipped, 1)
+		return
+	}
+
+	atomic.AddUint64(&c.stats.compressed, 1)
+	atomic.AddUint64(&c.stats.compressionSaved, uint64(len(resp.Body)-buf.Len()))
+
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
+	}
+	deleteHeader(resp.Headers, "Content-Length")
+	resp.Headers["Content-Encoding"] = "gzip"
+	resp.Headers["Content-Length"] = strconv.Itoa(buf.Len())
+	resp.Headers["Vary"] = "Accept-Encoding"
+	resp.Body = buf.Bytes()
+}
+
+func (r *CompressionRules) compressible(contentType string, size int) bool {
+	if size < r.MinSize {
+		return false
+	}
+	contentType = strings.ToLower(contentType)
+	for _, skip := range r.SkipContentTypes {
+		if strings.HasPrefix(contentType, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+func headerValue(headers map[string]string, key string) string {
+	if v, ok := headers[key]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func deleteHeader(headers map[string]string, key string) {
+	for k := range headers {
+		if strings.EqualFold(k, key) {
+			delete(headers, k)
+		}
+	}
+}
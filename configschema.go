@@ -0,0 +1,358 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigError is one problem found in a config file. Path locates the
+// value, as in "profiles.work.tunnels[1].protocol".
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + " " + e.Message
+}
+
+// ConfigErrors lists every problem ValidateConfig found.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+type schemaType int
+
+const (
+	schemaString schemaType = iota
+	schemaInt
+	schemaObject
+	schemaArray
+	schemaMap
+)
+
+// schema describes one value of the config file.
+type schema struct {
+	typ      schemaType
+	fields   map[string]*schema // schemaObject
+	order    []string           // schemaObject fields in the order errors are reported
+	required []string           // schemaObject
+	elem     *schema            // schemaArray and schemaMap
+	enum     []string           // schemaString
+	min, max int                // schemaInt
+	check    func(s string) string
+}
+
+func objectSchema(required []string, fields ...any) *schema {
+	s := &schema{typ: schemaObject, fields: make(map[string]*schema), required: required}
+	for i := 0; i < len(fields); i += 2 {
+		name := fields[i].(string)
+		s.fields[name] = fields[i+1].(*schema)
+		s.order = append(s.order, name)
+	}
+	return s
+}
+
+var subdomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var (
+	portSchema      = &schema{typ: schemaInt, min: 1, max: 65535}
+	protocolSchema  = &schema{typ: schemaString, enum: tunnelProtocols}
+	subdomainSchema = &schema{typ: schemaString, check: func(s string) string {
+		if !subdomainPattern.MatchString(s) {
+			return "must be lowercase letters, digits and hyphens, not starting or ending with a hyphen"
+		}
+		return ""
+	}}
+	domainSchema = &schema{typ: schemaString, check: func(s string) string {
+		if strings.Contains(s, "://") || strings.ContainsAny(s, "/: ") {
+			return "must be a bare domain name such as tunnel.example.com"
+		}
+		return ""
+	}}
+	serverURLSchema = &schema{typ: schemaString, check: func(s string) string {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return "must be a URL such as wss://api.outray.dev"
+		}
+		if u.Scheme != "ws" && u.Scheme != "wss" {
+			return "must use the ws or wss scheme"
+		}
+		return ""
+	}}
+
+	tunnelSchema = objectSchema([]string{"protocol", "port"},
+		"protocol", protocolSchema,
+		"port", portSchema,
+		"remotePort", portSchema,
+		"subdomain", subdomainSchema,
+		"customDomain", domainSchema,
+	)
+	profileSchema = objectSchema(nil,
+		"apiKey", &schema{typ: schemaString},
+		"serverUrl", serverURLSchema,
+		"protocol", protocolSchema,
+		"port", portSchema,
+		"remotePort", portSchema,
+		"subdomain", subdomainSchema,
+		"customDomain", domainSchema,
+		"tunnels", &schema{typ: schemaArray, elem: tunnelSchema},
+	)
+	configSchema = objectSchema(nil,
+		"default", &schema{typ: schemaString},
+		"profiles", &schema{typ: schemaMap, elem: profileSchema},
+	)
+)
+
+// remotePortRanges are the public ports the server hands out per protocol.
+var remotePortRanges = map[string][2]int{
+	"tcp": {20000, 30000},
+	"udp": {30001, 40000},
+}
+
+// ValidateConfig checks a profiles file against its schema and returns
+// ConfigErrors describing every problem, or nil. Tools that edit the file
+// can use it to report errors the way WithProfile would.
+func ValidateConfig(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return ConfigErrors{{Message: jsonErrorPosition(data, err)}}
+	}
+	if dec.More() {
+		return ConfigErrors{{Message: "unexpected data after the top-level object"}}
+	}
+
+	var errs ConfigErrors
+	configSchema.validate("", v, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	var f profilesFile
+	json.Unmarshal(data, &f)
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, ok := f.Profiles[f.Default]; f.Default != "" && !ok {
+		errs = append(errs, ConfigError{"default", fmt.Sprintf("names profile %q, which is not defined (have %s)", f.Default, strings.Join(names, ", "))})
+	}
+	for _, name := range names {
+		errs = f.Profiles[name].validate(joinPath("profiles", name), errs)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate checks what the schema can't express: remote port ranges and
+// one tunnel per protocol.
+func (p Profile) validate(path string, errs ConfigErrors) ConfigErrors {
+	errs = checkRemotePort(path, p.Protocol, p.RemotePort, errs)
+	seen := make(map[string]int)
+	for i, t := range p.Tunnels {
+		tpath := fmt.Sprintf("%s.tunnels[%d]", path, i)
+		if j, ok := seen[t.Protocol]; ok {
+			errs = append(errs, ConfigError{tpath + ".protocol", fmt.Sprintf("%q is already used by tunnels[%d]; only one tunnel per protocol is allowed", t.Protocol, j)})
+		}
+		seen[t.Protocol] = i
+		errs = checkRemotePort(tpath, t.Protocol, t.RemotePort, errs)
+	}
+	return errs
+}
+
+func checkRemotePort(path, protocol string, port int, errs ConfigErrors) ConfigErrors {
+	r, ok := remotePortRanges[protocol]
+	if !ok || port == 0 || (port >= r[0] && port <= r[1]) {
+		return errs
+	}
+	return append(errs, ConfigError{path + ".remotePort", fmt.Sprintf("must be between %d and %d for %s tunnels, got %d", r[0], r[1], protocol, port)})
+}
+
+func (s *schema) validate(path string, v any, errs *ConfigErrors) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, ConfigError{path, fmt.Sprintf(format, args...)})
+	}
+	if path == "" && s.typ == schemaObject {
+		// The top level reads better as "config file must be ...".
+		fail = func(format string, args ...any) {
+			*errs = append(*errs, ConfigError{"config file", fmt.Sprintf(format, args...)})
+		}
+	}
+
+	switch s.typ {
+	case schemaString:
+		str, ok := v.(string)
+		if !ok {
+			fail("must be a string, got %s", jsonKind(v))
+			return
+		}
+		if len(s.enum) > 0 && !slices.Contains(s.enum, str) {
+			fail("must be one of %s, got %q", strings.Join(s.enum, ", "), str)
+			return
+		}
+		if s.check != nil {
+			if msg := s.check(str); msg != "" {
+				fail("%s, got %q", msg, str)
+			}
+		}
+	case schemaInt:
+		num, ok := v.(json.Number)
+		if !ok {
+			fail("must be an integer, got %s", jsonKind(v))
+			return
+		}
+		n, err := strconv.Atoi(num.String())
+		if err != nil {
+			fail("must be an integer, got %s", num)
+			return
+		}
+		if n < s.min || n > s.max {
+			fail("must be between %d and %d, got %d", s.min, s.max, n)
+		}
+	case schemaArray:
+		items, ok := v.([]any)
+		if !ok {
+			fail("must be an array, got %s", jsonKind(v))
+			return
+		}
+		for i, item := range items {
+			s.elem.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+	case schemaMap:
+		m, ok := v.(map[string]any)
+		if !ok {
+			fail("must be an object, got %s", jsonKind(v))
+			return
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.elem.validate(joinPath(path, k), m[k], errs)
+		}
+	case schemaObject:
+		m, ok := v.(map[string]any)
+		if !ok {
+			fail("must be an object, got %s", jsonKind(v))
+			return
+		}
+		for _, name := range s.required {
+			if _, ok := m[name]; !ok {
+				*errs = append(*errs, ConfigError{joinPath(path, name), "is required"})
+			}
+		}
+		for _, name := range s.order {
+			if fv, ok := m[name]; ok {
+				s.fields[name].validate(joinPath(path, name), fv, errs)
+			}
+		}
+		var unknown []string
+		for k := range m {
+			if _, ok := s.fields[k]; !ok {
+				unknown = append(unknown, k)
+			}
+		}
+		sort.Strings(unknown)
+		for _, k := range unknown {
+			msg := "is not a known field"
+			if near := closest(k, s.order); near != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", near)
+			}
+			*errs = append(*errs, ConfigError{joinPath(path, k), msg})
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	if strings.ContainsAny(name, ".[] ") || name == "" {
+		return fmt.Sprintf("%s[%q]", path, name)
+	}
+	return path + "." + name
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	}
+	return "an object"
+}
+
+// jsonErrorPosition adds the line and column to a JSON syntax error.
+func jsonErrorPosition(data []byte, err error) string {
+	var syntax *json.SyntaxError
+	if !errors.As(err, &syntax) {
+		return "invalid JSON: " + err.Error()
+	}
+	offset := max(int(syntax.Offset)-1, 0) // Offset is just past the bad byte
+	before := data[:min(offset, len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, col, err)
+}
+
+// closest returns the candidate within two edits of s, if any.
+func closest(s string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(s), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,105 @@
+package outray
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	valid := `{
+		"default": "work",
+		"profiles": {
+			"work": {
+				"apiKey": "wk",
+				"serverUrl": "wss://tunnels.example.com",
+				"tunnels": [
+					{"protocol": "http", "port": 3000, "subdomain": "my-app"},
+					{"protocol": "tcp", "port": 5432, "remotePort": 25432}
+				]
+			}
+		}
+	}`
+	if err := ValidateConfig([]byte(valid)); err != nil {
+		t.Fatalf("Valid config rejected: %v", err)
+	}
+
+	tests := []struct {
+		config string
+		want   []string
+	}{
+		{
+			`{"profiles": {"work": {"tunnels": [{"protocol": "http", "port": 80}, {"protocol": "ftp", "port": 21}]}}}`,
+			[]string{`profiles.work.tunnels[1].protocol must be one of http, tcp, udp, got "ftp"`},
+		},
+		{
+			`{"profiles": {"work": {"port": "3000", "subdomian": "x", "serverUrl": "https://api.outray.dev"}}}`,
+			[]string{
+				"profiles.work.serverUrl must use the ws or wss scheme",
+				"profiles.work.port must be an integer, got a string",
+				"profiles.work.subdomian is not a known field (did you mean subdomain?)",
+			},
+		},
+		{
+			`{"profiles": {"work": {"tunnels": [{"protocol": "udp", "port": 70000, "remotePort": 25000}, {"port": 1}]}}}`,
+			[]string{
+				"profiles.work.tunnels[0].port must be between 1 and 65535, got 70000",
+				"profiles.work.tunnels[1].protocol is required",
+			},
+		},
+		{
+			`{"profiles": {"work": {"tunnels": [{"protocol": "udp", "port": 53, "remotePort": 25000}, {"protocol": "udp", "port": 54}]}}}`,
+			[]string{
+				"profiles.work.tunnels[0].remotePort must be between 30001 and 40000 for udp tunnels, got 25000",
+				`profiles.work.tunnels[1].protocol "udp" is already used by tunnels[0]`,
+			},
+		},
+		{
+			`{"default": "home", "profiles": {"work": {}}}`,
+			[]string{`default names profile "home", which is not defined (have work)`},
+		},
+		{
+			"{\n  \"profiles\": {\n    \"work\": {,}\n  }\n}",
+			[]string{"invalid JSON at line 3, column 14"},
+		},
+		{
+			`[]`,
+			[]string{"config file must be an object, got an array"},
+		},
+	}
+	for _, tt := range tests {
+		err := ValidateConfig([]byte(tt.config))
+		var errs ConfigErrors
+		if !errors.As(err, &errs) || len(errs) != len(tt.want) {
+			t.Errorf("%s:\ngot  %v\nwant %q", tt.config, err, tt.want)
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(errs[i].Error(), want) {
+				t.Errorf("%s: error %d = %q, want %q", tt.config, i, errs[i], want)
+			}
+		}
+	}
+}
+
+func TestProfileTunnels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"profiles": {"dev": {"tunnels": [
+		{"protocol": "http", "port": 3000},
+		{"protocol": "udp", "port": 53, "remotePort": 35053}
+	]}}}`), 0o600)
+	t.Setenv(EnvConfig, path)
+
+	c := NewClient(WithProfile("dev"))
+	specs := c.tunnelSpecs()
+	if len(specs) != 2 || specs[0].Port != 3000 || specs[1].Protocol != "udp" || specs[1].RemotePort != 35053 {
+		t.Errorf("Unexpected tunnels: %+v", specs)
+	}
+
+	os.WriteFile(path, []byte(`{"profiles": {"dev": {"tunnels": [{"protocol": "ftp", "port": 21}]}}}`), 0o600)
+	if c := NewClient(WithProfile("dev")); c.configErr == nil || !strings.Contains(c.configErr.Error(), "tunnels[0].protocol must be one of") {
+		t.Errorf("Expected a schema error, got %v", c.configErr)
+	}
+}
@@ -0,0 +1,227 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxCrashReports bounds the reports one client writes, so a callback
+	// that panics on every request doesn't fill the disk.
+	maxCrashReports    = 10
+	crashUploadTimeout = 10 * time.Second
+)
+
+// CrashReports configures the opt-in crash reporter.
+type CrashReports struct {
+	Dir string // where reports are written; required
+	// Endpoint, if set, receives each report as a JSON POST. Reports that
+	// could not be sent, such as those written just before the process
+	// died, are sent on the next Connect.
+	Endpoint   string
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+// CrashReport describes one panic in SDK code or a callback it ran.
+type CrashReport struct {
+	Time   time.Time              `json:"time"`
+	Where  string                 `json:"where"` // "goroutine", "callback" or "inbound frame"
+	Panic  string                 `json:"panic"`
+	Stack  string                 `json:"stack"`
+	Client ClientInfo             `json:"client"`
+	State  string                 `json:"state"`
+	Config map[string]interface{} `json:"config"`
+}
+
+type crashState struct {
+	written int32
+	sendMu  sync.Mutex // one upload pass at a time
+}
+
+// WithCrashReports records panics in the SDK's goroutines and in callbacks
+// to files in r.Dir, with the stack, the SDK version and the client's
+// configuration with credentials removed, and posts them to r.Endpoint if
+// set. Panics in callbacks and inbound frames are already recovered; those
+// in other SDK goroutines still crash the process once recorded.
+func WithCrashReports(r CrashReports) Option {
+	return func(c *Client) {
+		if r.Dir == "" {
+			c.configErr = errors.New("crash reports: no directory")
+			return
+		}
+		c.config.CrashReports = &r
+	}
+}
+
+// catchPanic records a panic in an SDK goroutine and lets it continue.
+// Without crash reports it doesn't recover at all, so the panic is left
+// untouched.
+func (c *Client) catchPanic() {
+	if c.conf().CrashReports == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		c.reportCrash("goroutine", r, debug.Stack(), false)
+		panic(r)
+	}
+}
+
+// reportCrash writes a report for a recovered panic and, when the process
+// survives it, sends it.
+func (c *Client) reportCrash(where string, r interface{}, stack []byte, survived bool) {
+	cfg := c.conf().CrashReports
+	if cfg == nil {
+		return
+	}
+	if atomic.AddInt32(&c.crashes.written, 1) > maxCrashReports {
+		return
+	}
+	report := CrashReport{
+		Time:   time.Now().UTC(),
+		Where:  where,
+		Panic:  c.scrub(fmt.Sprint(r)),
+		Stack:  c.scrub(string(stack)),
+		Client: *c.clientInfo(),
+		State:  ConnState(atomic.LoadInt32(&c.state)).String(),
+		Config: scrubConfig(*c.conf()),
+	}
+	path, err := writeCrashReport(cfg.Dir, report)
+	if err != nil {
+		c.logf("Failed to write crash report: %v", err)
+		return
+	}
+	c.logf("Wrote crash report %s", path)
+	if survived && cfg.Endpoint != "" {
+		c.spawn(c.sendCrashReports)
+	}
+}
+
+func writeCrashReport(dir string, report CrashReport) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "crash-"+report.Time.Format("20060102T150405")+"-*.json")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return f.Name(), err
+}
+
+// sendCrashReports posts unsent reports to the endpoint, renaming each one
+// sent to *.sent.
+func (c *Client) sendCrashReports() {
+	cfg := c.conf().CrashReports
+	if cfg == nil || cfg.Endpoint == "" {
+		return
+	}
+	c.crashes.sendMu.Lock()
+	defer c.crashes.sendMu.Unlock()
+	paths, _ := filepath.Glob(filepath.Join(cfg.Dir, "crash-*.json"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := postCrashReport(httpClientOr(cfg.HTTPClient), cfg.Endpoint, data); err != nil {
+			c.logf("Failed to send crash report %s: %v", filepath.Base(path), err)
+			return
+		}
+		os.Rename(path, path+".sent")
+	}
+}
+
+func postCrashReport(client *http.Client, endpoint string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), crashUploadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", endpoint, resp.Status)
+	}
+	return nil
+}
+
+// scrub removes the client's credentials and anything that looks like a
+// token from panic values and stacks.
+func (c *Client) scrub(s string) string {
+	for _, secret := range []string{c.conf().APIKey, string(c.conf().FrameSecret), string(c.conf().E2EKey)} {
+		if len(secret) >= 4 {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return RedactTokens.pattern.ReplaceAllString(s, redacted)
+}
+
+var secretFieldPattern = regexp.MustCompile(`(?i)key|secret|token|password|auth|header|proof`)
+
+// scrubConfig summarizes the configuration for a crash report. Plain
+// values are kept, apart from anything credential-like; other set values,
+// which may hold credentials or aren't meaningful outside the process,
+// are only marked as set. Unset fields and callbacks are left out.
+func scrubConfig(cfg Config) map[string]interface{} {
+	out := make(map[string]interface{})
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := range t.NumField() {
+		f, name := v.Field(i), t.Field(i).Name
+		if f.IsZero() || f.Kind() == reflect.Func {
+			continue
+		}
+		switch {
+		case secretFieldPattern.MatchString(name):
+			out[name] = redacted
+		case f.Kind() == reflect.String:
+			out[name] = scrubURL(f.String())
+		case f.Type() == reflect.TypeOf(time.Duration(0)):
+			out[name] = f.Interface().(time.Duration).String()
+		case f.Kind() == reflect.Bool, f.CanInt(), f.CanUint(), f.CanFloat():
+			out[name] = f.Interface()
+		default:
+			out[name] = "set"
+		}
+	}
+	return out
+}
+
+// scrubURL drops the credentials and query from s if it is a URL.
+func scrubURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return s
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	return u.String()
+}
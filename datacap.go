@@ -0,0 +1,348 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDataCapReached is returned by Connect's inner connection when a data
+// cap with pausing closes it; Connect itself waits for the next period.
+var ErrDataCapReached = errors.New("tunnel paused: data cap reached")
+
+const (
+	WarnDataCapNearing  = "DATA_CAP_NEARING"
+	WarnDataCapForecast = "DATA_CAP_FORECAST"
+	WarnDataCapReached  = "DATA_CAP_REACHED"
+)
+
+// dataCapSaveEvery is how much usage may go unsaved to the state file.
+const dataCapSaveEvery = 1 << 20
+
+// DataCapPeriod is how often a data cap resets.
+type DataCapPeriod int
+
+const (
+	Monthly DataCapPeriod = iota
+	Daily
+)
+
+// DataCap is a client-side limit on the bytes the tunnel carries per
+// period, both directions combined.
+type DataCap struct {
+	Bytes  uint64
+	Period DataCapPeriod // Monthly if unset
+	// ResetDay is the day of the month monthly caps reset on, 1-28
+	// (default 1). Periods start at local midnight.
+	ResetDay int
+	// Thresholds are the fractions of Bytes that raise DATA_CAP_NEARING
+	// warnings (default 0.8 and 0.9).
+	Thresholds []float64
+	// Pause closes the tunnel when the cap is reached and reopens it when
+	// the next period starts. Without it the cap only warns.
+	Pause bool
+	// StateFile keeps usage across restarts. Without it usage counts from
+	// when the client started.
+	StateFile string
+}
+
+type DataCapOption func(*DataCap)
+
+func WithDataCapPeriod(p DataCapPeriod) DataCapOption {
+	return func(d *DataCap) {
+		d.Period = p
+	}
+}
+
+func WithDataCapResetDay(day int) DataCapOption {
+	return func(d *DataCap) {
+		d.ResetDay = day
+	}
+}
+
+func WithDataCapThresholds(fractions ...float64) DataCapOption {
+	return func(d *DataCap) {
+		d.Thresholds = fractions
+	}
+}
+
+func WithDataCapPause() DataCapOption {
+	return func(d *DataCap) {
+		d.Pause = true
+	}
+}
+
+func WithDataCapStateFile(path string) DataCapOption {
+	return func(d *DataCap) {
+		d.StateFile = path
+	}
+}
+
+// DataUsage is the tunnel's usage in the current data cap period.
+type DataUsage struct {
+	Used        uint64
+	Limit       uint64
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	// Forecast projects Used to PeriodEnd at the rate seen since the client
+	// started counting this period.
+	Forecast uint64
+	Paused   bool
+}
+
+type dataCapState struct {
+	cfg DataCap
+
+	mu             sync.Mutex
+	start, end     time.Time
+	used           uint64 // bytes this period
+	counted        uint64 // client byte total already added to used
+	since          time.Time
+	sinceUsed      uint64 // used when this process started counting
+	fired          int
+	forecastWarned bool
+	reachedWarned  bool
+	saved          uint64
+	saveMu         sync.Mutex // serializes writes to the state file
+}
+
+type dataCapFile struct {
+	PeriodStart time.Time `json:"periodStart"`
+	Used        uint64    `json:"used"`
+}
+
+// WithDataCap limits the bytes the tunnel carries per month (or day, with
+// WithDataCapPeriod), warning as thresholds are crossed and when the
+// current rate is forecast to exceed the cap. WithDataCapPause closes the
+// tunnel once the cap is reached, which avoids overages on metered plans
+// and mobile hotspots.
+func WithDataCap(bytes uint64, opts ...DataCapOption) Option {
+	return func(c *Client) {
+		d := DataCap{Bytes: bytes, ResetDay: 1}
+		for _, opt := range opts {
+			opt(&d)
+		}
+		if d.Bytes == 0 {
+			c.configErr = errors.New("data cap must be greater than zero")
+			return
+		}
+		if d.ResetDay < 1 || d.ResetDay > 28 {
+			c.configErr = fmt.Errorf("data cap reset day must be 1-28, got %d", d.ResetDay)
+			return
+		}
+		if len(d.Thresholds) == 0 {
+			d.Thresholds = []float64{0.8, 0.9}
+		}
+		d.Thresholds = append([]float64(nil), d.Thresholds...)
+		sort.Float64s(d.Thresholds)
+
+		s := &dataCapState{cfg: d}
+		now := time.Now()
+		s.start, s.end = d.period(now)
+		s.since = now
+		if err := s.load(); err != nil {
+			c.configErr = fmt.Errorf("data cap state: %w", err)
+			return
+		}
+		c.dataCap = s
+	}
+}
+
+// period returns the cap period containing t.
+func (d DataCap) period(t time.Time) (start, end time.Time) {
+	y, m, day := t.Date()
+	if d.Period == Daily {
+		start = time.Date(y, m, day, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+	start = time.Date(y, m, d.ResetDay, 0, 0, 0, 0, t.Location())
+	if t.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// DataUsage reports usage against the data cap, or the zero value without
+// one.
+func (c *Client) DataUsage() DataUsage {
+	if c.dataCap == nil {
+		return DataUsage{}
+	}
+	c.checkDataCap(time.Now())
+	s := c.dataCap
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return DataUsage{
+		Used:        s.used,
+		Limit:       s.cfg.Bytes,
+		PeriodStart: s.start,
+		PeriodEnd:   s.end,
+		Forecast:    s.forecast(time.Now()),
+		Paused:      s.cfg.Pause && s.used >= s.cfg.Bytes,
+	}
+}
+
+func (c *Client) watchDataCap(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer c.dataCap.save()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.checkDataCap(time.Now()) && c.dataCap.cfg.Pause {
+				atomic.StoreInt32(&c.dataCapReached, 1)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// checkDataCap adds traffic since the last check to the period's usage,
+// raises any warnings due, and reports whether the cap is reached.
+func (c *Client) checkDataCap(now time.Time) bool {
+	s := c.dataCap
+	total := atomic.LoadUint64(&c.stats.bytesIn) + atomic.LoadUint64(&c.stats.bytesOut)
+
+	s.mu.Lock()
+	if !now.Before(s.end) {
+		s.start, s.end = s.cfg.period(now)
+		s.used, s.sinceUsed, s.since = 0, 0, now
+		s.fired, s.forecastWarned, s.reachedWarned = 0, false, false
+		s.saved = 0
+	}
+	s.used += total - s.counted
+	s.counted = total
+
+	var warnings []Warning
+	details := map[string]interface{}{"used": s.used, "limit": s.cfg.Bytes, "periodEnd": s.end}
+	for ; s.fired < len(s.cfg.Thresholds); s.fired++ {
+		t := s.cfg.Thresholds[s.fired]
+		if float64(s.used) < t*float64(s.cfg.Bytes) {
+			break
+		}
+		warnings = append(warnings, Warning{
+			Code:    WarnDataCapNearing,
+			Message: fmt.Sprintf("data cap %.0f%% used (%d of %d bytes)", t*100, s.used, s.cfg.Bytes),
+			Details: details,
+		})
+	}
+	reached := s.used >= s.cfg.Bytes
+	if reached && !s.reachedWarned {
+		s.reachedWarned = true
+		msg := "data cap reached"
+		if s.cfg.Pause {
+			msg += fmt.Sprintf("; tunnel paused until %s", s.end.Format(time.RFC1123))
+		}
+		warnings = append(warnings, Warning{Code: WarnDataCapReached, Message: msg, Details: details})
+	}
+	if forecast := s.forecast(now); !reached && !s.forecastWarned && forecast > s.cfg.Bytes && now.Sub(s.since) >= s.end.Sub(s.start)/24 {
+		s.forecastWarned = true
+		warnings = append(warnings, Warning{
+			Code:    WarnDataCapForecast,
+			Message: fmt.Sprintf("data cap forecast to be exceeded: %d of %d bytes by %s", forecast, s.cfg.Bytes, s.end.Format(time.RFC1123)),
+			Details: details,
+		})
+	}
+	save := s.used-s.saved >= dataCapSaveEvery
+	s.mu.Unlock()
+
+	if save {
+		s.save()
+	}
+	for _, w := range warnings {
+		w.Type = MsgTypeWarning
+		c.handleWarning(w)
+	}
+	return reached
+}
+
+// forecast projects usage to the end of the period. Callers hold s.mu.
+func (s *dataCapState) forecast(now time.Time) uint64 {
+	elapsed := now.Sub(s.since)
+	if elapsed <= 0 || !now.Before(s.end) {
+		return s.used
+	}
+	rate := float64(s.used-s.sinceUsed) / elapsed.Seconds()
+	return s.used + uint64(rate*s.end.Sub(now).Seconds())
+}
+
+// dataCapPausedUntil returns when the period ends if the cap is reached and
+// pausing is on, or the zero time.
+func (c *Client) dataCapPausedUntil(now time.Time) time.Time {
+	if c.dataCap == nil || !c.dataCap.cfg.Pause || !c.checkDataCap(now) {
+		return time.Time{}
+	}
+	c.dataCap.mu.Lock()
+	defer c.dataCap.mu.Unlock()
+	return c.dataCap.end
+}
+
+// connectCapped keeps the tunnel closed while the data cap is reached.
+func (c *Client) connectCapped(ctx context.Context) error {
+	for {
+		if until := c.dataCapPausedUntil(time.Now()); !until.IsZero() {
+			c.setState(StateIdle)
+			c.logf("Tunnel paused by data cap until %s", until.Format(time.RFC1123))
+			c.auditf(AuditDataCapPause, map[string]string{"until": until.Format(time.RFC3339)})
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(until)):
+			}
+			continue
+		}
+		err := c.connectWindows(ctx)
+		if ctx.Err() != nil || !errors.Is(err, ErrDataCapReached) {
+			return err
+		}
+	}
+}
+
+func (s *dataCapState) load() error {
+	if s.cfg.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.cfg.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f dataCapFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f.PeriodStart.Equal(s.start) {
+		s.used, s.sinceUsed, s.saved = f.Used, f.Used, f.Used
+	}
+	return nil
+}
+
+// save writes the period's usage to the state file, if there is one.
+func (s *dataCapState) save() {
+	if s.cfg.StateFile == "" {
+		return
+	}
+	s.mu.Lock()
+	f := dataCapFile{PeriodStart: s.start, Used: s.used}
+	s.saved = s.used
+	s.mu.Unlock()
+
+	data, _ := json.Marshal(f)
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	tmp := s.cfg.StateFile + ".tmp"
+	if os.WriteFile(tmp, data, 0o600) == nil {
+		os.Rename(tmp, s.cfg.StateFile)
+	}
+}
@@ -0,0 +1,138 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDataCapPeriod(t *testing.T) {
+	now := time.Date(2026, 3, 10, 15, 4, 0, 0, time.UTC)
+	tests := []struct {
+		cap        DataCap
+		start, end time.Time
+	}{
+		{DataCap{ResetDay: 1}, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{DataCap{ResetDay: 15}, time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{DataCap{ResetDay: 10}, time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 4, 10, 0, 0, 0, 0, time.UTC)},
+		{DataCap{Period: Daily}, time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		start, end := tt.cap.period(now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%+v: got %v-%v, want %v-%v", tt.cap, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestDataCapOptionErrors(t *testing.T) {
+	for _, opt := range []Option{
+		WithDataCap(0),
+		WithDataCap(1<<30, WithDataCapResetDay(31)),
+	} {
+		if err := NewClient(opt).Connect(context.Background()); err == nil {
+			t.Error("Expected a config error")
+		}
+	}
+}
+
+func TestDataCapWarnings(t *testing.T) {
+	var codes []string
+	c := NewClient(
+		WithDataCap(1000, WithDataCapPeriod(Daily)),
+		WithOnWarning(func(w Warning) { codes = append(codes, w.Code) }),
+	)
+	now := time.Now()
+
+	atomic.StoreUint64(&c.stats.bytesIn, 500)
+	if c.checkDataCap(now) {
+		t.Fatal("Cap reached at 50%")
+	}
+	atomic.StoreUint64(&c.stats.bytesOut, 450)
+	c.checkDataCap(now)
+	if len(codes) != 2 || codes[0] != WarnDataCapNearing || codes[1] != WarnDataCapNearing {
+		t.Fatalf("Expected 80%% and 90%% warnings, got %v", codes)
+	}
+	atomic.StoreUint64(&c.stats.bytesOut, 600)
+	if !c.checkDataCap(now) || !c.checkDataCap(now) {
+		t.Fatal("Cap not reached at 110%")
+	}
+	if len(codes) != 3 || codes[2] != WarnDataCapReached {
+		t.Fatalf("Expected one reached warning, got %v", codes)
+	}
+	if u := c.DataUsage(); u.Used != 1100 || u.Limit != 1000 || u.Paused {
+		t.Errorf("Unexpected usage: %+v", u)
+	}
+
+	// A new period starts from zero.
+	if c.checkDataCap(now.Add(24 * time.Hour)) {
+		t.Error("Cap still reached in the next period")
+	}
+}
+
+func TestDataCapForecast(t *testing.T) {
+	var codes []string
+	c := NewClient(
+		WithDataCap(1000, WithDataCapPeriod(Daily)),
+		WithOnWarning(func(w Warning) { codes = append(codes, w.Code) }),
+	)
+	s := c.dataCap
+	s.start = time.Now().Truncate(time.Hour).Add(-10 * time.Hour)
+	s.end = s.start.Add(24 * time.Hour)
+	s.since = s.start
+
+	// 300 bytes in 10 hours: forecast is ~720, under the cap.
+	atomic.StoreUint64(&c.stats.bytesIn, 300)
+	c.checkDataCap(s.start.Add(10 * time.Hour))
+	if len(codes) != 0 {
+		t.Fatalf("Unexpected warnings: %v", codes)
+	}
+	// 500 bytes in 10 hours: forecast is 1200.
+	atomic.StoreUint64(&c.stats.bytesIn, 500)
+	c.checkDataCap(s.start.Add(10 * time.Hour))
+	if len(codes) != 1 || codes[0] != WarnDataCapForecast {
+		t.Fatalf("Expected a forecast warning, got %v", codes)
+	}
+	if f := s.forecast(s.start.Add(10 * time.Hour)); f != 1200 {
+		t.Errorf("Forecast = %d, want 1200", f)
+	}
+}
+
+func TestDataCapPause(t *testing.T) {
+	c := NewClient(WithDataCap(100, WithDataCapPause()))
+	if !c.dataCapPausedUntil(time.Now()).IsZero() {
+		t.Fatal("Paused before the cap was reached")
+	}
+	atomic.StoreUint64(&c.stats.bytesIn, 100)
+	if until := c.dataCapPausedUntil(time.Now()); !until.Equal(c.dataCap.end) {
+		t.Fatalf("Expected a pause until %v, got %v", c.dataCap.end, until)
+	}
+	if !c.DataUsage().Paused {
+		t.Error("Usage doesn't report the pause")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Connect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Connect to wait out the pause, got %v", err)
+	}
+	if c.Status().State != StateIdle {
+		t.Errorf("State = %v, want idle", c.Status().State)
+	}
+}
+
+func TestDataCapStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	c := NewClient(WithDataCap(1<<30, WithDataCapStateFile(path)))
+	atomic.StoreUint64(&c.stats.bytesIn, 2<<20)
+	c.checkDataCap(time.Now())
+
+	c = NewClient(WithDataCap(1<<30, WithDataCapStateFile(path)))
+	atomic.StoreUint64(&c.stats.bytesIn, 10)
+	if u := c.DataUsage(); u.Used != 2<<20+10 {
+		t.Errorf("Used = %d, want usage carried over from the state file", u.Used)
+	}
+}
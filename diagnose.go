@@ -0,0 +1,264 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	diagTimeout = 10 * time.Second
+	// diagPadding is sent in one header to push the upgrade request over
+	// several full-size packets.
+	diagPadding = 8 << 10
+)
+
+type DiagnosticStatus string
+
+const (
+	DiagnosticOK      DiagnosticStatus = "ok"
+	DiagnosticWarn    DiagnosticStatus = "warn"
+	DiagnosticFail    DiagnosticStatus = "fail"
+	DiagnosticSkipped DiagnosticStatus = "skipped"
+)
+
+type DiagnosticCheck struct {
+	Name     string           `json:"name"`
+	Status   DiagnosticStatus `json:"status"`
+	Detail   string           `json:"detail,omitempty"`
+	Duration time.Duration    `json:"duration,omitempty"`
+}
+
+// DiagnosticReport is the result of Diagnose. It marshals to JSON for
+// attaching to support requests.
+type DiagnosticReport struct {
+	ServerURL string            `json:"serverUrl"`
+	Time      time.Time         `json:"time"`
+	Checks    []DiagnosticCheck `json:"checks"`
+}
+
+// OK reports whether no check failed. Warnings don't count.
+func (r *DiagnosticReport) OK() bool {
+	for _, check := range r.Checks {
+		if check.Status == DiagnosticFail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *DiagnosticReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Server: %s\n", r.ServerURL)
+	for _, check := range r.Checks {
+		fmt.Fprintf(&b, "[%-7s] %s", check.Status, check.Name)
+		if check.Detail != "" {
+			fmt.Fprintf(&b, ": %s", check.Detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (r *DiagnosticReport) add(name string, status DiagnosticStatus, took time.Duration, format string, args ...any) {
+	r.Checks = append(r.Checks, DiagnosticCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...), Duration: took})
+}
+
+// Diagnose checks the environment the client would run in, as configured
+// by opts: DNS resolution of the server, WebSocket reachability directly
+// and through the proxy from HTTPS_PROXY and friends, clock skew against
+// the server, the local services, and whether large packets get through
+// (a path MTU problem, common on VPNs, stalls connections that small
+// requests make fine). Nothing is exposed.
+func Diagnose(ctx context.Context, opts ...Option) *DiagnosticReport {
+	return NewClient(opts...).diagnose(ctx, http.ProxyFromEnvironment)
+}
+
+func (c *Client) diagnose(ctx context.Context, proxy func(*http.Request) (*url.URL, error)) *DiagnosticReport {
+	r := &DiagnosticReport{ServerURL: c.serverURL(), Time: time.Now()}
+	if c.configErr != nil {
+		r.add("configuration", DiagnosticFail, 0, "%v", c.configErr)
+		return r
+	}
+	u, err := url.Parse(r.ServerURL)
+	if err != nil || u.Host == "" {
+		r.add("configuration", DiagnosticFail, 0, "invalid server URL %q", r.ServerURL)
+		return r
+	}
+
+	c.diagnoseDNS(ctx, r, u.Hostname())
+
+	// A later check reuses whichever path reached the server.
+	var reached func(*http.Request) (*url.URL, error)
+	var resp *http.Response
+	var sent, received time.Time
+
+	start := time.Now()
+	if res, err := c.diagDial(ctx, nil, nil); err != nil {
+		r.add("websocket (direct)", DiagnosticFail, time.Since(start), "%s", diagDialError(err))
+	} else {
+		r.add("websocket (direct)", res.status(), res.took, "%s", res.detail())
+		reached, resp, sent, received = noProxy, res.resp, start, start.Add(res.took)
+	}
+
+	probe := &http.Request{URL: &url.URL{Scheme: strings.Replace(u.Scheme, "ws", "http", 1), Host: u.Host}}
+	if proxyURL, err := proxy(probe); err != nil {
+		r.add("websocket (proxy)", DiagnosticFail, 0, "invalid proxy settings: %v", err)
+	} else if proxyURL == nil {
+		r.add("websocket (proxy)", DiagnosticSkipped, 0, "no proxy configured for %s", u.Hostname())
+	} else {
+		via := http.ProxyURL(proxyURL)
+		start := time.Now()
+		if res, err := c.diagDial(ctx, via, nil); err != nil {
+			r.add("websocket (proxy)", DiagnosticFail, time.Since(start), "via %s: %s", proxyURL.Redacted(), diagDialError(err))
+		} else {
+			r.add("websocket (proxy)", res.status(), res.took, "via %s: %s", proxyURL.Redacted(), res.detail())
+			if reached == nil {
+				reached, resp, sent, received = via, res.resp, start, start.Add(res.took)
+			}
+		}
+	}
+
+	c.diagnoseClock(r, resp, sent, received)
+	for _, t := range c.plannedTunnels(c.openTunnelRequest(MsgTypeOpenTunnel)) {
+		start := time.Now()
+		detail, err := c.probeLocal(ctx, t.Protocol)
+		if err != nil {
+			r.add("local "+t.Protocol, DiagnosticFail, time.Since(start), "%s: %v", t.Local, err)
+		} else {
+			r.add("local "+t.Protocol, DiagnosticOK, time.Since(start), "%s: %s", t.Local, detail)
+		}
+	}
+	c.diagnoseMTU(ctx, r, reached)
+	return r
+}
+
+func noProxy(*http.Request) (*url.URL, error) { return nil, nil }
+
+func (c *Client) diagnoseDNS(ctx context.Context, r *DiagnosticReport, host string) {
+	if net.ParseIP(host) != nil {
+		r.add("dns", DiagnosticSkipped, 0, "server is an IP address")
+		return
+	}
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	took := time.Since(start)
+	switch {
+	case err != nil:
+		r.add("dns", DiagnosticFail, took, "%v", err)
+	case took > 2*time.Second:
+		r.add("dns", DiagnosticWarn, took, "%s resolved to %s, but took %s", host, strings.Join(addrs, ", "), took.Round(time.Millisecond))
+	default:
+		r.add("dns", DiagnosticOK, took, "%s resolved to %s", host, strings.Join(addrs, ", "))
+	}
+}
+
+func (c *Client) diagnoseClock(r *DiagnosticReport, resp *http.Response, sent, received time.Time) {
+	if resp == nil {
+		r.add("clock", DiagnosticSkipped, 0, "server not reached")
+		return
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		r.add("clock", DiagnosticSkipped, 0, "server sent no Date header")
+		return
+	}
+	// Date has second resolution; allow for it as skewFromDate does.
+	skew := date.Add(500 * time.Millisecond).Sub(sent.Add(received.Sub(sent) / 2)).Round(time.Second)
+	if abs(skew) > maxClockSkew {
+		r.add("clock", DiagnosticWarn, 0, "system clock is off by %s; the client compensates, but signed tokens and TLS may fail (check NTP)", skew)
+		return
+	}
+	r.add("clock", DiagnosticOK, 0, "within %s of the server", max(abs(skew), time.Second))
+}
+
+// diagnoseMTU repeats the upgrade with a padded request. When small
+// requests get through and this one stalls, full-size packets are being
+// dropped on the way.
+func (c *Client) diagnoseMTU(ctx context.Context, r *DiagnosticReport, proxy func(*http.Request) (*url.URL, error)) {
+	if proxy == nil {
+		r.add("mtu", DiagnosticSkipped, 0, "server not reached")
+		return
+	}
+	header := http.Header{"X-Outray-Padding": {strings.Repeat("x", diagPadding)}}
+	start := time.Now()
+	res, err := c.diagDial(ctx, proxy, header)
+	took := time.Since(start)
+	var ne net.Error
+	switch {
+	case err == nil:
+		r.add("mtu", DiagnosticOK, res.took, "%d KiB request got through", diagPadding>>10)
+	case errors.As(err, &ne) && ne.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		r.add("mtu", DiagnosticFail, took, "small requests reach the server but a %d KiB one stalls; large packets are being dropped, likely a path MTU problem (VPN, PPPoE). Try a lower MTU on the interface", diagPadding>>10)
+	default:
+		r.add("mtu", DiagnosticWarn, took, "%d KiB request failed: %s", diagPadding>>10, diagDialError(err))
+	}
+}
+
+type diagResult struct {
+	resp *http.Response
+	took time.Duration
+}
+
+// status is warn when the server answered but refused the upgrade: it is
+// reachable, but the tunnel won't open as configured.
+func (d diagResult) status() DiagnosticStatus {
+	if d.resp != nil && d.resp.StatusCode != http.StatusSwitchingProtocols {
+		return DiagnosticWarn
+	}
+	return DiagnosticOK
+}
+
+func (d diagResult) detail() string {
+	if d.resp != nil && d.resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Sprintf("server reachable but refused the upgrade: %s", d.resp.Status)
+	}
+	return fmt.Sprintf("connected in %s", d.took.Round(time.Millisecond))
+}
+
+// diagDial opens and closes one WebSocket connection. An upgrade the
+// server refuses still counts as reaching it.
+func (c *Client) diagDial(ctx context.Context, proxy func(*http.Request) (*url.URL, error), extra http.Header) (diagResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, diagTimeout)
+	defer cancel()
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = proxy
+	dialer.Subprotocols = c.conf().Subprotocols
+	header := c.dialHeader()
+	for k, v := range extra {
+		header[k] = v
+	}
+
+	start := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, c.serverURL(), header)
+	took := time.Since(start)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return diagResult{resp: resp, took: took}, nil
+		}
+		return diagResult{}, err
+	}
+	conn.Close()
+	return diagResult{resp: resp, took: took}, nil
+}
+
+func diagDialError(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("cannot resolve %s: %v", dnsErr.Name, dnsErr.Err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out; a firewall may be dropping the connection"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return fmt.Sprintf("cannot connect: %v", opErr.Err)
+	}
+	return err.Error()
+}
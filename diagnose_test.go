@@ -0,0 +1,119 @@
+package outray
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// diagServer accepts WebSocket upgrades with a Date header skew ahead of
+// the local clock.
+func diagServer(t *testing.T, skew time.Duration) string {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := http.Header{"Date": {time.Now().Add(skew).UTC().Format(http.TimeFormat)}}
+		conn, err := upgrader.Upgrade(w, r, h)
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+// connectProxy is a minimal HTTP CONNECT proxy.
+func connectProxy(t *testing.T) *url.URL {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		client, _, _ := w.(http.Hijacker).Hijack()
+		go func() {
+			io.Copy(upstream, client)
+			upstream.Close()
+		}()
+		io.Copy(client, upstream)
+		client.Close()
+	}))
+	t.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	return u
+}
+
+func checkStatus(t *testing.T, r *DiagnosticReport, name string, want DiagnosticStatus) {
+	t.Helper()
+	for _, check := range r.Checks {
+		if check.Name == name {
+			if check.Status != want {
+				t.Errorf("%s: %s (%s), want %s", name, check.Status, check.Detail, want)
+			}
+			return
+		}
+	}
+	t.Errorf("No %s check in:\n%s", name, r)
+}
+
+func TestDiagnose(t *testing.T) {
+	server := diagServer(t, 0)
+	proxy := connectProxy(t)
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+
+	c := NewClient(WithServerURL(server), WithUpstreamFallback(local.Addr().String()))
+	r := c.diagnose(context.Background(), http.ProxyURL(proxy))
+	if !r.OK() {
+		t.Errorf("Expected a clean report:\n%s", r)
+	}
+	checkStatus(t, r, "dns", DiagnosticSkipped)
+	checkStatus(t, r, "websocket (direct)", DiagnosticOK)
+	checkStatus(t, r, "websocket (proxy)", DiagnosticOK)
+	checkStatus(t, r, "clock", DiagnosticOK)
+	checkStatus(t, r, "local http", DiagnosticOK)
+	checkStatus(t, r, "mtu", DiagnosticOK)
+}
+
+func TestDiagnoseProblems(t *testing.T) {
+	server := diagServer(t, 5*time.Minute)
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := closed.Addr().String()
+	closed.Close()
+
+	c := NewClient(WithServerURL(strings.Replace(server, "127.0.0.1", "localhost", 1)), WithUpstreamFallback(addr))
+	r := c.diagnose(context.Background(), noProxy)
+	if r.OK() {
+		t.Errorf("Expected a failing report:\n%s", r)
+	}
+	checkStatus(t, r, "dns", DiagnosticOK)
+	checkStatus(t, r, "websocket (proxy)", DiagnosticSkipped)
+	checkStatus(t, r, "clock", DiagnosticWarn)
+	checkStatus(t, r, "local http", DiagnosticFail)
+}
+
+func TestDiagnoseUnreachable(t *testing.T) {
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := closed.Addr().String()
+	closed.Close()
+
+	r := NewClient(WithServerURL("ws://"+addr), WithPort(1)).diagnose(context.Background(), noProxy)
+	checkStatus(t, r, "websocket (direct)", DiagnosticFail)
+	checkStatus(t, r, "clock", DiagnosticSkipped)
+	checkStatus(t, r, "mtu", DiagnosticSkipped)
+}
@@ -0,0 +1,33 @@
+package outray
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func WithDialHeaders(h http.Header) Option {
+	return func(c *Client) {
+		c.config.DialHeaders = h
+	}
+}
+
+func WithSubprotocols(protocols ...string) Option {
+	return func(c *Client) {
+		c.config.Subprotocols = protocols
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = c.conf().Subprotocols
+
+	sent := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, c.serverURL(), c.dialHeader())
+	if err == nil {
+		c.skewFromDate(resp, sent, time.Now())
+	}
+	return conn, err
+}
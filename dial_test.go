@@ -0,0 +1,72 @@
+package outray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDialHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upgrader := websocket.Upgrader{Subprotocols: []string{"outray.v2"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+	serverURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	dial := func(opts ...Option) (http.Header, string) {
+		t.Helper()
+		c := NewClient(append([]Option{WithServerURL(serverURL), WithServerFlavor(SelfHosted), WithAPIKey("key")}, opts...)...)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		conn, err := c.dial(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return <-headers, conn.Subprotocol()
+	}
+
+	custom := http.Header{"X-Team": {"edge"}}
+	h, proto := dial(WithDialHeaders(custom), WithSubprotocols("outray.v2"))
+	if h.Get("X-Team") != "edge" || h.Get("Authorization") != "Bearer key" {
+		t.Errorf("Expected dial headers merged with the API key, got %v", h)
+	}
+	if proto != "outray.v2" {
+		t.Errorf("Expected subprotocol outray.v2, got %q", proto)
+	}
+	if custom.Get("Authorization") != "" {
+		t.Error("Expected the caller's headers to be left unchanged")
+	}
+
+	h, _ = dial(WithDialHeaders(http.Header{"Authorization": {"Basic abc"}}))
+	if got := h.Values("Authorization"); len(got) != 1 || got[0] != "Basic abc" {
+		t.Errorf("Expected an explicit Authorization header to win, got %v", got)
+	}
+
+	h, _ = dial(WithServerFlavor(Hosted))
+	if h.Get("Authorization") != "" {
+		t.Errorf("Expected no Authorization header for the hosted service, got %q", h.Get("Authorization"))
+	}
+
+	h, _ = dial(WithAPIKey("sk-live-123"), WithHandshakeProof(ProofHMAC), WithDialHeaders(custom))
+	for name, values := range h {
+		for _, v := range values {
+			if strings.Contains(v, "sk-live-123") {
+				t.Errorf("Expected the API key off the wire under ProofHMAC, got %s: %s", name, v)
+			}
+		}
+	}
+	if h.Get("X-Team") != "edge" {
+		t.Errorf("Expected custom dial headers under ProofHMAC, got %v", h)
+	}
+}
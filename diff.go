@@ -0,0 +1,181 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Change is one difference between two exchanges. Path names the field,
+// e.g. "status", "headers.Content-Type", "body.user.id", or "body:12" for
+// line 12 of a non-JSON body.
+type Change struct {
+	Path string      `json:"path"`
+	Kind string      `json:"kind"`
+	A    interface{} `json:"a,omitempty"`
+	B    interface{} `json:"b,omitempty"`
+}
+
+type ExchangeDiff struct {
+	Request  []Change `json:"request"`
+	Response []Change `json:"response"`
+}
+
+// maxLineDiff bounds the line-diff table; larger text bodies are reported
+// as a single change.
+const maxLineDiff = 1 << 20
+
+func DiffEntries(a, b JournalEntry) ExchangeDiff {
+	var d ExchangeDiff
+	if a.Request.Method != b.Request.Method {
+		d.Request = append(d.Request, Change{Path: "method", Kind: ChangeChanged, A: a.Request.Method, B: b.Request.Method})
+	}
+	if a.Request.Path != b.Request.Path {
+		d.Request = append(d.Request, Change{Path: "path", Kind: ChangeChanged, A: a.Request.Path, B: b.Request.Path})
+	}
+	d.Request = append(d.Request, diffHeaders(a.Request.Headers, b.Request.Headers)...)
+	d.Request = append(d.Request, diffBody(a.Request.Body, b.Request.Body)...)
+
+	if a.Response.StatusCode != b.Response.StatusCode {
+		d.Response = append(d.Response, Change{Path: "status", Kind: ChangeChanged, A: a.Response.StatusCode, B: b.Response.StatusCode})
+	}
+	d.Response = append(d.Response, diffHeaders(a.Response.Headers, b.Response.Headers)...)
+	d.Response = append(d.Response, diffBody(a.Response.Body, b.Response.Body)...)
+	return d
+}
+
+func diffHeaders(a, b map[string]string) []Change {
+	var changes []Change
+	for _, k := range unionKeys(a, b) {
+		va, inA := a[k]
+		vb, inB := b[k]
+		switch {
+		case !inA:
+			changes = append(changes, Change{Path: "headers." + k, Kind: ChangeAdded, B: vb})
+		case !inB:
+			changes = append(changes, Change{Path: "headers." + k, Kind: ChangeRemoved, A: va})
+		case va != vb:
+			changes = append(changes, Change{Path: "headers." + k, Kind: ChangeChanged, A: va, B: vb})
+		}
+	}
+	return changes
+}
+
+func diffBody(a, b []byte) []Change {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	var ja, jb interface{}
+	if json.Unmarshal(a, &ja) == nil && json.Unmarshal(b, &jb) == nil {
+		return diffJSON("body", ja, jb, nil)
+	}
+	return diffLines(string(a), string(b))
+}
+
+func diffJSON(path string, a, b interface{}, changes []Change) []Change {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, k := range unionKeys(va, vb) {
+			ca, inA := va[k]
+			cb, inB := vb[k]
+			switch {
+			case !inA:
+				changes = append(changes, Change{Path: path + "." + k, Kind: ChangeAdded, B: cb})
+			case !inB:
+				changes = append(changes, Change{Path: path + "." + k, Kind: ChangeRemoved, A: ca})
+			default:
+				changes = diffJSON(path+"."+k, ca, cb, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(va) || i < len(vb); i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(va):
+				changes = append(changes, Change{Path: p, Kind: ChangeAdded, B: vb[i]})
+			case i >= len(vb):
+				changes = append(changes, Change{Path: p, Kind: ChangeRemoved, A: va[i]})
+			default:
+				changes = diffJSON(p, va[i], vb[i], changes)
+			}
+		}
+		return changes
+	default:
+		if a == b {
+			return changes
+		}
+	}
+	return append(changes, Change{Path: path, Kind: ChangeChanged, A: a, B: b})
+}
+
+// diffLines reports added and removed lines using a longest common
+// subsequence, numbering lines from 1 in whichever side they belong to.
+func diffLines(a, b string) []Change {
+	la, lb := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(la)*len(lb) > maxLineDiff {
+		return []Change{{Path: "body", Kind: ChangeChanged, A: fmt.Sprintf("%d bytes", len(a)), B: fmt.Sprintf("%d bytes", len(b))}}
+	}
+
+	lcs := make([][]int, len(la)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(lb)+1)
+	}
+	for i := len(la) - 1; i >= 0; i-- {
+		for j := len(lb) - 1; j >= 0; j-- {
+			if la[i] == lb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var changes []Change
+	i, j := 0, 0
+	for i < len(la) || j < len(lb) {
+		switch {
+		case i < len(la) && j < len(lb) && la[i] == lb[j]:
+			i++
+			j++
+		case i < len(la) && (j == len(lb) || lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, Change{Path: "body:" + strconv.Itoa(i+1), Kind: ChangeRemoved, A: la[i]})
+			i++
+		default:
+			changes = append(changes, Change{Path: "body:" + strconv.Itoa(j+1), Kind: ChangeAdded, B: lb[j]})
+			j++
+		}
+	}
+	return changes
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
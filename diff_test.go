@@ -0,0 +1,37 @@
+package outray
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffEntries(t *testing.T) {
+	a := JournalEntry{
+		Request:  IncomingRequest{Method: "POST", Path: "/charge", Headers: map[string]string{"Idempotency-Key": "k1", "X-Retry": "0"}, Body: []byte(`{"amount":100,"items":["a"]}`)},
+		Response: IncomingResponse{StatusCode: 500, Body: []byte("error\ntimeout\n")},
+	}
+	b := JournalEntry{
+		Request:  IncomingRequest{Method: "POST", Path: "/charge", Headers: map[string]string{"Idempotency-Key": "k1", "Authorization": "Bearer x"}, Body: []byte(`{"amount":150,"items":["a","b"]}`)},
+		Response: IncomingResponse{StatusCode: 200, Body: []byte("ok\ntimeout\n")},
+	}
+
+	d := DiffEntries(a, b)
+	wantReq := []Change{
+		{Path: "headers.Authorization", Kind: ChangeAdded, B: "Bearer x"},
+		{Path: "headers.X-Retry", Kind: ChangeRemoved, A: "0"},
+		{Path: "body.amount", Kind: ChangeChanged, A: 100.0, B: 150.0},
+		{Path: "body.items[1]", Kind: ChangeAdded, B: "b"},
+	}
+	if !reflect.DeepEqual(d.Request, wantReq) {
+		t.Errorf("Unexpected request diff:\n got %+v\nwant %+v", d.Request, wantReq)
+	}
+
+	wantResp := []Change{
+		{Path: "status", Kind: ChangeChanged, A: 500, B: 200},
+		{Path: "body:1", Kind: ChangeRemoved, A: "error"},
+		{Path: "body:1", Kind: ChangeAdded, B: "ok"},
+	}
+	if !reflect.DeepEqual(d.Response, wantResp) {
+		t.Errorf("Unexpected response diff:\n got %+v\nwant %+v", d.Response, wantResp)
+	}
+}
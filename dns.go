@@ -0,0 +1,146 @@
+package outray
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDNSCacheTTL = 30 * time.Second
+
+type Resolver interface {
+	Resolve(ctx context.Context, host string) (ips []net.IP, ttl time.Duration, err error)
+}
+
+type ResolverFunc func(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	return f(ctx, host)
+}
+
+func WithResolver(r Resolver) Option {
+	return func(c *Client) {
+		c.config.Resolver = r
+	}
+}
+
+func WithDNSCache(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.config.DNSCacheTTL = ttl
+	}
+}
+
+func WithStaticHosts(hosts map[string]string) Option {
+	return func(c *Client) {
+		c.config.StaticHosts = hosts
+	}
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+func (c *Client) customResolution() bool {
+	return c.conf().Resolver != nil || c.conf().DNSCacheTTL > 0 || len(c.conf().StaticHosts) > 0
+}
+
+func (c *Client) resolveHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip, ok := c.conf().StaticHosts[strings.ToLower(host)]; ok {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			return []net.IP{parsed}, nil
+		}
+		host = ip
+	}
+
+	c.dns.mu.Lock()
+	entry, cached := c.dns.entries[host]
+	c.dns.mu.Unlock()
+	if cached && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	ips, ttl, err := c.lookup(ctx, host)
+	if err != nil {
+		if cached {
+			c.logf("DNS lookup for %s failed, using stale entry: %v", host, err)
+			return entry.ips, nil
+		}
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = c.conf().DNSCacheTTL
+	}
+	if ttl <= 0 {
+		ttl = defaultDNSCacheTTL
+	}
+	c.dns.mu.Lock()
+	if c.dns.entries == nil {
+		c.dns.entries = make(map[string]dnsEntry)
+	}
+	c.dns.entries[host] = dnsEntry{ips: ips, expires: time.Now().Add(ttl)}
+	c.dns.mu.Unlock()
+	return ips, nil
+}
+
+func (c *Client) lookup(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	if c.conf().Resolver != nil {
+		return c.conf().Resolver.Resolve(ctx, host)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, 0, nil
+}
+
+func (c *Client) filterFamily(ips []net.IP) []net.IP {
+	if c.conf().IPFamily == DualStack {
+		return ips
+	}
+	var out []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (c.conf().IPFamily == IPv4Only) {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+func (c *Client) dialResolved(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return c.upstreamDialer().DialContext(ctx, c.upstreamNetwork(network), addr)
+	}
+
+	ips, err := c.resolveHost(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	ips = c.filterFamily(ips)
+	if len(ips) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := c.upstreamDialer().DialContext(ctx, c.upstreamNetwork(network), net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
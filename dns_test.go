@@ -0,0 +1,40 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResolveHostStaleOnFailure(t *testing.T) {
+	calls := 0
+	c := NewClient(WithResolver(ResolverFunc(func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		calls++
+		if calls > 1 {
+			return nil, 0, errors.New("dns hiccup")
+		}
+		return []net.IP{net.ParseIP("10.0.0.1")}, time.Nanosecond, nil
+	})))
+
+	if _, err := c.resolveHost(context.Background(), "app.internal"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	ips, err := c.resolveHost(context.Background(), "app.internal")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Expected stale answer after failure, got %v %v", ips, err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected expired entry to be refreshed, got %d lookups", calls)
+	}
+}
+
+func TestStaticHosts(t *testing.T) {
+	c := NewClient(WithStaticHosts(map[string]string{"api.local": "127.0.0.1"}))
+	ips, err := c.resolveHost(context.Background(), "api.local")
+	if err != nil || !ips[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected static host mapping, got %v %v", ips, err)
+	}
+}
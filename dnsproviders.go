@@ -0,0 +1,261 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDNSTTL = 300
+
+// CloudflareDNS creates records through the Cloudflare API. The token
+// needs Zone.DNS edit permission, plus Zone.Zone read if ZoneID is empty
+// and the zone has to be looked up from the record name.
+type CloudflareDNS struct {
+	APIToken string
+	ZoneID   string
+	Endpoint string       // https://api.cloudflare.com/client/v4 if empty
+	Client   *http.Client // http.DefaultClient if nil
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+func (cf *CloudflareDNS) UpsertRecord(ctx context.Context, r DNSRecord) error {
+	zone := cf.ZoneID
+	if zone == "" {
+		var err error
+		if zone, err = cf.findZone(ctx, r.Name); err != nil {
+			return err
+		}
+	}
+
+	var existing []struct {
+		ID string `json:"id"`
+	}
+	q := url.Values{"type": {r.Type}, "name": {strings.TrimSuffix(r.Name, ".")}}
+	if err := cf.do(ctx, http.MethodGet, "/zones/"+zone+"/dns_records?"+q.Encode(), nil, &existing); err != nil {
+		return err
+	}
+
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = 1 // automatic
+	}
+	body := map[string]interface{}{"type": r.Type, "name": strings.TrimSuffix(r.Name, "."), "content": r.Value, "ttl": ttl, "proxied": false}
+	if len(existing) > 0 {
+		return cf.do(ctx, http.MethodPut, "/zones/"+zone+"/dns_records/"+existing[0].ID, body, nil)
+	}
+	return cf.do(ctx, http.MethodPost, "/zones/"+zone+"/dns_records", body, nil)
+}
+
+// findZone tries each parent of name in turn, most specific first.
+func (cf *CloudflareDNS) findZone(ctx context.Context, name string) (string, error) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		q := url.Values{"name": {strings.Join(labels[i:], ".")}}
+		if err := cf.do(ctx, http.MethodGet, "/zones?"+q.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone found for %s", name)
+}
+
+func (cf *CloudflareDNS) do(ctx context.Context, method, path string, in, out interface{}) error {
+	endpoint := cf.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.cloudflare.com/client/v4"
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cf.APIToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClientOr(cf.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("cloudflare: %s: %w", resp.Status, err)
+	}
+	if !result.Success {
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("cloudflare: %s (code %d)", result.Errors[0].Message, result.Errors[0].Code)
+		}
+		return fmt.Errorf("cloudflare: %s", resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
+
+// Route53DNS creates records in an AWS Route 53 hosted zone with an UPSERT
+// change. The credentials need route53:ChangeResourceRecordSets on the zone.
+type Route53DNS struct {
+	HostedZoneID    string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string       // for temporary credentials
+	Endpoint        string       // https://route53.amazonaws.com if empty
+	Client          *http.Client // http.DefaultClient if nil
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action string   `xml:"Action"`
+	Name   string   `xml:"ResourceRecordSet>Name"`
+	Type   string   `xml:"ResourceRecordSet>Type"`
+	TTL    int      `xml:"ResourceRecordSet>TTL"`
+	Values []string `xml:"ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+}
+
+func (r53 *Route53DNS) UpsertRecord(ctx context.Context, r DNSRecord) error {
+	endpoint := r53.Endpoint
+	if endpoint == "" {
+		endpoint = "https://route53.amazonaws.com"
+	}
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+	value := r.Value
+	if strings.EqualFold(r.Type, "TXT") {
+		value = strconv.Quote(value)
+	}
+	change := route53ChangeRequest{Changes: []route53Change{{Action: "UPSERT", Name: r.Name, Type: r.Type, TTL: ttl, Values: []string{value}}}}
+	body, err := xml.Marshal(change)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+
+	zone := strings.TrimPrefix(r53.HostedZoneID, "/hostedzone/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/2013-04-01/hostedzone/"+zone+"/rrset", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	if r53.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", r53.SessionToken)
+	}
+	signV4(req, body, r53.AccessKeyID, r53.SecretAccessKey, "us-east-1", "route53", time.Now())
+
+	resp, err := httpClientOr(r53.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var awsErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if xml.Unmarshal(data, &awsErr) == nil && awsErr.Code != "" {
+			return fmt.Errorf("route53: %s: %s", awsErr.Code, awsErr.Message)
+		}
+		return fmt.Errorf("route53: %s", resp.Status)
+	}
+	return nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header, signing the
+// Host, X-Amz-* and Content-Type headers.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := sha256Hex(body)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{req.Method, path, req.URL.Query().Encode(), canonHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func httpClientOr(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
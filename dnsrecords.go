@@ -0,0 +1,131 @@
+package outray
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	dnsPollInterval      = 15 * time.Second
+	dnsValidationTimeout = 30 * time.Minute
+)
+
+// DNSRecord is a record a custom domain needs before the server will route
+// it. Servers list them in tunnel_opened while the domain is unvalidated.
+type DNSRecord struct {
+	Type  string `json:"type"` // CNAME, TXT, A or AAAA
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	TTL   int    `json:"ttl,omitempty"`
+}
+
+func (r DNSRecord) String() string {
+	return fmt.Sprintf("%s %s -> %s", r.Type, r.Name, r.Value)
+}
+
+// DNSProvider creates or updates a record at a DNS host. CloudflareDNS and
+// Route53DNS are provided.
+type DNSProvider interface {
+	UpsertRecord(ctx context.Context, r DNSRecord) error
+}
+
+// WithDNSProvider creates the records a custom domain needs through p as
+// soon as the server asks for them, then polls DNS until they resolve.
+// Without it the records are only logged; see Client.DNSRecords.
+func WithDNSProvider(p DNSProvider) Option {
+	return func(c *Client) {
+		c.config.DNSProvider = p
+	}
+}
+
+// DNSRecords returns the records the server last asked for, or nil once
+// the custom domain is validated.
+func (c *Client) DNSRecords() []DNSRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.dnsRecords)
+}
+
+// provisionDNS runs until the records resolve, the connection closes, or
+// dnsValidationTimeout passes.
+func (c *Client) provisionDNS(records []DNSRecord, connDone <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsValidationTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-connDone:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if c.conf().DNSProvider == nil {
+		for _, r := range records {
+			c.logf("Custom domain needs DNS record: %s", r)
+		}
+		return
+	}
+	for _, r := range records {
+		if err := c.conf().DNSProvider.UpsertRecord(ctx, r); err != nil {
+			c.logf("Failed to create DNS record %s: %v", r, err)
+			return
+		}
+		c.logf("Created DNS record %s", r)
+	}
+	if err := WaitForDNS(ctx, records, dnsPollInterval); err != nil {
+		c.logf("DNS records not visible yet: %v", err)
+		return
+	}
+	c.logf("DNS records for custom domain resolve")
+}
+
+// Lookups used by WaitForDNS, swapped out in tests.
+var (
+	lookupCNAME = net.DefaultResolver.LookupCNAME
+	lookupTXT   = net.DefaultResolver.LookupTXT
+	lookupHost  = net.DefaultResolver.LookupHost
+)
+
+// WaitForDNS polls every interval until each record resolves to its value,
+// returning ctx's error, wrapped with the first unresolved record, if it
+// ends first. Records of other types are not checked.
+func WaitForDNS(ctx context.Context, records []DNSRecord, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pending := slices.DeleteFunc(slices.Clone(records), func(r DNSRecord) bool {
+			return dnsRecordResolves(ctx, r)
+		})
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", pending[0], ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func dnsRecordResolves(ctx context.Context, r DNSRecord) bool {
+	switch strings.ToUpper(r.Type) {
+	case "CNAME":
+		target, err := lookupCNAME(ctx, r.Name)
+		return err == nil && sameDNSName(target, r.Value)
+	case "TXT":
+		values, err := lookupTXT(ctx, r.Name)
+		return err == nil && slices.Contains(values, r.Value)
+	case "A", "AAAA":
+		addrs, err := lookupHost(ctx, r.Name)
+		return err == nil && slices.Contains(addrs, r.Value)
+	}
+	return true
+}
+
+func sameDNSName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
@@ -0,0 +1,150 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeDNSProvider struct {
+	mu      sync.Mutex
+	records []DNSRecord
+}
+
+func (p *fakeDNSProvider) UpsertRecord(ctx context.Context, r DNSRecord) error {
+	p.mu.Lock()
+	p.records = append(p.records, r)
+	p.mu.Unlock()
+	return nil
+}
+
+func fakeLookups(t *testing.T, cnames map[string]string) {
+	prevCNAME, prevTXT := lookupCNAME, lookupTXT
+	t.Cleanup(func() { lookupCNAME, lookupTXT = prevCNAME, prevTXT })
+	lookupCNAME = func(ctx context.Context, host string) (string, error) {
+		if target, ok := cnames[host]; ok {
+			return target, nil
+		}
+		return "", errors.New("no such host")
+	}
+	lookupTXT = func(ctx context.Context, host string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+}
+
+func TestProvisionDNS(t *testing.T) {
+	fakeLookups(t, map[string]string{"app.example.com": "edge.outray.dev."})
+	p := &fakeDNSProvider{}
+	c := NewClient(WithCustomDomain("app.example.com"), WithDNSProvider(p))
+	c.closed = true
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://app.example.com","dnsRecords":[{"type":"CNAME","name":"app.example.com","value":"edge.outray.dev"}]}`), 0)
+	c.Wait()
+
+	want := DNSRecord{Type: "CNAME", Name: "app.example.com", Value: "edge.outray.dev"}
+	if len(p.records) != 1 || p.records[0] != want {
+		t.Errorf("Expected provider to create %v, got %v", want, p.records)
+	}
+	if got := c.DNSRecords(); len(got) != 1 || got[0] != want {
+		t.Errorf("Unexpected DNSRecords: %v", got)
+	}
+}
+
+func TestWaitForDNS(t *testing.T) {
+	fakeLookups(t, map[string]string{"a.example.com": "edge.outray.dev."})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	records := []DNSRecord{{Type: "CNAME", Name: "a.example.com", Value: "EDGE.outray.dev"}}
+	if err := WaitForDNS(ctx, records, 10*time.Millisecond); err != nil {
+		t.Errorf("Expected matching CNAME to pass, got %v", err)
+	}
+
+	records = append(records, DNSRecord{Type: "TXT", Name: "_outray.a.example.com", Value: "token"})
+	err := WaitForDNS(ctx, records, 10*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "TXT _outray.a.example.com") {
+		t.Errorf("Expected timeout naming the TXT record, got %v", err)
+	}
+}
+
+func TestCloudflareDNS(t *testing.T) {
+	var created map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer cf-token" {
+			t.Errorf("Missing bearer token")
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			if r.URL.Query().Get("name") == "example.com" {
+				io.WriteString(w, `{"success":true,"result":[{"id":"zone1"}]}`)
+			} else {
+				io.WriteString(w, `{"success":true,"result":[]}`)
+			}
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/zone1/dns_records":
+			io.WriteString(w, `{"success":true,"result":[]}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/zones/zone1/dns_records":
+			json.NewDecoder(r.Body).Decode(&created)
+			io.WriteString(w, `{"success":true,"result":{"id":"rec1"}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"success":false,"errors":[{"code":1004,"message":"unexpected request"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	cf := &CloudflareDNS{APIToken: "cf-token", Endpoint: srv.URL}
+	if err := cf.UpsertRecord(context.Background(), DNSRecord{Type: "CNAME", Name: "app.example.com", Value: "edge.outray.dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if created["name"] != "app.example.com" || created["content"] != "edge.outray.dev" || created["proxied"] != false {
+		t.Errorf("Unexpected record body: %v", created)
+	}
+
+	cf.ZoneID = "missing"
+	if err := cf.UpsertRecord(context.Background(), DNSRecord{Type: "CNAME", Name: "x.example.com", Value: "y"}); err == nil || !strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("Expected Cloudflare error message, got %v", err)
+	}
+}
+
+func TestRoute53DNS(t *testing.T) {
+	var body, auth, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, auth, path = string(data), r.Header.Get("Authorization"), r.URL.Path
+	}))
+	defer srv.Close()
+
+	r53 := &Route53DNS{HostedZoneID: "/hostedzone/Z123", AccessKeyID: "AKID", SecretAccessKey: "secret", Endpoint: srv.URL}
+	if err := r53.UpsertRecord(context.Background(), DNSRecord{Type: "TXT", Name: "_outray.example.com", Value: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/2013-04-01/hostedzone/Z123/rrset" {
+		t.Errorf("Unexpected path %s", path)
+	}
+	for _, want := range []string{"<Action>UPSERT</Action>", "<Type>TXT</Type>", "<TTL>300</TTL>", "<Value>&#34;token&#34;</Value>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %s in change batch: %s", want, body)
+		}
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/route53/aws4_request") {
+		t.Errorf("Unexpected Authorization: %s", auth)
+	}
+}
+
+// TestSignV4 uses the get-vanilla case from the AWS SigV4 test suite.
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Unexpected signature:\n got %s\nwant %s", got, want)
+	}
+}
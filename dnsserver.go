@@ -0,0 +1,181 @@
+package outray
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	dnsHeaderLen   = 12
+	dnsTypeOPT     = 41
+	dnsFlagTC      = 0x0200
+	dnsClassicSize = 512  // UDP limit without EDNS (RFC 1035)
+	dnsDefaultUDP  = 1232 // DNS Flag Day 2020 size, safe from fragmentation
+	dnsTCPTimeout  = 5 * time.Second
+)
+
+// DNSServerOptions configures WithDNSServer.
+type DNSServerOptions struct {
+	Port int // local DNS server port; 53 if zero
+
+	// RemotePort is the public port both tunnels ask for, so resolvers
+	// find the TCP side where they sent the UDP query. Required.
+	RemotePort int
+
+	// MaxUDPSize caps responses sent back over UDP, on top of the size the
+	// querier advertises with EDNS (512 without it). Defaults to 1232.
+	MaxUDPSize int
+}
+
+// WithDNSServer exposes a local DNS server on paired UDP and TCP tunnels
+// with the same public port, so resolvers can retry truncated answers over
+// TCP. UDP responses are relayed up to the querier's EDNS size; larger
+// ones are cut to the question with the TC bit set. When the local server
+// truncates a UDP answer (or doesn't answer UDP at all), the query is
+// retried over TCP and the full answer is relayed if it fits.
+func WithDNSServer(opts DNSServerOptions) Option {
+	return func(c *Client) {
+		if opts.Port == 0 {
+			opts.Port = 53
+		}
+		if opts.MaxUDPSize == 0 {
+			opts.MaxUDPSize = dnsDefaultUDP
+		}
+		if opts.RemotePort == 0 {
+			c.configErr = errors.New("dns server needs a remote port shared by its udp and tcp tunnels")
+			return
+		}
+		if opts.MaxUDPSize < dnsClassicSize {
+			c.configErr = fmt.Errorf("dns max udp size must be at least %d, got %d", dnsClassicSize, opts.MaxUDPSize)
+			return
+		}
+		c.config.DNSServer = &opts
+		c.UDP(opts.Port, WithTunnelRemotePort(opts.RemotePort))
+		c.TCP(opts.Port, WithTunnelRemotePort(opts.RemotePort))
+	}
+}
+
+// exchangeDNS answers one UDP query, falling back to TCP and truncating
+// as needed.
+func (c *Client) exchangeDNS(query []byte) ([]byte, error) {
+	resp, err := c.exchangeUDP(query)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil, err
+	}
+	if err != nil || dnsTruncated(resp) {
+		if full, tcpErr := c.exchangeDNSTCP(query); tcpErr == nil {
+			resp, err = full, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	limit := min(dnsUDPSize(query), c.conf().DNSServer.MaxUDPSize)
+	if len(resp) > limit {
+		return dnsTruncate(resp, query), nil
+	}
+	return resp, nil
+}
+
+// exchangeDNSTCP sends a query to the local server over TCP, where
+// messages carry a two-byte length prefix.
+func (c *Client) exchangeDNSTCP(query []byte) ([]byte, error) {
+	conn, err := c.dialUpstream("tcp")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTCPTimeout))
+
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func dnsTruncated(msg []byte) bool {
+	return len(msg) >= dnsHeaderLen && binary.BigEndian.Uint16(msg[2:])&dnsFlagTC != 0
+}
+
+// dnsUDPSize is the largest UDP response the querier accepts: the payload
+// size of its OPT record, or 512 without one.
+func dnsUDPSize(query []byte) int {
+	off := dnsSkipQuestions(query)
+	if off < 0 {
+		return dnsClassicSize
+	}
+	records := int(binary.BigEndian.Uint16(query[6:])) + int(binary.BigEndian.Uint16(query[8:])) + int(binary.BigEndian.Uint16(query[10:]))
+	for range records {
+		off = dnsSkipName(query, off)
+		if off < 0 || off+10 > len(query) {
+			return dnsClassicSize
+		}
+		typ, class := binary.BigEndian.Uint16(query[off:]), binary.BigEndian.Uint16(query[off+2:])
+		if typ == dnsTypeOPT {
+			return max(int(class), dnsClassicSize)
+		}
+		off += 10 + int(binary.BigEndian.Uint16(query[off+8:]))
+	}
+	return dnsClassicSize
+}
+
+// dnsSkipQuestions returns the offset just past the question section, or
+// -1.
+func dnsSkipQuestions(msg []byte) int {
+	if len(msg) < dnsHeaderLen {
+		return -1
+	}
+	off := dnsHeaderLen
+	for range binary.BigEndian.Uint16(msg[4:]) {
+		off = dnsSkipName(msg, off)
+		if off < 0 || off+4 > len(msg) {
+			return -1
+		}
+		off += 4
+	}
+	return off
+}
+
+// dnsSkipName returns the offset just past the name at off, or -1.
+func dnsSkipName(msg []byte, off int) int {
+	for off >= 0 && off < len(msg) {
+		switch n := int(msg[off]); {
+		case n == 0:
+			return off + 1
+		case n&0xc0 == 0xc0:
+			return off + 2
+		default:
+			off += 1 + n
+		}
+	}
+	return -1
+}
+
+// dnsTruncate reduces a response to its header and the query's question,
+// with the TC bit set, telling the resolver to retry over TCP.
+func dnsTruncate(resp, query []byte) []byte {
+	if len(resp) < dnsHeaderLen {
+		return resp
+	}
+	out := append([]byte(nil), resp[:dnsHeaderLen]...)
+	binary.BigEndian.PutUint16(out[2:], binary.BigEndian.Uint16(out[2:])|dnsFlagTC)
+	clear(out[4:dnsHeaderLen])
+	if off := dnsSkipQuestions(query); off > 0 {
+		copy(out[4:], query[4:6])
+		out = append(out, query[dnsHeaderLen:off]...)
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func dnsQuery(edns uint16) []byte {
+	q := []byte{0xbe, 0xef, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	q = append(q, "\x07example\x03com\x00"...)
+	q = append(q, 0, 16, 0, 1) // TXT IN
+	if edns > 0 {
+		q[11] = 1
+		q = append(q, 0, 0, dnsTypeOPT)
+		q = binary.BigEndian.AppendUint16(q, edns)
+		q = append(q, 0, 0, 0, 0, 0, 0)
+	}
+	return q
+}
+
+// dnsBackend answers UDP with a truncated reply and TCP with a 1500-byte
+// one, both on the same port.
+func dnsBackend(t *testing.T) int {
+	for range 10 {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := pc.LocalAddr().(*net.UDPAddr).Port
+		ln, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+			continue
+		}
+		t.Cleanup(func() { pc.Close(); ln.Close() })
+
+		go func() {
+			buf := make([]byte, 512)
+			for {
+				n, addr, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				resp := append([]byte(nil), buf[:n]...)
+				resp[2] |= 0x82 // QR, TC
+				pc.WriteTo(resp, addr)
+			}
+		}()
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				var size [2]byte
+				io.ReadFull(conn, size[:])
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				io.ReadFull(conn, query)
+				resp := append(query[:2:2], 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0)
+				resp = append(resp, bytes.Repeat([]byte{'x'}, 1500-len(resp))...)
+				conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp))))
+				conn.Write(resp)
+				conn.Close()
+			}
+		}()
+		return port
+	}
+	t.Fatal("no port free for both udp and tcp")
+	return 0
+}
+
+func TestDNSServerFallback(t *testing.T) {
+	c := NewClient(WithDNSServer(DNSServerOptions{Port: dnsBackend(t), RemotePort: 35053, MaxUDPSize: 4096}))
+
+	resp, err := c.exchangeDNS(dnsQuery(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 1500 || dnsTruncated(resp) {
+		t.Errorf("Expected the full TCP answer over EDNS, got %d bytes (tc=%v)", len(resp), dnsTruncated(resp))
+	}
+
+	query := dnsQuery(0)
+	resp, err = c.exchangeDNS(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dnsTruncated(resp) || !bytes.Equal(resp[dnsHeaderLen:], query[dnsHeaderLen:]) || resp[0] != 0xbe {
+		t.Errorf("Expected a truncated reply carrying the question, got %x", resp)
+	}
+}
+
+func TestDNSServerTunnels(t *testing.T) {
+	c := NewClient(WithDNSServer(DNSServerOptions{RemotePort: 35053}))
+	h := c.openTunnelRequest(MsgTypeOpenTunnel)
+	if len(h.Tunnels) != 2 || h.Tunnels[0].RemotePort != 35053 || h.Tunnels[1].RemotePort != 35053 {
+		t.Errorf("Expected paired tunnels on port 35053, got %+v", h.Tunnels)
+	}
+	if spec, _ := c.tunnel("udp"); spec.Port != 53 {
+		t.Errorf("Expected local port 53, got %d", spec.Port)
+	}
+	if c.conf().DNSServer.MaxUDPSize != dnsDefaultUDP {
+		t.Errorf("Expected default UDP size, got %d", c.conf().DNSServer.MaxUDPSize)
+	}
+	if NewClient(WithDNSServer(DNSServerOptions{RemotePort: 35053, MaxUDPSize: 100})).configErr == nil {
+		t.Error("Expected a UDP size under 512 to be rejected")
+	}
+	if NewClient(WithDNSServer(DNSServerOptions{})).configErr == nil {
+		t.Error("Expected a missing remote port to be rejected")
+	}
+}
+
+func TestDNSUDPSize(t *testing.T) {
+	if n := dnsUDPSize(dnsQuery(0)); n != 512 {
+		t.Errorf("Expected 512 without EDNS, got %d", n)
+	}
+	if n := dnsUDPSize(dnsQuery(1232)); n != 1232 {
+		t.Errorf("Expected 1232 from OPT, got %d", n)
+	}
+	if n := dnsUDPSize([]byte{1, 2, 3}); n != 512 {
+		t.Errorf("Expected 512 for garbage, got %d", n)
+	}
+}
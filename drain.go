@@ -0,0 +1,48 @@
+package outray
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
+// WithDrainTimeout makes the client quiesce before reconnecting after a
+// keepalive timeout: new streams and requests are refused, and in-flight
+// HTTP requests get up to d to finish on the old connection.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.config.DrainTimeout = d
+	}
+}
+
+// drain waits for in-flight HTTP requests to finish, refusing new work in
+// the meantime, for at most DrainTimeout.
+func (c *Client) drain() {
+	n := c.activeRequests()
+	if c.conf().DrainTimeout <= 0 || n == 0 {
+		return
+	}
+	atomic.StoreInt32(&c.draining, 1)
+	defer atomic.StoreInt32(&c.draining, 0)
+
+	c.logf("Draining %d in-flight requests before reconnecting", n)
+	deadline := time.Now().Add(c.conf().DrainTimeout)
+	for c.activeRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+}
+
+func (c *Client) activeRequests() int {
+	c.inflightMu.Lock()
+	defer c.inflightMu.Unlock()
+	return len(c.inflight)
+}
+
+// isTimeout reports whether the read loop stopped because no frame or pong
+// arrived in time, which leaves the write side usable for draining.
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
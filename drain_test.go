@@ -0,0 +1,35 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	c := NewClient(WithDrainTimeout(time.Second))
+	_, done := c.trackRequest(IncomingRequest{ID: "r1"})
+
+	drained := make(chan struct{})
+	go func() {
+		c.drain()
+		close(drained)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for c.overloaded() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c.overloaded() == "" {
+		t.Fatal("Expected new work to be refused while draining")
+	}
+
+	done()
+	select {
+	case <-drained:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Drain did not finish after the in-flight request completed")
+	}
+	if reason := c.overloaded(); reason != "" {
+		t.Errorf("Expected new work to be accepted after draining, got %q", reason)
+	}
+}
@@ -0,0 +1,243 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	MsgTypeValidateTunnel  = "validate_tunnel"
+	MsgTypeTunnelValidated = "tunnel_validated"
+)
+
+const (
+	dryRunDialTimeout   = 3 * time.Second
+	dryRunServerTimeout = 10 * time.Second
+)
+
+// TunnelValidated answers a validate_tunnel request: the handshake would
+// be accepted, and URL is what the tunnel would be given.
+type TunnelValidated struct {
+	Type         string   `json:"type"`
+	URL          string   `json:"url,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Plan is what DryRun found: the tunnels Connect would open and the result
+// of each preflight check.
+type Plan struct {
+	ServerURL string
+	Tunnels   []PlannedTunnel
+	// URL is the public URL the server would assign, if it said.
+	URL    string
+	Checks []PlanCheck
+}
+
+type PlannedTunnel struct {
+	Protocol     string
+	Local        string // address the client would dial, or "custom upstream"
+	RemotePort   int
+	Subdomain    string
+	CustomDomain string
+}
+
+// PlanCheck is one preflight check. Err is nil when it passed.
+type PlanCheck struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// DryRun checks everything Connect needs without exposing anything: the
+// resolved configuration, the token scope, that each local service
+// accepts connections, and, through a validate_tunnel request the server
+// answers without opening a tunnel, the credentials and requested names.
+// The error joins every failed check; the plan is returned either way.
+func (c *Client) DryRun(ctx context.Context) (*Plan, error) {
+	plan := &Plan{ServerURL: c.serverURL()}
+	if c.configErr != nil {
+		plan.add("configuration", "", c.configErr)
+		return plan, plan.err()
+	}
+	plan.add("configuration", "", nil)
+
+	handshake := c.openTunnelRequest(MsgTypeValidateTunnel)
+	plan.Tunnels = c.plannedTunnels(handshake)
+	if c.conf().TokenScope != nil {
+		plan.add("token scope", "", checkScope(c.conf().TokenScope, handshake))
+	}
+	for _, t := range plan.Tunnels {
+		detail, err := c.probeLocal(ctx, t.Protocol)
+		plan.add("local "+t.Protocol, detail, err)
+	}
+
+	validated, err := c.validateTunnel(ctx, handshake)
+	if err == nil {
+		plan.URL = validated.URL
+	}
+	plan.add("credentials", plan.URL, err)
+	return plan, plan.err()
+}
+
+func (p *Plan) add(name, detail string, err error) {
+	p.Checks = append(p.Checks, PlanCheck{Name: name, Detail: detail, Err: err})
+}
+
+func (p *Plan) err() error {
+	var errs []error
+	for _, check := range p.Checks {
+		if check.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", check.Name, check.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String formats the plan for a terminal.
+func (p *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Server: %s\n", p.ServerURL)
+	for _, t := range p.Tunnels {
+		fmt.Fprintf(&b, "Tunnel: %s -> %s", t.Protocol, t.Local)
+		if t.RemotePort != 0 {
+			fmt.Fprintf(&b, ", remote port %d", t.RemotePort)
+		}
+		if t.Subdomain != "" {
+			fmt.Fprintf(&b, ", subdomain %s", t.Subdomain)
+		}
+		if t.CustomDomain != "" {
+			fmt.Fprintf(&b, ", domain %s", t.CustomDomain)
+		}
+		b.WriteString("\n")
+	}
+	if p.URL != "" {
+		fmt.Fprintf(&b, "URL: %s\n", p.URL)
+	}
+	for _, check := range p.Checks {
+		status := "ok"
+		if check.Err != nil {
+			status = "FAIL: " + check.Err.Error()
+		} else if check.Detail != "" {
+			status += " (" + check.Detail + ")"
+		}
+		fmt.Fprintf(&b, "[%s] %s\n", check.Name, status)
+	}
+	return b.String()
+}
+
+func (c *Client) plannedTunnels(handshake OpenTunnelRequest) []PlannedTunnel {
+	specs := c.tunnelSpecs()
+	if len(specs) == 0 {
+		specs = []TunnelSpec{{
+			Protocol:     handshake.Protocol,
+			RemotePort:   handshake.Port,
+			Subdomain:    handshake.Subdomain,
+			CustomDomain: handshake.CustomDomain,
+		}}
+	}
+	tunnels := make([]PlannedTunnel, len(specs))
+	for i, spec := range specs {
+		tunnels[i] = PlannedTunnel{
+			Protocol:     spec.Protocol,
+			Local:        c.localTarget(spec.Protocol),
+			RemotePort:   spec.RemotePort,
+			Subdomain:    spec.Subdomain,
+			CustomDomain: spec.CustomDomain,
+		}
+	}
+	return tunnels
+}
+
+func (c *Client) localTarget(protocol string) string {
+	u, ok := c.upstream(protocol).(*localUpstream)
+	if !ok {
+		return "custom upstream"
+	}
+	return strings.Join(u.addrs(), ", ")
+}
+
+// probeLocal checks that the local service for protocol accepts
+// connections. UDP has no handshake, so only the socket is checked.
+func (c *Client) probeLocal(ctx context.Context, protocol string) (string, error) {
+	if protocol == "http" && (c.conf().OnRequest != nil || c.conf().OnRequestAsync != nil) {
+		return "handled in-process", nil
+	}
+	if !c.hasUpstream(protocol) {
+		return "", errors.New("no local port or upstream configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, dryRunDialTimeout)
+	defer cancel()
+	u := c.upstream(protocol)
+	dial := u.DialTCP
+	if protocol == "udp" {
+		dial = u.DialUDP
+	}
+	conn, err := dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	if protocol == "udp" {
+		return "not probed: UDP has no handshake", nil
+	}
+	return "accepting connections", nil
+}
+
+// validateTunnel sends the handshake as validate_tunnel on a connection of
+// its own and waits for the verdict.
+func (c *Client) validateTunnel(ctx context.Context, handshake OpenTunnelRequest) (TunnelValidated, error) {
+	ctx, cancel := context.WithTimeout(ctx, dryRunServerTimeout)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return TunnelValidated{}, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	frames := c.newFrameSession()
+	handshake.FrameNonce = frames.nonce
+	data, err := c.encodeFrame(c.handshakeFrame(handshake))
+	if err != nil {
+		return TunnelValidated{}, err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, c.signFrame(data, frames.nonce, 1)); err != nil {
+		return TunnelValidated{}, err
+	}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return TunnelValidated{}, errors.New("server did not answer validate_tunnel; it may not support dry runs")
+			}
+			return TunnelValidated{}, err
+		}
+		if data, err = c.verifyFrame(data, frames); err != nil {
+			return TunnelValidated{}, err
+		}
+		data = c.normalizeMessage(data)
+		var env messageEnvelope
+		json.Unmarshal(data, &env)
+		switch env.Type {
+		case MsgTypeTunnelValidated:
+			var msg TunnelValidated
+			json.Unmarshal(data, &msg)
+			return msg, nil
+		case MsgTypeError:
+			var msg ServerMessage
+			json.Unmarshal(data, &msg)
+			return TunnelValidated{}, newServerError(msg)
+		case MsgTypeTunnelOpened:
+			// The server took the request for open_tunnel; closing the
+			// connection on return takes the tunnel down again.
+			return TunnelValidated{}, errors.New("server opened a tunnel instead of validating; it does not support dry runs")
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	ts := newTestServer(t)
+	go func() {
+		conn := <-ts.conns
+		defer conn.Close()
+		var h OpenTunnelRequest
+		if conn.ReadJSON(&h) != nil || h.Type != MsgTypeValidateTunnel {
+			return
+		}
+		conn.WriteJSON(TunnelValidated{Type: MsgTypeTunnelValidated, URL: "https://" + h.Subdomain + ".outray.app"})
+		conn.ReadMessage()
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c := NewClient(WithServerURL(ts.URL()), WithSubdomain("my-app"), WithUpstreamFallback(ln.Addr().String()))
+	plan, err := c.DryRun(context.Background())
+	if err != nil {
+		t.Fatalf("DryRun failed: %v\n%s", err, plan)
+	}
+	if plan.URL != "https://my-app.outray.app" || len(plan.Tunnels) != 1 || plan.Tunnels[0].Local != ln.Addr().String() {
+		t.Errorf("Unexpected plan:\n%s", plan)
+	}
+	if s := plan.String(); !strings.Contains(s, "[credentials] ok") || !strings.Contains(s, "[local http] ok") {
+		t.Errorf("Unexpected plan output:\n%s", s)
+	}
+}
+
+func TestDryRunFailures(t *testing.T) {
+	ts := newTestServer(t)
+	go func() {
+		conn := <-ts.conns
+		defer conn.Close()
+		conn.ReadMessage()
+		conn.WriteJSON(ServerMessage{Type: MsgTypeError, Code: ErrCodeInvalidAPIKey, Message: "bad key"})
+		conn.ReadMessage()
+	}()
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	c := NewClient(WithServerURL(ts.URL()), WithUpstreamFallback(addr))
+	plan, err := c.DryRun(context.Background())
+	var serr *ServerError
+	if !errors.As(err, &serr) || serr.Code != ErrCodeInvalidAPIKey {
+		t.Errorf("Expected the server's auth error, got %v", err)
+	}
+	if len(plan.Checks) != 3 || plan.Checks[1].Err == nil {
+		t.Errorf("Expected the local check to fail:\n%s", plan)
+	}
+}
+
+func TestDryRunConfigError(t *testing.T) {
+	c := NewClient(WithDataCap(0))
+	plan, err := c.DryRun(context.Background())
+	if err == nil || len(plan.Checks) != 1 {
+		t.Errorf("Expected only a failed configuration check, got %v:\n%s", err, plan)
+	}
+}
+
+func TestDryRunNeverOpensTunnel(t *testing.T) {
+	ts := newTestServer(t)
+	go func() {
+		conn := <-ts.conns
+		defer conn.Close()
+		conn.ReadMessage()
+		conn.WriteJSON(TunnelOpened{Type: MsgTypeTunnelOpened, URL: "https://x.outray.app"})
+		conn.ReadMessage()
+	}()
+	c := NewClient(WithServerURL(ts.URL()), WithOnRequest(func(req IncomingRequest) IncomingResponse { return IncomingResponse{} }))
+	if _, err := c.DryRun(context.Background()); err == nil || !strings.Contains(err.Error(), "does not support dry runs") {
+		t.Errorf("Expected a dry-run support error, got %v", err)
+	}
+}
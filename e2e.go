@@ -0,0 +1,110 @@
+package outray
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+)
+
+const e2eHeader = "X-Outray-E2E"
+
+var errE2EPayload = errors.New("e2e: payload too short or tampered")
+
+func WithE2EEncryption(key []byte) Option {
+	return func(c *Client) {
+		c.config.E2EKey = key
+	}
+}
+
+func newE2ECipher(key []byte) cipher.AEAD {
+	if len(key) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	return aead
+}
+
+// e2eFrame says where a sealed payload belongs. It is bound to the
+// ciphertext as additional data, so a payload can't be moved to another
+// frame type, stream or position, and a stream can't be cut short by
+// marking an earlier chunk final.
+type e2eFrame struct {
+	typ   string
+	id    string // request, connection or packet ID
+	seq   uint64 // position within the stream, 0 for single frames
+	final bool
+}
+
+// aad is "type\x00id\x00seq\x00final", with seq in decimal and final
+// "1" or "0".
+func (f e2eFrame) aad() []byte {
+	final := "0"
+	if f.final {
+		final = "1"
+	}
+	return []byte(f.typ + "\x00" + f.id + "\x00" + strconv.FormatUint(f.seq, 10) + "\x00" + final)
+}
+
+func (c *Client) seal(plain []byte, f e2eFrame) []byte {
+	aead := c.e2eCipher()
+	if aead == nil {
+		return plain
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, plain, f.aad())
+}
+
+func (c *Client) unseal(sealed []byte, f e2eFrame) ([]byte, error) {
+	aead := c.e2eCipher()
+	if aead == nil {
+		return sealed, nil
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errE2EPayload
+	}
+	plain, err := aead.Open(nil, sealed[:n], sealed[n:], f.aad())
+	if err != nil {
+		return nil, errE2EPayload
+	}
+	return plain, nil
+}
+
+// unsealRequest opens a request body. Empty bodies are sealed too, so a
+// request can't be passed off as bodiless.
+func (c *Client) unsealRequest(req *IncomingRequest) error {
+	if c.e2eCipher() == nil {
+		return nil
+	}
+	body, err := c.unseal(req.Body, e2eFrame{typ: MsgTypeRequest, id: req.ID})
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+func (c *Client) sealResponse(resp *IncomingResponse) {
+	if c.e2eCipher() == nil {
+		return
+	}
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
+	}
+	resp.Headers[e2eHeader] = "aes-256-gcm"
+	resp.Body = c.seal(resp.Body, e2eFrame{typ: MsgTypeResponse, id: resp.ID})
+}
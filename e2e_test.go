@@ -0,0 +1,53 @@
+package outray
+
+import "testing"
+
+func TestE2ERoundTrip(t *testing.T) {
+	a := NewClient(WithE2EEncryption([]byte("shared secret")))
+	b := NewClient(WithE2EEncryption([]byte("shared secret")))
+	frame := e2eFrame{typ: MsgTypeTCPData, id: "conn-1", seq: 3}
+
+	sealed := a.seal([]byte("hello"), frame)
+	if string(sealed) == "hello" {
+		t.Fatal("Expected payload to be encrypted")
+	}
+	plain, err := b.unseal(sealed, frame)
+	if err != nil || string(plain) != "hello" {
+		t.Errorf("Expected roundtrip, got %q %v", plain, err)
+	}
+
+	wrong := NewClient(WithE2EEncryption([]byte("other")))
+	if _, err := wrong.unseal(sealed, frame); err == nil {
+		t.Error("Expected wrong key to fail")
+	}
+}
+
+func TestE2EBindsFrame(t *testing.T) {
+	c := NewClient(WithE2EEncryption([]byte("shared secret")))
+	frame := e2eFrame{typ: MsgTypeRequestChunk, id: "req-1", seq: 2}
+	sealed := c.seal([]byte("hello"), frame)
+
+	for name, other := range map[string]e2eFrame{
+		"type":  {typ: MsgTypeTCPData, id: "req-1", seq: 2},
+		"id":    {typ: MsgTypeRequestChunk, id: "req-2", seq: 2},
+		"seq":   {typ: MsgTypeRequestChunk, id: "req-1", seq: 3},
+		"final": {typ: MsgTypeRequestChunk, id: "req-1", seq: 2, final: true},
+	} {
+		if _, err := c.unseal(sealed, other); err == nil {
+			t.Errorf("Expected a payload moved to another %s to fail", name)
+		}
+	}
+}
+
+func TestE2EEmptyRequestBody(t *testing.T) {
+	c := NewClient(WithE2EEncryption([]byte("shared secret")))
+	req := IncomingRequest{ID: "req-1", Method: "GET", Path: "/"}
+	if err := c.unsealRequest(&req); err == nil {
+		t.Error("Expected an unsealed empty body to be rejected")
+	}
+
+	req.Body = c.seal(nil, e2eFrame{typ: MsgTypeRequest, id: "req-1"})
+	if err := c.unsealRequest(&req); err != nil || len(req.Body) != 0 {
+		t.Errorf("Expected a sealed empty body, got %q %v", req.Body, err)
+	}
+}
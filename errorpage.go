@@ -0,0 +1,85 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+type ErrorPageData struct {
+	StatusCode int
+	Status     string
+	RequestID  string
+	Method     string
+	Path       string
+	Error      string
+}
+
+func WithErrorPage(statusCode int, tmpl string) Option {
+	return func(c *Client) {
+		if c.config.ErrorPages == nil {
+			c.config.ErrorPages = make(map[int]string)
+		}
+		c.config.ErrorPages[statusCode] = tmpl
+	}
+}
+
+func (c *Client) errorResponse(req IncomingRequest, statusCode int, body string, cause error) IncomingResponse {
+	tmpl, ok := c.conf().ErrorPages[statusCode]
+	if !ok {
+		return IncomingResponse{StatusCode: statusCode, Body: []byte(body)}
+	}
+
+	data := ErrorPageData{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		RequestID:  req.ID,
+		Method:     req.Method,
+		Path:       req.Path,
+		Error:      cause.Error(),
+	}
+
+	page, contentType, err := renderErrorPage(tmpl, data)
+	if err != nil {
+		c.logf("Error page for %d failed to render: %v", statusCode, err)
+		return IncomingResponse{StatusCode: statusCode, Body: []byte(body)}
+	}
+
+	return IncomingResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": contentType},
+		Body:       page,
+	}
+}
+
+func renderErrorPage(tmpl string, data ErrorPageData) ([]byte, string, error) {
+	var buf bytes.Buffer
+	trimmed := strings.TrimSpace(tmpl)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		t, err := template.New("error").Funcs(template.FuncMap{"json": jsonString}).Parse(tmpl)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := t.Execute(&buf, data); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "application/json", nil
+	}
+
+	t, err := htmltemplate.New("error").Parse(tmpl)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "text/html; charset=utf-8", nil
+}
+
+func jsonString(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
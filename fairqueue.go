@@ -0,0 +1,166 @@
+package outray
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FairQueue caps how many requests are proxied to the upstream at once.
+// Requests over the cap wait in a queue per client IP, and freed slots go to
+// the waiting IPs in turn, so one aggressive consumer can't starve the rest.
+type FairQueue struct {
+	// MaxConcurrent is how many requests may be proxied at once; it must
+	// be at least 1.
+	MaxConcurrent int
+	// MaxQueuePerIP is how many requests one IP may have waiting before
+	// further ones get 429 (default 10).
+	MaxQueuePerIP int
+	// MaxWait is how long a request may wait for a slot before it gets 429
+	// (default 30s).
+	MaxWait time.Duration
+}
+
+type fairQueue struct {
+	cfg FairQueue
+
+	mu     sync.Mutex
+	active int
+	queues map[string][]chan struct{}
+	order  []string // IPs with waiters, served round-robin
+}
+
+// WithFairQueue throttles requests proxied to the upstream with per-IP fair
+// queuing. It doesn't apply to streaming uploads (which would stall the
+// tunnel while queued) or to OnRequest and OnRequestAsync handlers.
+func WithFairQueue(q FairQueue) Option {
+	return func(c *Client) {
+		if q.MaxConcurrent <= 0 {
+			c.configErr = fmt.Errorf("fair queue max concurrent must be at least 1, got %d", q.MaxConcurrent)
+			return
+		}
+		if q.MaxQueuePerIP <= 0 {
+			q.MaxQueuePerIP = 10
+		}
+		if q.MaxWait <= 0 {
+			q.MaxWait = 30 * time.Second
+		}
+		c.fairq = &fairQueue{cfg: q, queues: make(map[string][]chan struct{})}
+	}
+}
+
+// acquire waits for a slot for a request from ip. It reports false if the
+// IP's queue is full, MaxWait elapses or ctx is done first.
+func (q *fairQueue) acquire(ctx context.Context, ip string) bool {
+	q.mu.Lock()
+	if q.active < q.cfg.MaxConcurrent && len(q.order) == 0 {
+		q.active++
+		q.mu.Unlock()
+		return true
+	}
+	if len(q.queues[ip]) >= q.cfg.MaxQueuePerIP {
+		q.mu.Unlock()
+		return false
+	}
+	ch := make(chan struct{})
+	if len(q.queues[ip]) == 0 {
+		q.order = append(q.order, ip)
+	}
+	q.queues[ip] = append(q.queues[ip], ch)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.cfg.MaxWait)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.queues[ip]
+	i := slices.Index(waiters, ch)
+	if i < 0 {
+		// Granted a slot just as we gave up; hand it on.
+		q.releaseLocked()
+		return false
+	}
+	q.queues[ip] = slices.Delete(waiters, i, i+1)
+	if len(q.queues[ip]) == 0 {
+		delete(q.queues, ip)
+		q.order = slices.DeleteFunc(q.order, func(s string) bool { return s == ip })
+	}
+	return false
+}
+
+func (q *fairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+// releaseLocked passes the caller's slot to the next IP in turn, or frees
+// it if nobody is waiting.
+func (q *fairQueue) releaseLocked() {
+	if len(q.order) == 0 {
+		q.active--
+		return
+	}
+	ip := q.order[0]
+	q.order = q.order[1:]
+	waiters := q.queues[ip]
+	close(waiters[0])
+	if len(waiters) > 1 {
+		q.queues[ip] = waiters[1:]
+		q.order = append(q.order, ip)
+	} else {
+		delete(q.queues, ip)
+	}
+}
+
+func (q *fairQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, waiters := range q.queues {
+		n += len(waiters)
+	}
+	return n
+}
+
+// throttle waits for an upstream slot for req, responding 429 itself if
+// none comes. The returned func releases the slot.
+func (c *Client) throttle(ctx context.Context, req IncomingRequest) (func(), bool) {
+	if c.fairq == nil {
+		return func() {}, true
+	}
+	ip := ""
+	if addr := c.clientIP(req); addr != nil {
+		ip = addr.String()
+	}
+	if !c.fairq.acquire(ctx, ip) {
+		if ctx.Err() == nil {
+			atomic.AddUint64(&c.stats.throttled, 1)
+			c.respond(req, IncomingResponse{
+				StatusCode: http.StatusTooManyRequests,
+				Headers:    map[string]string{"Retry-After": "1"},
+				Body:       []byte("Too Many Requests"),
+			}, "send response error")
+		}
+		return nil, false
+	}
+	return c.fairq.release, true
+}
+
+func (c *Client) queuedRequests() int {
+	if c.fairq == nil {
+		return 0
+	}
+	return c.fairq.queued()
+}
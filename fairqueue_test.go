@@ -0,0 +1,74 @@
+package outray
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFairQueue(t *testing.T) {
+	c := NewClient(WithFairQueue(FairQueue{MaxConcurrent: 1, MaxQueuePerIP: 2, MaxWait: time.Second}))
+	q := c.fairq
+	ctx := context.Background()
+
+	if !q.acquire(ctx, "a") {
+		t.Fatal("Expected a free slot")
+	}
+
+	got := make(chan string, 3)
+	wait := func(ip string) {
+		go func() {
+			if q.acquire(ctx, ip) {
+				got <- ip
+			}
+		}()
+	}
+	// Queue in a fixed order: a, a, then b.
+	for i, ip := range []string{"a", "a", "b"} {
+		wait(ip)
+		for q.queued() != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if q.acquire(ctx, "a") {
+		t.Error("Expected a full per-IP queue to refuse")
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		q.release()
+		order = append(order, <-got)
+	}
+	if order[0] != "a" || order[1] != "b" || order[2] != "a" {
+		t.Errorf("Expected round-robin order [a b a], got %v", order)
+	}
+}
+
+func TestFairQueueTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	c := connectTestClient(t, ts, WithFairQueue(FairQueue{MaxConcurrent: 1, MaxWait: 20 * time.Millisecond}))
+	ts.accept(t)
+
+	c.fairq.acquire(context.Background(), "other")
+	release, ok := c.throttle(context.Background(), IncomingRequest{ID: "r1", RemoteAddr: "192.0.2.1:5000"})
+	if ok || release != nil {
+		t.Fatal("Expected the request to time out waiting")
+	}
+	if got := c.Stats().Throttled; got != 1 {
+		t.Errorf("Expected Throttled=1, got %d", got)
+	}
+	if q := c.fairq.queued(); q != 0 {
+		t.Errorf("Expected empty queue after timeout, got %d", q)
+	}
+}
+
+func TestFairQueueMaxConcurrent(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		if err := NewClient(WithFairQueue(FairQueue{MaxConcurrent: n})).configErr; err == nil {
+			t.Errorf("Expected MaxConcurrent %d to be rejected", n)
+		}
+	}
+	if err := NewClient(WithFairQueue(FairQueue{MaxConcurrent: 1})).configErr; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
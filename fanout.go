@@ -0,0 +1,55 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WithFanOut sends a copy of every incoming HTTP request to each target
+// ("host:port" or a base URL) in addition to the normal handler or
+// upstream. Only the primary answers; copies are fire-and-forget.
+func WithFanOut(targets ...string) Option {
+	return func(c *Client) {
+		c.config.FanOut = targets
+	}
+}
+
+func (c *Client) fanOut(req IncomingRequest) {
+	if req.stream != nil {
+		c.logf("Skipping fan-out for streamed request %s", req.ID)
+		return
+	}
+	for _, target := range c.conf().FanOut {
+		c.spawn(func() { c.sendCopy(target, req) })
+	}
+}
+
+func (c *Client) sendCopy(target string, req IncomingRequest) {
+	base := target
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	copyReq, err := http.NewRequestWithContext(ctx, req.Method, strings.TrimSuffix(base, "/")+req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		c.logf("Fan-out to %s failed: %v", target, err)
+		return
+	}
+	for k, v := range req.Headers {
+		copyReq.Header.Set(k, v)
+	}
+
+	resp, err := c.upstreamClient().Do(copyReq)
+	if err != nil {
+		c.logf("Fan-out to %s failed: %v", target, err)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
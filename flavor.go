@@ -0,0 +1,131 @@
+package outray
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ServerFlavor int
+
+const (
+	Hosted ServerFlavor = iota
+	SelfHosted
+)
+
+func WithServerFlavor(f ServerFlavor) Option {
+	return func(c *Client) {
+		c.config.ServerFlavor = f
+	}
+}
+
+func WithPathPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.config.PathPrefix = prefix
+	}
+}
+
+func WithHandshakeFields(fields map[string]interface{}) Option {
+	return func(c *Client) {
+		c.config.HandshakeFields = fields
+	}
+}
+
+func (c *Client) serverURL() string {
+	raw := c.conf().ServerURL
+	if c.conf().ServerFlavor != SelfHosted && c.conf().PathPrefix == "" {
+		return raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	if c.conf().PathPrefix != "" {
+		u.Path = "/" + strings.Trim(strings.TrimSuffix(c.conf().PathPrefix, "/")+"/"+strings.TrimPrefix(u.Path, "/"), "/")
+	}
+	return u.String()
+}
+
+// dialHeader is the WebSocket upgrade request's header. Self-hosted servers
+// get the API key as a bearer token, except under ProofHMAC, where the key
+// never leaves the client and the signed handshake authenticates instead.
+func (c *Client) dialHeader() http.Header {
+	header := http.Header{}
+	if c.conf().DialHeaders != nil {
+		header = c.conf().DialHeaders.Clone()
+	}
+	if c.conf().ServerFlavor == SelfHosted && c.conf().HandshakeProof != ProofHMAC &&
+		c.conf().APIKey != "" && header.Get("Authorization") == "" {
+		header.Set("Authorization", "Bearer "+c.conf().APIKey)
+	}
+	return header
+}
+
+func (c *Client) handshakeFrame(handshake OpenTunnelRequest) interface{} {
+	if len(c.conf().HandshakeFields) == 0 && c.conf().HandshakeProof != ProofHMAC {
+		return handshake
+	}
+
+	fields := frameFields(handshake)
+	for k, v := range c.conf().HandshakeFields {
+		if k != "type" {
+			fields[k] = v
+		}
+	}
+	if c.conf().HandshakeProof == ProofHMAC {
+		c.proveFields(fields)
+	}
+	return fields
+}
+
+// normalizeMessage accepts the looser shapes used by self-hosted relays:
+// fields nested under "payload" and alternative spellings of the tunnel URL.
+func (c *Client) normalizeMessage(data []byte) []byte {
+	if c.conf().ServerFlavor != SelfHosted {
+		return data
+	}
+
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return data
+	}
+
+	if payload, ok := msg["payload"]; ok {
+		var inner map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &inner); err == nil {
+			delete(msg, "payload")
+			for k, v := range inner {
+				if _, exists := msg[k]; !exists {
+					msg[k] = v
+				}
+			}
+		}
+	}
+	if _, ok := msg["url"]; !ok {
+		for _, alt := range []string{"publicUrl", "public_url", "tunnelUrl"} {
+			if v, ok := msg[alt]; ok {
+				msg["url"] = v
+				break
+			}
+		}
+	}
+	if _, ok := msg["message"]; !ok {
+		if v, ok := msg["error"]; ok {
+			msg["message"] = v
+		}
+	}
+
+	out, err := json.Marshal(msg)
+	if err != nil {
+		return data
+	}
+	return out
+}
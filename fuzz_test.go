@@ -0,0 +1,142 @@
+package outray
+
+import (
+	"encoding/base64"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fuzzClient returns a client with an in-memory backend whose writes fail
+// fast, so handlers run their full path without a server.
+func fuzzClient(opts ...Option) *Client {
+	backend := &VirtualBackend{
+		HTTP: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.Copy(w, r.Body) }),
+		TCP: func(conn net.Conn) {
+			conn.Write([]byte("hi"))
+			conn.Close()
+		},
+		UDP: func(packet []byte) []byte { return append([]byte("ok:"), packet...) },
+	}
+	c := NewClient(append([]Option{WithUpstream(backend)}, opts...)...)
+	c.closed = true
+	return c
+}
+
+// settle closes any TCP streams and uploads a frame opened, including ones
+// still being set up, until the client's goroutines have all finished.
+func settle(c *Client) {
+	for atomic.LoadInt64(&c.goroutines) > 0 {
+		c.abortUploads(errClientClosed)
+		c.tcpConnsMu.Lock()
+		streams := maps.Clone(c.tcpConns)
+		c.tcpConnsMu.Unlock()
+		for id, stream := range streams {
+			c.finishTCP(id, stream)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Wait()
+}
+
+func FuzzHandleMessage(f *testing.F) {
+	for _, seed := range []string{
+		string(benchRequest),
+		string(benchTCPData),
+		`{"type":"tunnel_opened","url":"https://a.outray.dev","quota":{"requests":10},"serverTime":1700000000000}`,
+		`{"type":"tcp_connection","connectionId":"c1"}`,
+		`{"type":"tcp_close","connectionId":"c1"}`,
+		`{"type":"udp_data","packetId":"p1","data":"aGk=","sourceAddress":"192.0.2.1","sourcePort":53}`,
+		`{"type":"request","requestId":"r1","streaming":true,"headers":{"Content-Length":"5"}}`,
+		`{"type":"request_chunk","requestId":"r1","data":"aGVsbG8=","final":true}`,
+		`{"type":"request_cancel","requestId":"r1"}`,
+		`{"type":"error","code":"SCOPE_VIOLATION","details":{"field":"protocol"}}`,
+		`{"type":"warning","code":"QUOTA","details":{"used":1}}`,
+		`{"type":"deprecation","minVersion":"v9"}`,
+		`{"type":"request","requestId":"","headers":null,"body":"!!"}`,
+		`{"type":`,
+		`[]`,
+		`null`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, c := range []*Client{
+			fuzzClient(),
+			fuzzClient(
+				WithE2EEncryption(make([]byte, 32)),
+				WithPolicy(Policy{Rules: []PolicyRule{{Pattern: "/admin/*", Access: AccessDeny}}, BasicUsers: map[string]string{"u": "p"}}),
+				WithAnomalyDetection(AnomalyThresholds{}),
+				WithRouteTemplates("/users/{id}"),
+			),
+		} {
+			c.handleMessage(data, 0)
+			settle(c)
+		}
+	})
+}
+
+func FuzzSelfHostedMessage(f *testing.F) {
+	f.Add([]byte(`{"type":"tunnel_opened","payload":{"publicUrl":"https://x"}}`))
+	f.Add([]byte(`{"type":"request","payload":"nope"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := fuzzClient(WithServerFlavor(SelfHosted))
+		c.handleMessage(data, 0)
+		settle(c)
+	})
+}
+
+func FuzzTCPData(f *testing.F) {
+	f.Add("c1", "aGVsbG8=", uint64(0), false, uint32(0))
+	f.Add("", "====", uint64(1), true, uint32(0))
+	f.Add("c1", "not base64!", uint64(7), true, uint32(0x9a71bb4c))
+	f.Fuzz(func(t *testing.T, connID, data string, seq uint64, withCRC bool, sum uint32) {
+		var crc *uint32
+		if withCRC {
+			crc = &sum
+		}
+		local, remote := net.Pipe()
+		defer remote.Close()
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				if _, err := remote.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+
+		c := fuzzClient()
+		stream := &tcpStream{conn: local, done: make(chan struct{})}
+		c.tcpConns["c1"] = stream
+		c.handleTCPData(TCPData{ConnectionID: connID, Data: data, Seq: seq, CRC32C: crc})
+		c.handleTCPHalfClose(connID, seq)
+		c.handleTCPClose(connID)
+		c.Wait()
+		local.Close()
+
+		c.tcpConnsMu.Lock()
+		defer c.tcpConnsMu.Unlock()
+		if s, ok := c.tcpConns["c1"]; ok && s != stream {
+			t.Fatalf("Connection map corrupted: %+v", c.tcpConns)
+		}
+	})
+}
+
+func FuzzUDPData(f *testing.F) {
+	f.Add("p1", base64.StdEncoding.EncodeToString([]byte("query")), "192.0.2.1", 53)
+	f.Add("", "%%%", "", -1)
+	f.Add("p2", "", "::1", 70000)
+	f.Fuzz(func(t *testing.T, id, data, addr string, port int) {
+		c := fuzzClient(WithMaxUDPSessions(1))
+		c.handleUDPData(UDPData{Type: MsgTypeUDPData, PacketID: id, Data: data, SourceAddress: addr, SourcePort: port})
+		c.Wait()
+		if n := c.Stats().UDPSessions; n > 1 {
+			t.Fatalf("Expected at most one session, got %d", n)
+		}
+	})
+}
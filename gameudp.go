@@ -0,0 +1,227 @@
+package outray
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GameUDP tunes UDP tunnels for game servers (Minecraft Bedrock, Valheim
+// and the like), which stream packets both ways instead of answering one
+// request at a time. Each player gets a long-lived local socket, so the
+// server sees a stable address, and every packet the server sends back is
+// relayed, not just the first.
+type GameUDP struct {
+	// MaxOutstanding is how many packets one session may have waiting for
+	// the local server before further ones are dropped (default 512).
+	MaxOutstanding int
+	// RatePerSecond caps the packets one session may send per second,
+	// allowing bursts of up to Burst (default RatePerSecond). Packets over
+	// the cap are dropped. Zero means no cap.
+	RatePerSecond float64
+	Burst         int
+	// IdleTimeout closes a session after this long with no packets either
+	// way (default 2m).
+	IdleTimeout time.Duration
+}
+
+type gameUDP struct {
+	cfg GameUDP
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+// udpSession is one player's flow: packets from the tunnel are queued and
+// written in the order they are handled, which with concurrent handling
+// may differ from the order they were sent; game protocols expect that.
+type udpSession struct {
+	source string
+	conn   net.Conn
+	queue  chan []byte
+	done   chan struct{}
+	once   sync.Once
+	seen   int64 // unix nanos of the last packet either way
+
+	mu       sync.Mutex
+	packetID string // latest inbound packet, used to address replies
+	epoch    uint64
+	tokens   float64
+	refilled time.Time
+}
+
+// WithGameUDP switches UDP tunnels from request/response exchanges to
+// long-lived sessions per source address.
+func WithGameUDP(g GameUDP) Option {
+	return func(c *Client) {
+		if g.MaxOutstanding <= 0 {
+			g.MaxOutstanding = 512
+		}
+		if g.Burst <= 0 {
+			g.Burst = max(int(g.RatePerSecond), 1)
+		}
+		if g.IdleTimeout <= 0 {
+			g.IdleTimeout = 2 * time.Minute
+		}
+		c.gameUDP = &gameUDP{cfg: g, sessions: make(map[string]*udpSession)}
+	}
+}
+
+// deliverGameUDP queues a packet on its session, opening one if needed.
+func (c *Client) deliverGameUDP(source string, packet UDPData, data []byte) {
+	s, err := c.gameSession(source)
+	if err != nil {
+		if c.conf().OnError != nil {
+			c.safeOnError(err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	s.packetID, s.epoch = packet.PacketID, packet.epoch
+	allowed := s.allow(c.gameUDP.cfg, time.Now())
+	s.mu.Unlock()
+	if !allowed {
+		atomic.AddUint64(&c.stats.udpDropped, 1)
+		return
+	}
+	s.touch()
+	select {
+	case s.queue <- data:
+	default:
+		atomic.AddUint64(&c.stats.udpDropped, 1)
+	}
+}
+
+func (c *Client) gameSession(source string) (*udpSession, error) {
+	g := c.gameUDP
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.sessions[source]; ok {
+		return s, nil
+	}
+
+	conn, err := c.upstream("udp").DialUDP(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c.holdUDPSession(source)
+	s := &udpSession{
+		source:   source,
+		conn:     conn,
+		queue:    make(chan []byte, g.cfg.MaxOutstanding),
+		done:     make(chan struct{}),
+		tokens:   float64(g.cfg.Burst),
+		refilled: time.Now(),
+	}
+	s.touch()
+	g.sessions[source] = s
+	c.spawn(func() { c.writeGameUDP(s) })
+	c.spawn(func() { c.readGameUDP(s) })
+	return s, nil
+}
+
+func (c *Client) writeGameUDP(s *udpSession) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.queue:
+			if _, err := s.conn.Write(data); err != nil && !errors.Is(err, net.ErrClosed) {
+				c.logf("UDP session %s: %v", s.source, err)
+			}
+		}
+	}
+}
+
+// readGameUDP relays everything the local server sends until the session
+// goes idle or is closed.
+func (c *Client) readGameUDP(s *udpSession) {
+	defer c.closeGameSession(s)
+	idle := c.gameUDP.cfg.IdleTimeout
+	buf := make([]byte, maxUDPPayload)
+	for {
+		s.conn.SetReadDeadline(time.Unix(0, atomic.LoadInt64(&s.seen)).Add(idle))
+		n, err := s.conn.Read(buf)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			if time.Since(time.Unix(0, atomic.LoadInt64(&s.seen))) >= idle {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			// A connected UDP socket reports ICMP port unreachable as a
+			// read error; the server may just be restarting.
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s.touch()
+
+		s.mu.Lock()
+		packetID, epoch := s.packetID, s.epoch
+		s.mu.Unlock()
+
+		atomic.AddUint64(&c.stats.bytesOut, uint64(n))
+		sealed := c.seal(buf[:n], e2eFrame{typ: MsgTypeUDPResponse, id: packetID})
+		msg := UDPResponse{
+			Type:     MsgTypeUDPResponse,
+			PacketID: packetID,
+			Data:     base64.StdEncoding.EncodeToString(sealed),
+			CRC32C:   c.checksum(sealed),
+		}
+		c.writeStreamJSONOn(epoch, s.source, PriorityUDP, msg)
+	}
+}
+
+func (c *Client) closeGameSession(s *udpSession) {
+	s.once.Do(func() {
+		g := c.gameUDP
+		g.mu.Lock()
+		if g.sessions[s.source] == s {
+			delete(g.sessions, s.source)
+		}
+		g.mu.Unlock()
+		close(s.done)
+		s.conn.Close()
+		c.releaseUDPSession(s.source)
+	})
+}
+
+func (c *Client) closeGameSessions() {
+	if c.gameUDP == nil {
+		return
+	}
+	c.gameUDP.mu.Lock()
+	sessions := make([]*udpSession, 0, len(c.gameUDP.sessions))
+	for _, s := range c.gameUDP.sessions {
+		sessions = append(sessions, s)
+	}
+	c.gameUDP.mu.Unlock()
+	for _, s := range sessions {
+		c.closeGameSession(s)
+	}
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.seen, time.Now().UnixNano())
+}
+
+// allow takes a token from the session's bucket. Callers hold s.mu.
+func (s *udpSession) allow(cfg GameUDP, now time.Time) bool {
+	if cfg.RatePerSecond <= 0 {
+		return true
+	}
+	s.tokens = min(s.tokens+now.Sub(s.refilled).Seconds()*cfg.RatePerSecond, float64(cfg.Burst))
+	s.refilled = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
@@ -0,0 +1,125 @@
+package outray
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gameServer replies to every packet twice and records the source ports
+// it hears from.
+func gameServer(t *testing.T) (int, func() map[int]bool) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var mu sync.Mutex
+	ports := make(map[int]bool)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			ports[addr.(*net.UDPAddr).Port] = true
+			mu.Unlock()
+			pc.WriteTo(buf[:n], addr)
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port, func() map[int]bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ports
+	}
+}
+
+func gamePacket(id string) UDPData {
+	return UDPData{PacketID: id, Data: base64.StdEncoding.EncodeToString([]byte(id)), SourceAddress: "203.0.113.5", SourcePort: 4000}
+}
+
+func TestGameUDPSession(t *testing.T) {
+	port, ports := gameServer(t)
+	replies := make(chan UDPResponse, 16)
+	c := NewClient(
+		WithProtocol("udp"),
+		WithUpstreamFallback(net.JoinHostPort("127.0.0.1", strconv.Itoa(port))),
+		WithGameUDP(GameUDP{}),
+		WithMessageTap(func(d Direction, msgType string, payload []byte) {
+			if d == Outbound && msgType == MsgTypeUDPResponse {
+				var r UDPResponse
+				json.Unmarshal(payload, &r)
+				replies <- r
+			}
+		}),
+	)
+	c.closed = true
+
+	for _, id := range []string{"p1", "p2", "p3"} {
+		c.handleUDPData(gamePacket(id))
+	}
+	for i := range 6 {
+		select {
+		case <-replies:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected 6 replies, got %d", i)
+		}
+	}
+	if n := len(ports()); n != 1 {
+		t.Errorf("Expected one stable source port, server saw %d", n)
+	}
+	if s := c.Stats(); s.UDPSessions != 1 || s.UDPPackets != 3 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+
+	c.closeGameSessions()
+	c.Wait()
+	if s := c.Stats(); s.UDPSessions != 0 {
+		t.Errorf("Expected the session released, got %d", s.UDPSessions)
+	}
+}
+
+func TestGameUDPRateCap(t *testing.T) {
+	port, _ := gameServer(t)
+	c := NewClient(
+		WithProtocol("udp"),
+		WithUpstreamFallback(net.JoinHostPort("127.0.0.1", strconv.Itoa(port))),
+		WithGameUDP(GameUDP{RatePerSecond: 1, Burst: 2}),
+	)
+	c.closed = true
+	defer func() {
+		c.closeGameSessions()
+		c.Wait()
+	}()
+
+	for i := range 5 {
+		c.handleUDPData(gamePacket("p" + strconv.Itoa(i)))
+	}
+	if dropped := c.Stats().UDPDropped; dropped != 3 {
+		t.Errorf("Expected 3 packets over the cap dropped, got %d", dropped)
+	}
+}
+
+func TestGameUDPIdleTimeout(t *testing.T) {
+	port, _ := gameServer(t)
+	c := NewClient(
+		WithProtocol("udp"),
+		WithUpstreamFallback(net.JoinHostPort("127.0.0.1", strconv.Itoa(port))),
+		WithGameUDP(GameUDP{IdleTimeout: 50 * time.Millisecond}),
+	)
+	c.closed = true
+
+	c.handleUDPData(gamePacket("p1"))
+	c.Wait()
+	if s := c.Stats(); s.UDPSessions != 0 {
+		t.Errorf("Expected the idle session closed, got %d", s.UDPSessions)
+	}
+}
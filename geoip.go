@@ -0,0 +1,189 @@
+package outray
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// UnknownCountry labels requests whose country couldn't be determined.
+const UnknownCountry = "??"
+
+// GeoIPResolver maps a client IP to an ISO 3166-1 alpha-2 country code.
+type GeoIPResolver interface {
+	Country(ip net.IP) (string, error)
+}
+
+type GeoIPFunc func(ip net.IP) (string, error)
+
+func (f GeoIPFunc) Country(ip net.IP) (string, error) {
+	return f(ip)
+}
+
+type CountryStats struct {
+	Requests uint64
+	Blocked  uint64
+}
+
+type countryStats struct {
+	mu     sync.Mutex
+	counts map[string]*CountryStats
+}
+
+// WithGeoIP looks up the requester's country when the server doesn't
+// supply one, using the address from clientIP.
+func WithGeoIP(r GeoIPResolver) Option {
+	return func(c *Client) {
+		c.config.GeoIP = r
+	}
+}
+
+// WithCountryFilter rejects HTTP requests with 403 unless their country is
+// in allow (when non-empty) and not in deny. With an allow list, requests of
+// unknown origin are rejected too.
+func WithCountryFilter(allow, deny []string) Option {
+	return func(c *Client) {
+		c.config.AllowCountries = upperAll(allow)
+		c.config.DenyCountries = upperAll(deny)
+	}
+}
+
+// CountryStats returns per-country request counts. Countries are only
+// tracked when the server supplies them or WithGeoIP is set.
+func (c *Client) CountryStats() map[string]CountryStats {
+	c.countries.mu.Lock()
+	defer c.countries.mu.Unlock()
+	out := make(map[string]CountryStats, len(c.countries.counts))
+	for k, v := range c.countries.counts {
+		out[k] = *v
+	}
+	return out
+}
+
+// filterCountry records the request's country and reports whether it may
+// proceed. It stores the resolved country on req so later stages don't
+// look it up again.
+func (c *Client) filterCountry(req *IncomingRequest) bool {
+	country := c.requestCountry(*req)
+	req.Country = country
+	if country == "" {
+		if len(c.conf().AllowCountries) == 0 && len(c.conf().DenyCountries) == 0 {
+			return true
+		}
+		country = UnknownCountry
+	}
+
+	allowed := !slices.Contains(c.conf().DenyCountries, country) &&
+		(len(c.conf().AllowCountries) == 0 || slices.Contains(c.conf().AllowCountries, country))
+
+	c.countries.mu.Lock()
+	if c.countries.counts == nil {
+		c.countries.counts = make(map[string]*CountryStats)
+	}
+	s := c.countries.counts[country]
+	if s == nil {
+		s = &CountryStats{}
+		c.countries.counts[country] = s
+	}
+	s.Requests++
+	if !allowed {
+		s.Blocked++
+	}
+	c.countries.mu.Unlock()
+	return allowed
+}
+
+func (c *Client) requestCountry(req IncomingRequest) string {
+	if req.Country != "" {
+		return strings.ToUpper(req.Country)
+	}
+	if c.conf().GeoIP == nil {
+		return ""
+	}
+	ip := c.clientIP(req)
+	if ip == nil {
+		return ""
+	}
+	country, err := c.conf().GeoIP.Country(ip)
+	if err != nil {
+		c.logf("GeoIP lookup for %s failed: %v", ip, err)
+		return ""
+	}
+	return strings.ToUpper(country)
+}
+
+// WithTrustedProxies names the proxies, as CIDRs or single IPs, whose
+// X-Forwarded-For entries are believed. Without it the client address is
+// only ever the server-supplied remoteAddr, as anyone can send the header.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(c *Client) {
+		for _, p := range proxies {
+			cidr := p
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else if ip != nil {
+				cidr += "/128"
+			}
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				c.configErr = fmt.Errorf("invalid trusted proxy %q", p)
+				return
+			}
+			c.config.TrustedProxies = append(c.config.TrustedProxies, n)
+		}
+	}
+}
+
+// clientIP returns the address of the visitor behind req, or nil if it is
+// unknown. It is the server-supplied remoteAddr, unless that is a trusted
+// proxy: then X-Forwarded-For is read from the right, skipping trusted
+// hops, since only the entries they appended can be believed.
+func (c *Client) clientIP(req IncomingRequest) net.IP {
+	ip := parseHostIP(req.RemoteAddr)
+	if ip == nil || !c.trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(headerValue(req.Headers, "X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHostIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+		if !c.trustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func (c *Client) trustedProxy(ip net.IP) bool {
+	for _, n := range c.conf().TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+func (c *Client) rejectCountry(req IncomingRequest) {
+	c.reject(req, IncomingResponse{StatusCode: http.StatusForbidden, Body: []byte("Forbidden")}, "country blocked")
+}
+
+func upperAll(codes []string) []string {
+	out := make([]string, len(codes))
+	for i, code := range codes {
+		out[i] = strings.ToUpper(code)
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package outray
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCountryFilter(t *testing.T) {
+	var handled int
+	c := NewClient(
+		WithCountryFilter([]string{"us", "de"}, nil),
+		WithGeoIP(GeoIPFunc(func(ip net.IP) (string, error) {
+			if ip.Equal(net.ParseIP("203.0.113.9")) {
+				return "de", nil
+			}
+			return "", nil
+		})),
+		WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			handled++
+			return IncomingResponse{StatusCode: 200}
+		}),
+	)
+	c.closed = true
+
+	c.dispatchRequest(IncomingRequest{ID: "1", Country: "US"})
+	c.dispatchRequest(IncomingRequest{ID: "2", Country: "RU"})
+	// Without trusted proxies, X-Forwarded-For is the visitor's word.
+	c.dispatchRequest(IncomingRequest{ID: "3", Headers: map[string]string{"X-Forwarded-For": "203.0.113.9, 10.0.0.1"}})
+	c.dispatchRequest(IncomingRequest{ID: "4", RemoteAddr: "198.51.100.1:4000"})
+
+	if handled != 1 {
+		t.Errorf("Expected only the US request to pass, got %d handled", handled)
+	}
+	stats := c.CountryStats()
+	if stats["RU"].Blocked != 1 || stats["DE"].Requests != 0 || stats[UnknownCountry].Blocked != 2 {
+		t.Errorf("Unexpected country stats: %+v", stats)
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	c := NewClient(WithTrustedProxies("10.0.0.0/8", "192.0.2.1"))
+	if c.configErr != nil {
+		t.Fatal(c.configErr)
+	}
+	tests := []struct {
+		remote, xff, want string
+	}{
+		{"198.51.100.1:4000", "203.0.113.9", "198.51.100.1"},
+		{"", "203.0.113.9", ""},
+		{"192.0.2.1:4000", "203.0.113.9", "203.0.113.9"},
+		// The leftmost entry is the visitor's claim; the rightmost
+		// untrusted hop is the one a trusted proxy saw.
+		{"192.0.2.1:4000", "1.1.1.1, 203.0.113.9, 10.0.0.5", "203.0.113.9"},
+		{"10.0.0.2:4000", "10.0.0.3, 10.0.0.5", "10.0.0.3"},
+		{"192.0.2.1:4000", "garbage", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		req := IncomingRequest{RemoteAddr: tt.remote, Headers: map[string]string{"X-Forwarded-For": tt.xff}}
+		got := c.clientIP(req)
+		if (tt.want == "" && got != nil) || (tt.want != "" && !got.Equal(net.ParseIP(tt.want))) {
+			t.Errorf("clientIP(%q, %q) = %v, want %q", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+
+	if c := NewClient(WithTrustedProxies("not-an-ip")); c.configErr == nil {
+		t.Error("Expected an invalid proxy to be rejected")
+	}
+}
+
+func TestCountryResolvedOnce(t *testing.T) {
+	var lookups int
+	c := NewClient(
+		WithAnomalyDetection(AnomalyThresholds{}),
+		WithGeoIP(GeoIPFunc(func(ip net.IP) (string, error) {
+			lookups++
+			return "de", nil
+		})),
+		WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			if req.Country != "DE" {
+				t.Errorf("Expected the handler to see the resolved country, got %q", req.Country)
+			}
+			return IncomingResponse{StatusCode: 200}
+		}),
+	)
+	c.closed = true
+
+	c.dispatchRequest(IncomingRequest{ID: "1", RemoteAddr: "203.0.113.9:4000"})
+	if lookups != 1 {
+		t.Errorf("Expected one GeoIP lookup per request, got %d", lookups)
+	}
+	if got := c.CountryStats()["DE"].Requests; got != 1 {
+		t.Errorf("Expected the request counted under DE, got %d", got)
+	}
+}
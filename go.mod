@@ -2,4 +2,4 @@ module github.com/sodiqscript111/outray-go
 
 go 1.25.3
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,171 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errGroupStarted = errors.New("group already started")
+
+type GroupEventType string
+
+const (
+	GroupEventOpen    GroupEventType = "open"
+	GroupEventError   GroupEventType = "error"
+	GroupEventWarning GroupEventType = "warning"
+	GroupEventExit    GroupEventType = "exit"
+)
+
+// GroupEvent is an event from one member of a Group, tagged with the name
+// it was added under.
+type GroupEvent struct {
+	Name    string
+	Type    GroupEventType
+	URL     string
+	Err     error
+	Warning *Warning
+}
+
+// Group runs several tunnels as a unit: members share the group's options
+// (typically credentials and server URL), start and stop together, and the
+// first member to fail stops the rest.
+type Group struct {
+	opts    []Option
+	onEvent func(GroupEvent)
+
+	mu      sync.Mutex
+	members []*groupMember
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	err     error
+	errOnce sync.Once
+}
+
+type groupMember struct {
+	name   string
+	client *Client
+}
+
+func NewGroup(opts ...Option) *Group {
+	return &Group{opts: opts}
+}
+
+// OnEvent registers a callback receiving open, error, warning, and exit events from
+// every member. It must be set before members are added.
+func (g *Group) OnEvent(fn func(GroupEvent)) {
+	g.onEvent = fn
+}
+
+// Add creates a member client from the shared options followed by opts.
+func (g *Group) Add(name string, opts ...Option) *Client {
+	opts = append(append(append([]Option{}, g.opts...), opts...), g.memberEvents(name))
+	c := NewClient(opts...)
+
+	g.mu.Lock()
+	g.members = append(g.members, &groupMember{name: name, client: c})
+	g.mu.Unlock()
+	return c
+}
+
+// memberEvents wraps a member's callbacks so they also feed OnEvent. It
+// goes last so it wraps whatever the other options set.
+func (g *Group) memberEvents(name string) Option {
+	return func(c *Client) {
+		onOpen, onError, onWarning := c.config.OnOpen, c.config.OnError, c.config.OnWarning
+		c.config.OnOpen = func(url string) {
+			if onOpen != nil {
+				onOpen(url)
+			}
+			g.emit(GroupEvent{Name: name, Type: GroupEventOpen, URL: url})
+		}
+		c.config.OnError = func(err error) {
+			if onError != nil {
+				onError(err)
+			}
+			g.emit(GroupEvent{Name: name, Type: GroupEventError, Err: err})
+		}
+		c.config.OnWarning = func(w Warning) {
+			if onWarning != nil {
+				onWarning(w)
+			}
+			g.emit(GroupEvent{Name: name, Type: GroupEventWarning, Warning: &w})
+		}
+	}
+}
+
+func (g *Group) Client(name string) *Client {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, m := range g.members {
+		if m.name == name {
+			return m.client
+		}
+	}
+	return nil
+}
+
+// Start connects every member in the background. Use Wait to block until
+// the group stops.
+func (g *Group) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cancel != nil {
+		return errGroupStarted
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	g.cancel = cancel
+	for _, m := range g.members {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			err := m.client.Connect(ctx)
+			g.emit(GroupEvent{Name: m.name, Type: GroupEventExit, Err: err})
+			if err != nil && ctx.Err() == nil {
+				g.errOnce.Do(func() { g.err = fmt.Errorf("%s: %w", m.name, err) })
+				cancel()
+			}
+		}()
+	}
+	return nil
+}
+
+// Stop disconnects every member and waits for them to exit.
+func (g *Group) Stop() error {
+	g.mu.Lock()
+	cancel := g.cancel
+	g.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return g.Wait()
+}
+
+// Wait blocks until every member has exited and returns the first member
+// error, if any. A group stopped via its context or Stop returns nil.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// Stats sums the counters and gauges of every member.
+func (g *Group) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var total Stats
+	for _, m := range g.members {
+		total.add(m.client.Stats())
+	}
+	return total
+}
+
+func (g *Group) emit(ev GroupEvent) {
+	if g.onEvent != nil {
+		g.onEvent(ev)
+	}
+}
@@ -0,0 +1,103 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupStopsOnFirstError(t *testing.T) {
+	ts := newTestServer(t)
+	g := NewGroup(WithServerURL(ts.URL()), WithAPIKey("key"))
+
+	exits := make(chan GroupEvent, 2)
+	g.OnEvent(func(ev GroupEvent) {
+		if ev.Type == GroupEventExit {
+			exits <- ev
+		}
+	})
+	g.Add("web", WithPort(3000))
+	g.Add("db", WithProtocol("tcp"), WithPort(5432), WithIdleTimeout(50*time.Millisecond))
+
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(context.Background()); err == nil {
+		t.Error("Expected second Start to fail")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Errorf("Expected idle timeout from db, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Group did not stop after a member failed")
+	}
+	if len(exits) != 2 {
+		t.Errorf("Expected both members to exit, got %d exit events", len(exits))
+	}
+}
+
+func TestGroupStatsSumsEveryField(t *testing.T) {
+	g := NewGroup(WithAPIKey("key"), WithFairQueue(FairQueue{MaxConcurrent: 1}))
+	web := g.Add("web", WithPort(3000))
+	api := g.Add("api", WithPort(4000))
+
+	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		close(stop)
+		cancel()
+	})
+
+	// Give every counter and gauge a distinct nonzero value per member.
+	for i, c := range []*Client{web, api} {
+		n := uint64(i + 1)
+		for _, p := range []*uint64{
+			&c.stats.requests, &c.stats.requestErrors, &c.stats.bytesIn, &c.stats.bytesOut,
+			&c.stats.tcpConnections, &c.stats.udpPackets, &c.stats.udpDropped, &c.stats.reconnects,
+			&c.stats.compressed, &c.stats.compressionSkipped, &c.stats.compressionSaved,
+			&c.stats.shed, &c.stats.banned, &c.stats.throttled, &c.stats.checksumFailures,
+		} {
+			atomic.AddUint64(p, n)
+		}
+		atomic.AddInt64(&c.tcpActive, int64(n))
+
+		c.spawn(func() { <-stop })
+		t.Cleanup(c.trackWrite(100 * (i + 1)))
+		c.acquireUDPSession("203.0.113.1:9000", 0)
+		_, done := c.trackRequest(IncomingRequest{ID: "req"})
+		t.Cleanup(done)
+
+		if !c.fairq.acquire(ctx, "198.51.100.1") {
+			t.Fatal("Expected the first request to get a slot")
+		}
+		go c.fairq.acquire(ctx, "198.51.100.1")
+		for c.queuedRequests() != 1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	ws, as, total := web.Stats(), api.Stats(), g.Stats()
+	w, a, got := reflect.ValueOf(ws), reflect.ValueOf(as), reflect.ValueOf(total)
+	for i := 0; i < got.NumField(); i++ {
+		name := got.Type().Field(i).Name
+		if w.Field(i).IsZero() || a.Field(i).IsZero() {
+			t.Errorf("%s: expected the test to set it on both members", name)
+			continue
+		}
+		if f := w.Field(i); f.Kind() == reflect.Uint64 {
+			if want := f.Uint() + a.Field(i).Uint(); got.Field(i).Uint() != want {
+				t.Errorf("%s = %d, want %d", name, got.Field(i).Uint(), want)
+			}
+		} else if want := f.Int() + a.Field(i).Int(); got.Field(i).Int() != want {
+			t.Errorf("%s = %d, want %d", name, got.Field(i).Int(), want)
+		}
+	}
+}
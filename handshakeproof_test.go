@@ -0,0 +1,64 @@
+package outray
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshakeProof(t *testing.T) {
+	plain, _ := json.Marshal(NewClient(WithAPIKey("key")).openTunnelRequest(MsgTypeOpenTunnel))
+	var fields map[string]interface{}
+	json.Unmarshal(plain, &fields)
+	for _, k := range []string{"nonce", "timestamp", "proof"} {
+		if _, ok := fields[k]; ok {
+			t.Errorf("Expected plain handshake without %q", k)
+		}
+	}
+
+	c := NewClient(WithAPIKey("key"), WithHandshakeProof(ProofHMAC))
+	a := c.openTunnelRequest(MsgTypeOpenTunnel)
+	b := c.openTunnelRequest(MsgTypeOpenTunnel)
+	if a.Nonce == "" || a.Nonce == b.Nonce {
+		t.Errorf("Expected a fresh nonce per handshake, got %q and %q", a.Nonce, b.Nonce)
+	}
+	if d := time.Since(time.UnixMilli(a.Timestamp)); d < 0 || d > time.Second {
+		t.Errorf("Unexpected timestamp %d", a.Timestamp)
+	}
+	if a.APIKey != "" || a.KeyID != apiKeyID("key") {
+		t.Errorf("Expected the key ID in place of the API key, got %q and %q", a.APIKey, a.KeyID)
+	}
+}
+
+func TestHandshakeProofSignsFrame(t *testing.T) {
+	c := NewClient(WithAPIKey("key"), WithHandshakeProof(ProofHMAC),
+		WithHandshakeFields(map[string]interface{}{"region": "eu"}))
+	data, _ := json.Marshal(c.handshakeFrame(c.openTunnelRequest(MsgTypeOpenTunnel)))
+	if strings.Contains(string(data), `"key"`) {
+		t.Fatalf("Expected the API key to stay off the wire, got %s", data)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	proof, _ := fields["proof"].(string)
+	if proof == "" || proof != handshakeMAC("key", fields) {
+		t.Fatalf("Expected the proof to be the HMAC of the frame, got %s", data)
+	}
+	for _, k := range []string{"region", "nonce", "subdomain", "type"} {
+		tampered := make(map[string]interface{})
+		for f, v := range fields {
+			tampered[f] = v
+		}
+		tampered[k] = "tampered"
+		if handshakeMAC("key", tampered) == proof {
+			t.Errorf("Expected %q to be covered by the proof", k)
+		}
+	}
+
+	attach, _ := json.Marshal(c.attachFrame("t1", 1, ""))
+	fields = nil
+	json.Unmarshal(attach, &fields)
+	if _, ok := fields["apiKey"]; ok || fields["proof"] != handshakeMAC("key", fields) {
+		t.Errorf("Expected a proven attach frame without the API key, got %s", attach)
+	}
+}
@@ -0,0 +1,126 @@
+package outray
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxProbes = 100
+
+// credentialHeaders are replaced in recorded probes, which would otherwise
+// keep whatever credentials a refused client sent.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Auth-Token"}
+
+// Honeypot changes how requests refused by the access filters (country,
+// client certificate, JWT, policy) are answered. Delay holds the response
+// back to slow scanners down, and Decoy, if set, replaces the real
+// rejection so probes can't tell they were blocked.
+type Honeypot struct {
+	Delay time.Duration
+	// MaxDelayed caps how many delayed responses are held back at once
+	// (default 100); past it, refused requests are answered straight away
+	// so a flood of probes can't pile up goroutines.
+	MaxDelayed int
+	Decoy      *IncomingResponse
+	OnProbe    func(p Probe)
+}
+
+// Probe records a refused request. Credential headers such as
+// Authorization and Cookie are redacted, as are matches of WithRedaction
+// rules.
+type Probe struct {
+	Time       time.Time
+	Reason     string
+	Method     string
+	Path       string
+	RemoteAddr string
+	Country    string
+	UserAgent  string
+	Headers    map[string]string
+}
+
+type probeLog struct {
+	mu      sync.Mutex
+	probes  []Probe
+	delayed int32 // delayed responses in flight
+}
+
+func WithHoneypot(h Honeypot) Option {
+	return func(c *Client) {
+		if h.MaxDelayed <= 0 {
+			h.MaxDelayed = 100
+		}
+		c.config.Honeypot = &h
+	}
+}
+
+// Probes returns the most recent refused requests, oldest first.
+func (c *Client) Probes() []Probe {
+	c.probes.mu.Lock()
+	defer c.probes.mu.Unlock()
+	return append([]Probe(nil), c.probes.probes...)
+}
+
+// reject answers a request turned away by an access filter, recording it as
+// a probe and applying the honeypot if configured.
+func (c *Client) reject(req IncomingRequest, resp IncomingResponse, reason string) {
+	p := Probe{
+		Time:       time.Now(),
+		Reason:     reason,
+		Method:     req.Method,
+		Path:       req.Path,
+		RemoteAddr: req.RemoteAddr,
+		Country:    c.requestCountry(req),
+		UserAgent:  headerValue(req.Headers, "User-Agent"),
+		Headers:    c.probeHeaders(req.Headers),
+	}
+	c.probes.mu.Lock()
+	c.probes.probes = append(c.probes.probes, p)
+	if len(c.probes.probes) > maxProbes {
+		c.probes.probes = c.probes.probes[1:]
+	}
+	c.probes.mu.Unlock()
+
+	h := c.conf().Honeypot
+	if h == nil {
+		c.respond(req, resp, "send response error")
+		return
+	}
+	if h.OnProbe != nil {
+		c.safeCallback(func() { h.OnProbe(p) })
+	}
+	if h.Decoy != nil {
+		resp = *h.Decoy
+	}
+	if h.Delay <= 0 || atomic.AddInt32(&c.probes.delayed, 1) > int32(h.MaxDelayed) {
+		if h.Delay > 0 {
+			atomic.AddInt32(&c.probes.delayed, -1)
+		}
+		c.respond(req, resp, "send response error")
+		return
+	}
+	c.spawn(func() {
+		defer atomic.AddInt32(&c.probes.delayed, -1)
+		ctx, done := c.trackRequest(req)
+		defer done()
+		select {
+		case <-time.After(h.Delay):
+			c.respond(req, resp, "send response error")
+		case <-ctx.Done():
+		}
+	})
+}
+
+func (c *Client) probeHeaders(h map[string]string) map[string]string {
+	out := redactHeaders(h, c.conf().Redaction)
+	for k := range out {
+		for _, name := range credentialHeaders {
+			if http.CanonicalHeaderKey(k) == name {
+				out[k] = redacted
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoneypot(t *testing.T) {
+	ts := newTestServer(t)
+	probes := make(chan Probe, 1)
+	c := connectTestClient(t, ts,
+		WithPolicy(Policy{Rules: []PolicyRule{{Pattern: "/wp-admin/*", Access: AccessDeny}}}),
+		WithHoneypot(Honeypot{
+			Delay:   200 * time.Millisecond,
+			Decoy:   &IncomingResponse{StatusCode: 200, Body: []byte("<html>Login</html>")},
+			OnProbe: func(p Probe) { probes <- p },
+		}),
+	)
+	conn := ts.accept(t)
+
+	start := time.Now()
+	conn.WriteJSON(map[string]interface{}{
+		"type": "request", "requestId": "r1", "method": "GET", "path": "/wp-admin/setup.php",
+		"headers": map[string]string{"User-Agent": "scanner/1.0"}, "remoteAddr": "198.51.100.7:5000",
+	})
+
+	var resp IncomingResponse
+	readFrame(t, conn, &resp)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected tarpit delay, responded after %v", elapsed)
+	}
+	if resp.StatusCode != 200 || string(resp.Body) != "<html>Login</html>" {
+		t.Errorf("Expected decoy response, got %d %q", resp.StatusCode, resp.Body)
+	}
+
+	p := <-probes
+	if p.Reason != "denied by policy" || p.UserAgent != "scanner/1.0" || p.RemoteAddr != "198.51.100.7:5000" {
+		t.Errorf("Unexpected probe: %+v", p)
+	}
+	if got := c.Probes(); len(got) != 1 || got[0].Path != "/wp-admin/setup.php" {
+		t.Errorf("Expected probe to be recorded, got %+v", got)
+	}
+}
+
+func TestHoneypotRedactsAndCaps(t *testing.T) {
+	tap, responses := tapResponses()
+	c := NewClient(tap, WithRedaction(RedactEmails), WithHoneypot(Honeypot{Delay: time.Hour, MaxDelayed: 1}))
+	c.closed = true
+
+	req := IncomingRequest{ID: "r1", Method: "GET", Path: "/admin", Headers: map[string]string{
+		"authorization": "Bearer secret",
+		"Cookie":        "session=secret",
+		"X-From":        "ops@example.com",
+		"Accept":        "*/*",
+	}}
+	c.reject(req, IncomingResponse{StatusCode: 403}, "denied by policy")
+	h := c.Probes()[0].Headers
+	if h["authorization"] != redacted || h["Cookie"] != redacted || h["X-From"] != redacted || h["Accept"] != "*/*" {
+		t.Errorf("Expected credentials redacted in the probe, got %v", h)
+	}
+	if req.Headers["Cookie"] != "session=secret" {
+		t.Error("Expected the request's own headers to be left alone")
+	}
+
+	// The first response is held back; the next goes out at once.
+	req.ID = "r2"
+	c.reject(req, IncomingResponse{StatusCode: 403}, "denied by policy")
+	if resp := waitResponse(t, responses); resp.ID != "r2" {
+		t.Errorf("Expected r2 to be answered past the cap, got %s", resp.ID)
+	}
+	for {
+		c.inflightMu.Lock()
+		_, held := c.inflight["r1"]
+		c.inflightMu.Unlock()
+		if held {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.cancelRequest("r1")
+	c.Wait()
+}
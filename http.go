@@ -1,6 +1,8 @@
 package outray
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -8,57 +10,165 @@ import (
 	"time"
 )
 
-func (c *Client) proxyHTTP(req IncomingRequest) IncomingResponse {
-	if c.config.RequestMiddleware != nil {
-		if earlyResp := c.config.RequestMiddleware(&req); earlyResp != nil {
-			return *earlyResp
-		}
+func (c *Client) newHTTPClient(cfg *Config) *http.Client {
+	transport := &http.Transport{
+		DialContext:         c.dialUpstreamContext,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
 	}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
 
-	targetURL := fmt.Sprintf("http://localhost:%d%s", c.config.Port, req.Path)
-
-	var bodyReader *strings.Reader
-	if len(req.Body) > 0 {
-		bodyReader = strings.NewReader(string(req.Body))
-	} else {
-		bodyReader = strings.NewReader("")
-	}
+// samePool reports whether an upstream client built for a also suits b.
+func samePool(a, b Config) bool {
+	return a.MaxIdleConns == b.MaxIdleConns &&
+		a.MaxIdleConnsPerHost == b.MaxIdleConnsPerHost &&
+		a.IdleConnTimeout == b.IdleConnTimeout
+}
 
-	proxyReq, err := http.NewRequest(req.Method, targetURL, bodyReader)
-	if err != nil {
-		return IncomingResponse{StatusCode: 500, Body: []byte(err.Error())}
-	}
+func (c *Client) proxyHTTP(ctx context.Context, req IncomingRequest) IncomingResponse {
+	return c.proxyHTTPVia(ctx, c.upstream("http"), req)
+}
 
-	for k, v := range req.Headers {
-		proxyReq.Header.Set(k, v)
+func (c *Client) proxyHTTPVia(ctx context.Context, u Upstream, req IncomingRequest) IncomingResponse {
+	if c.conf().RequestMiddleware != nil {
+		if earlyResp := c.conf().RequestMiddleware(&req); earlyResp != nil {
+			return *earlyResp
+		}
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(proxyReq)
+	resp, err := c.doUpstream(ctx, u, req)
 	if err != nil {
-		return IncomingResponse{StatusCode: 502, Body: []byte(fmt.Sprintf("Proxy Error: %v", err))}
+		return c.upstreamErrorResponse(req, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return IncomingResponse{StatusCode: 500, Body: []byte(err.Error())}
-	}
-
-	respHeaders := make(map[string]string)
-	for k, v := range resp.Header {
-		respHeaders[k] = v[0]
+		return c.errorResponse(req, 500, err.Error(), err)
 	}
 
 	response := IncomingResponse{
 		StatusCode: resp.StatusCode,
-		Headers:    respHeaders,
+		Headers:    flattenHeaders(resp.Header),
 		Body:       body,
 	}
 
-	if c.config.ResponseMiddleware != nil {
-		c.config.ResponseMiddleware(&req, &response)
+	if c.conf().ResponseMiddleware != nil {
+		c.conf().ResponseMiddleware(&req, &response)
 	}
 
 	return response
 }
+
+func (c *Client) upstreamErrorResponse(req IncomingRequest, err error) IncomingResponse {
+	if _, ok := err.(requestError); ok {
+		return c.errorResponse(req, 500, err.Error(), err)
+	}
+	return c.errorResponse(req, 502, fmt.Sprintf("Proxy Error: %v", err), err)
+}
+
+// flattenHeaders turns h into the one value per name that frames carry.
+// Repeated headers are joined the way HTTP allows, with "; " for Cookie.
+// Set-Cookie can't be joined at all, so its values go one per line (no
+// header value contains a newline) and expandHeaders splits them again.
+func flattenHeaders(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k, v := range h {
+		if len(v) > 0 {
+			headers[k] = joinHeader(k, v)
+		}
+	}
+	return headers
+}
+
+func joinHeader(name string, values []string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Set-Cookie":
+		return strings.Join(values, "\n")
+	case "Cookie":
+		return strings.Join(values, "; ")
+	}
+	return strings.Join(values, ", ")
+}
+
+// expandHeaders is the reverse of flattenHeaders.
+func expandHeaders(headers map[string]string) http.Header {
+	h := make(http.Header, len(headers))
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Set-Cookie" {
+			for _, cookie := range strings.Split(v, "\n") {
+				h.Add(k, cookie)
+			}
+			continue
+		}
+		h.Set(k, v)
+	}
+	return h
+}
+
+type requestError struct {
+	err error
+}
+
+func (e requestError) Error() string {
+	return e.err.Error()
+}
+
+func (c *Client) doUpstream(ctx context.Context, u Upstream, req IncomingRequest) (*http.Response, error) {
+	backoff := c.conf().UpstreamRetryBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		proxyReq, err := newUpstreamRequest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := u.RoundTrip(proxyReq)
+		if err == nil {
+			return resp, nil
+		}
+		if _, ok := err.(requestError); ok {
+			return nil, err
+		}
+		if attempt >= c.conf().UpstreamRetryAttempts || !isIdempotent(req.Method) || req.stream != nil {
+			return nil, err
+		}
+
+		c.logf("Upstream %s %s failed: %v. Retrying in %v...", req.Method, req.Path, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func newUpstreamRequest(ctx context.Context, req IncomingRequest) (*http.Request, error) {
+	var body io.Reader = bytes.NewReader(req.Body)
+	if req.stream != nil {
+		body = req.stream
+	}
+	proxyReq, err := http.NewRequestWithContext(ctx, req.Method, "http://upstream"+req.Path, body)
+	if err != nil {
+		return nil, requestError{err}
+	}
+	for k, v := range req.Headers {
+		proxyReq.Header.Set(k, v)
+	}
+	if req.stream != nil {
+		proxyReq.ContentLength = contentLength(req.Headers)
+	}
+	return proxyReq, nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestUpstreamRetry(t *testing.T) {
+	c := NewClient(WithPort(8080), WithUpstreamRetry(2, time.Millisecond))
+
+	var calls int
+	client := &localUpstream{c: c, client: &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("connection refused")
+	})}}
+
+	if _, err := c.doUpstream(context.Background(), client, IncomingRequest{Method: "GET", Path: "/"}); err == nil {
+		t.Fatal("Expected error")
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts for GET, got %d", calls)
+	}
+
+	calls = 0
+	c.doUpstream(context.Background(), client, IncomingRequest{Method: "POST", Path: "/"})
+	if calls != 1 {
+		t.Errorf("Expected POST not to be retried, got %d attempts", calls)
+	}
+}
+
+func TestUpstreamFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stub"))
+	}))
+	defer srv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln.Addr().String()
+	ln.Close()
+
+	c := NewClient(WithUpstreamFallback(down, strings.TrimPrefix(srv.URL, "http://")))
+	resp := c.proxyHTTP(context.Background(), IncomingRequest{Method: "GET", Path: "/"})
+	if resp.StatusCode != 200 || string(resp.Body) != "stub" {
+		t.Errorf("Expected fallback response, got %d %q", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestUpstreamPool(t *testing.T) {
+	var opened, closed atomic.Int32
+	arrived := make(chan struct{}, 4)
+	release := make(chan struct{})
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			arrived <- struct{}{}
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			opened.Add(1)
+		case http.StateClosed:
+			closed.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	c := NewClient(WithPort(upstream.Listener.Addr().(*net.TCPAddr).Port), WithUpstreamPool(8, 2, 45*time.Second))
+	transport := c.upstreamClient().Transport.(*http.Transport)
+	if transport.MaxIdleConns != 8 || transport.MaxIdleConnsPerHost != 2 || transport.IdleConnTimeout != 45*time.Second {
+		t.Errorf("Expected pool limits 8/2/45s, got %d/%d/%v", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	for range 5 {
+		if resp := c.proxyHTTP(context.Background(), IncomingRequest{Method: "GET", Path: "/"}); resp.StatusCode != 200 {
+			t.Fatalf("Unexpected response: %d %s", resp.StatusCode, resp.Body)
+		}
+	}
+	if n := opened.Load(); n != 1 {
+		t.Errorf("Expected sequential requests to reuse one connection, got %d", n)
+	}
+
+	// Four requests at once need four connections; only two may stay idle.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.proxyHTTP(context.Background(), IncomingRequest{Method: "GET", Path: "/slow"})
+		}()
+	}
+	for range 4 {
+		<-arrived
+	}
+	close(release)
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for closed.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n, m := opened.Load(), closed.Load(); n != 4 || m != 2 {
+		t.Errorf("Expected 4 connections with 2 closed beyond MaxIdleConnsPerHost, got %d opened and %d closed", n, m)
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	c := NewClient(WithErrorPage(502, `{"id": {{json .RequestID}}, "status": {{.StatusCode}}}`))
+	resp := c.errorResponse(IncomingRequest{ID: "req-1"}, 502, "Proxy Error", errors.New("down"))
+	if string(resp.Body) != `{"id": "req-1", "status": 502}` {
+		t.Errorf("Unexpected error page body: %s", resp.Body)
+	}
+	if resp.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Expected JSON content type, got %q", resp.Headers["Content-Type"])
+	}
+}
+
+func TestCompressionRules(t *testing.T) {
+	c := NewClient(WithCompression(CompressionRules{MinSize: 16}))
+	req := IncomingRequest{Headers: map[string]string{"accept-encoding": "gzip, br"}}
+
+	text := IncomingResponse{
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    []byte(strings.Repeat("compress me ", 100)),
+	}
+	c.compressResponse(req, &text)
+	if text.Headers["Content-Encoding"] != "gzip" {
+		t.Error("Expected text body to be compressed")
+	}
+
+	image := IncomingResponse{
+		Headers: map[string]string{"Content-Type": "image/png"},
+		Body:    []byte(strings.Repeat("x", 100)),
+	}
+	c.compressResponse(req, &image)
+	if image.Headers["Content-Encoding"] != "" {
+		t.Error("Expected image body to be skipped")
+	}
+
+	if s := c.Stats(); s.CompressedResponses != 1 || s.CompressionSkipped != 1 || s.CompressionSaved == 0 {
+		t.Errorf("Unexpected compression counters: %+v", s)
+	}
+}
+
+func TestStreamingUpload(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer srv.Close()
+
+	var progress []UploadProgress
+	c := NewClient(
+		WithUpstreamFallback(strings.TrimPrefix(srv.URL, "http://")),
+		WithUploadProgress(func(p UploadProgress) { progress = append(progress, p) }),
+	)
+	c.closed = true
+
+	c.startUpload(IncomingRequest{ID: "up-1", Method: "POST", Path: "/upload", Streaming: true, Headers: map[string]string{"Content-Length": "10"}})
+	c.handleRequestChunk(RequestChunk{ID: "up-1", Data: []byte("hello")})
+	c.handleRequestChunk(RequestChunk{ID: "up-1", Data: []byte("world"), Final: true})
+
+	select {
+	case body := <-received:
+		if body != "helloworld" {
+			t.Errorf("Unexpected upstream body %q", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Upload never reached upstream")
+	}
+	if len(progress) != 2 || progress[1].Bytes != 10 || progress[1].Total != 10 || !progress[1].Done {
+		t.Errorf("Unexpected progress events: %+v", progress)
+	}
+}
+
+func TestStreamingUploadSlowUpstream(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithUpstreamFallback(strings.TrimPrefix(srv.URL, "http://")))
+	c.closed = true
+	if u, ok := c.untimedUpstream().(*localUpstream); !ok || u.client.Timeout != 0 {
+		t.Error("Expected uploads to use a client without an overall timeout")
+	}
+
+	c.startUpload(IncomingRequest{ID: "up-1", Method: "POST", Path: "/upload", Streaming: true})
+	chunk := strings.Repeat("x", 64<<10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			c.handleRequestChunk(RequestChunk{ID: "up-1", Data: []byte(chunk)})
+		}
+		c.handleRequestChunk(RequestChunk{ID: "up-1", Final: true})
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Chunks blocked on the local service")
+	}
+
+	close(release)
+	select {
+	case body := <-received:
+		if len(body) != 20*len(chunk) {
+			t.Errorf("Expected %d bytes upstream, got %d", 20*len(chunk), len(body))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Upload never reached upstream")
+	}
+}
+
+func TestStreamingUploadNoUpstream(t *testing.T) {
+	tap, responses := tapResponses()
+	c := NewClient(tap, WithProtocol("tcp"))
+	c.closed = true
+
+	c.startUpload(IncomingRequest{ID: "up-1", Method: "POST", Path: "/upload", Streaming: true})
+	if resp := waitResponse(t, responses); resp.ID != "up-1" || resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected a 502, got %+v", resp)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	copies := make(chan string, 2)
+	teammate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		copies <- r.URL.Path + " " + string(body)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer teammate.Close()
+
+	c := NewClient(WithFanOut(strings.TrimPrefix(teammate.URL, "http://"), teammate.URL+"/mirror"), WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		return IncomingResponse{StatusCode: 200}
+	}))
+	c.closed = true
+	c.dispatchRequest(IncomingRequest{ID: "r1", Method: "POST", Path: "/hook", Body: []byte("evt")})
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-copies:
+			got[p] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for fan-out copies")
+		}
+	}
+	if !got["/hook evt"] || !got["/mirror/hook evt"] {
+		t.Errorf("Unexpected copies: %v", got)
+	}
+}
@@ -0,0 +1,62 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var ErrIdleTimeout = errors.New("tunnel closed after idle timeout")
+
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.config.IdleTimeout = d
+	}
+}
+
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+func (c *Client) idleFor() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActivity)))
+}
+
+func (c *Client) watchIdle(ctx context.Context, cancel context.CancelFunc) {
+	interval := c.conf().IdleTimeout / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.idleFor() >= c.conf().IdleTimeout {
+				c.logf("No traffic for %v, closing tunnel", c.conf().IdleTimeout)
+				atomic.StoreInt32(&c.idleExpired, 1)
+				cancel()
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) contextErr(ctx context.Context) error {
+	if atomic.LoadInt32(&c.idleExpired) == 1 {
+		return ErrIdleTimeout
+	}
+	if atomic.LoadInt32(&c.dataCapReached) == 1 {
+		return ErrDataCapReached
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,193 @@
+package outray
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JournalQuery filters journal entries. Zero fields match everything.
+type JournalQuery struct {
+	Path        string // substring of the request path
+	Method      string
+	Protocol    string // "redis" or "mysql" for inspected TCP commands
+	Status      int    // exact status code
+	StatusClass int    // 1-5, e.g. 5 for any 5xx
+	Header      string
+	HeaderValue string // substring; with Header empty, any header value
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+}
+
+func (q JournalQuery) match(e JournalEntry) bool {
+	if q.Path != "" && !strings.Contains(e.Request.Path, q.Path) {
+		return false
+	}
+	if q.Method != "" && !strings.EqualFold(q.Method, e.Request.Method) {
+		return false
+	}
+	if q.Protocol != "" && !strings.EqualFold(q.Protocol, e.Protocol) {
+		return false
+	}
+	if q.Status != 0 && e.Response.StatusCode != q.Status {
+		return false
+	}
+	if q.StatusClass != 0 && e.Response.StatusCode/100 != q.StatusClass {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if q.Header != "" {
+		v := headerValue(e.Request.Headers, q.Header)
+		return v != "" && strings.Contains(v, q.HeaderValue)
+	}
+	if q.HeaderValue != "" {
+		for _, v := range e.Request.Headers {
+			if strings.Contains(v, q.HeaderValue) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Search returns matching entries, newest first.
+func (j *Journal) Search(q JournalQuery) ([]JournalEntry, error) {
+	var found []JournalEntry
+	err := j.scan(true, func(e JournalEntry) bool {
+		if q.match(e) {
+			found = append(found, e)
+		}
+		return q.Limit <= 0 || len(found) < q.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Find returns the newest entry for a request ID, looked up in the index
+// so only that entry is read.
+func (j *Journal) Find(id string) (JournalEntry, bool, error) {
+	j.mu.Lock()
+	i := len(j.index) - 1
+	for i >= 0 && j.index[i].id != id {
+		i--
+	}
+	var ref journalRef
+	if i >= 0 {
+		ref = j.index[i]
+	}
+	j.mu.Unlock()
+	if i < 0 {
+		return JournalEntry{}, false, nil
+	}
+
+	r := journalReader{j: j}
+	defer r.close()
+	return r.read(ref)
+}
+
+// NewInspector serves a JSON API over a journal:
+//
+//	GET /api/requests?path=&method=&protocol=&status=&header=&value=&since=&until=&limit=
+//	GET /api/requests/{id}
+//	GET /api/diff?a={id}&b={id}
+//
+// status accepts a code (502) or a class (5xx); since and until are RFC 3339.
+func NewInspector(j *Journal) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/requests", func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseJournalQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := j.Search(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeInspectorJSON(w, entries)
+	})
+	mux.HandleFunc("GET /api/requests/{id}", func(w http.ResponseWriter, r *http.Request) {
+		e, ok, err := j.Find(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeInspectorJSON(w, e)
+	})
+	mux.HandleFunc("GET /api/diff", func(w http.ResponseWriter, r *http.Request) {
+		var pair [2]JournalEntry
+		for i, key := range []string{"a", "b"} {
+			e, ok, err := j.Find(r.URL.Query().Get(key))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "unknown request "+key, http.StatusNotFound)
+				return
+			}
+			pair[i] = e
+		}
+		writeInspectorJSON(w, DiffEntries(pair[0], pair[1]))
+	})
+	return mux
+}
+
+func parseJournalQuery(r *http.Request) (JournalQuery, error) {
+	v := r.URL.Query()
+	q := JournalQuery{
+		Path:        v.Get("path"),
+		Method:      v.Get("method"),
+		Protocol:    v.Get("protocol"),
+		Header:      v.Get("header"),
+		HeaderValue: v.Get("value"),
+	}
+
+	if s := strings.ToLower(v.Get("status")); s != "" {
+		if len(s) == 3 && strings.HasSuffix(s, "xx") {
+			q.StatusClass = int(s[0] - '0')
+		} else if code, err := strconv.Atoi(s); err == nil {
+			q.Status = code
+		} else {
+			return q, err
+		}
+	}
+	for key, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if s := v.Get(key); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, err
+			}
+			*t = parsed
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+func writeInspectorJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,53 @@
+package outray
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInspectorSearch(t *testing.T) {
+	j, err := OpenJournal(t.TempDir(), JournalLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	now := time.Now()
+	for _, e := range []JournalEntry{
+		{Time: now.Add(-48 * time.Hour), Request: IncomingRequest{ID: "a", Method: "POST", Path: "/webhooks/stripe"}, Response: IncomingResponse{StatusCode: 500}},
+		{Time: now.Add(-20 * time.Hour), Request: IncomingRequest{ID: "b", Method: "POST", Path: "/webhooks/stripe", Headers: map[string]string{"Stripe-Signature": "t=1"}}, Response: IncomingResponse{StatusCode: 502}},
+		{Time: now.Add(-10 * time.Hour), Request: IncomingRequest{ID: "c", Method: "POST", Path: "/webhooks/stripe"}, Response: IncomingResponse{StatusCode: 200}},
+		{Time: now, Request: IncomingRequest{ID: "d", Method: "GET", Path: "/health"}, Response: IncomingResponse{StatusCode: 503}},
+	} {
+		if err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(NewInspector(j))
+	defer srv.Close()
+
+	since := now.Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+	resp, err := http.Get(srv.URL + "/api/requests?path=stripe&status=5xx&header=stripe-signature&since=" + since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var found []JournalEntry
+	json.NewDecoder(resp.Body).Decode(&found)
+	if len(found) != 1 || found[0].Request.ID != "b" {
+		t.Errorf("Expected only request b, got %+v", found)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/requests/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown ID, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,316 @@
+package outray
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const journalSuffix = ".jsonl"
+
+// JournalEntry is one request/response exchange. Streamed responses are
+// recorded without a body.
+//
+// Commands seen by WithCommandInspection are journaled too, with Protocol
+// set, the command name as Request.Method and the TCP connection ID as
+// Request.ID. They have no response and can't be replayed.
+type JournalEntry struct {
+	Time     time.Time        `json:"time"`
+	Duration time.Duration    `json:"duration"`
+	Protocol string           `json:"protocol,omitempty"`
+	Request  IncomingRequest  `json:"request"`
+	Response IncomingResponse `json:"response"`
+}
+
+type JournalLimits struct {
+	MaxSegmentBytes int64
+	MaxSegments     int
+}
+
+// Journal is a bounded on-disk log of recent exchanges, kept as numbered
+// JSON-lines segment files so it survives restarts. The oldest segment is
+// deleted once MaxSegments is exceeded.
+type Journal struct {
+	dir    string
+	limits JournalLimits
+
+	mu    sync.Mutex
+	f     *os.File
+	size  int64
+	seq   int
+	index []journalRef // every entry on disk, oldest first
+}
+
+// journalRef locates one entry on disk. Segments only grow, so the bytes
+// it points at don't change once indexed.
+type journalRef struct {
+	seq    int
+	offset int64
+	size   int
+	id     string // request ID
+}
+
+func WithJournal(j *Journal) Option {
+	return func(c *Client) {
+		c.config.Journal = j
+	}
+}
+
+// OpenJournal opens (or creates) a journal in dir and appends to its newest
+// segment. Zero limits default to 4 MiB segments and 8 segments.
+func OpenJournal(dir string, limits JournalLimits) (*Journal, error) {
+	if limits.MaxSegmentBytes <= 0 {
+		limits.MaxSegmentBytes = 4 << 20
+	}
+	if limits.MaxSegments <= 0 {
+		limits.MaxSegments = 8
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+
+	j := &Journal{dir: dir, limits: limits}
+	segs, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) > 0 {
+		j.seq = segs[len(segs)-1]
+	}
+	for _, seq := range segs {
+		if err := j.indexSegment(seq); err != nil {
+			return nil, err
+		}
+	}
+	if err := j.openSegment(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) Append(e JournalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	if j.size > 0 && j.size+int64(len(data)) > j.limits.MaxSegmentBytes {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.f.Write(data)
+	if err == nil {
+		j.index = append(j.index, journalRef{seq: j.seq, offset: j.size, size: n, id: e.Request.ID})
+	}
+	j.size += int64(n)
+	return err
+}
+
+// Entries returns every entry still on disk, oldest first.
+func (j *Journal) Entries() ([]JournalEntry, error) {
+	var entries []JournalEntry
+	err := j.scan(false, func(e JournalEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	return entries, err
+}
+
+// scan decodes entries, oldest or newest first, until fn returns false.
+// Only copying the index holds the lock, so reads don't stall Append.
+func (j *Journal) scan(newestFirst bool, fn func(JournalEntry) bool) error {
+	j.mu.Lock()
+	refs := slices.Clone(j.index)
+	j.mu.Unlock()
+	if newestFirst {
+		slices.Reverse(refs)
+	}
+
+	r := journalReader{j: j}
+	defer r.close()
+	for _, ref := range refs {
+		e, ok, err := r.read(ref)
+		if err != nil {
+			return err
+		}
+		if ok && !fn(e) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// journalReader reads indexed entries, keeping the last segment open.
+type journalReader struct {
+	j       *Journal
+	seq     int
+	f       *os.File
+	missing bool // segment seq was pruned after the index was copied
+}
+
+func (r *journalReader) read(ref journalRef) (JournalEntry, bool, error) {
+	if (r.f == nil && !r.missing) || r.seq != ref.seq {
+		r.close()
+		r.seq = ref.seq
+		f, err := os.Open(r.j.segmentPath(ref.seq))
+		if err != nil && !os.IsNotExist(err) {
+			return JournalEntry{}, false, err
+		}
+		r.f, r.missing = f, err != nil
+	}
+	if r.missing {
+		return JournalEntry{}, false, nil
+	}
+	buf := make([]byte, ref.size)
+	if _, err := r.f.ReadAt(buf, ref.offset); err != nil {
+		return JournalEntry{}, false, err
+	}
+	var e JournalEntry
+	if json.Unmarshal(buf, &e) != nil {
+		return JournalEntry{}, false, nil
+	}
+	return e, true, nil
+}
+
+func (r *journalReader) close() {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+}
+
+// indexSegment adds the complete entries in segment seq to the index.
+func (j *Journal) indexSegment(seq int) error {
+	f, err := os.Open(j.segmentPath(seq))
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // a torn final line isn't an entry
+		}
+		if err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+		var e JournalEntry
+		if json.Unmarshal(line, &e) == nil {
+			j.index = append(j.index, journalRef{seq: seq, offset: offset, size: len(line), id: e.Request.ID})
+		}
+		offset += int64(len(line))
+	}
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+func (j *Journal) rotate() error {
+	j.f.Close()
+	j.seq++
+	if err := j.openSegment(); err != nil {
+		return err
+	}
+
+	segs, err := j.segments()
+	if err != nil {
+		return err
+	}
+	for len(segs) > j.limits.MaxSegments {
+		os.Remove(j.segmentPath(segs[0]))
+		segs = segs[1:]
+	}
+	j.index = slices.DeleteFunc(j.index, func(r journalRef) bool { return r.seq < segs[0] })
+	return nil
+}
+
+func (j *Journal) openSegment() error {
+	f, err := os.OpenFile(j.segmentPath(j.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("journal: %w", err)
+	}
+	j.f = f
+	j.size = info.Size()
+	return nil
+}
+
+func (j *Journal) segments() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(j.dir, "*"+journalSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var segs []int
+	for _, name := range names {
+		var seq int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(filepath.Base(name), journalSuffix), "%d", &seq); err == nil {
+			segs = append(segs, seq)
+		}
+	}
+	sort.Ints(segs)
+	return segs, nil
+}
+
+func (j *Journal) segmentPath(seq int) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%08d%s", seq, journalSuffix))
+}
+
+// Replay sends a journaled request through the client's current handler or
+// upstream and returns the new response.
+func (c *Client) Replay(ctx context.Context, e JournalEntry) IncomingResponse {
+	return c.processRequest(ctx, e.Request)
+}
+
+// record is called once per completed exchange, before compression and
+// sealing, with the response the local service produced.
+func (c *Client) record(req IncomingRequest, resp IncomingResponse) {
+	var latency time.Duration
+	if !req.received.IsZero() {
+		latency = time.Since(req.received)
+	}
+	c.observeRoute(req, resp.StatusCode, latency)
+	c.checkRequestLimits(req, resp, latency)
+	c.detectAnomalies(req, resp)
+	if c.tui != nil {
+		c.tui.add(req, resp, latency)
+	}
+	if c.conf().Journal == nil {
+		return
+	}
+	entry := JournalEntry{Time: time.Now(), Duration: latency, Request: req, Response: resp}
+	c.redact(&entry)
+	if err := c.conf().Journal.Append(entry); err != nil {
+		c.logf("Failed to journal request %s: %v", req.ID, err)
+	}
+}
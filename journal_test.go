@@ -0,0 +1,89 @@
+package outray
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestJournalRotationAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir, JournalLimits{MaxSegmentBytes: 300, MaxSegments: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled []string
+	c := NewClient(WithJournal(j), WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		handled = append(handled, req.Path)
+		return IncomingResponse{StatusCode: 200, Body: []byte("ok")}
+	}))
+	c.closed = true
+	for i := 0; i < 6; i++ {
+		c.handleMessage([]byte(fmt.Sprintf(`{"type":"request","requestId":"r%d","method":"POST","path":"/hook/%d"}`, i, i)), 0)
+	}
+	j.Close()
+
+	j, err = OpenJournal(dir, JournalLimits{MaxSegmentBytes: 300, MaxSegments: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 || len(entries) >= 6 {
+		t.Fatalf("Expected old segments to be pruned, got %d entries", len(entries))
+	}
+	last := entries[len(entries)-1]
+	if last.Request.Path != "/hook/5" || string(last.Response.Body) != "ok" {
+		t.Errorf("Unexpected last entry: %+v", last)
+	}
+
+	handled = nil
+	if resp := c.Replay(context.Background(), last); resp.StatusCode != 200 || len(handled) != 1 || handled[0] != "/hook/5" {
+		t.Errorf("Expected replay through OnRequest, got %d %v", resp.StatusCode, handled)
+	}
+}
+
+func TestJournalIndex(t *testing.T) {
+	dir := t.TempDir()
+	limits := JournalLimits{MaxSegmentBytes: 400, MaxSegments: 3}
+	j, err := OpenJournal(dir, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		j.Append(JournalEntry{Request: IncomingRequest{ID: fmt.Sprintf("r%d", i), Path: fmt.Sprintf("/%d", i)}})
+	}
+
+	// Reading doesn't hold the journal's lock, so Append isn't held up.
+	err = j.scan(false, func(e JournalEntry) bool {
+		return j.Append(JournalEntry{Request: IncomingRequest{ID: "during", Path: "/during"}}) != nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := j.Search(JournalQuery{Limit: 2})
+	if err != nil || len(found) != 2 || found[0].Request.ID != "during" || found[1].Request.ID != "r9" {
+		t.Fatalf("Expected the two newest entries, got %+v %v", found, err)
+	}
+	j.Close()
+
+	j, err = OpenJournal(dir, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if e, ok, err := j.Find("r8"); err != nil || !ok || e.Request.Path != "/8" {
+		t.Errorf("Expected to find r8 after reopening, got %+v %v %v", e, ok, err)
+	}
+	if _, ok, _ := j.Find("r0"); ok {
+		t.Error("Expected pruned entries to be gone from the index")
+	}
+	if _, ok, _ := j.Find("missing"); ok {
+		t.Error("Expected no entry for an unknown ID")
+	}
+}
@@ -0,0 +1,348 @@
+package outray
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	jwksRefreshInterval = time.Hour
+	jwksMinRefresh      = 30 * time.Second
+)
+
+var (
+	errJWTMissing    = errors.New("missing bearer token")
+	errJWTMalformed  = errors.New("malformed token")
+	errJWTSignature  = errors.New("invalid token signature")
+	errJWTExpired    = errors.New("token expired")
+	errJWTNotYet     = errors.New("token not yet valid")
+	errJWTUnknownKey = errors.New("no key for token")
+	errJWTIssuer     = errors.New("token issuer not accepted")
+	errJWTAudience   = errors.New("token audience not accepted")
+	errJWTNoKeys     = errors.New("jwt access requires WithJWTAuth")
+)
+
+// JWTKeySource resolves the verification key for a token's kid and alg.
+// Keys are *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, or []byte
+// for HMAC.
+type JWTKeySource interface {
+	Key(kid, alg string) (interface{}, error)
+}
+
+// JWTRequirements are the claims a token must carry on top of a valid
+// signature and exp/nbf. Claims values must equal the claim, or be one of
+// its elements when the claim is an array.
+type JWTRequirements struct {
+	Issuer   string
+	Audience string
+	Claims   map[string]string
+	Leeway   time.Duration
+}
+
+// WithJWTAuth rejects tunneled requests without a valid
+// "Authorization: Bearer" token with 401 before they reach the local app.
+// The verified claims are passed on in IncomingRequest.Claims.
+func WithJWTAuth(keys JWTKeySource, req JWTRequirements) Option {
+	return func(c *Client) {
+		c.config.JWTKeys = keys
+		c.config.JWTRequirements = req
+	}
+}
+
+// StaticKey verifies every token with a single key.
+func StaticKey(key interface{}) JWTKeySource {
+	return staticKey{key}
+}
+
+type staticKey struct{ key interface{} }
+
+func (s staticKey) Key(kid, alg string) (interface{}, error) {
+	return s.key, nil
+}
+
+// JWKS fetches keys from a JSON Web Key Set URL, caching them for an hour
+// and refetching early when a token names an unknown kid.
+func JWKS(url string) JWTKeySource {
+	return &jwks{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+func (j *jwks) Key(kid, alg string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stale := time.Since(j.fetched) > jwksRefreshInterval
+	if _, ok := j.keys[kid]; stale || (!ok && time.Since(j.fetched) > jwksMinRefresh) {
+		if err := j.refresh(); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errJWTUnknownKey
+}
+
+func (j *jwks) refresh() error {
+	j.fetched = time.Now()
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	j.keys = keys
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64Int(k.N)
+		e, err2 := b64Int(k.E)
+		if err1 != nil || err2 != nil {
+			return nil, errJWTMalformed
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := b64Int(k.X)
+		y, err2 := b64Int(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errJWTMalformed
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errJWTMalformed
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+type jwtValidator struct {
+	keys JWTKeySource
+	req  JWTRequirements
+}
+
+// validate checks the bearer token in headers and returns its claims.
+func (v jwtValidator) validate(headers map[string]string, now time.Time) (map[string]interface{}, error) {
+	token, ok := strings.CutPrefix(headerValue(headers, "Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errJWTMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+	key, err := v.keys.Key(header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+	return claims, v.checkClaims(claims, now)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil || json.Unmarshal(data, v) != nil {
+		return errJWTMalformed
+	}
+	return nil
+}
+
+func verifyJWS(alg string, key interface{}, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if alg == "EdDSA" {
+		if k, ok := key.(ed25519.PublicKey); ok && ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+		return errJWTSignature
+	}
+	if hash == 0 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	var ok bool
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write(signed)
+		ok = alg[:2] == "HS" && hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			ok = rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+		case "PS":
+			ok = rsa.VerifyPSS(k, hash, digest, sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] == "ES" && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			ok = ecdsa.Verify(k, digest, r, s)
+		}
+	}
+	if !ok {
+		return errJWTSignature
+	}
+	return nil
+}
+
+func (v jwtValidator) checkClaims(claims map[string]interface{}, now time.Time) error {
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(v.req.Leeway)) {
+		return errJWTExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.req.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errJWTNotYet
+	}
+	if v.req.Issuer != "" && !claimHas(claims["iss"], v.req.Issuer) {
+		return errJWTIssuer
+	}
+	if v.req.Audience != "" && !claimHas(claims["aud"], v.req.Audience) {
+		return errJWTAudience
+	}
+	for name, want := range v.req.Claims {
+		if !claimHas(claims[name], want) {
+			return fmt.Errorf("claim %q does not match", name)
+		}
+	}
+	return nil
+}
+
+// claimHas reports whether a string claim equals want or an array claim
+// contains it.
+func claimHas(claim interface{}, want string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == want
+	case []interface{}:
+		return slices.Contains(c, interface{}(want))
+	}
+	return false
+}
+
+func (c *Client) checkJWT(req *IncomingRequest) error {
+	if c.conf().JWTKeys == nil {
+		return errJWTNoKeys
+	}
+	v := jwtValidator{keys: c.conf().JWTKeys, req: c.conf().JWTRequirements}
+	claims, err := v.validate(req.Headers, time.Now())
+	if err != nil {
+		return err
+	}
+	req.Claims = claims
+	return nil
+}
+
+func (c *Client) rejectUnauthorized(req IncomingRequest, err error) {
+	c.logf("Rejected request %s %s: %v", req.Method, req.Path, err)
+	c.reject(req, IncomingResponse{
+		StatusCode: http.StatusUnauthorized,
+		Headers:    map[string]string{"WWW-Authenticate": `Bearer error="invalid_token"`},
+		Body:       []byte("Unauthorized"),
+	}, err.Error())
+}
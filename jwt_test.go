@@ -0,0 +1,115 @@
+package outray
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func TestJWTValidate(t *testing.T) {
+	secret := []byte("s3cret")
+	v := jwtValidator{keys: StaticKey(secret), req: JWTRequirements{
+		Issuer:   "https://auth.example.com",
+		Audience: "api",
+		Claims:   map[string]string{"role": "admin"},
+	}}
+	now := time.Now()
+	bearer := func(claims map[string]interface{}) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + signTestJWT(t, "HS256", "", secret, claims)}
+	}
+	valid := map[string]interface{}{
+		"iss":  "https://auth.example.com",
+		"aud":  []string{"web", "api"},
+		"role": []string{"admin"},
+		"exp":  now.Add(time.Minute).Unix(),
+	}
+
+	if _, err := v.validate(bearer(valid), now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+	if _, err := v.validate(bearer(valid), now.Add(2*time.Minute)); err != errJWTExpired {
+		t.Errorf("Expected expired token, got %v", err)
+	}
+	valid["aud"] = "other"
+	if _, err := v.validate(bearer(valid), now); err != errJWTAudience {
+		t.Errorf("Expected audience mismatch, got %v", err)
+	}
+	if _, err := v.validate(nil, now); err != errJWTMissing {
+		t.Errorf("Expected missing token, got %v", err)
+	}
+	forged := signTestJWT(t, "HS256", "", []byte("wrong"), valid)
+	if _, err := v.validate(map[string]string{"Authorization": "Bearer " + forged}, now); err != errJWTSignature {
+		t.Errorf("Expected bad signature, got %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	enc := base64.RawURLEncoding
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "r1", "n": enc.EncodeToString(rsaKey.N.Bytes()), "e": enc.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "e1", "crv": "P-256", "x": enc.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))), "y": enc.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	}))
+	defer srv.Close()
+
+	var handled int
+	c := NewClient(
+		WithJWTAuth(JWKS(srv.URL), JWTRequirements{}),
+		WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			if req.Claims["sub"] == "alice" {
+				handled++
+			}
+			return IncomingResponse{StatusCode: 200}
+		}),
+	)
+	c.closed = true
+
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Minute).Unix()}
+	for _, token := range []string{
+		signTestJWT(t, "RS256", "r1", rsaKey, claims),
+		signTestJWT(t, "ES256", "e1", ecKey, claims),
+		signTestJWT(t, "RS256", "missing", rsaKey, claims),
+	} {
+		c.dispatchRequest(IncomingRequest{ID: "1", Headers: map[string]string{"Authorization": "Bearer " + token}})
+	}
+	if handled != 2 {
+		t.Errorf("Expected RSA and EC tokens to pass and unknown kid to fail, got %d handled", handled)
+	}
+}
@@ -0,0 +1,115 @@
+package outray
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	MsgTypeHeartbeat    = "heartbeat"
+	MsgTypeHeartbeatAck = "heartbeat_ack"
+)
+
+// CapHeartbeat is advertised in tunnel_opened by servers that answer
+// heartbeat frames. WebSocket pings can be answered by a proxy in front of
+// a server that has stopped serving the tunnel; a heartbeat_ack can only
+// come from the server itself.
+const CapHeartbeat = "heartbeat"
+
+type Heartbeat struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+func WithKeepAlive(interval, timeout time.Duration) Option {
+	return func(c *Client) {
+		if interval > 0 {
+			c.config.KeepAliveInterval = interval
+		}
+		if timeout > 0 {
+			c.config.KeepAliveTimeout = timeout
+		}
+	}
+}
+
+func (c *Client) readTimeout() time.Duration {
+	return c.conf().KeepAliveInterval + c.conf().KeepAliveTimeout
+}
+
+func (c *Client) keepAlive(conn *websocket.Conn, w *frameWriter, done <-chan struct{}) {
+	conn.SetReadDeadline(time.Now().Add(c.readTimeout()))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(c.readTimeout()))
+	})
+	routeControlFrames(conn, w)
+
+	c.spawn(func() {
+		ticker := time.NewTicker(c.conf().KeepAliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := w.writeControl(websocket.PingMessage, []byte{}, time.Now().Add(c.conf().KeepAliveTimeout)); err != nil {
+					c.logf("Ping failed: %v", err)
+					return
+				}
+			}
+		}
+	})
+}
+
+// heartbeat sends a heartbeat frame every KeepAliveInterval once the server
+// advertises CapHeartbeat, and closes conn when one goes unacknowledged for
+// KeepAliveTimeout, so the read loop ends and the client reconnects.
+func (c *Client) heartbeat(conn *websocket.Conn, done <-chan struct{}) {
+	atomic.StoreUint64(&c.heartbeatAck, 0)
+	ticker := time.NewTicker(c.conf().KeepAliveInterval)
+	defer ticker.Stop()
+
+	var seq, pending uint64 // pending is the oldest unacknowledged seq
+	var pendingAt time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		supported := c.hasCapability(CapHeartbeat)
+		c.mu.Unlock()
+		if !supported {
+			continue
+		}
+
+		if pending != 0 && atomic.LoadUint64(&c.heartbeatAck) >= pending {
+			pending = 0
+		}
+		if pending != 0 && time.Since(pendingAt) > c.conf().KeepAliveTimeout {
+			c.logf("Heartbeat %d unacknowledged after %v; reconnecting", pending, c.conf().KeepAliveTimeout)
+			conn.Close()
+			return
+		}
+		seq++
+		if pending == 0 {
+			pending, pendingAt = seq, time.Now()
+		}
+		if err := c.writeJSON(PriorityControl, Heartbeat{Type: MsgTypeHeartbeat, Seq: seq}); err != nil {
+			c.logf("Heartbeat failed: %v", err)
+			return
+		}
+	}
+}
+
+func (c *Client) handleHeartbeatAck(seq uint64) {
+	for {
+		acked := atomic.LoadUint64(&c.heartbeatAck)
+		if seq <= acked || atomic.CompareAndSwapUint64(&c.heartbeatAck, acked, seq) {
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package outray
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitClosed reads from conn until the client closes it, failing if that
+// takes longer than within.
+func waitClosed(t *testing.T, conn *websocket.Conn, within time.Duration) time.Duration {
+	t.Helper()
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(within))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("Expected the client to close the connection within %v", within)
+			}
+			return time.Since(start)
+		}
+	}
+}
+
+func TestKeepAliveMissedPong(t *testing.T) {
+	ts := newTestServer(t)
+	connectTestClient(t, ts, WithKeepAlive(20*time.Millisecond, 50*time.Millisecond))
+	conn := ts.accept(t)
+
+	// A server that never answers pings, as when the link has silently
+	// dropped.
+	conn.SetPingHandler(func(string) error { return nil })
+	if elapsed := waitClosed(t, conn, 2*time.Second); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the connection to last until the timeout, closed after %v", elapsed)
+	}
+}
+
+func TestHeartbeatUnacknowledged(t *testing.T) {
+	ts := newTestServer(t)
+	connectTestClient(t, ts, WithKeepAlive(20*time.Millisecond, 100*time.Millisecond))
+	conn := ts.accept(t)
+	conn.WriteJSON(TunnelOpened{Type: MsgTypeTunnelOpened, URL: "https://a.example", Capabilities: []string{CapHeartbeat}})
+
+	// Pings are still answered, as a proxy would, and some heartbeats are
+	// acknowledged before the server stops.
+	start := time.Now()
+	var acked int
+	for acked < 3 {
+		var hb Heartbeat
+		readFrame(t, conn, &hb)
+		if hb.Type == MsgTypeHeartbeat {
+			conn.WriteJSON(Heartbeat{Type: MsgTypeHeartbeatAck, Seq: hb.Seq})
+			acked++
+		}
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Error("Expected heartbeats every interval")
+	}
+	if elapsed := waitClosed(t, conn, 2*time.Second); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the connection to last until the timeout, closed after %v", elapsed)
+	}
+}
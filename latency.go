@@ -0,0 +1,78 @@
+package outray
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// LatencyProfile tunes TCP streams for the traffic they carry.
+type LatencyProfile int
+
+const (
+	// Balanced is the default: 4 KiB reads, no coalescing, and a 9s
+	// keepalive with a 30s timeout.
+	Balanced LatencyProfile = iota
+	// Interactive suits RDP, VNC and SSH. Every read is sent at once with
+	// Nagle off on the local side, reads are large enough to carry a
+	// screen update in one frame, and a 5s keepalive with a 10s timeout
+	// notices a dead tunnel before the session looks frozen.
+	Interactive
+	// Bulk suits file transfers and backups: reads are coalesced for 2ms
+	// into frames of up to 64 KiB, Nagle stays on, and the keepalive is
+	// relaxed so a busy link isn't mistaken for a dead one.
+	Bulk
+)
+
+type latencySettings struct {
+	bufferSize        int
+	coalesceWindow    time.Duration
+	coalesceMaxBytes  int
+	noDelay           bool
+	keepAliveInterval time.Duration
+	keepAliveTimeout  time.Duration
+}
+
+var latencyProfiles = map[LatencyProfile]latencySettings{
+	Balanced:    {bufferSize: 4096, noDelay: true, keepAliveInterval: 9 * time.Second, keepAliveTimeout: 30 * time.Second},
+	Interactive: {bufferSize: 32 << 10, noDelay: true, keepAliveInterval: 5 * time.Second, keepAliveTimeout: 10 * time.Second},
+	Bulk:        {bufferSize: 64 << 10, coalesceWindow: 2 * time.Millisecond, coalesceMaxBytes: 64 << 10, keepAliveInterval: 15 * time.Second, keepAliveTimeout: 60 * time.Second},
+}
+
+func (p LatencyProfile) String() string {
+	switch p {
+	case Balanced:
+		return "balanced"
+	case Interactive:
+		return "interactive"
+	case Bulk:
+		return "bulk"
+	}
+	return "unknown"
+}
+
+// WithLatencyProfile sets read buffer size, coalescing and keepalive for
+// the profile. Later WithTCPCoalescing and WithKeepAlive options still
+// override the values it sets.
+func WithLatencyProfile(p LatencyProfile) Option {
+	return func(c *Client) {
+		s, ok := latencyProfiles[p]
+		if !ok {
+			c.configErr = fmt.Errorf("unknown latency profile %d", int(p))
+			return
+		}
+		c.config.LatencyProfile = p
+		c.config.CoalesceWindow = s.coalesceWindow
+		c.config.CoalesceMaxBytes = s.coalesceMaxBytes
+		c.config.KeepAliveInterval = s.keepAliveInterval
+		c.config.KeepAliveTimeout = s.keepAliveTimeout
+	}
+}
+
+// tuneTCP sets Nagle on a local connection to suit the profile. Go turns
+// it off by default, so only Bulk changes anything.
+func (c *Client) tuneTCP(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(latencyProfiles[c.conf().LatencyProfile].noDelay)
+	}
+}
@@ -0,0 +1,28 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyProfiles(t *testing.T) {
+	c := NewClient()
+	if c.tcpBufferSize() != 4096 || c.conf().KeepAliveInterval != 9*time.Second {
+		t.Errorf("Expected Balanced defaults, got buffer %d, keepalive %v", c.tcpBufferSize(), c.conf().KeepAliveInterval)
+	}
+
+	c = NewClient(WithTCPCoalescing(time.Millisecond, 1024), WithLatencyProfile(Interactive))
+	if c.conf().CoalesceWindow != 0 || c.tcpBufferSize() != 32<<10 || c.conf().KeepAliveTimeout != 10*time.Second {
+		t.Errorf("Unexpected Interactive settings: %+v", *c.conf())
+	}
+
+	c = NewClient(WithLatencyProfile(Bulk), WithKeepAlive(time.Second, 0))
+	if c.conf().CoalesceWindow != 2*time.Millisecond || c.tcpBufferSize() != 64<<10 ||
+		c.conf().KeepAliveInterval != time.Second || c.conf().KeepAliveTimeout != 60*time.Second {
+		t.Errorf("Unexpected Bulk settings: %+v", *c.conf())
+	}
+
+	if NewClient(WithLatencyProfile(LatencyProfile(9))).configErr == nil {
+		t.Error("Expected an unknown profile to be rejected")
+	}
+}
@@ -0,0 +1,158 @@
+package outray
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const MsgTypeStreamRejected = "stream_rejected"
+
+type RejectPolicy int
+
+const (
+	RejectAndNotify RejectPolicy = iota
+	RejectSilently
+)
+
+type StreamRejected struct {
+	Type         string `json:"type"`
+	Protocol     string `json:"protocol"`
+	ConnectionID string `json:"connectionId,omitempty"`
+	PacketID     string `json:"packetId,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+func WithMaxTCPConnections(n int) Option {
+	return func(c *Client) {
+		c.config.MaxTCPConnections = n
+	}
+}
+
+func WithMaxUDPSessions(n int) Option {
+	return func(c *Client) {
+		c.config.MaxUDPSessions = n
+	}
+}
+
+// WithUDPSessionTimeout sets how long a UDP source may go without a packet
+// before its session ends and stops counting toward WithMaxUDPSessions
+// (default 1m).
+func WithUDPSessionTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		if d > 0 {
+			c.config.UDPSessionTimeout = d
+		}
+	}
+}
+
+func WithRejectPolicy(p RejectPolicy) Option {
+	return func(c *Client) {
+		c.config.RejectPolicy = p
+	}
+}
+
+func (c *Client) acquireTCPSlot() bool {
+	n := atomic.AddInt64(&c.tcpActive, 1)
+	if c.conf().MaxTCPConnections > 0 && n > int64(c.conf().MaxTCPConnections) {
+		atomic.AddInt64(&c.tcpActive, -1)
+		return false
+	}
+	return true
+}
+
+func (c *Client) releaseTCPSlot() {
+	atomic.AddInt64(&c.tcpActive, -1)
+}
+
+// udpSource is one UDP session: a source address the tunnel has seen
+// recently. It lasts until UDPSessionTimeout passes without a packet, or,
+// when a game session holds it, until that session closes.
+type udpSource struct {
+	seen time.Time
+	held int
+}
+
+// acquireUDPSession records a packet from source, starting its session if
+// there is room under MaxUDPSessions. A session started by a packet on a
+// peer link (see peerEpochBit) answers over that link and is not pinned.
+func (c *Client) acquireUDPSession(source string, epoch uint64) bool {
+	c.udpSessionsMu.Lock()
+	defer c.udpSessionsMu.Unlock()
+	now := time.Now()
+	s, ok := c.udpSessions[source]
+	if !ok {
+		if c.conf().MaxUDPSessions > 0 && len(c.udpSessions) >= c.conf().MaxUDPSessions {
+			c.expireUDPSessionsLocked(now)
+		}
+		if c.conf().MaxUDPSessions > 0 && len(c.udpSessions) >= c.conf().MaxUDPSessions {
+			return false
+		}
+		s = &udpSource{}
+		c.udpSessions[source] = s
+		if epoch&peerEpochBit == 0 {
+			c.pinStream(source)
+		}
+	}
+	s.seen = now
+	return true
+}
+
+// holdUDPSession ties source's session to a game session, which applies
+// its own idle timeout.
+func (c *Client) holdUDPSession(source string) {
+	c.udpSessionsMu.Lock()
+	defer c.udpSessionsMu.Unlock()
+	if s, ok := c.udpSessions[source]; ok {
+		s.held++
+	}
+}
+
+// releaseUDPSession ends the session held by a closing game session.
+func (c *Client) releaseUDPSession(source string) {
+	c.udpSessionsMu.Lock()
+	defer c.udpSessionsMu.Unlock()
+	if s, ok := c.udpSessions[source]; ok && s.held > 0 {
+		if s.held--; s.held == 0 {
+			delete(c.udpSessions, source)
+			c.unpinStream(source)
+		}
+	}
+}
+
+// activeUDPSessions expires idle sessions and counts the rest.
+func (c *Client) activeUDPSessions() int {
+	c.udpSessionsMu.Lock()
+	defer c.udpSessionsMu.Unlock()
+	c.expireUDPSessionsLocked(time.Now())
+	return len(c.udpSessions)
+}
+
+func (c *Client) expireUDPSessionsLocked(now time.Time) {
+	for source, s := range c.udpSessions {
+		if s.held == 0 && now.Sub(s.seen) >= c.conf().UDPSessionTimeout {
+			delete(c.udpSessions, source)
+			c.unpinStream(source)
+		}
+	}
+}
+
+func (c *Client) rejectStream(msg StreamRejected) {
+	id := msg.ConnectionID
+	if id == "" {
+		id = msg.PacketID
+	}
+	c.logf("Rejected %s stream %s: %s", msg.Protocol, id, msg.Reason)
+
+	if c.conf().RejectPolicy == RejectSilently {
+		return
+	}
+	msg.Type = MsgTypeStreamRejected
+	if err := c.writeStreamJSON(id, PriorityControl, msg); err != nil {
+		c.logf("Failed to notify server of rejected stream %s: %v", id, err)
+	}
+}
+
+func limitReason(kind string, max int) string {
+	return fmt.Sprintf("%s limit of %d reached", kind, max)
+}
@@ -0,0 +1,112 @@
+package outray
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+)
+
+var errListenerClosed = errors.New("outray: listener closed")
+
+type GRPCServer interface {
+	Serve(net.Listener) error
+	GracefulStop()
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "outray" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+type tunnelConn struct {
+	net.Conn
+	id string
+}
+
+func (tc *tunnelConn) LocalAddr() net.Addr  { return tunnelAddr("tunnel") }
+func (tc *tunnelConn) RemoteAddr() net.Addr { return tunnelAddr(tc.id) }
+
+type tunnelListener struct {
+	c     *Client
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (c *Client) Listen() net.Listener {
+	ln := &tunnelListener{
+		c:     c,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	c.listenerMu.Lock()
+	c.listener = ln
+	c.listenerMu.Unlock()
+	return ln
+}
+
+func (c *Client) ServeGRPC(ctx context.Context, srv GRPCServer) error {
+	if c.conf().Protocol == "http" {
+		c.applyOptions(WithProtocol("tcp"))
+	}
+
+	ln := c.Listen()
+	defer ln.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	err := c.Connect(ctx)
+	srv.GracefulStop()
+	<-errc
+	return err
+}
+
+func (c *Client) activeListener() *tunnelListener {
+	c.listenerMu.Lock()
+	defer c.listenerMu.Unlock()
+	return c.listener
+}
+
+func (ln *tunnelListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ln.conns:
+		return conn, nil
+	case <-ln.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (ln *tunnelListener) Close() error {
+	ln.once.Do(func() {
+		close(ln.done)
+		ln.c.listenerMu.Lock()
+		if ln.c.listener == ln {
+			ln.c.listener = nil
+		}
+		ln.c.listenerMu.Unlock()
+	})
+	return nil
+}
+
+func (ln *tunnelListener) Addr() net.Addr {
+	return tunnelAddr("tunnel")
+}
+
+func (ln *tunnelListener) deliver(connID string) (net.Conn, error) {
+	local, remote := net.Pipe()
+	var conn net.Conn = &tunnelConn{Conn: remote, id: connID}
+	if cfg := ln.c.tlsTermination(); cfg != nil {
+		conn = tls.Server(conn, cfg)
+	}
+	select {
+	case ln.conns <- conn:
+		return local, nil
+	case <-ln.done:
+		local.Close()
+		remote.Close()
+		return nil, errListenerClosed
+	}
+}
@@ -0,0 +1,232 @@
+package outray
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// mdnsService is the DNS-SD service type tunnels are advertised under, so
+// `dns-sd -B _outray._tcp` or `avahi-browse _outray._tcp` lists them.
+const mdnsService = "_outray._tcp.local."
+
+const (
+	mdnsTTL        = 120
+	mdnsTypePTR    = 12
+	mdnsTypeTXT    = 16
+	mdnsTypeSRV    = 33
+	mdnsClassIN    = 1
+	mdnsCacheFlush = 0x8000
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+var errBadDNSName = errors.New("malformed dns name")
+
+// WithMDNS advertises the public URL on the local network via mDNS while the
+// tunnel is open, as a TXT record on an _outray._tcp service named name
+// (the hostname if empty).
+func WithMDNS(name string) Option {
+	return func(c *Client) {
+		c.config.MDNS = true
+		c.config.MDNSName = name
+	}
+}
+
+type mdnsAdvertiser struct {
+	conn     *net.UDPConn
+	instance string
+	host     string
+	port     int
+
+	mu  sync.Mutex
+	txt []string
+}
+
+func (c *Client) advertise(url string) {
+	c.mdnsMu.Lock()
+	defer c.mdnsMu.Unlock()
+	if c.mdns == nil {
+		a, err := newMDNSAdvertiser(c.conf().MDNSName, c.conf().Port)
+		if err != nil {
+			c.logf("mDNS advertisement disabled: %v", err)
+			return
+		}
+		c.mdns = a
+		c.spawn(a.serve)
+	}
+	c.mdns.update([]string{"url=" + url, "protocol=" + c.conf().Protocol})
+}
+
+func (c *Client) stopAdvertising() {
+	c.mdnsMu.Lock()
+	defer c.mdnsMu.Unlock()
+	if c.mdns != nil {
+		c.mdns.close()
+		c.mdns = nil
+	}
+}
+
+func newMDNSAdvertiser(name string, port int) (*mdnsAdvertiser, error) {
+	host, _ := os.Hostname()
+	host = mdnsLabel(strings.Split(host, ".")[0])
+	if host == "" {
+		host = "outray"
+	}
+	if name == "" {
+		name = host
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	return &mdnsAdvertiser{
+		conn:     conn,
+		instance: mdnsLabel(name) + "." + mdnsService,
+		host:     host + ".local.",
+		port:     port,
+	}, nil
+}
+
+// update replaces the TXT record and announces it unsolicited.
+func (a *mdnsAdvertiser) update(txt []string) {
+	a.mu.Lock()
+	a.txt = txt
+	a.mu.Unlock()
+	a.conn.WriteToUDP(a.response(mdnsTTL), mdnsGroup)
+}
+
+func (a *mdnsAdvertiser) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		names, err := mdnsQuestions(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(name, mdnsService) || strings.EqualFold(name, a.instance) {
+				a.conn.WriteToUDP(a.response(mdnsTTL), mdnsGroup)
+				break
+			}
+		}
+	}
+}
+
+// close sends a goodbye (TTL 0) so browsers drop the entry immediately.
+func (a *mdnsAdvertiser) close() {
+	a.conn.WriteToUDP(a.response(0), mdnsGroup)
+	a.conn.Close()
+}
+
+// response builds an mDNS answer with the PTR, SRV and TXT records.
+func (a *mdnsAdvertiser) response(ttl uint32) []byte {
+	a.mu.Lock()
+	txt := a.txt
+	a.mu.Unlock()
+
+	msg := []byte{0, 0, 0x84, 0, 0, 0, 0, 3, 0, 0, 0, 0}
+	msg = appendRecord(msg, mdnsService, mdnsTypePTR, mdnsClassIN, ttl, appendName(nil, a.instance))
+
+	srv := binary.BigEndian.AppendUint16(nil, 0)
+	srv = binary.BigEndian.AppendUint16(srv, 0)
+	srv = binary.BigEndian.AppendUint16(srv, uint16(a.port))
+	srv = appendName(srv, a.host)
+	msg = appendRecord(msg, a.instance, mdnsTypeSRV, mdnsClassIN|mdnsCacheFlush, ttl, srv)
+
+	var rdata []byte
+	for _, s := range txt {
+		rdata = append(rdata, byte(len(s)))
+		rdata = append(rdata, s...)
+	}
+	return appendRecord(msg, a.instance, mdnsTypeTXT, mdnsClassIN|mdnsCacheFlush, ttl, rdata)
+}
+
+func appendRecord(b []byte, name string, typ, class uint16, ttl uint32, rdata []byte) []byte {
+	b = appendName(b, name)
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// mdnsQuestions returns the names asked about in a query. Responses yield
+// no names.
+func mdnsQuestions(msg []byte) ([]string, error) {
+	if len(msg) < 12 {
+		return nil, errBadDNSName
+	}
+	if msg[2]&0x80 != 0 {
+		return nil, nil
+	}
+	var names []string
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		off = next + 4
+	}
+	return names, nil
+}
+
+// readName decodes the possibly compressed name at off and returns it with
+// the offset just past it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errBadDNSName
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errBadDNSName
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errBadDNSName
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+// mdnsLabel makes s usable as a single DNS label.
+func mdnsLabel(s string) string {
+	s = strings.ReplaceAll(s, ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
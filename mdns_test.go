@@ -0,0 +1,44 @@
+package outray
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMDNSQuestions(t *testing.T) {
+	// One question for _outray._tcp.local. and a second that points back
+	// into the first with a compression pointer.
+	query := []byte{0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0}
+	query = appendName(query, mdnsService)
+	query = append(query, 0, mdnsTypePTR, 0, mdnsClassIN)
+	query = append(query, 3, 'd', 'e', 'v', 0xC0, 12, 0, mdnsTypeTXT, 0, mdnsClassIN)
+
+	names, err := mdnsQuestions(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != mdnsService || names[1] != "dev."+mdnsService {
+		t.Errorf("Unexpected questions: %q", names)
+	}
+
+	if _, err := mdnsQuestions(append(query[:12:12], 0xC0)); err == nil {
+		t.Error("Expected truncated name to be rejected")
+	}
+}
+
+func TestMDNSResponse(t *testing.T) {
+	a := &mdnsAdvertiser{instance: "dev." + mdnsService, host: "laptop.local.", port: 8080}
+	a.txt = []string{"url=https://dev.outray.dev"}
+	msg := a.response(mdnsTTL)
+
+	if names, _ := mdnsQuestions(msg); names != nil {
+		t.Error("Expected response not to be treated as a query")
+	}
+	name, _, err := readName(msg, 12)
+	if err != nil || name != mdnsService {
+		t.Errorf("Expected first answer for %s, got %q (%v)", mdnsService, name, err)
+	}
+	if !bytes.Contains(msg, []byte("url=https://dev.outray.dev")) {
+		t.Error("Expected TXT record with the public URL")
+	}
+}
@@ -0,0 +1,102 @@
+package outray
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var benchTCPData = []byte(`{"type":"tcp_data","connectionId":"conn-1","data":"aGVsbG8gd29ybGQ="}`)
+
+var benchRequest = []byte(`{"type":"request","requestId":"req-1","method":"POST","path":"/webhook","headers":{"Content-Type":"application/json"},"body":"eyJvayI6dHJ1ZX0="}`)
+
+func legacyDecode(data []byte) {
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	switch raw["type"] {
+	case MsgTypeTCPData:
+		_, _ = raw["connectionId"].(string)
+		_, _ = raw["data"].(string)
+	case MsgTypeRequest:
+		b, _ := json.Marshal(raw)
+		var req IncomingRequest
+		json.Unmarshal(b, &req)
+	}
+}
+
+func TestHandleMessageRequest(t *testing.T) {
+	var got IncomingRequest
+	c := NewClient(WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		got = req
+		return IncomingResponse{StatusCode: 200}
+	}))
+	c.closed = true
+	c.handleMessage(benchRequest, 0)
+	if got.ID != "req-1" || got.Path != "/webhook" || string(got.Body) != `{"ok":true}` {
+		t.Errorf("Unexpected decoded request: %+v", got)
+	}
+}
+
+func TestHandleMessageServerError(t *testing.T) {
+	var got error
+	c := NewClient(WithOnError(func(err error) { got = err }))
+	c.handleMessage([]byte(`{"type":"error","code":"QUOTA_EXCEEDED","message":"bandwidth quota reached","retryable":false,"details":{"limit":"10GB"}}`), 0)
+
+	var serr *ServerError
+	if !errors.As(got, &serr) {
+		t.Fatalf("Expected *ServerError, got %T", got)
+	}
+	if serr.Code != ErrCodeQuotaExceeded || serr.Retryable || serr.Details["limit"] != "10GB" {
+		t.Errorf("Unexpected server error: %+v", serr)
+	}
+}
+
+func TestHandleMessageWarning(t *testing.T) {
+	var warned Warning
+	var errored bool
+	c := NewClient(
+		WithOnWarning(func(w Warning) { warned = w }),
+		WithOnError(func(err error) { errored = true }),
+	)
+	c.handleMessage([]byte(`{"type":"warning","code":"QUOTA_NEARING","message":"90% of bandwidth used"}`), 0)
+
+	if warned.Code != WarnQuotaNearing || warned.Message != "90% of bandwidth used" {
+		t.Errorf("Unexpected warning: %+v", warned)
+	}
+	if errored {
+		t.Error("Expected warning not to reach OnError")
+	}
+}
+
+func BenchmarkHandleMessageTCPData(b *testing.B) {
+	c := NewClient()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.handleMessage(benchTCPData, 0)
+	}
+}
+
+func BenchmarkLegacyDecodeTCPData(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyDecode(benchTCPData)
+	}
+}
+
+func BenchmarkHandleMessageRequest(b *testing.B) {
+	c := NewClient(WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		return IncomingResponse{StatusCode: 200}
+	}))
+	c.closed = true
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.handleMessage(benchRequest, 0)
+	}
+}
+
+func BenchmarkLegacyDecodeRequest(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyDecode(benchRequest)
+	}
+}
@@ -0,0 +1,81 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// SlackNotifier is an EventSink that posts events to a Slack incoming
+// webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Channel    string // overrides the webhook's default channel if set
+	Client     *http.Client
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, e Event) error {
+	msg := map[string]string{"text": eventText(e, "*", "`")}
+	if s.Channel != "" {
+		msg["channel"] = s.Channel
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.Client, s.WebhookURL, nil, body)
+}
+
+// DiscordNotifier is an EventSink that posts events to a Discord webhook.
+type DiscordNotifier struct {
+	WebhookURL string
+	Username   string // overrides the webhook's default name if set
+	Client     *http.Client
+}
+
+func (d *DiscordNotifier) Notify(ctx context.Context, e Event) error {
+	msg := map[string]string{"content": eventText(e, "**", "`")}
+	if d.Username != "" {
+		msg["username"] = d.Username
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, d.Client, d.WebhookURL, nil, body)
+}
+
+// eventText renders e as a one-line chat message, using bold and code as
+// the markers for the target's markdown flavour.
+func eventText(e Event, bold, code string) string {
+	switch e.Type {
+	case EventTunnelOpened:
+		return fmt.Sprintf("🟢 %sTunnel up%s: %s", bold, bold, e.URL)
+	case EventTunnelClosed:
+		if e.Error != "" {
+			return fmt.Sprintf("🔴 %sTunnel closed%s: %s (%s%s%s)", bold, bold, orUnknown(e.URL), code, e.Error, code)
+		}
+		return fmt.Sprintf("🔴 %sTunnel closed%s: %s", bold, bold, orUnknown(e.URL))
+	case EventDisconnected:
+		return fmt.Sprintf("🟡 %sTunnel disconnected%s, reconnecting: %s%s%s", bold, bold, code, e.Error, code)
+	case EventError:
+		return fmt.Sprintf("⛔ %sTunnel error%s: %s%s%s", bold, bold, code, e.Error, code)
+	case EventWarning:
+		if e.Warning == nil {
+			break
+		}
+		if e.Warning.Code != "" {
+			return fmt.Sprintf("⚠️ %sWarning%s %s%s%s: %s", bold, bold, code, e.Warning.Code, code, e.Warning.Message)
+		}
+		return fmt.Sprintf("⚠️ %sWarning%s: %s", bold, bold, e.Warning.Message)
+	}
+	return fmt.Sprintf("%s%s%s", bold, e.Type, bold)
+}
+
+func orUnknown(url string) string {
+	if url == "" {
+		return "(no URL)"
+	}
+	return url
+}
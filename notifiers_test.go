@@ -0,0 +1,52 @@
+package outray
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatNotifiers(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	opened := Event{Type: EventTunnelOpened, URL: "https://a.outray.dev"}
+	if err := (&SlackNotifier{WebhookURL: srv.URL, Channel: "#dev"}).Notify(t.Context(), opened); err != nil {
+		t.Fatal(err)
+	}
+	if got["text"] != "🟢 *Tunnel up*: https://a.outray.dev" || got["channel"] != "#dev" {
+		t.Errorf("Unexpected Slack message: %v", got)
+	}
+
+	warning := Event{Type: EventWarning, Warning: &Warning{Code: WarnQuotaNearing, Message: "90% of bandwidth used"}}
+	if err := (&DiscordNotifier{WebhookURL: srv.URL}).Notify(t.Context(), warning); err != nil {
+		t.Fatal(err)
+	}
+	if got["content"] != "⚠️ **Warning** `QUOTA_NEARING`: 90% of bandwidth used" {
+		t.Errorf("Unexpected Discord message: %v", got)
+	}
+}
+
+func TestEventText(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{Event{Type: EventTunnelClosed, URL: "https://a.outray.dev"}, "🔴 *Tunnel closed*: https://a.outray.dev"},
+		{Event{Type: EventTunnelClosed, Error: "context canceled"}, "🔴 *Tunnel closed*: (no URL) (`context canceled`)"},
+		{Event{Type: EventDisconnected, Error: "EOF"}, "🟡 *Tunnel disconnected*, reconnecting: `EOF`"},
+		{Event{Type: EventError, Error: "AUTH_EXPIRED: token expired"}, "⛔ *Tunnel error*: `AUTH_EXPIRED: token expired`"},
+		{Event{Type: EventWarning, Warning: &Warning{Message: "maintenance at 02:00"}}, "⚠️ *Warning*: maintenance at 02:00"},
+	}
+	for _, tt := range tests {
+		if got := eventText(tt.e, "*", "`"); got != tt.want {
+			t.Errorf("eventText(%s) = %q, want %q", tt.e.Type, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// notifyTimeout bounds each delivery to an EventSink.
+const notifyTimeout = 10 * time.Second
+
+type EventType string
+
+const (
+	EventTunnelOpened EventType = "tunnel_opened"
+	EventTunnelClosed EventType = "tunnel_closed"
+	EventDisconnected EventType = "disconnected"
+	EventError        EventType = "error"
+	EventWarning      EventType = "warning"
+)
+
+// Event is a tunnel lifecycle or alert event delivered to EventSinks.
+type Event struct {
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	URL     string    `json:"url,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Warning *Warning  `json:"warning,omitempty"`
+}
+
+// EventSink receives events from a client. Notify is called from a
+// background goroutine, one event at a time in the order they happened.
+type EventSink interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// WithEventSink delivers tunnel opens and closes, disconnects, server
+// errors and warnings to s. It may be given more than once.
+func WithEventSink(s EventSink) Option {
+	return func(c *Client) {
+		c.config.EventSinks = append(c.config.EventSinks, s)
+	}
+}
+
+// WithNotifyWebhook POSTs every event as JSON to url, so a team channel or
+// service hears when a shared tunnel goes up or down.
+func WithNotifyWebhook(url string) Option {
+	return WithEventSink(&Webhook{URL: url})
+}
+
+// Webhook is an EventSink that POSTs each Event as JSON.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client // http.DefaultClient if nil
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Headers, body)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClientOr(client).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// eventQueue delivers events in order on a goroutine that only runs while
+// there is something to send, so Wait isn't held up by an idle notifier.
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []Event
+	running bool
+}
+
+func (c *Client) notify(e Event) {
+	if len(c.conf().EventSinks) == 0 {
+		return
+	}
+	e.Time = time.Now()
+
+	q := &c.events
+	q.mu.Lock()
+	q.pending = append(q.pending, e)
+	start := !q.running
+	q.running = true
+	q.mu.Unlock()
+	if start {
+		c.spawn(c.deliverEvents)
+	}
+}
+
+func (c *Client) deliverEvents() {
+	q := &c.events
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		e := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		for _, s := range c.conf().EventSinks {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			if err := s.Notify(ctx, e); err != nil {
+				c.logf("Failed to deliver %s event: %v", e.Type, err)
+			}
+			cancel()
+		}
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,50 @@
+package outray
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotifyWebhook(t *testing.T) {
+	events := make(chan Event, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("Bad webhook body: %v", err)
+		}
+		events <- e
+	}))
+	defer srv.Close()
+
+	c := NewClient(WithNotifyWebhook(srv.URL))
+	c.closed = true
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://a.outray.dev"}`), 0)
+	c.handleMessage([]byte(`{"type":"error","code":"AUTH_EXPIRED","message":"token expired"}`), 0)
+	c.handleMessage([]byte(`{"type":"warning","code":"QUOTA_NEARING","message":"nearly out"}`), 0)
+	c.Wait()
+
+	want := []EventType{EventTunnelOpened, EventError, EventWarning}
+	for i, typ := range want {
+		e := <-events
+		if e.Type != typ {
+			t.Fatalf("Event %d: expected %s, got %s", i, typ, e.Type)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("Event %d has no time", i)
+		}
+	}
+}
+
+func TestNotifyWebhookStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := (&Webhook{URL: srv.URL}).Notify(t.Context(), Event{Type: EventTunnelClosed})
+	if err == nil {
+		t.Error("Expected error for non-2xx webhook response")
+	}
+}
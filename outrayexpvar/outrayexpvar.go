@@ -0,0 +1,20 @@
+// Package outrayexpvar publishes client stats through expvar.
+//
+// It lives apart from outray because importing expvar registers
+// /debug/vars on http.DefaultServeMux, which a tunnel library shouldn't do
+// to every program that uses it:
+//
+//	outrayexpvar.Publish("outray", client)
+package outrayexpvar
+
+import (
+	"expvar"
+
+	outray "github.com/sodiqscript111/outray-go"
+)
+
+// Publish exposes client.Stats() under name in expvar (and so
+// /debug/vars). Like expvar.Publish, it panics if name is already taken.
+func Publish(name string, client *outray.Client) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return client.Stats() }))
+}
@@ -0,0 +1,22 @@
+package outrayexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	outray "github.com/sodiqscript111/outray-go"
+)
+
+func TestPublish(t *testing.T) {
+	Publish("outray_test", outray.NewClient())
+
+	v := expvar.Get("outray_test")
+	if v == nil {
+		t.Fatal("Expected stats to be published")
+	}
+	var stats outray.Stats
+	if err := json.Unmarshal([]byte(v.String()), &stats); err != nil {
+		t.Fatalf("Expected published stats to be JSON: %v", err)
+	}
+}
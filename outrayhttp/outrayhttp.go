@@ -0,0 +1,28 @@
+// Package outrayhttp serves tunneled traffic with Go web frameworks.
+//
+// Gin, Echo, Chi, gorilla/mux and the standard library all expose their
+// routers as http.Handler, so they plug in directly:
+//
+//	engine := gin.Default()
+//	outrayhttp.Serve(ctx, client, engine)
+//
+// Fiber apps can be converted with github.com/gofiber/fiber/v2/middleware/adaptor:
+//
+//	outrayhttp.Serve(ctx, client, adaptor.FiberApp(app))
+package outrayhttp
+
+import (
+	"context"
+	"net/http"
+
+	outray "github.com/sodiqscript111/outray-go"
+)
+
+func Serve(ctx context.Context, client *outray.Client, h http.Handler) error {
+	client.Handle(h)
+	return client.Connect(ctx)
+}
+
+func ServeFunc(ctx context.Context, client *outray.Client, fn http.HandlerFunc) error {
+	return Serve(ctx, client, fn)
+}
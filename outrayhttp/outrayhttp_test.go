@@ -0,0 +1,71 @@
+package outrayhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	outray "github.com/sodiqscript111/outray-go"
+)
+
+func TestServe(t *testing.T) {
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+			conns <- conn
+		}
+	}))
+	defer server.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Item", r.PathValue("id"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created " + r.URL.Query().Get("name")))
+	})
+
+	client := outray.NewClient(outray.WithServerURL("ws" + strings.TrimPrefix(server.URL, "http")))
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, client, mux) }()
+
+	var conn *websocket.Conn
+	select {
+	case conn = <-conns:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client never connected")
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var handshake map[string]interface{}
+	if err := conn.ReadJSON(&handshake); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteJSON(outray.TunnelOpened{Type: outray.MsgTypeTunnelOpened, URL: "https://a.outray.dev"})
+	conn.WriteJSON(map[string]interface{}{
+		"type": outray.MsgTypeRequest, "requestId": "r1", "method": "POST", "path": "/items/42?name=widget",
+	})
+
+	var resp outray.IncomingResponse
+	for resp.Type != outray.MsgTypeResponse {
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("No response: %v", err)
+		}
+	}
+	if resp.ID != "r1" || resp.StatusCode != http.StatusCreated || resp.Headers["X-Item"] != "42" || string(resp.Body) != "created widget" {
+		t.Errorf("Unexpected response %+v (%q)", resp, resp.Body)
+	}
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after its context was cancelled")
+	}
+	client.Wait()
+}
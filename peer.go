@@ -0,0 +1,231 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MsgTypePeerOffer  = "peer_offer"
+	MsgTypePeerAnswer = "peer_answer"
+)
+
+// CapPeerSignaling is advertised in tunnel_opened by servers that relay
+// WebRTC offers from peers that can reach the tunnel directly.
+const CapPeerSignaling = "webrtc_signaling"
+
+// peerEpochBit marks the epochs of peer links, keeping them apart from the
+// server connection's.
+const peerEpochBit = 1 << 63
+
+const peerAnswerTimeout = 30 * time.Second
+
+var errPeerClosed = errors.New("peer data channel closed")
+
+// PeerTransport sets up WebRTC data channels, as offered by a peer through
+// the server's signaling. The SDK has no WebRTC stack of its own; wrap one
+// such as github.com/pion/webrtc. Offers and answers carry their ICE
+// candidates (gathering is complete before they are sent).
+type PeerTransport interface {
+	// Answer accepts an SDP offer and returns the SDP answer with a channel
+	// that becomes usable once the data channel opens.
+	Answer(ctx context.Context, offer string) (answer string, ch PeerChannel, err error)
+}
+
+// PeerChannel is an ordered, reliable data channel carrying one tunnel
+// frame per message.
+type PeerChannel interface {
+	Send(msg []byte) error
+	// Recv blocks for the next message and returns an error once the
+	// channel is closed.
+	Recv() ([]byte, error)
+	Close() error
+}
+
+type PeerOffer struct {
+	Type   string `json:"type"`
+	PeerID string `json:"peerId"`
+	SDP    string `json:"sdp"`
+}
+
+type PeerAnswer struct {
+	Type   string `json:"type"`
+	PeerID string `json:"peerId"`
+	SDP    string `json:"sdp,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// FrameNonce identifies the link in frame signatures, as the handshake
+	// does for a WebSocket connection.
+	FrameNonce string `json:"frameNonce,omitempty"`
+}
+
+// peerFrames are the frame types a peer may send: stream data only, never
+// control messages that belong to the server.
+var peerFrames = map[string]bool{
+	MsgTypeTCPConnection: true,
+	MsgTypeTCPData:       true,
+	MsgTypeTCPHalfClose:  true,
+	MsgTypeTCPClose:      true,
+	MsgTypeUDPData:       true,
+}
+
+type peerLink struct {
+	id     string
+	epoch  uint64
+	ch     PeerChannel
+	mu     sync.Mutex // serializes Send
+	sent   uint64     // frames sent, guarded by mu
+	frames *frameSession
+}
+
+type peerLinks struct {
+	next  uint64
+	mu    sync.Mutex
+	links map[uint64]*peerLink
+}
+
+// WithPeerTransport is experimental. It lets TCP and UDP tunnel traffic
+// flow over WebRTC data channels straight to peers that support it, with
+// the server only relaying the offer and answer. Streams from peers
+// without WebRTC, and everything else, stay on the WebSocket connection.
+func WithPeerTransport(t PeerTransport) Option {
+	return func(c *Client) {
+		c.config.PeerTransport = t
+	}
+}
+
+// handlePeerOffer answers a peer's offer and, once the channel is up,
+// serves the streams the peer opens on it.
+func (c *Client) handlePeerOffer(offer PeerOffer) {
+	if c.conf().PeerTransport == nil {
+		c.answerPeer(PeerAnswer{PeerID: offer.PeerID, Error: "peer transport not enabled"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), peerAnswerTimeout)
+	defer cancel()
+	sdp, ch, err := c.conf().PeerTransport.Answer(ctx, offer.SDP)
+	if err != nil {
+		c.logf("Failed to answer peer %s: %v", offer.PeerID, err)
+		c.answerPeer(PeerAnswer{PeerID: offer.PeerID, Error: err.Error()})
+		return
+	}
+	frames := c.newFrameSession()
+	if err := c.answerPeer(PeerAnswer{PeerID: offer.PeerID, SDP: sdp, FrameNonce: frames.nonce}); err != nil {
+		ch.Close()
+		return
+	}
+	c.addPeerLink(offer.PeerID, ch, frames)
+}
+
+func (c *Client) addPeerLink(id string, ch PeerChannel, frames *frameSession) {
+	link := &peerLink{id: id, epoch: peerEpochBit | atomic.AddUint64(&c.peers.next, 1), ch: ch, frames: frames}
+	c.peers.mu.Lock()
+	if c.peers.links == nil {
+		c.peers.links = make(map[uint64]*peerLink)
+	}
+	c.peers.links[link.epoch] = link
+	c.peers.mu.Unlock()
+	c.logf("Peer %s connected over WebRTC", link.id)
+	c.spawn(func() { c.runPeerLink(link) })
+}
+
+func (c *Client) answerPeer(answer PeerAnswer) error {
+	answer.Type = MsgTypePeerAnswer
+	if err := c.writeJSON(PriorityControl, answer); err != nil {
+		c.logf("Failed to send peer answer for %s: %v", answer.PeerID, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Client) runPeerLink(link *peerLink) {
+	defer c.closePeerLink(link)
+	for {
+		msg, err := link.ch.Recv()
+		if err != nil {
+			c.logf("Peer %s disconnected: %v", link.id, err)
+			return
+		}
+		if !c.peerMayHandle(link, msg) {
+			c.logf("Dropped frame from peer %s", link.id)
+			continue
+		}
+		c.handleFrame(msg, link.epoch, link.frames)
+	}
+}
+
+// peerMayHandle reports whether a peer frame opens a new stream or belongs
+// to one the peer itself opened; streams from the server are off limits.
+func (c *Client) peerMayHandle(link *peerLink, msg []byte) bool {
+	var frame struct {
+		Type         string `json:"type"`
+		ConnectionID string `json:"connectionId"`
+	}
+	if json.Unmarshal(msg, &frame) != nil || !peerFrames[frame.Type] {
+		return false
+	}
+	if frame.Type == MsgTypeUDPData {
+		return true
+	}
+	stream, ok := c.tcpStream(frame.ConnectionID)
+	if frame.Type == MsgTypeTCPConnection {
+		return !ok
+	}
+	return ok && stream.epoch == link.epoch
+}
+
+// closePeerLink drops the link and the streams opened over it.
+func (c *Client) closePeerLink(link *peerLink) {
+	c.peers.mu.Lock()
+	delete(c.peers.links, link.epoch)
+	c.peers.mu.Unlock()
+	link.ch.Close()
+
+	c.tcpConnsMu.Lock()
+	var streams []string
+	for id, stream := range c.tcpConns {
+		if stream.epoch == link.epoch {
+			streams = append(streams, id)
+		}
+	}
+	c.tcpConnsMu.Unlock()
+	for _, id := range streams {
+		c.handleTCPClose(id)
+	}
+}
+
+func (c *Client) closePeerLinks() {
+	c.peers.mu.Lock()
+	links := make([]*peerLink, 0, len(c.peers.links))
+	for _, link := range c.peers.links {
+		links = append(links, link)
+	}
+	c.peers.mu.Unlock()
+	for _, link := range links {
+		link.ch.Close()
+	}
+}
+
+// writePeer sends a frame over the peer link with the given epoch. ok is
+// false if epoch isn't a peer link's.
+func (c *Client) writePeer(epoch uint64, data []byte) (ok bool, err error) {
+	if epoch&peerEpochBit == 0 {
+		return false, nil
+	}
+	c.peers.mu.Lock()
+	link := c.peers.links[epoch]
+	c.peers.mu.Unlock()
+	if link == nil {
+		return true, errStaleConnection
+	}
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	link.sent++
+	if err := link.ch.Send(c.signFrame(data, link.frames.nonce, link.sent)); err != nil {
+		return true, errors.Join(errPeerClosed, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,253 @@
+package outray
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// memPeer is one end of an in-memory data channel.
+type memPeer struct {
+	in, out chan []byte
+	done    chan struct{}
+}
+
+func (p *memPeer) Send(msg []byte) error {
+	select {
+	case p.out <- msg:
+		return nil
+	case <-p.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (p *memPeer) Recv() ([]byte, error) {
+	select {
+	case msg := <-p.in:
+		return msg, nil
+	case <-p.done:
+		return nil, io.EOF
+	}
+}
+
+func (p *memPeer) Close() error {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	return nil
+}
+
+type memTransport struct {
+	peer *memPeer
+	err  error
+}
+
+func (t *memTransport) Answer(ctx context.Context, offer string) (string, PeerChannel, error) {
+	if t.err != nil {
+		return "", nil, t.err
+	}
+	return "answer:" + offer, t.peer, nil
+}
+
+func newMemPeer() *memPeer {
+	return &memPeer{in: make(chan []byte, 16), out: make(chan []byte, 16), done: make(chan struct{})}
+}
+
+func echoBackend(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func peerFrame(t *testing.T, v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPeerOfferAnswered(t *testing.T) {
+	answers := make(chan PeerAnswer, 2)
+	peer := newMemPeer()
+	c := NewClient(
+		WithProtocol("tcp"),
+		WithPeerTransport(&memTransport{peer: peer}),
+		WithMessageTap(func(d Direction, msgType string, payload []byte) {
+			if d == Outbound && msgType == MsgTypePeerAnswer {
+				var a PeerAnswer
+				json.Unmarshal(payload, &a)
+				answers <- a
+			}
+		}),
+	)
+	c.closed = true
+
+	c.handleMessage(peerFrame(t, PeerOffer{Type: MsgTypePeerOffer, PeerID: "p1", SDP: "offer"}), 0)
+	select {
+	case a := <-answers:
+		if a.PeerID != "p1" || a.SDP != "answer:offer" || a.Error != "" {
+			t.Errorf("Unexpected answer: %+v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No answer sent")
+	}
+}
+
+func TestPeerOfferRefused(t *testing.T) {
+	answers := make(chan PeerAnswer, 2)
+	tap := WithMessageTap(func(d Direction, msgType string, payload []byte) {
+		if d == Outbound && msgType == MsgTypePeerAnswer {
+			var a PeerAnswer
+			json.Unmarshal(payload, &a)
+			answers <- a
+		}
+	})
+	for _, c := range []*Client{
+		NewClient(tap),
+		NewClient(tap, WithPeerTransport(&memTransport{err: errors.New("no ice")})),
+	} {
+		c.closed = true
+		c.handlePeerOffer(PeerOffer{PeerID: "p1", SDP: "offer"})
+		if a := <-answers; a.Error == "" || a.SDP != "" {
+			t.Errorf("Expected an error answer, got %+v", a)
+		}
+	}
+}
+
+func TestPeerStream(t *testing.T) {
+	peer := newMemPeer()
+	c := NewClient(
+		WithProtocol("tcp"),
+		WithUpstreamFallback(echoBackend(t)),
+	)
+	c.closed = true
+	c.addPeerLink("p1", peer, c.newFrameSession())
+
+	peer.in <- peerFrame(t, TCPConnection{Type: MsgTypeTCPConnection, ID: "s1"})
+	for range 100 {
+		if _, ok := c.tcpStream("s1"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	peer.in <- peerFrame(t, TCPData{Type: MsgTypeTCPData, ConnectionID: "s1", Data: base64.StdEncoding.EncodeToString([]byte("ping")), Seq: 1})
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-peer.out:
+			var d TCPData
+			json.Unmarshal(msg, &d)
+			if d.Type != MsgTypeTCPData {
+				continue
+			}
+			if got, _ := base64.StdEncoding.DecodeString(d.Data); string(got) != "ping" || d.ConnectionID != "s1" {
+				t.Fatalf("Unexpected reply: %+v", d)
+			}
+		case <-deadline:
+			t.Fatal("No reply over the peer channel")
+		}
+		break
+	}
+
+	peer.Close()
+	for range 100 {
+		if _, ok := c.tcpStream("s1"); !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Stream still open after the peer channel closed")
+}
+
+func TestPeerCannotTouchServerStreams(t *testing.T) {
+	c := NewClient(WithProtocol("tcp"))
+	link := &peerLink{id: "p1", epoch: peerEpochBit | 1}
+	c.tcpConns["s1"] = &tcpStream{epoch: 0}
+
+	for _, frame := range []any{
+		TCPConnection{Type: MsgTypeTCPConnection, ID: "s1"},
+		TCPData{Type: MsgTypeTCPData, ConnectionID: "s1"},
+		TCPClose{Type: MsgTypeTCPClose, ConnectionID: "s1"},
+		Warning{Type: MsgTypeWarning},
+	} {
+		if c.peerMayHandle(link, peerFrame(t, frame)) {
+			t.Errorf("Peer allowed to send %+v", frame)
+		}
+	}
+	if !c.peerMayHandle(link, peerFrame(t, TCPConnection{Type: MsgTypeTCPConnection, ID: "s2"})) {
+		t.Error("Peer refused a new stream")
+	}
+}
+
+func TestPeerStreamsWithPool(t *testing.T) {
+	peer := newMemPeer()
+	c := NewClient(
+		WithProtocol("tcp"),
+		WithUpstreamFallback(echoBackend(t)),
+		WithConnectionPool(4),
+	)
+	c.closed = true
+	c.pool = []*poolConn{{epoch: 1}, {epoch: 1}, {epoch: 1}}
+	c.addPeerLink("p1", peer, c.newFrameSession())
+	defer peer.Close()
+
+	const streams = 8
+	for i := range streams {
+		id := fmt.Sprintf("s%d", i)
+		peer.in <- peerFrame(t, TCPConnection{Type: MsgTypeTCPConnection, ID: id})
+		for range 100 {
+			if _, ok := c.tcpStream(id); ok {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		peer.in <- peerFrame(t, TCPData{Type: MsgTypeTCPData, ConnectionID: id, Data: base64.StdEncoding.EncodeToString([]byte(id)), Seq: 1})
+	}
+
+	echoed := make(map[string]bool)
+	deadline := time.After(2 * time.Second)
+	for len(echoed) < streams {
+		select {
+		case msg := <-peer.out:
+			var d TCPData
+			json.Unmarshal(msg, &d)
+			if got, _ := base64.StdEncoding.DecodeString(d.Data); d.Type == MsgTypeTCPData && string(got) == d.ConnectionID {
+				echoed[d.ConnectionID] = true
+			}
+		case <-deadline:
+			t.Fatalf("Only %d of %d streams answered over the peer link", len(echoed), streams)
+		}
+	}
+
+	c.poolMu.RLock()
+	pinned := len(c.pinned)
+	c.poolMu.RUnlock()
+	if pinned != 0 {
+		t.Errorf("Expected peer streams not to be pinned to the pool, got %d pins", pinned)
+	}
+}
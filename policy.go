@@ -0,0 +1,164 @@
+package outray
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Access is what a policy rule requires of a request.
+type Access string
+
+const (
+	AccessPublic Access = "public"
+	AccessBasic  Access = "basic"
+	AccessJWT    Access = "jwt"
+	AccessDeny   Access = "deny"
+)
+
+func (a Access) known() bool {
+	switch a {
+	case AccessPublic, AccessBasic, AccessJWT, AccessDeny:
+		return true
+	}
+	return false
+}
+
+// PolicyRule applies Access to paths matching Pattern. Patterns use
+// path.Match syntax, and a trailing "/*" also matches the prefix itself and
+// everything below it, so "/admin/*" covers "/admin" and "/admin/users/7".
+type PolicyRule struct {
+	Pattern string
+	Access  Access
+}
+
+// Policy maps request paths to the authentication they need. The first
+// matching rule wins, checked against the decoded and cleaned path so
+// neither "/public/../admin" nor "/%61dmin" can dodge an "/admin/*" rule;
+// Default (public if empty) applies otherwise.
+// AccessBasic checks BasicUsers (user to password); AccessJWT uses the keys
+// and requirements from WithJWTAuth.
+type Policy struct {
+	Rules      []PolicyRule
+	Default    Access
+	BasicUsers map[string]string
+	Realm      string
+}
+
+// WithPolicy enforces p at the tunnel before requests reach the local app.
+// With a policy set, WithJWTAuth only applies where a rule asks for jwt.
+// An Access or pattern the policy doesn't understand is a configuration
+// error, and such a rule denies its requests.
+func WithPolicy(p Policy) Option {
+	return func(c *Client) {
+		if err := p.validate(); err != nil {
+			c.configErr = err
+		}
+		c.config.Policy = &p
+	}
+}
+
+func (p *Policy) validate() error {
+	for i, r := range p.Rules {
+		if !r.Access.known() {
+			return fmt.Errorf("policy rule %d (%s): unknown access %q", i, r.Pattern, r.Access)
+		}
+		if _, err := path.Match(r.Pattern, "/"); err != nil {
+			return fmt.Errorf("policy rule %d: pattern %q: %w", i, r.Pattern, err)
+		}
+	}
+	if p.Default != "" && !p.Default.known() {
+		return fmt.Errorf("policy default: unknown access %q", p.Default)
+	}
+	return nil
+}
+
+// access returns what reqPath requires. Paths that don't decode are an
+// error, as the local app might decode them differently.
+func (p *Policy) access(reqPath string) (Access, error) {
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	reqPath, err := url.PathUnescape(reqPath)
+	if err != nil {
+		return "", err
+	}
+	reqPath = path.Clean("/" + reqPath)
+	for _, r := range p.Rules {
+		if policyMatch(r.Pattern, reqPath) {
+			return r.Access, nil
+		}
+	}
+	if p.Default == "" {
+		return AccessPublic, nil
+	}
+	return p.Default, nil
+}
+
+func policyMatch(pattern, reqPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && (reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/")) {
+		return true
+	}
+	ok, _ := path.Match(pattern, reqPath)
+	return ok
+}
+
+func (p *Policy) checkBasic(headers map[string]string) bool {
+	encoded, ok := strings.CutPrefix(headerValue(headers, "Authorization"), "Basic ")
+	if !ok {
+		return false
+	}
+	creds, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	user, pass, _ := strings.Cut(string(creds), ":")
+	want, ok := p.BasicUsers[user]
+	return ok && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+}
+
+// authorize applies the access policy (or plain WithJWTAuth), responding
+// itself when req is refused.
+func (c *Client) authorize(req *IncomingRequest) bool {
+	access := AccessPublic
+	if c.conf().Policy != nil {
+		var err error
+		if access, err = c.conf().Policy.access(req.Path); err != nil {
+			c.reject(*req, IncomingResponse{StatusCode: http.StatusBadRequest, Body: []byte("Bad Request")}, "undecodable path")
+			return false
+		}
+	} else if c.conf().JWTKeys != nil {
+		access = AccessJWT
+	}
+
+	switch access {
+	case AccessPublic:
+	case AccessDeny:
+		c.reject(*req, IncomingResponse{StatusCode: http.StatusForbidden, Body: []byte("Forbidden")}, "denied by policy")
+		return false
+	case AccessBasic:
+		if !c.conf().Policy.checkBasic(req.Headers) {
+			realm := c.conf().Policy.Realm
+			if realm == "" {
+				realm = "outray"
+			}
+			c.reject(*req, IncomingResponse{
+				StatusCode: http.StatusUnauthorized,
+				Headers:    map[string]string{"WWW-Authenticate": `Basic realm="` + realm + `"`},
+				Body:       []byte("Unauthorized"),
+			}, "basic auth failed")
+			return false
+		}
+	case AccessJWT:
+		if err := c.checkJWT(req); err != nil {
+			c.rejectUnauthorized(*req, err)
+			return false
+		}
+	default:
+		c.reject(*req, IncomingResponse{StatusCode: http.StatusForbidden, Body: []byte("Forbidden")}, "unknown policy access")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package outray
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	var handled []string
+	c := NewClient(
+		WithPolicy(Policy{
+			Rules: []PolicyRule{
+				{Pattern: "/webhooks/*", Access: AccessPublic},
+				{Pattern: "/admin/*", Access: AccessDeny},
+				{Pattern: "/api/*", Access: AccessJWT},
+			},
+			Default:    AccessBasic,
+			BasicUsers: map[string]string{"dev": "pw"},
+		}),
+		WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			handled = append(handled, req.ID)
+			return IncomingResponse{StatusCode: 200}
+		}),
+	)
+	c.closed = true
+
+	basic := map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("dev:pw"))}
+	for _, req := range []IncomingRequest{
+		{ID: "hook", Path: "/webhooks/github?x=1"},
+		{ID: "admin", Path: "/admin/users"},
+		{ID: "sneaky", Path: "/webhooks/../admin/users"},
+		{ID: "encoded", Path: "/%61dmin/secret"},
+		{ID: "encoded-slash", Path: "/webhooks%2F..%2Fadmin"},
+		{ID: "admin-root", Path: "/admin"},
+		{ID: "bad-escape", Path: "/webhooks/%zz"},
+		{ID: "api", Path: "/api/orders"},
+		{ID: "home-anon", Path: "/"},
+		{ID: "home-basic", Path: "/", Headers: basic},
+	} {
+		c.dispatchRequest(req)
+	}
+
+	want := []string{"hook", "home-basic"}
+	if len(handled) != len(want) || handled[0] != want[0] || handled[1] != want[1] {
+		t.Errorf("Expected %v to pass, got %v", want, handled)
+	}
+}
+
+func TestPolicyPaths(t *testing.T) {
+	p := &Policy{Rules: []PolicyRule{{Pattern: "/admin/*", Access: AccessDeny}}}
+	for _, tt := range []struct {
+		path string
+		want Access
+	}{
+		{"/admin", AccessDeny},
+		{"/admin/", AccessDeny},
+		{"/%61dmin/secret", AccessDeny},
+		{"/admin%2fsecret", AccessDeny},
+		{"/administrator", AccessPublic},
+	} {
+		if got, err := p.access(tt.path); err != nil || got != tt.want {
+			t.Errorf("access(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+	if _, err := p.access("/admin%zz"); err == nil {
+		t.Error("Expected an undecodable path to be an error")
+	}
+}
+
+func TestPolicyUnknownAccess(t *testing.T) {
+	for _, p := range []Policy{
+		{Rules: []PolicyRule{{Pattern: "/admin/*", Access: "Deny"}}},
+		{Rules: []PolicyRule{{Pattern: "/public/*", Access: AccessPublic}}, Default: "private"},
+		{Rules: []PolicyRule{{Pattern: "/admin/[", Access: AccessDeny}}},
+	} {
+		var handled bool
+		c := NewClient(WithPolicy(p), WithOnRequest(func(req IncomingRequest) IncomingResponse {
+			handled = true
+			return IncomingResponse{StatusCode: 200}
+		}))
+		c.closed = true
+		if c.configErr == nil {
+			t.Errorf("Expected %+v to be a configuration error", p)
+		}
+		if p.Rules[0].Access.known() && p.Default == "" {
+			continue // a bad pattern matches nothing; the default applies
+		}
+		c.dispatchRequest(IncomingRequest{ID: "r1", Path: "/admin/users"})
+		if handled {
+			t.Errorf("Expected %+v to deny rather than let the request through", p)
+		}
+	}
+}
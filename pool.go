@@ -0,0 +1,301 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const MsgTypeAttachTunnel = "attach_tunnel"
+
+var errClientClosed = errors.New("client is closed")
+
+// errStaleConnection is returned for writes by work that belongs to a
+// connection that has since been replaced by a reconnect.
+var errStaleConnection = errors.New("connection was replaced")
+
+type AttachTunnelRequest struct {
+	Type       string `json:"type"`
+	APIKey     string `json:"apiKey,omitempty"`
+	KeyID      string `json:"keyId,omitempty"`
+	TunnelID   string `json:"tunnelId"`
+	Index      int    `json:"index"`
+	Nonce      string `json:"nonce,omitempty"`
+	Timestamp  int64  `json:"timestamp,omitempty"`
+	FrameNonce string `json:"frameNonce,omitempty"`
+}
+
+type poolConn struct {
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	writer *frameWriter
+	frames *frameSession
+	epoch  uint64
+	closed bool
+}
+
+func WithConnectionPool(n int) Option {
+	return func(c *Client) {
+		c.config.ConnectionPool = n
+	}
+}
+
+func (p *poolConn) writeMessage(prio Priority, data []byte) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errClientClosed
+	}
+	w := p.writer
+	p.mu.Unlock()
+	return w.writeMessage(prio, data)
+}
+
+func (p *poolConn) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.writer.close()
+	return p.conn.Close()
+}
+
+func (c *Client) writeJSON(prio Priority, v interface{}) error {
+	data, err := c.encodeFrame(v)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(prio, data)
+}
+
+func (c *Client) writeMessage(prio Priority, data []byte) error {
+	return c.writeMessageOn(0, prio, data)
+}
+
+// writeMessageOn writes data on the connection numbered epoch, failing with
+// errStaleConnection if it has been replaced. Epoch 0 means whichever
+// connection is current.
+func (c *Client) writeMessageOn(epoch uint64, prio Priority, data []byte) error {
+	defer c.trackWrite(len(data))()
+	c.touch()
+	if ok, err := c.writePeer(epoch, data); ok {
+		return err
+	}
+	c.mu.Lock()
+	if c.closed || c.writer == nil {
+		c.mu.Unlock()
+		return errClientClosed
+	}
+	if epoch != 0 && epoch != c.epoch {
+		c.mu.Unlock()
+		return errStaleConnection
+	}
+	w := c.writer
+	c.mu.Unlock()
+	return w.writeMessage(prio, data)
+}
+
+func (c *Client) writeStreamJSON(streamID string, prio Priority, v interface{}) error {
+	return c.writeStreamJSONOn(0, streamID, prio, v)
+}
+
+// writeStreamJSONOn is writeStreamJSON for a stream opened on connection
+// epoch. A stream pinned to a pool connection that has gone fails with
+// errStaleConnection rather than moving, which could reorder its frames.
+// Streams on a peer link stay on it and never touch the pool.
+func (c *Client) writeStreamJSONOn(epoch uint64, streamID string, prio Priority, v interface{}) error {
+	data, err := c.encodeFrame(v)
+	if err != nil {
+		return err
+	}
+	if epoch&peerEpochBit != 0 {
+		return c.writeMessageOn(epoch, prio, data)
+	}
+	p := c.poolConnFor(streamID)
+	if p == nil {
+		return c.writeMessageOn(epoch, prio, data)
+	}
+	if epoch != 0 && p.epoch != epoch {
+		return errStaleConnection
+	}
+	c.touch()
+	defer c.trackWrite(len(data))()
+	if err := p.writeMessage(prio, data); errors.Is(err, errClientClosed) {
+		return errStaleConnection
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// poolConnFor returns the pool connection for streamID's frames, or nil for
+// the main connection.
+func (c *Client) poolConnFor(streamID string) *poolConn {
+	c.poolMu.RLock()
+	defer c.poolMu.RUnlock()
+	if p, ok := c.pinned[streamID]; ok {
+		return p
+	}
+	return c.pickPoolConnLocked(streamID)
+}
+
+func (c *Client) pickPoolConnLocked(streamID string) *poolConn {
+	if len(c.pool) == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(streamID))
+	idx := int(h.Sum32() % uint32(len(c.pool)+1))
+	if idx == 0 {
+		return nil
+	}
+	return c.pool[idx-1]
+}
+
+// pinStream keeps streamID's frames on the connection it picks now until
+// unpinStream, so they stay in order as pool connections come and go.
+// Streams that send more than one frame pin themselves when they open;
+// single frames are spread over the pool without one.
+func (c *Client) pinStream(streamID string) {
+	if c.conf().ConnectionPool <= 1 {
+		return
+	}
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	if _, ok := c.pinned[streamID]; ok {
+		return
+	}
+	if c.pinned == nil {
+		c.pinned = make(map[string]*poolConn)
+	}
+	c.pinned[streamID] = c.pickPoolConnLocked(streamID)
+}
+
+func (c *Client) unpinStream(streamID string) {
+	if c.conf().ConnectionPool <= 1 {
+		return
+	}
+	c.poolMu.Lock()
+	delete(c.pinned, streamID)
+	c.poolMu.Unlock()
+}
+
+func (c *Client) openPool(tunnelID string, epoch uint64) {
+	if tunnelID == "" {
+		c.logf("Server did not return a tunnel ID; connection pool disabled")
+		return
+	}
+
+	for i := 1; i < c.conf().ConnectionPool; i++ {
+		p, err := c.attach(tunnelID, i)
+		if err != nil {
+			c.logf("Failed to attach pool connection %d: %v", i, err)
+			continue
+		}
+		p.epoch = epoch
+
+		// c.mu is taken before c.poolMu, as in Close, and held until the
+		// connection is in the pool so Close can't miss it.
+		c.mu.Lock()
+		if c.closed || c.epoch != epoch {
+			c.mu.Unlock()
+			p.close()
+			return
+		}
+		c.poolMu.Lock()
+		c.pool = append(c.pool, p)
+		c.poolMu.Unlock()
+		c.mu.Unlock()
+
+		c.spawn(func() { c.runPoolConn(p) })
+	}
+}
+
+func (c *Client) attach(tunnelID string, index int) (*poolConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := c.newFrameSession()
+	p := &poolConn{conn: conn, writer: c.newWriter(conn, frames), frames: frames}
+	c.spawn(p.writer.run)
+	data, err := c.encodeFrame(c.attachFrame(tunnelID, index, frames.nonce))
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	if err := p.writeMessage(PriorityControl, data); err != nil {
+		p.close()
+		return nil, fmt.Errorf("failed to send attach: %w", err)
+	}
+	return p, nil
+}
+
+// attachFrame is the attach_tunnel request, proven the same way as the
+// handshake under ProofHMAC.
+func (c *Client) attachFrame(tunnelID string, index int, frameNonce string) interface{} {
+	req := AttachTunnelRequest{
+		Type:       MsgTypeAttachTunnel,
+		APIKey:     c.conf().APIKey,
+		TunnelID:   tunnelID,
+		Index:      index,
+		FrameNonce: frameNonce,
+	}
+	if c.conf().HandshakeProof != ProofHMAC {
+		return req
+	}
+	req.APIKey = ""
+	req.KeyID = apiKeyID(c.conf().APIKey)
+	req.Nonce, req.Timestamp = c.handshakeNonce()
+	fields := frameFields(req)
+	c.proveFields(fields)
+	return fields
+}
+
+func (c *Client) runPoolConn(p *poolConn) {
+	done := make(chan struct{})
+	defer close(done)
+
+	c.keepAlive(p.conn, p.writer, done)
+
+	if err := c.readLoop(p.conn, p.epoch, p.frames); err != nil {
+		c.logf("Pool connection closed: %v", err)
+	}
+	c.removePoolConn(p)
+	p.close()
+}
+
+func (c *Client) removePoolConn(p *poolConn) {
+	c.poolMu.Lock()
+	defer c.poolMu.Unlock()
+	for i, pc := range c.pool {
+		if pc == p {
+			c.pool = append(c.pool[:i], c.pool[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Client) closePool() {
+	c.poolMu.Lock()
+	pool := c.pool
+	c.pool = nil
+	c.pinned = nil
+	c.poolMu.Unlock()
+
+	for _, p := range pool {
+		p.close()
+	}
+}
@@ -0,0 +1,153 @@
+package outray
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// drainTestServer accepts every connection and closes them at cleanup.
+func drainTestServer(t *testing.T) *testServer {
+	ts := newTestServer(t)
+	var conns []*websocket.Conn
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case conn := <-ts.conns:
+				conns = append(conns, conn)
+			case <-stop:
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	return ts
+}
+
+func TestPoolCloseDuringAttach(t *testing.T) {
+	ts := drainTestServer(t)
+	for i := range 20 {
+		c := NewClient(WithServerURL(ts.URL()), WithConnectionPool(4))
+		c.mu.Lock()
+		c.epoch = 1
+		c.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			c.openPool("tunnel-1", 1)
+			close(done)
+		}()
+		time.Sleep(time.Duration(i%5) * time.Millisecond)
+		c.Close()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("openPool and Close deadlocked")
+		}
+		c.poolMu.RLock()
+		n := len(c.pool)
+		c.poolMu.RUnlock()
+		if n != 0 {
+			t.Fatalf("Expected Close to leave no pool connections, got %d", n)
+		}
+		c.Wait()
+	}
+}
+
+func TestPoolPinnedStreams(t *testing.T) {
+	c := NewClient(WithConnectionPool(4))
+	c.pool = []*poolConn{{epoch: 1}}
+
+	pins := make(map[string]*poolConn)
+	for i := range 50 {
+		id := fmt.Sprintf("stream-%d", i)
+		c.pinStream(id)
+		pins[id] = c.poolConnFor(id)
+	}
+	c.pool = append(c.pool, &poolConn{epoch: 1}, &poolConn{epoch: 1})
+	for id, p := range pins {
+		if c.poolConnFor(id) != p {
+			t.Fatalf("Expected %s to keep its connection as the pool grew", id)
+		}
+	}
+
+	for id := range pins {
+		c.unpinStream(id)
+	}
+	if len(c.pinned) != 0 {
+		t.Errorf("Expected unpinned streams to be forgotten, got %d", len(c.pinned))
+	}
+}
+
+func TestPoolPinnedConnectionGone(t *testing.T) {
+	c := NewClient(WithConnectionPool(2))
+	gone := &poolConn{epoch: 1, closed: true}
+	c.pool = []*poolConn{gone}
+	c.epoch = 1
+
+	var id string
+	for i := 0; c.poolConnFor(id) != gone; i++ {
+		id = fmt.Sprintf("stream-%d", i)
+	}
+	c.pinStream(id)
+	// The main connection would fail with errClientClosed, as it has no
+	// writer; the stream must not be moved there.
+	err := c.writeStreamJSONOn(1, id, PriorityTCP, TCPData{Type: MsgTypeTCPData, ConnectionID: id})
+	if !errors.Is(err, errStaleConnection) {
+		t.Errorf("Expected errStaleConnection for a stream whose connection is gone, got %v", err)
+	}
+}
+
+func TestPoolClosedOnReconnect(t *testing.T) {
+	ts := newTestServer(t)
+	connectTestClient(t, ts, WithConnectionPool(2))
+	main := ts.accept(t)
+	main.WriteJSON(TunnelOpened{Type: MsgTypeTunnelOpened, URL: "https://a.example", TunnelID: "tunnel-1"})
+	pooled := ts.accept(t)
+
+	main.Close()
+	pooled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := pooled.ReadMessage(); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatal("Expected the pool connection to close with the main connection")
+			}
+			break
+		}
+	}
+}
+
+func TestPoolPeerEpoch(t *testing.T) {
+	c := NewClient(WithConnectionPool(4))
+	c.closed = true
+	c.pool = []*poolConn{{epoch: 1}, {epoch: 1}, {epoch: 1}}
+	peer := newMemPeer()
+	c.addPeerLink("p1", peer, c.newFrameSession())
+	defer peer.Close()
+
+	// Peer streams hash into the pool like any other, but their frames
+	// belong on the peer link whatever epoch the pool is on.
+	for i := range 8 {
+		id := fmt.Sprintf("stream-%d", i)
+		if err := c.writeStreamJSONOn(peerEpochBit|1, id, PriorityTCP, TCPData{Type: MsgTypeTCPData, ConnectionID: id}); err != nil {
+			t.Errorf("Write for %s failed: %v", id, err)
+		}
+	}
+	if n := len(peer.out); n != 8 {
+		t.Errorf("Expected every frame on the peer link, got %d of 8", n)
+	}
+}
@@ -0,0 +1,250 @@
+package outray
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// Postgres startup codes, in place of a protocol version.
+const (
+	pgProtocol3     = 196608
+	pgCancelRequest = 80877102
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+)
+
+const (
+	pgMaxStartup     = 10000 // the server's own limit
+	pgStartupTimeout = 30 * time.Second
+)
+
+var errPGStartup = errors.New("malformed postgres startup message")
+
+// PostgresOptions turns on Postgres mode for a TCP tunnel.
+type PostgresOptions struct {
+	// AllowDatabases rejects sessions for any other database. Encrypted
+	// sessions can't be checked, so when set (and TLS isn't terminated by
+	// the client) SSL requests are declined and clients must connect with
+	// sslmode=prefer or weaker.
+	AllowDatabases []string
+
+	// RequireTLS rejects plaintext sessions with a clear error instead of
+	// letting credentials cross the tunnel unencrypted.
+	RequireTLS bool
+
+	// OnStartup is called for each session once its startup message is
+	// read, rejected ones included, or for sessions encrypted end to end
+	// when TLS starts.
+	OnStartup func(s PostgresStartup)
+}
+
+// PostgresStartup describes a Postgres session opening through the tunnel.
+type PostgresStartup struct {
+	ConnectionID string
+	User         string // empty when the session is encrypted end to end
+	Database     string
+	Application  string
+	TLS          bool
+	Rejected     string // why the session was turned away, if it was
+}
+
+// WithPostgres reads the Postgres startup message on each TCP stream to
+// log who connects to which database, enforce AllowDatabases, and turn
+// away sessions that don't meet RequireTLS with a proper ErrorResponse.
+// With WithTLSTermination the client answers SSL requests itself, so a
+// local server without TLS can still serve sslmode=require clients.
+func WithPostgres(opts PostgresOptions) Option {
+	return func(c *Client) {
+		c.config.Postgres = &opts
+	}
+}
+
+// postgresStream returns the tunnel side of a stream whose startup is
+// handled here before it is relayed to the local server.
+func (c *Client) postgresStream(msg TCPConnection, epoch uint64) net.Conn {
+	tunnelSide, inner := net.Pipe()
+	c.spawn(func() {
+		defer inner.Close()
+		inner.SetReadDeadline(time.Now().Add(pgStartupTimeout))
+		client, first, startup, err := c.pgHandshake(msg.ID, inner)
+		if err != nil {
+			c.logf("Postgres stream %s: %v", msg.ID, err)
+			return
+		}
+		inner.SetReadDeadline(time.Time{})
+
+		if startup != nil {
+			startup.Rejected = c.pgReject(startup)
+			c.reportPGStartup(startup)
+			if startup.Rejected != "" {
+				c.logf("Rejected Postgres session for user %q to database %q: %s", startup.User, startup.Database, startup.Rejected)
+				pgError(client, "28000", startup.Rejected)
+				return
+			}
+			c.logf("Postgres session for user %q to database %q", startup.User, startup.Database)
+		}
+
+		upstream, err := c.dialTCP(msg.RemoteAddr, TLSInfo{})
+		if err != nil {
+			if startup != nil {
+				pgError(client, "08006", "local Postgres server unavailable")
+			}
+			c.reportTCPError(epoch, msg.ID, StreamErrDial, err)
+			c.handleTCPClose(msg.ID)
+			return
+		}
+		defer upstream.Close()
+		if _, err := upstream.Write(first); err != nil {
+			return
+		}
+		relay(client, upstream)
+	})
+	return tunnelSide
+}
+
+// pgHandshake reads the client's opening messages. It returns the conn to
+// relay (a TLS conn if the client terminated TLS), the bytes to send to
+// the local server first, and the parsed startup. startup is nil for
+// cancel requests and for sessions encrypted end to end, which are passed
+// through untouched.
+func (c *Client) pgHandshake(connID string, conn net.Conn) (net.Conn, []byte, *PostgresStartup, error) {
+	pg := c.conf().Postgres
+	encrypted := false
+	for {
+		raw, code, err := readPGStartup(conn)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if encrypted {
+				return nil, nil, nil, errPGStartup
+			}
+			if cfg := c.tlsTermination(); code == pgSSLRequest && cfg != nil {
+				if _, err := conn.Write([]byte{'S'}); err != nil {
+					return nil, nil, nil, err
+				}
+				tc := tls.Server(conn, cfg)
+				if err := tc.Handshake(); err != nil {
+					return nil, nil, nil, fmt.Errorf("TLS handshake: %w", err)
+				}
+				conn, encrypted = tc, true
+				continue
+			}
+			if len(pg.AllowDatabases) > 0 || code == pgGSSENCRequest {
+				// Decline so the client retries in plaintext we can read.
+				if _, err := conn.Write([]byte{'N'}); err != nil {
+					return nil, nil, nil, err
+				}
+				continue
+			}
+			c.reportPGStartup(&PostgresStartup{ConnectionID: connID, TLS: true})
+			return conn, raw, nil, nil
+		case pgCancelRequest:
+			return conn, raw, nil, nil
+		case pgProtocol3:
+			startup, err := parsePGStartup(raw)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			startup.ConnectionID, startup.TLS = connID, encrypted
+			return conn, raw, startup, nil
+		default:
+			return nil, nil, nil, fmt.Errorf("unsupported postgres protocol %d.%d", code>>16, code&0xffff)
+		}
+	}
+}
+
+func (c *Client) pgReject(s *PostgresStartup) string {
+	pg := c.conf().Postgres
+	if pg.RequireTLS && !s.TLS {
+		return "this tunnel requires TLS; connect with sslmode=require"
+	}
+	if len(pg.AllowDatabases) > 0 && !slices.Contains(pg.AllowDatabases, s.Database) {
+		return fmt.Sprintf("database %q is not allowed through this tunnel", s.Database)
+	}
+	return ""
+}
+
+func (c *Client) reportPGStartup(s *PostgresStartup) {
+	if s != nil && c.conf().Postgres.OnStartup != nil {
+		c.safeCallback(func() { c.conf().Postgres.OnStartup(*s) })
+	}
+}
+
+// readPGStartup reads one length-prefixed startup-phase message and
+// returns it whole along with its code.
+func readPGStartup(r io.Reader) ([]byte, uint32, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	n := binary.BigEndian.Uint32(header[:4])
+	if n < 8 || n > pgMaxStartup {
+		return nil, 0, errPGStartup
+	}
+	raw := make([]byte, n)
+	copy(raw, header[:])
+	if _, err := io.ReadFull(r, raw[8:]); err != nil {
+		return nil, 0, err
+	}
+	return raw, binary.BigEndian.Uint32(header[4:]), nil
+}
+
+// parsePGStartup reads the name/value parameters of a protocol 3.0
+// StartupMessage.
+func parsePGStartup(raw []byte) (*PostgresStartup, error) {
+	params := map[string]string{}
+	rest := raw[8:]
+	for len(rest) > 0 && rest[0] != 0 {
+		name, after, ok := cutNul(rest)
+		if !ok {
+			return nil, errPGStartup
+		}
+		value, after, ok := cutNul(after)
+		if !ok {
+			return nil, errPGStartup
+		}
+		params[name] = value
+		rest = after
+	}
+	if params["user"] == "" {
+		return nil, errPGStartup
+	}
+	s := &PostgresStartup{User: params["user"], Database: params["database"], Application: params["application_name"]}
+	if s.Database == "" {
+		s.Database = s.User
+	}
+	return s, nil
+}
+
+func cutNul(b []byte) (string, []byte, bool) {
+	i := slices.Index(b, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(b[:i]), b[i+1:], true
+}
+
+// pgError sends a FATAL ErrorResponse, which clients show verbatim.
+func pgError(w io.Writer, code, message string) {
+	var body []byte
+	for _, f := range []struct {
+		tag   byte
+		value string
+	}{{'S', "FATAL"}, {'V', "FATAL"}, {'C', code}, {'M', message}} {
+		body = append(body, f.tag)
+		body = append(body, f.value...)
+		body = append(body, 0)
+	}
+	body = append(body, 0)
+	msg := []byte{'E'}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(body)+4))
+	w.Write(append(msg, body...))
+}
@@ -0,0 +1,152 @@
+package outray
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func pgStartupMessage(params ...string) []byte {
+	body := binary.BigEndian.AppendUint32(nil, pgProtocol3)
+	for _, p := range params {
+		body = append(append(body, p...), 0)
+	}
+	body = append(body, 0)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...)
+}
+
+func pgRequest(code uint32) []byte {
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), code)
+}
+
+// pgBackend records what the first connection sends before "OK".
+func pgBackend(t *testing.T, n int) (net.Listener, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, n)
+		io.ReadFull(conn, buf)
+		got <- buf
+		conn.Write([]byte("OK"))
+	}()
+	return ln, got
+}
+
+func TestPostgresAllowDatabases(t *testing.T) {
+	startup := pgStartupMessage("user", "alice", "database", "app", "application_name", "psql")
+	backend, got := pgBackend(t, len(startup))
+
+	var sessions []PostgresStartup
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithUpstreamFallback(backend.Addr().String()),
+		WithPostgres(PostgresOptions{AllowDatabases: []string{"app"}, OnStartup: func(s PostgresStartup) { sessions = append(sessions, s) }}),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	// SSL is declined so the startup can be checked, then passed on intact.
+	conn := relay.open(c, "ok")
+	conn.Write(pgRequest(pgSSLRequest))
+	reply := make([]byte, 1)
+	if io.ReadFull(conn, reply); reply[0] != 'N' {
+		t.Fatalf("Expected SSL request to be declined, got %q", reply)
+	}
+	conn.Write(startup)
+	if b := <-got; !bytes.Equal(b, startup) {
+		t.Errorf("Backend got %q, want the startup message", b)
+	}
+	if b, _ := io.ReadAll(conn); string(b) != "OK" {
+		t.Errorf("Expected backend reply, got %q", b)
+	}
+	conn.Close()
+
+	conn = relay.open(c, "denied")
+	conn.Write(pgStartupMessage("user", "mallory", "database", "postgres"))
+	b, _ := io.ReadAll(conn)
+	if len(b) == 0 || b[0] != 'E' || !bytes.Contains(b, []byte("C28000\x00")) || !bytes.Contains(b, []byte(`database "postgres" is not allowed`)) {
+		t.Errorf("Expected FATAL ErrorResponse, got %q", b)
+	}
+	conn.Close()
+	c.Wait()
+
+	if len(sessions) != 2 || sessions[0].User != "alice" || sessions[0].Application != "psql" || sessions[0].Rejected != "" ||
+		sessions[1].Database != "postgres" || sessions[1].Rejected == "" {
+		t.Errorf("Unexpected sessions: %+v", sessions)
+	}
+}
+
+func TestPostgresRequireTLS(t *testing.T) {
+	relay := &tunnelRelay{}
+	c := NewClient(WithPort(1), WithPostgres(PostgresOptions{RequireTLS: true}), WithMessageTap(relay.tap))
+	c.closed = true
+
+	conn := relay.open(c, "plain")
+	defer conn.Close()
+	conn.Write(pgStartupMessage("user", "alice"))
+	b, _ := io.ReadAll(conn)
+	if !strings.Contains(string(b), "sslmode=require") {
+		t.Errorf("Expected TLS-required error, got %q", b)
+	}
+}
+
+func TestPostgresTLSTermination(t *testing.T) {
+	startup := pgStartupMessage("user", "alice", "database", "app")
+	backend, got := pgBackend(t, len(startup))
+	cert, pool := testCertificate(t, "db.outray.dev")
+
+	var session PostgresStartup
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithUpstreamFallback(backend.Addr().String()),
+		WithTLSTermination(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		WithPostgres(PostgresOptions{RequireTLS: true, OnStartup: func(s PostgresStartup) { session = s }}),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	raw := relay.open(c, "conn-1")
+	defer raw.Close()
+	raw.Write(pgRequest(pgSSLRequest))
+	reply := make([]byte, 1)
+	if io.ReadFull(raw, reply); reply[0] != 'S' {
+		t.Fatalf("Expected the client to accept SSL, got %q", reply)
+	}
+	conn := tls.Client(raw, &tls.Config{ServerName: "db.outray.dev", RootCAs: pool})
+	conn.Write(startup)
+	if b := <-got; !bytes.Equal(b, startup) {
+		t.Errorf("Expected plaintext startup at the backend, got %q", b)
+	}
+	if b, _ := io.ReadAll(conn); string(b) != "OK" {
+		t.Errorf("Expected backend reply over TLS, got %q", b)
+	}
+	if !session.TLS || session.User != "alice" {
+		t.Errorf("Unexpected session: %+v", session)
+	}
+}
+
+func TestParsePGStartup(t *testing.T) {
+	s, err := parsePGStartup(pgStartupMessage("user", "bob"))
+	if err != nil || s.Database != "bob" {
+		t.Errorf("Expected database to default to the user, got %+v, %v", s, err)
+	}
+	if _, err := parsePGStartup(pgStartupMessage("database", "app")); err == nil {
+		t.Error("Expected a startup without a user to be rejected")
+	}
+	if _, _, err := readPGStartup(bytes.NewReader([]byte{0, 1, 0, 0, 0, 3, 0, 0})); err != errPGStartup {
+		t.Errorf("Expected oversized startup to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package outray
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// EnvProfile selects a profile when WithProfile is given no name.
+	EnvProfile = "OUTRAY_PROFILE"
+	// EnvConfig overrides the location of the profiles file.
+	EnvConfig = "OUTRAY_CONFIG"
+)
+
+// Profile is a named set of defaults from the profiles file. Zero fields are
+// left to other options.
+type Profile struct {
+	APIKey       string `json:"apiKey,omitempty"`
+	ServerURL    string `json:"serverUrl,omitempty"`
+	Protocol     string `json:"protocol,omitempty"`
+	Port         int    `json:"port,omitempty"`
+	RemotePort   int    `json:"remotePort,omitempty"`
+	Subdomain    string `json:"subdomain,omitempty"`
+	CustomDomain string `json:"customDomain,omitempty"`
+
+	// Tunnels registers a handler per protocol, as Client.HTTP, TCP and
+	// UDP do.
+	Tunnels []ProfileTunnel `json:"tunnels,omitempty"`
+}
+
+type ProfileTunnel struct {
+	Protocol     string `json:"protocol"`
+	Port         int    `json:"port"`
+	RemotePort   int    `json:"remotePort,omitempty"`
+	Subdomain    string `json:"subdomain,omitempty"`
+	CustomDomain string `json:"customDomain,omitempty"`
+}
+
+type profilesFile struct {
+	Default  string             `json:"default,omitempty"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// WithProfile applies a profile from the profiles file. An empty name falls
+// back to $OUTRAY_PROFILE and then the file's "default"; if none is set the
+// option does nothing. Options after it override the profile's values. A
+// missing or unreadable profile makes Connect fail.
+func WithProfile(name string) Option {
+	return func(c *Client) {
+		p, err := LoadProfile(name)
+		if err != nil {
+			c.configErr = err
+			return
+		}
+		p.apply(c)
+	}
+}
+
+// ProfilesPath returns where profiles are read from: $OUTRAY_CONFIG, or
+// outray/config.json under the user config directory.
+func ProfilesPath() (string, error) {
+	if path := os.Getenv(EnvConfig); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "outray", "config.json"), nil
+}
+
+// LoadProfile reads the named profile, resolving an empty name as
+// WithProfile does.
+func LoadProfile(name string) (Profile, error) {
+	if name == "" {
+		name = os.Getenv(EnvProfile)
+	}
+	path, err := ProfilesPath()
+	if err != nil {
+		return Profile{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && name == "" {
+		return Profile{}, nil
+	}
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+
+	if err := ValidateConfig(data); err != nil {
+		return Profile{}, fmt.Errorf("profiles file %s: %w", path, err)
+	}
+	var f profilesFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Profile{}, fmt.Errorf("profiles file %s: %w", path, err)
+	}
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+	p, ok := f.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	if p.APIKey, err = OpenSecret(p.APIKey, os.Getenv(EnvPassphrase)); err != nil {
+		return Profile{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+	return p, nil
+}
+
+func (p Profile) apply(c *Client) {
+	if p.APIKey != "" {
+		c.config.APIKey = p.APIKey
+	}
+	if p.ServerURL != "" {
+		c.config.ServerURL = p.ServerURL
+	}
+	if p.Protocol != "" {
+		c.config.Protocol = p.Protocol
+	}
+	if p.Port != 0 {
+		c.config.Port = p.Port
+	}
+	if p.RemotePort != 0 {
+		c.config.RemotePort = p.RemotePort
+	}
+	if p.Subdomain != "" {
+		c.config.Subdomain = p.Subdomain
+	}
+	if p.CustomDomain != "" {
+		c.config.CustomDomain = p.CustomDomain
+	}
+	for _, t := range p.Tunnels {
+		c.register(t.Protocol, t.Port, []TunnelOption{
+			WithTunnelRemotePort(t.RemotePort),
+			WithTunnelSubdomain(t.Subdomain),
+			WithTunnelCustomDomain(t.CustomDomain),
+		})
+	}
+}
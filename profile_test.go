@@ -0,0 +1,40 @@
+package outray
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"default": "personal",
+		"profiles": {
+			"personal": {"apiKey": "pk", "subdomain": "me"},
+			"work": {"apiKey": "wk", "serverUrl": "wss://tunnels.example.com", "port": 3000}
+		}
+	}`), 0o600)
+	t.Setenv(EnvConfig, path)
+	t.Setenv(EnvProfile, "")
+
+	c := NewClient(WithProfile("work"), WithPort(4000))
+	if c.conf().APIKey != "wk" || c.conf().ServerURL != "wss://tunnels.example.com" || c.conf().Port != 4000 {
+		t.Errorf("Expected work profile with port overridden, got %+v", *c.conf())
+	}
+
+	if c := NewClient(WithProfile("")); c.conf().APIKey != "pk" {
+		t.Errorf("Expected default profile, got key %q", c.conf().APIKey)
+	}
+
+	t.Setenv(EnvProfile, "work")
+	if c := NewClient(WithProfile("")); c.conf().APIKey != "wk" {
+		t.Errorf("Expected OUTRAY_PROFILE to select work, got key %q", c.conf().APIKey)
+	}
+
+	c = NewClient(WithProfile("staging"))
+	if err := c.Connect(context.Background()); err == nil {
+		t.Error("Expected Connect to fail for an unknown profile")
+	}
+}
@@ -0,0 +1,105 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// WithProxyProtocol prepends an HAProxy PROXY protocol header (version 1
+// or 2) to each local TCP connection, so backends that understand it see
+// the public client's address instead of the client's loopback one. The
+// source comes from the server's tcp_connection frame; when it is missing
+// a v1 UNKNOWN or v2 LOCAL header is sent.
+func WithProxyProtocol(version int) Option {
+	return func(c *Client) {
+		if version != 1 && version != 2 {
+			c.configErr = fmt.Errorf("proxy protocol version must be 1 or 2, got %d", version)
+			return
+		}
+		c.config.ProxyProtocol = version
+	}
+}
+
+// dialTCP dials the upstream for a new stream from remoteAddr, choosing
+// the port by info's TLS routes and sending a PROXY header if configured.
+func (c *Client) dialTCP(remoteAddr string, info TLSInfo) (net.Conn, error) {
+	conn, err := c.dialTLSRoute(info)
+	if err != nil {
+		return nil, err
+	}
+	c.tuneTCP(conn)
+	if c.conf().ProxyProtocol == 0 {
+		return conn, nil
+	}
+	header := proxyHeader(c.conf().ProxyProtocol, remoteAddr, conn.RemoteAddr())
+	if _, err := conn.Write(header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// proxyHeader describes a connection from src to dst. dst is given in
+// src's address family, or as the unspecified address if it has no form
+// there.
+func proxyHeader(version int, src string, dst net.Addr) []byte {
+	srcAddr, err := netip.ParseAddrPort(src)
+	dstAddr := netip.AddrPort{}
+	if tcp, ok := dst.(*net.TCPAddr); ok {
+		dstAddr = tcp.AddrPort()
+	}
+	if err != nil {
+		if version == 1 {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		return append(slices.Clone(proxyV2Signature), 0x20, 0x00, 0x00, 0x00)
+	}
+
+	s := srcAddr.Addr().Unmap()
+	d := dstAddr.Addr().Unmap()
+	switch {
+	case s.Is4() && !d.Is4():
+		d = netip.IPv4Unspecified()
+	case s.Is6() && !d.Is6():
+		if d.Is4() {
+			d = netip.AddrFrom16(d.As16())
+		} else {
+			d = netip.IPv6Unspecified()
+		}
+	}
+
+	if version == 1 {
+		family := "TCP4"
+		if s.Is6() {
+			family = "TCP6"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, s, d, srcAddr.Port(), dstAddr.Port())
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(0x21) // version 2, PROXY
+	if s.Is4() {
+		buf.WriteByte(0x11) // TCP over IPv4
+		binary.Write(&buf, binary.BigEndian, uint16(12))
+		a, b := s.As4(), d.As4()
+		buf.Write(a[:])
+		buf.Write(b[:])
+	} else {
+		buf.WriteByte(0x21) // TCP over IPv6
+		binary.Write(&buf, binary.BigEndian, uint16(36))
+		a, b := s.As16(), d.As16()
+		buf.Write(a[:])
+		buf.Write(b[:])
+	}
+	binary.Write(&buf, binary.BigEndian, srcAddr.Port())
+	binary.Write(&buf, binary.BigEndian, dstAddr.Port())
+	return buf.Bytes()
+}
@@ -0,0 +1,72 @@
+package outray
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProxyProtocolV1(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		got <- line
+	}()
+
+	c := NewClient(WithUpstreamFallback(ln.Addr().String()), WithProxyProtocol(1))
+	c.closed = true
+	c.handleTCPConnection(TCPConnection{ID: "conn-1", RemoteAddr: "203.0.113.7:51234"}, 0)
+	defer c.handleTCPClose("conn-1")
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	select {
+	case line := <-got:
+		if want := "PROXY TCP4 203.0.113.7 127.0.0.1 51234 " + strconv.Itoa(port) + "\r\n"; line != want {
+			t.Errorf("Expected %q, got %q", want, line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Backend never received a PROXY header")
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5432}
+	tests := []struct {
+		version int
+		src     string
+		want    string
+	}{
+		{1, "[2001:db8::1]:4000", "PROXY TCP6 2001:db8::1 ::ffff:127.0.0.1 4000 5432\r\n"},
+		{1, "", "PROXY UNKNOWN\r\n"},
+		{2, "203.0.113.7:51234", "0d0a0d0a000d0a515549540a" + "21" + "11" + "000c" + "cb007107" + "7f000001" + "c822" + "1538"},
+		{2, "", "0d0a0d0a000d0a515549540a" + "20" + "00" + "0000"},
+	}
+	for _, tt := range tests {
+		got := proxyHeader(tt.version, tt.src, dst)
+		want := []byte(tt.want)
+		if tt.version == 2 {
+			want, _ = hex.DecodeString(tt.want)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("proxyHeader(%d, %q) = %q, want %q", tt.version, tt.src, got, want)
+		}
+	}
+
+	if c := NewClient(WithProxyProtocol(3)); c.configErr == nil {
+		t.Error("Expected an error for PROXY protocol version 3")
+	}
+}
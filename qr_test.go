@@ -0,0 +1,68 @@
+package outray
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestQRReedSolomon(t *testing.T) {
+	// Version 1-M "HELLO WORLD" from the QR specification walkthrough.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("Expected EC codewords %v, got %v", want, got)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	url := "https://my-app.outray.app/some/longer/path?x=1"
+	q, err := encodeQR([]byte(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.version != 4 || q.size != 33 {
+		t.Errorf("Expected version 4 (33x33), got %d (%dx%d)", q.version, q.size, q.size)
+	}
+
+	// Both copies of the format information must agree.
+	for i := 0; i < 8; i++ {
+		var first bool
+		switch {
+		case i <= 5:
+			first = q.modules[i][8]
+		case i == 6:
+			first = q.modules[7][8]
+		default:
+			first = q.modules[8][8]
+		}
+		if first != q.modules[8][q.size-1-i] {
+			t.Errorf("Format bit %d differs between copies", i)
+		}
+	}
+
+	img, err := QRCode(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := decoded.Bounds().Dx(); w != (q.size+2*qrQuietZone)*qrPNGScale {
+		t.Errorf("Unexpected PNG width %d", w)
+	}
+
+	text, err := QRCodeText(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(text, "\n"); lines != (q.size+2*qrQuietZone+1)/2 {
+		t.Errorf("Unexpected terminal height %d", lines)
+	}
+
+	if _, err := QRCode(strings.Repeat("x", 300)); err != errQRTooLong {
+		t.Errorf("Expected errQRTooLong, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package outray
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	QuotaBytes    = "bytes"
+	QuotaRequests = "requests"
+)
+
+// Quota is a usage allowance for the tunnel. Zero fields are unlimited.
+// Servers may send one in tunnel_opened, replacing the configured values.
+type Quota struct {
+	Bytes    uint64 `json:"bytes,omitempty"`
+	Requests uint64 `json:"requests,omitempty"`
+}
+
+type QuotaThreshold struct {
+	Kind      string
+	Threshold float64
+	Used      uint64
+	Limit     uint64
+}
+
+type quotaState struct {
+	limits     Quota
+	thresholds []float64
+	fired      map[string]int
+}
+
+// WithQuota tracks usage against q and fires OnQuotaThreshold as each
+// fraction in thresholds is crossed (default 0.8).
+func WithQuota(q Quota, thresholds ...float64) Option {
+	return func(c *Client) {
+		c.config.Quota = q
+		c.config.QuotaThresholds = thresholds
+	}
+}
+
+func WithOnQuotaThreshold(fn func(t QuotaThreshold)) Option {
+	return func(c *Client) {
+		c.config.OnQuotaThreshold = fn
+	}
+}
+
+func (c *Client) setQuota(q Quota) {
+	thresholds := append([]float64(nil), c.conf().QuotaThresholds...)
+	if len(thresholds) == 0 {
+		thresholds = []float64{0.8}
+	}
+	sort.Float64s(thresholds)
+
+	c.quotaMu.Lock()
+	defer c.quotaMu.Unlock()
+	if c.quota.fired != nil && c.quota.limits == q {
+		return
+	}
+	c.quota = quotaState{limits: q, thresholds: thresholds, fired: make(map[string]int)}
+}
+
+func (c *Client) watchQuota(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkQuota()
+		}
+	}
+}
+
+func (c *Client) checkQuota() {
+	bytes := atomic.LoadUint64(&c.stats.bytesIn) + atomic.LoadUint64(&c.stats.bytesOut)
+	requests := atomic.LoadUint64(&c.stats.requests)
+
+	c.quotaMu.Lock()
+	var crossed []QuotaThreshold
+	crossed = c.quota.cross(crossed, QuotaBytes, bytes, c.quota.limits.Bytes)
+	crossed = c.quota.cross(crossed, QuotaRequests, requests, c.quota.limits.Requests)
+	c.quotaMu.Unlock()
+
+	for _, t := range crossed {
+		c.logf("Quota %s at %.0f%% (%d of %d)", t.Kind, t.Threshold*100, t.Used, t.Limit)
+		if c.conf().OnQuotaThreshold != nil {
+			c.safeCallback(func() { c.conf().OnQuotaThreshold(t) })
+		}
+	}
+}
+
+// cross appends every threshold newly crossed for one kind, so a jump from
+// 50% to 95% reports both 80% and 90%.
+func (q *quotaState) cross(crossed []QuotaThreshold, kind string, used, limit uint64) []QuotaThreshold {
+	if limit == 0 {
+		return crossed
+	}
+	for i := q.fired[kind]; i < len(q.thresholds); i++ {
+		if float64(used) < q.thresholds[i]*float64(limit) {
+			break
+		}
+		crossed = append(crossed, QuotaThreshold{Kind: kind, Threshold: q.thresholds[i], Used: used, Limit: limit})
+		q.fired[kind] = i + 1
+	}
+	return crossed
+}
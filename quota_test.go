@@ -0,0 +1,37 @@
+package outray
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestQuotaThresholds(t *testing.T) {
+	var got []QuotaThreshold
+	c := NewClient(
+		WithQuota(Quota{Bytes: 1000, Requests: 10}, 0.8, 0.9),
+		WithOnQuotaThreshold(func(q QuotaThreshold) { got = append(got, q) }),
+	)
+
+	atomic.StoreUint64(&c.stats.bytesIn, 500)
+	c.checkQuota()
+	if len(got) != 0 {
+		t.Fatalf("Expected no thresholds at 50%%, got %+v", got)
+	}
+
+	atomic.StoreUint64(&c.stats.bytesOut, 450)
+	atomic.StoreUint64(&c.stats.requests, 8)
+	c.checkQuota()
+	c.checkQuota()
+	if len(got) != 3 {
+		t.Fatalf("Expected bytes 80%%/90%% and requests 80%% once each, got %+v", got)
+	}
+	if got[0].Kind != QuotaBytes || got[1].Threshold != 0.9 || got[2].Kind != QuotaRequests {
+		t.Errorf("Unexpected thresholds: %+v", got)
+	}
+
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://x.outray.dev","quota":{"bytes":10000}}`), 0)
+	c.checkQuota()
+	if len(got) != 3 {
+		t.Errorf("Expected raised server quota to reset thresholds without firing, got %+v", got[3:])
+	}
+}
@@ -0,0 +1,59 @@
+package outray
+
+import (
+	"bytes"
+	"slices"
+	"sync/atomic"
+)
+
+const MsgTypeUpdateTunnel = "update_tunnel"
+
+// CapUpdateTunnel is advertised in tunnel_opened by servers that accept
+// update_tunnel on an open connection.
+const CapUpdateTunnel = "update_tunnel"
+
+// Reconfigure applies opts to a running client. Changes to the tunnel
+// itself (subdomain, custom domain, protocol, remote port) are sent as an
+// update_tunnel frame when the server supports it; anything else, or a
+// server without that capability, triggers an immediate reconnect with the
+// new settings. OnOpen fires again with the resulting URL.
+func (c *Client) Reconfigure(opts ...Option) error {
+	prev := c.applyOptions(opts...)
+	c.setQuota(c.conf().Quota)
+
+	c.mu.Lock()
+	conn := c.conn
+	connected := conn != nil && !c.closed
+	inPlace := c.hasCapability(CapUpdateTunnel) && !connectionChanged(prev, *c.conf())
+	c.mu.Unlock()
+
+	if !connected {
+		c.auditf(AuditReconfigure, map[string]string{"mode": "deferred"})
+		return nil
+	}
+	if inPlace {
+		c.auditf(AuditReconfigure, map[string]string{"mode": "update_tunnel"})
+		return c.writeJSON(PriorityControl, c.handshakeFrame(c.openTunnelRequest(MsgTypeUpdateTunnel)))
+	}
+
+	c.logf("Reconnecting to apply new configuration")
+	c.auditf(AuditReconfigure, map[string]string{"mode": "reconnect"})
+	atomic.StoreInt32(&c.reconfiguring, 1)
+	return conn.Close()
+}
+
+func (c *Client) hasCapability(name string) bool {
+	return slices.Contains(c.capabilities, name)
+}
+
+// connectionChanged reports whether the new config can only take effect on
+// a fresh connection.
+func connectionChanged(prev, next Config) bool {
+	return prev.ServerURL != next.ServerURL ||
+		prev.APIKey != next.APIKey ||
+		prev.ServerFlavor != next.ServerFlavor ||
+		prev.PathPrefix != next.PathPrefix ||
+		prev.ConnectionPool != next.ConnectionPool ||
+		!bytes.Equal(prev.FrameSecret, next.FrameSecret) ||
+		!bytes.Equal(prev.E2EKey, next.E2EKey)
+}
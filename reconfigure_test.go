@@ -0,0 +1,103 @@
+package outray
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconfigure(t *testing.T) {
+	ts := newTestServer(t)
+	opened := make(chan string, 4)
+	c := connectTestClient(t, ts, WithSubdomain("old"), WithOnOpen(func(url string) { opened <- url }))
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://old.outray.dev", "capabilities": []string{CapUpdateTunnel}})
+	<-opened
+
+	if err := c.Reconfigure(WithSubdomain("new")); err != nil {
+		t.Fatal(err)
+	}
+	var update OpenTunnelRequest
+	readFrame(t, conn, &update)
+	if update.Type != MsgTypeUpdateTunnel || update.Subdomain != "new" {
+		t.Errorf("Expected in-place update_tunnel, got %+v", update)
+	}
+
+	if err := c.Reconfigure(WithAPIKey("rotated")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-ts.conns:
+		defer conn.Close()
+		var handshake OpenTunnelRequest
+		readFrame(t, conn, &handshake)
+		if handshake.Type != MsgTypeOpenTunnel || handshake.APIKey != "rotated" || handshake.Subdomain != "new" {
+			t.Errorf("Expected reconnect handshake with new settings, got %+v", handshake)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected immediate reconnect after connection-level change")
+	}
+	if s := c.Stats(); s.Reconnects != 0 {
+		t.Errorf("Expected reconfigure not to count as a failed connection, got %d", s.Reconnects)
+	}
+}
+
+func TestReconfigureDuringRequests(t *testing.T) {
+	var closed atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	ts := newTestServer(t)
+	opened := make(chan string, 1)
+	c := connectTestClient(t, ts, WithPort(upstream.Listener.Addr().(*net.TCPAddr).Port), WithOnOpen(func(url string) { opened <- url }))
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev", "capabilities": []string{CapUpdateTunnel}})
+	<-opened
+
+	const requests = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range requests {
+			c.Reconfigure(WithSubdomain(fmt.Sprintf("sub-%d", i)), WithUpstreamPool(10+i%2, 2, time.Minute))
+		}
+	}()
+	for i := range requests {
+		conn.WriteJSON(map[string]interface{}{"type": MsgTypeRequest, "requestId": fmt.Sprintf("r%d", i), "method": "GET", "path": "/"})
+	}
+	for got := 0; got < requests; {
+		var resp IncomingResponse
+		readFrame(t, conn, &resp)
+		if resp.Type == MsgTypeResponse {
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Unexpected response during reconfigure: %d %s", resp.StatusCode, resp.Body)
+			}
+			got++
+		}
+	}
+	<-done
+
+	// Replacing the upstream pool drops the old one's idle connections.
+	before := closed.Load()
+	c.Reconfigure(WithUpstreamPool(5, 1, time.Minute))
+	deadline := time.Now().Add(2 * time.Second)
+	for closed.Load() == before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if closed.Load() == before {
+		t.Error("Expected the replaced upstream client's idle connections to be closed")
+	}
+}
@@ -0,0 +1,120 @@
+package outray
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// RedactionRule scrubs sensitive data from recorded traffic. Rules apply to
+// journal entries only; live traffic is never modified.
+type RedactionRule struct {
+	pattern  *regexp.Regexp
+	jsonPath []string
+}
+
+var (
+	RedactCreditCards = RedactRegexp(regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`))
+	RedactEmails      = RedactRegexp(regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`))
+	RedactTokens      = RedactRegexp(regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+|\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]+`))
+)
+
+// RedactRegexp replaces every match in bodies and header values.
+func RedactRegexp(re *regexp.Regexp) RedactionRule {
+	return RedactionRule{pattern: re}
+}
+
+// RedactJSONPath replaces the value at a dot-separated path in JSON bodies.
+// "*" matches any object key or array element, e.g. "cards.*.number".
+func RedactJSONPath(path string) RedactionRule {
+	return RedactionRule{jsonPath: strings.Split(path, ".")}
+}
+
+func WithRedaction(rules ...RedactionRule) Option {
+	return func(c *Client) {
+		c.config.Redaction = append(c.config.Redaction, rules...)
+	}
+}
+
+func (c *Client) redact(e *JournalEntry) {
+	if len(c.conf().Redaction) == 0 {
+		return
+	}
+	e.Request.Headers = redactHeaders(e.Request.Headers, c.conf().Redaction)
+	e.Request.Body = redactBody(e.Request.Body, c.conf().Redaction)
+	e.Response.Headers = redactHeaders(e.Response.Headers, c.conf().Redaction)
+	e.Response.Body = redactBody(e.Response.Body, c.conf().Redaction)
+}
+
+func redactHeaders(h map[string]string, rules []RedactionRule) map[string]string {
+	if h == nil {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		for _, r := range rules {
+			if r.pattern != nil {
+				v = r.pattern.ReplaceAllString(v, redacted)
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func redactBody(body []byte, rules []RedactionRule) []byte {
+	if len(body) == 0 {
+		return body
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&doc) == nil {
+		changed := false
+		for _, r := range rules {
+			if r.jsonPath != nil {
+				doc = redactPath(doc, r.jsonPath, &changed)
+			}
+		}
+		if changed {
+			if out, err := json.Marshal(doc); err == nil {
+				body = out
+			}
+		}
+	}
+
+	for _, r := range rules {
+		if r.pattern != nil {
+			body = r.pattern.ReplaceAll(body, []byte(redacted))
+		}
+	}
+	return body
+}
+
+func redactPath(v interface{}, path []string, changed *bool) interface{} {
+	if len(path) == 0 {
+		*changed = true
+		return redacted
+	}
+	key, rest := path[0], path[1:]
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if key == "*" || key == k {
+				node[k] = redactPath(child, rest, changed)
+			}
+		}
+	case []interface{}:
+		for i, child := range node {
+			if key == "*" || key == strconv.Itoa(i) {
+				node[i] = redactPath(child, rest, changed)
+			}
+		}
+	}
+	return v
+}
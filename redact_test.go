@@ -0,0 +1,33 @@
+package outray
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	c := NewClient(WithRedaction(RedactJSONPath("customer.email"), RedactJSONPath("cards.*.cvc"), RedactCreditCards, RedactTokens))
+
+	req := IncomingRequest{
+		Headers: map[string]string{"Authorization": "Bearer abc.def"},
+		Body:    []byte(`{"customer":{"email":"a@b.co","id":7},"cards":[{"number":"4242 4242 4242 4242","cvc":"123"}]}`),
+	}
+	e := JournalEntry{Request: req}
+	c.redact(&e)
+
+	body := string(e.Request.Body)
+	for _, leaked := range []string{"a@b.co", "4242", `"123"`} {
+		if strings.Contains(body, leaked) {
+			t.Errorf("Expected %q to be redacted from %s", leaked, body)
+		}
+	}
+	if !strings.Contains(body, `"id":7`) {
+		t.Errorf("Expected unrelated fields to survive, got %s", body)
+	}
+	if e.Request.Headers["Authorization"] != redacted {
+		t.Errorf("Expected token header to be redacted, got %q", e.Request.Headers["Authorization"])
+	}
+	if req.Headers["Authorization"] != "Bearer abc.def" {
+		t.Error("Expected live request headers to be left untouched")
+	}
+}
@@ -0,0 +1,59 @@
+package outray
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// roundTrip sends msg and waits until ctx is done for the server frame
+// whose requestId is id.
+func (c *Client) roundTrip(ctx context.Context, id string, msg interface{}) ([]byte, error) {
+	reply := make(chan []byte, 1)
+	c.repliesMu.Lock()
+	if c.replies == nil {
+		c.replies = make(map[string]chan []byte)
+	}
+	c.replies[id] = reply
+	c.repliesMu.Unlock()
+	defer func() {
+		c.repliesMu.Lock()
+		delete(c.replies, id)
+		c.repliesMu.Unlock()
+	}()
+
+	if err := c.writeJSON(PriorityControl, msg); err != nil {
+		return nil, err
+	}
+	select {
+	case data := <-reply:
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) handleReply(data []byte) {
+	var env struct {
+		RequestID string `json:"requestId"`
+	}
+	if json.Unmarshal(data, &env) != nil {
+		return
+	}
+	c.repliesMu.Lock()
+	reply, ok := c.replies[env.RequestID]
+	c.repliesMu.Unlock()
+	if ok {
+		select {
+		case reply <- data:
+		default:
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
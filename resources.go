@@ -0,0 +1,74 @@
+package outray
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// ResourceLimits are hard caps on what the client holds in memory. When one
+// is reached, new TCP streams and UDP packets are rejected and new HTTP
+// requests get 503 until usage drops; existing streams keep running.
+type ResourceLimits struct {
+	MaxGoroutines    int
+	MaxBufferedBytes int64
+}
+
+func WithResourceLimits(l ResourceLimits) Option {
+	return func(c *Client) {
+		c.config.ResourceLimits = l
+	}
+}
+
+// spawn runs f on a goroutine counted in Stats.Goroutines and awaited by
+// Wait.
+func (c *Client) spawn(f func()) {
+	atomic.AddInt64(&c.goroutines, 1)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer atomic.AddInt64(&c.goroutines, -1)
+		defer c.catchPanic()
+		f()
+	}()
+}
+
+// trackWrite counts a frame as pending until the returned func is called.
+func (c *Client) trackWrite(n int) func() {
+	atomic.AddInt64(&c.pendingWrites, 1)
+	atomic.AddInt64(&c.bufferedBytes, int64(n))
+	return func() {
+		atomic.AddInt64(&c.pendingWrites, -1)
+		atomic.AddInt64(&c.bufferedBytes, -int64(n))
+	}
+}
+
+// overloaded returns why new work should be refused, or "" if it can
+// proceed.
+func (c *Client) overloaded() string {
+	if atomic.LoadInt32(&c.draining) == 1 {
+		return "draining before reconnect"
+	}
+	l := c.conf().ResourceLimits
+	if n := atomic.LoadInt64(&c.goroutines); l.MaxGoroutines > 0 && n >= int64(l.MaxGoroutines) {
+		return limitReason("goroutine", l.MaxGoroutines)
+	}
+	if n := atomic.LoadInt64(&c.bufferedBytes); l.MaxBufferedBytes > 0 && n >= l.MaxBufferedBytes {
+		return fmt.Sprintf("buffered bytes limit of %d reached", l.MaxBufferedBytes)
+	}
+	return ""
+}
+
+func (c *Client) shed(reason string) {
+	atomic.AddUint64(&c.stats.shed, 1)
+	c.logf("Shedding load: %s", reason)
+}
+
+func (c *Client) rejectOverloaded(req IncomingRequest, reason string) {
+	c.shed(reason)
+	c.respond(req, IncomingResponse{
+		StatusCode: http.StatusServiceUnavailable,
+		Headers:    map[string]string{"Retry-After": "1"},
+		Body:       []byte("Service Unavailable: " + reason),
+	}, "send response error")
+}
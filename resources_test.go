@@ -0,0 +1,40 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResourceLimits(t *testing.T) {
+	c := NewClient(WithResourceLimits(ResourceLimits{MaxGoroutines: 1, MaxBufferedBytes: 8}))
+	if reason := c.overloaded(); reason != "" {
+		t.Fatalf("Expected idle client to accept work, got %q", reason)
+	}
+
+	done := c.trackWrite(16)
+	if s := c.Stats(); s.PendingWrites != 1 || s.BufferedBytes != 16 {
+		t.Errorf("Expected 1 pending write of 16 bytes, got %d/%d", s.PendingWrites, s.BufferedBytes)
+	}
+	if c.overloaded() == "" {
+		t.Error("Expected buffered bytes limit to be reached")
+	}
+	done()
+
+	release := make(chan struct{})
+	c.spawn(func() { <-release })
+	if s := c.Stats(); s.Goroutines != 1 {
+		t.Errorf("Expected 1 goroutine, got %d", s.Goroutines)
+	}
+	if c.overloaded() == "" {
+		t.Error("Expected goroutine limit to be reached")
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Goroutines != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if reason := c.overloaded(); reason != "" {
+		t.Errorf("Expected limits to clear, got %q", reason)
+	}
+}
@@ -0,0 +1,149 @@
+package outray
+
+import (
+	"errors"
+	"sync"
+)
+
+var errResponseDone = errors.New("response already completed")
+
+type ResponseWriter interface {
+	Header() map[string]string
+	WriteHeader(statusCode int)
+	Write(p []byte) (int, error)
+	Flush() error
+	Close() error
+	Respond(resp IncomingResponse) error
+}
+
+type AsyncRequestHandler func(req IncomingRequest, w ResponseWriter)
+
+func WithOnRequestAsync(fn AsyncRequestHandler) Option {
+	return func(c *Client) {
+		c.config.OnRequestAsync = fn
+	}
+}
+
+type responseWriter struct {
+	c   *Client
+	req IncomingRequest
+
+	mu        sync.Mutex
+	status    int
+	headers   map[string]string
+	body      []byte
+	streaming bool
+	done      bool
+	seq       uint64 // chunks sent
+	capture   chan IncomingResponse
+}
+
+func (c *Client) newResponseWriter(req IncomingRequest) *responseWriter {
+	return &responseWriter{c: c, req: req, status: 200, headers: make(map[string]string)}
+}
+
+func (w *responseWriter) Header() map[string]string {
+	return w.headers
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.streaming && !w.done {
+		w.status = statusCode
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return 0, errResponseDone
+	}
+	w.body = append(w.body, p...)
+	return len(p), nil
+}
+
+func (w *responseWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return errResponseDone
+	}
+	if w.capture != nil {
+		return nil
+	}
+	if err := w.startLocked(); err != nil {
+		return err
+	}
+	return w.sendChunkLocked(false)
+}
+
+func (w *responseWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return errResponseDone
+	}
+	w.done = true
+
+	if w.streaming {
+		return w.sendChunkLocked(true)
+	}
+	return w.completeLocked(IncomingResponse{
+		StatusCode: w.status,
+		Headers:    w.headers,
+		Body:       w.body,
+	})
+}
+
+func (w *responseWriter) Respond(resp IncomingResponse) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done || w.streaming {
+		return errResponseDone
+	}
+	w.done = true
+	return w.completeLocked(resp)
+}
+
+func (w *responseWriter) completeLocked(resp IncomingResponse) error {
+	if w.capture != nil {
+		w.capture <- resp
+		return nil
+	}
+	return w.c.respondErr(w.req, resp)
+}
+
+func (w *responseWriter) startLocked() error {
+	if w.streaming {
+		return nil
+	}
+	w.streaming = true
+	if w.c.e2eCipher() != nil {
+		w.headers[e2eHeader] = "aes-256-gcm"
+	}
+	w.c.stats.addRequest(IncomingResponse{StatusCode: w.status}, len(w.req.Body))
+	w.c.pinStream(w.req.ID)
+	return w.c.writeStreamJSONOn(w.req.epoch, w.req.ID, PriorityHTTP, ResponseStart{
+		Type:       MsgTypeResponseStart,
+		ID:         w.req.ID,
+		StatusCode: w.status,
+		Headers:    w.headers,
+	})
+}
+
+func (w *responseWriter) sendChunkLocked(final bool) error {
+	if len(w.body) == 0 && !final {
+		return nil
+	}
+	w.seq++
+	chunk := ResponseChunk{Type: MsgTypeResponseChunk, ID: w.req.ID, Final: final}
+	w.c.stats.addBytesOut(len(w.body))
+	chunk.Data = w.c.seal(w.body, e2eFrame{typ: MsgTypeResponseChunk, id: w.req.ID, seq: w.seq, final: final})
+	w.body = nil
+	if final {
+		defer w.c.unpinStream(w.req.ID)
+	}
+	return w.c.writeStreamJSONOn(w.req.epoch, w.req.ID, PriorityHTTP, chunk)
+}
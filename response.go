@@ -0,0 +1,61 @@
+package outray
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func JSON(statusCode int, v interface{}) IncomingResponse {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return Text(http.StatusInternalServerError, err.Error())
+	}
+	return newResponse(statusCode, "application/json", body)
+}
+
+func Text(statusCode int, text string) IncomingResponse {
+	return newResponse(statusCode, "text/plain; charset=utf-8", []byte(text))
+}
+
+func Redirect(statusCode int, url string) IncomingResponse {
+	resp := newResponse(statusCode, "text/html; charset=utf-8", []byte("<a href=\""+html.EscapeString(url)+"\">"+http.StatusText(statusCode)+"</a>.\n"))
+	resp.Headers["Location"] = url
+	return resp
+}
+
+func File(path string) IncomingResponse {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Text(http.StatusNotFound, "404 page not found")
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return Text(http.StatusForbidden, "403 Forbidden")
+		}
+		return Text(http.StatusInternalServerError, err.Error())
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return newResponse(http.StatusOK, contentType, body)
+}
+
+func newResponse(statusCode int, contentType string, body []byte) IncomingResponse {
+	return IncomingResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type":   contentType,
+			"Content-Length": strconv.Itoa(len(body)),
+		},
+		Body: body,
+	}
+}
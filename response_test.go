@@ -0,0 +1,30 @@
+package outray
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResponseHelpers(t *testing.T) {
+	resp := JSON(201, map[string]int{"n": 1})
+	if resp.StatusCode != 201 || string(resp.Body) != `{"n":1}` || resp.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected JSON response %+v", resp)
+	}
+
+	resp = Redirect(302, "https://example.com/?a=1&b=2")
+	if resp.Headers["Location"] != "https://example.com/?a=1&b=2" {
+		t.Errorf("Unexpected Location %q", resp.Headers["Location"])
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.html")
+	os.WriteFile(path, []byte("<h1>hi</h1>"), 0o644)
+	resp = File(path)
+	if resp.StatusCode != 200 || resp.Headers["Content-Type"] != "text/html; charset=utf-8" || resp.Headers["Content-Length"] != "11" {
+		t.Errorf("Unexpected file response %+v", resp.Headers)
+	}
+	if File(filepath.Join(dir, "missing")).StatusCode != 404 {
+		t.Error("Expected 404 for missing file")
+	}
+}
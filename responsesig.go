@@ -0,0 +1,79 @@
+package outray
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseSignatureHeader carries an Ed25519 signature over the request
+// line, status and body of a response, proving it passed through a tunnel
+// holding the private key.
+const ResponseSignatureHeader = "X-Outray-Signature"
+
+var (
+	errResponseSignature = errors.New("response signature missing or invalid")
+	errResponseExpired   = errors.New("response signature expired")
+)
+
+// WithResponseSigning signs every buffered response with key. Consumers
+// check it with VerifyResponse and the matching public key. Streamed
+// responses are not signed since their body is not known up front.
+func WithResponseSigning(key ed25519.PrivateKey) Option {
+	return func(c *Client) {
+		c.config.ResponseKey = key
+	}
+}
+
+func (c *Client) signResponse(req IncomingRequest, resp *IncomingResponse) {
+	if c.conf().ResponseKey == nil {
+		return
+	}
+	ts := c.now().Unix()
+	sig := ed25519.Sign(c.conf().ResponseKey, responseSigningInput(ts, req.Method, req.Path, resp.StatusCode, resp.Body))
+	headers := make(map[string]string, len(resp.Headers)+1)
+	maps.Copy(headers, resp.Headers)
+	resp.Headers = headers
+	resp.Headers[ResponseSignatureHeader] = fmt.Sprintf("t=%d,v1=%s", ts, base64.RawURLEncoding.EncodeToString(sig))
+}
+
+func responseSigningInput(ts int64, method, path string, status int, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return fmt.Appendf(nil, "v1\n%d\n%s\n%s\n%d\n%s", ts, method, path, status, hex.EncodeToString(sum[:]))
+}
+
+// VerifyResponse checks the X-Outray-Signature header of resp against pub.
+// body is the decoded response body, and signatures older than maxAge are
+// rejected (zero disables the check).
+func VerifyResponse(pub ed25519.PublicKey, resp *http.Response, body []byte, maxAge time.Duration) error {
+	var ts int64
+	var sig []byte
+	for _, part := range strings.Split(resp.Header.Get(ResponseSignatureHeader), ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts, _ = strconv.ParseInt(v, 10, 64)
+		case "v1":
+			sig, _ = base64.RawURLEncoding.DecodeString(v)
+		}
+	}
+	if ts == 0 || sig == nil || resp.Request == nil {
+		return errResponseSignature
+	}
+	if maxAge > 0 && abs(time.Since(time.Unix(ts, 0))) > maxAge {
+		return errResponseExpired
+	}
+	input := responseSigningInput(ts, resp.Request.Method, resp.Request.URL.RequestURI(), resp.StatusCode, body)
+	if !ed25519.Verify(pub, input, sig) {
+		return errResponseSignature
+	}
+	return nil
+}
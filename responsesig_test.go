@@ -0,0 +1,38 @@
+package outray
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseSigning(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	c := NewClient(WithResponseSigning(priv))
+
+	req := IncomingRequest{ID: "r1", Method: "GET", Path: "/orders?id=7"}
+	resp := IncomingResponse{StatusCode: 200, Headers: map[string]string{"Content-Type": "text/plain"}, Body: []byte("ok")}
+	c.signResponse(req, &resp)
+
+	httpResp := &http.Response{
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{},
+		Request:    httptest.NewRequest("GET", "https://a.outray.dev/orders?id=7", nil),
+	}
+	for k, v := range resp.Headers {
+		httpResp.Header.Set(k, v)
+	}
+
+	if err := VerifyResponse(pub, httpResp, resp.Body, time.Minute); err != nil {
+		t.Fatalf("Expected valid signature, got %v", err)
+	}
+	if err := VerifyResponse(pub, httpResp, []byte("tampered"), time.Minute); err != errResponseSignature {
+		t.Errorf("Expected tampered body to fail, got %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := VerifyResponse(other, httpResp, resp.Body, time.Minute); err != errResponseSignature {
+		t.Errorf("Expected wrong key to fail, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package outray
+
+const MsgTypeReauthenticate = "reauthenticate"
+
+// CapReauthenticate is advertised in tunnel_opened by servers that accept a
+// new API key on an open connection.
+const CapReauthenticate = "reauthenticate"
+
+// RotateAPIKey switches the client to key. When the server supports it the
+// open connection re-authenticates in place and active streams carry on;
+// otherwise in-flight requests are drained (see WithDrainTimeout) and the
+// client reconnects with the new key. Pooled connections pick the key up
+// when they next attach.
+func (c *Client) RotateAPIKey(key string) error {
+	c.mu.Lock()
+	connected := c.conn != nil && !c.closed
+	inPlace := connected && c.hasCapability(CapReauthenticate)
+	c.mu.Unlock()
+	if inPlace || !connected {
+		c.applyOptions(WithAPIKey(key))
+	}
+
+	if !connected {
+		c.auditf(AuditKeyRotation, map[string]string{"mode": "deferred"})
+		return nil
+	}
+	if inPlace {
+		c.auditf(AuditKeyRotation, map[string]string{"mode": "reauthenticate"})
+		return c.writeJSON(PriorityControl, c.handshakeFrame(c.openTunnelRequest(MsgTypeReauthenticate)))
+	}
+
+	c.auditf(AuditKeyRotation, map[string]string{"mode": "reconnect"})
+	c.drain()
+	return c.Reconfigure(WithAPIKey(key))
+}
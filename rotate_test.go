@@ -0,0 +1,75 @@
+package outray
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRotateAPIKey(t *testing.T) {
+	ts := newTestServer(t)
+	opened := make(chan string, 4)
+	c := connectTestClient(t, ts, WithAPIKey("old"), WithOnOpen(func(url string) { opened <- url }))
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev", "capabilities": []string{CapReauthenticate}})
+	<-opened
+
+	if err := c.RotateAPIKey("new"); err != nil {
+		t.Fatal(err)
+	}
+	var reauth OpenTunnelRequest
+	readFrame(t, conn, &reauth)
+	if reauth.Type != MsgTypeReauthenticate || reauth.APIKey != "new" {
+		t.Errorf("Expected in-place reauthenticate, got %+v", reauth)
+	}
+
+	// Without the capability the client reconnects with the new key.
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev"})
+	<-opened
+	if err := c.RotateAPIKey("newer"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-ts.conns:
+		defer conn.Close()
+		var handshake OpenTunnelRequest
+		readFrame(t, conn, &handshake)
+		if handshake.Type != MsgTypeOpenTunnel || handshake.APIKey != "newer" {
+			t.Errorf("Expected reconnect with rotated key, got %+v", handshake)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected reconnect after rotation")
+	}
+}
+
+func TestRotateAPIKeyDuringAttach(t *testing.T) {
+	ts := drainTestServer(t)
+	c := NewClient(WithServerURL(ts.URL()), WithServerFlavor(SelfHosted), WithAPIKey("key-0"), WithConnectionPool(4))
+	c.mu.Lock()
+	c.epoch = 1
+	c.mu.Unlock()
+
+	// Pool connections read the key for the dial header and the attach
+	// frame while it is being rotated.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 5 {
+			c.openPool("tunnel-1", 1)
+			c.closePool()
+		}
+	}()
+	for i := range 20 {
+		if err := c.RotateAPIKey(fmt.Sprintf("key-%d", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	c.Close()
+	c.Wait()
+
+	if key := c.conf().APIKey; key != "key-20" {
+		t.Errorf("Expected the last rotated key, got %q", key)
+	}
+}
@@ -0,0 +1,163 @@
+package outray
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRoutes caps distinct routes tracked; the rest are folded into
+// otherRoute so hostile or unbounded paths can't grow memory.
+const (
+	maxRoutes  = 1000
+	otherRoute = "other"
+)
+
+var LatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+var idSegment = regexp.MustCompile(`^(?:\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,}|[A-Za-z0-9_-]{24,})$`)
+
+// RouteStats aggregates requests for one templated route such as
+// "GET /users/:id".
+type RouteStats struct {
+	Route    string
+	Count    uint64
+	Errors   uint64
+	Statuses map[int]uint64
+	// Latency counts requests per LatencyBuckets upper bound, with one extra
+	// slot for anything slower than the last bucket.
+	Latency []uint64
+	Total   time.Duration
+}
+
+// Quantile estimates the q-th latency quantile (0-1) as the upper bound of
+// the bucket containing it.
+func (r RouteStats) Quantile(q float64) time.Duration {
+	if r.Count == 0 {
+		return 0
+	}
+	target := uint64(q * float64(r.Count))
+	var seen uint64
+	for i, n := range r.Latency {
+		seen += n
+		if seen > target || seen == r.Count {
+			if i < len(LatencyBuckets) {
+				return LatencyBuckets[i]
+			}
+			break
+		}
+	}
+	return LatencyBuckets[len(LatencyBuckets)-1]
+}
+
+func (r RouteStats) Mean() time.Duration {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Total / time.Duration(r.Count)
+}
+
+type routeStats struct {
+	mu     sync.Mutex
+	routes map[string]*RouteStats
+}
+
+// WithRouteTemplates declares path templates like "/users/:id/orders" used
+// to group requests. Paths matching no template fall back to replacing
+// numeric, UUID, and long opaque segments with ":id".
+func WithRouteTemplates(templates ...string) Option {
+	return func(c *Client) {
+		c.config.RouteTemplates = templates
+	}
+}
+
+// RouteStats returns the n busiest routes (all when n <= 0).
+func (c *Client) RouteStats(n int) []RouteStats {
+	c.routes.mu.Lock()
+	out := make([]RouteStats, 0, len(c.routes.routes))
+	for _, r := range c.routes.routes {
+		cp := *r
+		cp.Statuses = make(map[int]uint64, len(r.Statuses))
+		for k, v := range r.Statuses {
+			cp.Statuses[k] = v
+		}
+		cp.Latency = append([]uint64(nil), r.Latency...)
+		out = append(out, cp)
+	}
+	c.routes.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Route < out[j].Route
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func (c *Client) observeRoute(req IncomingRequest, status int, latency time.Duration) {
+	route := req.Method + " " + c.routeTemplate(req.Path)
+
+	c.routes.mu.Lock()
+	defer c.routes.mu.Unlock()
+	if c.routes.routes == nil {
+		c.routes.routes = make(map[string]*RouteStats)
+	}
+	r := c.routes.routes[route]
+	if r == nil {
+		if len(c.routes.routes) >= maxRoutes {
+			route = otherRoute
+			r = c.routes.routes[route]
+		}
+		if r == nil {
+			r = &RouteStats{Route: route, Statuses: make(map[int]uint64), Latency: make([]uint64, len(LatencyBuckets)+1)}
+			c.routes.routes[route] = r
+		}
+	}
+
+	r.Count++
+	if status >= 500 {
+		r.Errors++
+	}
+	r.Statuses[status]++
+	r.Total += latency
+	r.Latency[sort.Search(len(LatencyBuckets), func(i int) bool { return latency <= LatencyBuckets[i] })]++
+}
+
+func (c *Client) routeTemplate(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for _, tmpl := range c.conf().RouteTemplates {
+		parts := strings.Split(strings.Trim(tmpl, "/"), "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		match := true
+		for i, p := range parts {
+			if !strings.HasPrefix(p, ":") && p != segments[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return tmpl
+		}
+	}
+
+	for i, s := range segments {
+		if idSegment.MatchString(s) {
+			segments[i] = ":id"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
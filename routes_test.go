@@ -0,0 +1,26 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouteStats(t *testing.T) {
+	c := NewClient(WithRouteTemplates("/repos/:owner/:name"))
+
+	c.observeRoute(IncomingRequest{Method: "GET", Path: "/users/42?x=1"}, 200, 3*time.Millisecond)
+	c.observeRoute(IncomingRequest{Method: "GET", Path: "/users/7"}, 200, 40*time.Millisecond)
+	c.observeRoute(IncomingRequest{Method: "GET", Path: "/users/550e8400-e29b-41d4-a716-446655440000"}, 503, 2*time.Second)
+	c.observeRoute(IncomingRequest{Method: "GET", Path: "/repos/acme/api"}, 200, time.Millisecond)
+
+	top := c.RouteStats(1)
+	if len(top) != 1 || top[0].Route != "GET /users/:id" || top[0].Count != 3 || top[0].Errors != 1 {
+		t.Fatalf("Unexpected top route: %+v", top)
+	}
+	if p50, p99 := top[0].Quantile(0.5), top[0].Quantile(0.99); p50 != 50*time.Millisecond || p99 != 2500*time.Millisecond {
+		t.Errorf("Unexpected quantiles p50=%v p99=%v", p50, p99)
+	}
+	if all := c.RouteStats(0); len(all) != 2 || all[1].Route != "GET /repos/:owner/:name" {
+		t.Errorf("Expected template route, got %+v", all)
+	}
+}
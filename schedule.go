@@ -0,0 +1,209 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a set of weekly windows during which the tunnel is open.
+type Schedule struct {
+	loc     *time.Location
+	windows []scheduleWindow
+}
+
+type scheduleWindow struct {
+	days       [7]bool
+	start, end int // minutes since midnight; end <= start wraps past midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// WithSchedule opens the tunnel only inside the given windows and closes it
+// outside them. See ParseSchedule for the format; an invalid spec makes
+// Connect fail.
+func WithSchedule(spec string) Option {
+	return func(c *Client) {
+		c.config.Schedule = spec
+	}
+}
+
+// ParseSchedule parses windows separated by ";", each an optional day list
+// followed by a time range, with an optional leading time zone:
+//
+//	Mon-Fri 09:00-18:00
+//	TZ=Europe/Berlin Mon-Fri 09:00-12:30; Mon-Fri 13:30-18:00; Sat 10:00-14:00
+//	22:00-06:00
+//
+// Days are "*", ranges ("Mon-Fri"), or lists ("Sat,Sun"). A range whose end
+// is before its start continues past midnight.
+func ParseSchedule(spec string) (*Schedule, error) {
+	s := &Schedule{loc: time.Local}
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "TZ="); ok {
+		name, windows, _ := strings.Cut(rest, " ")
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %w", err)
+		}
+		s.loc = loc
+		spec = windows
+	}
+
+	for _, part := range strings.Split(spec, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		var w scheduleWindow
+		var err error
+		switch len(fields) {
+		case 1:
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		case 2:
+			if w.days, err = parseDays(fields[0]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("schedule: invalid window %q", part)
+		}
+		if w.start, w.end, err = parseTimeRange(fields[len(fields)-1]); err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	if len(s.windows) == 0 {
+		return nil, errors.New("schedule: no windows")
+	}
+	return s, nil
+}
+
+func parseDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	if spec == "*" {
+		return [7]bool{true, true, true, true, true, true, true}, nil
+	}
+	for _, item := range strings.Split(strings.ToLower(spec), ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		start, ok := weekdays[from]
+		if !ok {
+			return days, fmt.Errorf("schedule: unknown day %q", from)
+		}
+		end := start
+		if isRange {
+			if end, ok = weekdays[to]; !ok {
+				return days, fmt.Errorf("schedule: unknown day %q", to)
+			}
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeRange(spec string) (int, int, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("schedule: invalid time range %q", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("schedule: invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Open reports whether t falls inside any window.
+func (s *Schedule) Open(t time.Time) bool {
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s.windows {
+		if w.end > w.start {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when Open next changes after t, or the zero time if it never
+// does.
+func (s *Schedule) Next(t time.Time) time.Time {
+	open := s.Open(t)
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < 8*24*60; i++ {
+		if s.Open(next) != open {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+func (c *Client) connectScheduled(ctx context.Context) error {
+	sched, err := ParseSchedule(c.conf().Schedule)
+	if err != nil {
+		return err
+	}
+
+	for {
+		now := time.Now()
+		next := sched.Next(now)
+		if !sched.Open(now) {
+			if next.IsZero() {
+				return errors.New("schedule: tunnel is never open")
+			}
+			c.setState(StateIdle)
+			c.logf("Tunnel closed by schedule until %s", next.Format(time.RFC1123))
+			c.auditf(AuditSchedulePause, map[string]string{"until": next.Format(time.RFC3339)})
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(next)):
+			}
+			continue
+		}
+
+		var windowCtx context.Context
+		var cancel context.CancelFunc
+		if next.IsZero() {
+			windowCtx, cancel = context.WithCancel(ctx)
+		} else {
+			windowCtx, cancel = context.WithDeadline(ctx, next)
+		}
+		err := c.connect(windowCtx)
+		cancel()
+		if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		c.logf("Tunnel closed by schedule")
+	}
+}
@@ -0,0 +1,46 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	s, err := ParseSchedule("TZ=UTC Mon-Fri 09:00-18:00; Sat,Sun 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(day, clock string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", day+" "+clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	// 2024-05-06 is a Monday.
+	cases := []struct {
+		t    time.Time
+		open bool
+	}{
+		{at("2024-05-06", "08:59"), false},
+		{at("2024-05-06", "09:00"), true},
+		{at("2024-05-10", "17:59"), true},
+		{at("2024-05-10", "18:00"), false},
+		{at("2024-05-11", "23:00"), true},
+		{at("2024-05-13", "01:30"), true},
+		{at("2024-05-13", "02:30"), false},
+	}
+	for _, tc := range cases {
+		if got := s.Open(tc.t); got != tc.open {
+			t.Errorf("Open(%s) = %v, want %v", tc.t.Format("Mon 15:04"), got, tc.open)
+		}
+	}
+
+	if next := s.Next(at("2024-05-10", "18:30")); !next.Equal(at("2024-05-11", "22:00")) {
+		t.Errorf("Expected next opening Saturday 22:00, got %s", next)
+	}
+	if _, err := ParseSchedule("Mon-Fri 9am-5pm"); err == nil {
+		t.Error("Expected invalid time range to be rejected")
+	}
+}
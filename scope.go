@@ -0,0 +1,100 @@
+package outray
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// ErrCodeScopeViolation is sent by the server when the token's scope does
+// not allow the requested tunnel.
+const ErrCodeScopeViolation = "SCOPE_VIOLATION"
+
+// TokenScope describes what a scoped token may open. Empty fields are
+// unrestricted.
+type TokenScope struct {
+	Protocols     []string `json:"protocols,omitempty"`
+	Subdomains    []string `json:"subdomains,omitempty"`
+	CustomDomains []string `json:"customDomains,omitempty"`
+	RemotePorts   []int    `json:"remotePorts,omitempty"`
+}
+
+// ScopeError reports a tunnel outside the token's scope. Server is set when
+// the server rejected it; otherwise the client caught it before dialing.
+type ScopeError struct {
+	Field  string
+	Value  string
+	Server *ServerError
+}
+
+func (e *ScopeError) Error() string {
+	if e.Server != nil && e.Field == "" {
+		return e.Server.Error()
+	}
+	return fmt.Sprintf("token scope does not allow %s %q", e.Field, e.Value)
+}
+
+func (e *ScopeError) Unwrap() error {
+	if e.Server == nil {
+		return nil
+	}
+	return e.Server
+}
+
+// WithScopedToken authenticates with a least-privilege token and declares
+// its scope in the handshake. Tunnels outside the scope fail Connect with a
+// *ScopeError instead of being attempted.
+func WithScopedToken(token string, scope TokenScope) Option {
+	return func(c *Client) {
+		c.config.APIKey = token
+		c.config.TokenScope = &scope
+	}
+}
+
+// checkScope validates every tunnel in the handshake against the declared
+// scope.
+func checkScope(scope *TokenScope, h OpenTunnelRequest) error {
+	if scope == nil {
+		return nil
+	}
+	specs := h.Tunnels
+	if len(specs) == 0 {
+		specs = []TunnelSpec{{Protocol: h.Protocol, RemotePort: h.Port, Subdomain: h.Subdomain, CustomDomain: h.CustomDomain}}
+	}
+	for _, s := range specs {
+		switch {
+		case !scopeAllows(scope.Protocols, s.Protocol):
+			return &ScopeError{Field: "protocol", Value: s.Protocol}
+		case !scopeAllows(scope.Subdomains, s.Subdomain):
+			return &ScopeError{Field: "subdomain", Value: s.Subdomain}
+		case !scopeAllows(scope.CustomDomains, s.CustomDomain):
+			return &ScopeError{Field: "customDomain", Value: s.CustomDomain}
+		case !scopeAllows(scope.RemotePorts, s.RemotePort):
+			return &ScopeError{Field: "remotePort", Value: strconv.Itoa(s.RemotePort)}
+		}
+	}
+	return nil
+}
+
+// scopeAllows treats an empty list as unrestricted and an unset value as
+// not requesting anything.
+func scopeAllows[T comparable](allowed []T, v T) bool {
+	var zero T
+	return len(allowed) == 0 || v == zero || slices.Contains(allowed, v)
+}
+
+// scopeError turns a SCOPE_VIOLATION frame into a *ScopeError.
+func scopeError(serr *ServerError) error {
+	if serr.Code != ErrCodeScopeViolation {
+		return serr
+	}
+	field, _ := serr.Details["field"].(string)
+	value, _ := serr.Details["value"].(string)
+	return &ScopeError{Field: field, Value: value, Server: serr}
+}
+
+func isLocalScopeError(err error) bool {
+	var se *ScopeError
+	return errors.As(err, &se) && se.Server == nil
+}
@@ -0,0 +1,44 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestScopedTokenLocalViolation(t *testing.T) {
+	c := NewClient(
+		WithServerURL("ws://127.0.0.1:1"),
+		WithSubdomain("other"),
+		WithScopedToken("tok", TokenScope{Protocols: []string{"http"}, Subdomains: []string{"ci"}}),
+	)
+	err := c.Connect(context.Background())
+	var se *ScopeError
+	if !errors.As(err, &se) || se.Field != "subdomain" || se.Value != "other" {
+		t.Fatalf("Expected subdomain scope error, got %v", err)
+	}
+}
+
+func TestScopedTokenServerViolation(t *testing.T) {
+	ts := newTestServer(t)
+	errs := make(chan error, 1)
+	connectTestClient(t, ts,
+		WithScopedToken("tok", TokenScope{Protocols: []string{"http"}}),
+		WithOnError(func(err error) { errs <- err }),
+	)
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "error",
+		"code":    ErrCodeScopeViolation,
+		"message": "token not valid for this subdomain",
+		"details": map[string]interface{}{"field": "subdomain", "value": "x"},
+	})
+
+	err := <-errs
+	var se *ScopeError
+	var serr *ServerError
+	if !errors.As(err, &se) || se.Field != "subdomain" || !errors.As(err, &serr) {
+		t.Errorf("Expected *ScopeError wrapping *ServerError, got %#v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package outray
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvPassphrase holds the passphrase for encrypted profile API keys.
+const EnvPassphrase = "OUTRAY_PASSPHRASE"
+
+// sealedPrefix marks an encrypted secret: AES-256-GCM under a key derived
+// from the passphrase with PBKDF2-SHA256, encoded as salt|nonce|ciphertext.
+const sealedPrefix = "enc:v1:"
+
+const (
+	secretSaltSize   = 16
+	secretIterations = 600000
+)
+
+var (
+	errNoPassphrase    = errors.New("encrypted API key: set " + EnvPassphrase)
+	errSealedSecret    = errors.New("encrypted API key: wrong passphrase or corrupted value")
+	errEmptyPassphrase = errors.New("empty passphrase")
+)
+
+// SealSecret encrypts secret with passphrase. The result is safe to store
+// in the profiles file in place of a plaintext API key.
+func SealSecret(secret, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errEmptyPassphrase
+	}
+	salt := make([]byte, secretSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, secretSaltSize+aead.NonceSize())
+	copy(out, salt)
+	if _, err := rand.Read(out[secretSaltSize:]); err != nil {
+		return "", err
+	}
+	out = aead.Seal(out, out[secretSaltSize:], []byte(secret), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(out), nil
+}
+
+// OpenSecret decrypts a value produced by SealSecret. Values without the
+// encrypted prefix are returned unchanged.
+func OpenSecret(sealed, passphrase string) (string, error) {
+	encoded, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return sealed, nil
+	}
+	if passphrase == "" {
+		return "", errNoPassphrase
+	}
+	data, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < secretSaltSize {
+		return "", errSealedSecret
+	}
+	aead, err := secretCipher(passphrase, data[:secretSaltSize])
+	if err != nil {
+		return "", err
+	}
+	data = data[secretSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", errSealedSecret
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errSealedSecret
+	}
+	return string(plain), nil
+}
+
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, secretIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveProfile writes p under name in the profiles file, creating the file
+// with owner-only permissions if needed. With a passphrase the API key is
+// stored encrypted.
+func SaveProfile(name string, p Profile, passphrase string) error {
+	if passphrase != "" && p.APIKey != "" {
+		sealed, err := SealSecret(p.APIKey, passphrase)
+		if err != nil {
+			return err
+		}
+		p.APIKey = sealed
+	}
+
+	path, err := ProfilesPath()
+	if err != nil {
+		return err
+	}
+	var f profilesFile
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &f); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if f.Profiles == nil {
+		f.Profiles = make(map[string]Profile)
+	}
+	f.Profiles[name] = p
+
+	data, err = json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
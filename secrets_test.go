@@ -0,0 +1,53 @@
+package outray
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSealSecret(t *testing.T) {
+	sealed, err := SealSecret("sk_live_123", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "sk_live_123") {
+		t.Fatalf("Expected an opaque sealed value, got %q", sealed)
+	}
+	if plain, err := OpenSecret(sealed, "hunter2"); err != nil || plain != "sk_live_123" {
+		t.Errorf("Expected round trip, got %q (%v)", plain, err)
+	}
+	if _, err := OpenSecret(sealed, "wrong"); err != errSealedSecret {
+		t.Errorf("Expected errSealedSecret for wrong passphrase, got %v", err)
+	}
+	if plain, _ := OpenSecret("plain-key", ""); plain != "plain-key" {
+		t.Errorf("Expected plaintext values to pass through, got %q", plain)
+	}
+}
+
+func TestSaveProfileEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outray", "config.json")
+	t.Setenv(EnvConfig, path)
+	t.Setenv(EnvProfile, "")
+
+	if err := SaveProfile("work", Profile{APIKey: "wk", Subdomain: "acme"}, "pass"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), `"wk"`) {
+		t.Error("Expected API key to be encrypted on disk")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected 0600 permissions, got %v", info.Mode().Perm())
+	}
+
+	t.Setenv(EnvPassphrase, "")
+	if _, err := LoadProfile("work"); err == nil {
+		t.Error("Expected loading without a passphrase to fail")
+	}
+	t.Setenv(EnvPassphrase, "pass")
+	if p, err := LoadProfile("work"); err != nil || p.APIKey != "wk" || p.Subdomain != "acme" {
+		t.Errorf("Expected decrypted profile, got %+v (%v)", p, err)
+	}
+}
@@ -0,0 +1,66 @@
+package outray
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type testServer struct {
+	*httptest.Server
+	conns chan *websocket.Conn
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{conns: make(chan *websocket.Conn, 4)}
+	upgrader := websocket.Upgrader{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ts.conns <- conn
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) URL() string {
+	return "ws" + strings.TrimPrefix(ts.Server.URL, "http")
+}
+
+func (ts *testServer) accept(t *testing.T) *websocket.Conn {
+	select {
+	case conn := <-ts.conns:
+		t.Cleanup(func() { conn.Close() })
+		var handshake map[string]interface{}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&handshake); err != nil {
+			t.Fatalf("Failed to read handshake: %v", err)
+		}
+		return conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("Client never connected")
+		return nil
+	}
+}
+
+func connectTestClient(t *testing.T, ts *testServer, opts ...Option) *Client {
+	c := NewClient(append([]Option{WithServerURL(ts.URL())}, opts...)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Connect(ctx)
+	t.Cleanup(cancel)
+	return c
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn, v interface{}) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(v); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+}
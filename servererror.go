@@ -0,0 +1,40 @@
+package outray
+
+import "fmt"
+
+const (
+	ErrCodeAuthExpired    = "AUTH_EXPIRED"
+	ErrCodeInvalidAPIKey  = "INVALID_API_KEY"
+	ErrCodeQuotaExceeded  = "QUOTA_EXCEEDED"
+	ErrCodeRateLimited    = "RATE_LIMITED"
+	ErrCodeSubdomainTaken = "SUBDOMAIN_TAKEN"
+)
+
+// ServerError is an error frame sent by the server. Code is empty for
+// servers that only send a message.
+type ServerError struct {
+	Code      string
+	Message   string
+	Retryable bool
+	Details   map[string]interface{}
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newServerError(msg ServerMessage) *ServerError {
+	return &ServerError{
+		Code:      msg.Code,
+		Message:   msg.Message,
+		Retryable: msg.Retryable,
+		Details:   msg.Details,
+	}
+}
+
+func (e *ServerError) authFailure() bool {
+	return e.Code == ErrCodeAuthExpired || e.Code == ErrCodeInvalidAPIKey
+}
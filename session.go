@@ -0,0 +1,210 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const sessionSaveInterval = 5 * time.Second
+
+// SessionResume asks the server to reattach to a tunnel the client held
+// before it restarted.
+type SessionResume struct {
+	TunnelID string `json:"tunnelId"`
+	Token    string `json:"token"`
+}
+
+// savedSession is the session file's contents.
+type savedSession struct {
+	URL      string        `json:"url,omitempty"`
+	TunnelID string        `json:"tunnelId,omitempty"`
+	Token    string        `json:"sessionToken,omitempty"`
+	Streams  []savedStream `json:"streams,omitempty"`
+	Stats    Stats         `json:"stats"`
+	SavedAt  time.Time     `json:"savedAt"`
+}
+
+type savedStream struct {
+	ID         string `json:"connectionId"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+}
+
+type sessionStore struct {
+	path string
+
+	mu       sync.Mutex
+	prev     savedSession // as loaded at startup
+	tunnelID string
+	token    string
+	saveMu   sync.Mutex // serializes writes to the file
+}
+
+// WithSessionFile keeps the tunnel's session in a file so a restarted
+// process picks up where the last one stopped: it asks for the same URL
+// (and resumes the tunnel outright on servers that issued a session
+// token), continues the Stats counters, and tells the server that streams
+// open at the restart are gone. The file is written every few seconds and
+// on Close.
+func WithSessionFile(path string) Option {
+	return func(c *Client) {
+		c.session = &sessionStore{path: path}
+	}
+}
+
+// restoreSession loads the session file once all options are applied.
+func (c *Client) restoreSession() {
+	if c.session == nil {
+		return
+	}
+	data, err := os.ReadFile(c.session.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var prev savedSession
+	if err == nil {
+		err = json.Unmarshal(data, &prev)
+	}
+	if err != nil {
+		c.configErr = fmt.Errorf("session file: %w", err)
+		return
+	}
+	c.session.prev = prev
+	c.session.tunnelID, c.session.token = prev.TunnelID, prev.Token
+	if c.config.PreferredURL == "" {
+		c.config.PreferredURL = prev.URL
+	}
+
+	s := prev.Stats
+	atomic.AddUint64(&c.stats.requests, s.Requests)
+	atomic.AddUint64(&c.stats.requestErrors, s.RequestErrors)
+	atomic.AddUint64(&c.stats.bytesIn, s.BytesIn)
+	atomic.AddUint64(&c.stats.bytesOut, s.BytesOut)
+	atomic.AddUint64(&c.stats.tcpConnections, s.TCPConnections)
+	atomic.AddUint64(&c.stats.udpPackets, s.UDPPackets)
+	atomic.AddUint64(&c.stats.udpDropped, s.UDPDropped)
+	atomic.AddUint64(&c.stats.reconnects, s.Reconnects)
+	atomic.AddUint64(&c.stats.compressed, s.CompressedResponses)
+	atomic.AddUint64(&c.stats.compressionSkipped, s.CompressionSkipped)
+	atomic.AddUint64(&c.stats.compressionSaved, s.CompressionSaved)
+	atomic.AddUint64(&c.stats.shed, s.Shed)
+	atomic.AddUint64(&c.stats.banned, s.Banned)
+	atomic.AddUint64(&c.stats.throttled, s.Throttled)
+	atomic.AddUint64(&c.stats.checksumFailures, s.ChecksumFailures)
+	if c.dataCap != nil {
+		// Bytes from before the restart are already in the cap's own state.
+		c.dataCap.counted = s.BytesIn + s.BytesOut
+	}
+	c.logf("Restored session for %s from %s", prev.URL, prev.SavedAt.Format(time.RFC3339))
+}
+
+// sessionResume is the handshake's resume request, if there is a session
+// to resume.
+func (c *Client) sessionResume() *SessionResume {
+	if c.session == nil {
+		return nil
+	}
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if c.session.tunnelID == "" || c.session.token == "" {
+		return nil
+	}
+	return &SessionResume{TunnelID: c.session.tunnelID, Token: c.session.token}
+}
+
+// sessionOpened records the session the server assigned. When it is the
+// one saved before a restart, the streams that were open then are
+// reported as lost so the server can close them at once.
+func (c *Client) sessionOpened(msg TunnelOpened) {
+	if c.session == nil {
+		return
+	}
+	s := c.session
+	s.mu.Lock()
+	var lost []savedStream
+	if msg.TunnelID != "" && msg.TunnelID == s.prev.TunnelID {
+		lost = s.prev.Streams
+	}
+	s.prev.Streams = nil
+	s.tunnelID = msg.TunnelID
+	if msg.SessionToken != "" {
+		s.token = msg.SessionToken
+	}
+	s.mu.Unlock()
+
+	for _, stream := range lost {
+		msg := TCPError{
+			Type:         MsgTypeTCPError,
+			ConnectionID: stream.ID,
+			Code:         StreamErrRestarted,
+			Message:      "client restarted",
+		}
+		if err := c.writeJSON(PriorityControl, msg); err != nil {
+			c.logf("Failed to report lost stream %s: %v", stream.ID, err)
+		}
+	}
+	c.saveSession(msg.URL)
+}
+
+func (c *Client) watchSession(ctx context.Context) {
+	ticker := time.NewTicker(sessionSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.saveSession(c.Status().URL)
+		}
+	}
+}
+
+// saveSession writes the session file. Callers must not hold c.mu.
+func (c *Client) saveSession(url string) {
+	if c.session == nil {
+		return
+	}
+	c.tcpConnsMu.Lock()
+	streams := make([]savedStream, 0, len(c.tcpConns))
+	for id, stream := range c.tcpConns {
+		streams = append(streams, savedStream{ID: id, RemoteAddr: stream.remoteAddr})
+	}
+	c.tcpConnsMu.Unlock()
+	sort.Slice(streams, func(i, j int) bool { return streams[i].ID < streams[j].ID })
+
+	s := c.session
+	s.mu.Lock()
+	saved := savedSession{
+		URL:      url,
+		TunnelID: s.tunnelID,
+		Token:    s.token,
+		Streams:  streams,
+		Stats:    c.Stats(),
+		SavedAt:  time.Now(),
+	}
+	if saved.URL == "" {
+		saved.URL = s.prev.URL
+	}
+	s.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		c.logf("Failed to save session: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		c.logf("Failed to save session: %v", err)
+	}
+}
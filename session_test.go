@@ -0,0 +1,79 @@
+package outray
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSessionSaveAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	c := NewClient(WithProtocol("tcp"), WithSessionFile(path))
+	c.closed = true
+	c.publicURL = "tcp://edge.outray.dev:30123"
+	atomic.StoreUint64(&c.stats.requests, 7)
+	atomic.StoreUint64(&c.stats.bytesIn, 1000)
+	c.tcpConns["s1"] = &tcpStream{remoteAddr: "203.0.113.5:4000"}
+	c.sessionOpened(TunnelOpened{URL: c.publicURL, TunnelID: "t1", SessionToken: "tok"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved savedSession
+	json.Unmarshal(data, &saved)
+	if saved.URL != c.publicURL || saved.Token != "tok" || len(saved.Streams) != 1 || saved.Streams[0].RemoteAddr != "203.0.113.5:4000" {
+		t.Fatalf("Unexpected session file: %s", data)
+	}
+
+	c = NewClient(WithProtocol("tcp"), WithSessionFile(path))
+	if c.conf().PreferredURL != "tcp://edge.outray.dev:30123" {
+		t.Errorf("PreferredURL = %q", c.conf().PreferredURL)
+	}
+	if s := c.Stats(); s.Requests != 7 || s.BytesIn != 1000 {
+		t.Errorf("Counters not restored: %+v", s)
+	}
+	if r := c.openTunnelRequest(MsgTypeOpenTunnel).Resume; r == nil || r.TunnelID != "t1" || r.Token != "tok" {
+		t.Errorf("Unexpected resume request: %+v", r)
+	}
+}
+
+func TestSessionReportsLostStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	os.WriteFile(path, []byte(`{"url":"tcp://edge:30123","tunnelId":"t1","sessionToken":"tok","streams":[{"connectionId":"s1"},{"connectionId":"s2"}]}`), 0o600)
+
+	for _, tt := range []struct {
+		tunnelID string
+		lost     int
+	}{
+		{"t1", 2},
+		{"t2", 0},
+	} {
+		var lost []string
+		c := NewClient(WithSessionFile(path), WithMessageTap(func(d Direction, msgType string, payload []byte) {
+			if d == Outbound && msgType == MsgTypeTCPError {
+				var e TCPError
+				json.Unmarshal(payload, &e)
+				if e.Code == StreamErrRestarted {
+					lost = append(lost, e.ConnectionID)
+				}
+			}
+		}))
+		c.closed = true
+		c.sessionOpened(TunnelOpened{URL: "tcp://edge:30123", TunnelID: tt.tunnelID})
+		if len(lost) != tt.lost {
+			t.Errorf("Tunnel %s: reported %v lost", tt.tunnelID, lost)
+		}
+		os.WriteFile(path, []byte(`{"url":"tcp://edge:30123","tunnelId":"t1","sessionToken":"tok","streams":[{"connectionId":"s1"},{"connectionId":"s2"}]}`), 0o600)
+	}
+}
+
+func TestSessionFileCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	os.WriteFile(path, []byte("{"), 0o600)
+	if c := NewClient(WithSessionFile(path)); c.configErr == nil {
+		t.Error("Expected a config error for a corrupt session file")
+	}
+}
@@ -0,0 +1,114 @@
+package outray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MsgTypeCreateShareLink = "create_share_link"
+	MsgTypeShareLink       = "share_link"
+)
+
+// CapShareLinks is advertised in tunnel_opened by servers that mint
+// expiring or shortened share links.
+const CapShareLinks = "share_links"
+
+var (
+	errNotOpen               = errors.New("tunnel is not open")
+	errShareLinksUnsupported = errors.New("server does not support expiring or shortened share links")
+)
+
+type ShareLinkOptions struct {
+	TTL     time.Duration // the link stops working after TTL; zero never expires
+	Shorten bool          // also ask the server for a short URL
+}
+
+// ShareLink is a link to the current tunnel that can be handed to someone
+// else.
+type ShareLink struct {
+	URL      string
+	ShortURL string    // empty unless requested and the server provides one
+	Expires  time.Time // zero if the link doesn't expire
+}
+
+type createShareLink struct {
+	Type       string `json:"type"`
+	RequestID  string `json:"requestId"`
+	TTLSeconds int64  `json:"ttlSeconds,omitempty"`
+	Shorten    bool   `json:"shorten,omitempty"`
+}
+
+type shareLinkReply struct {
+	Type      string     `json:"type"`
+	RequestID string     `json:"requestId"`
+	URL       string     `json:"url"`
+	ShortURL  string     `json:"shortUrl,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// String returns the short URL when there is one.
+func (l ShareLink) String() string {
+	if l.ShortURL != "" {
+		return l.ShortURL
+	}
+	return l.URL
+}
+
+// Mailto returns a mailto: URL with the link in the body, for "share by
+// email" buttons.
+func (l ShareLink) Mailto(subject string) string {
+	body := l.String()
+	if !l.Expires.IsZero() {
+		body += "\n\nThis link expires " + l.Expires.Format(time.RFC1123) + "."
+	}
+	q := url.Values{"subject": {subject}, "body": {body}}
+	// mailto wants %20 for spaces, not the + that Encode produces.
+	return "mailto:?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+// ShareLink returns a link to the open tunnel for sharing. A link with
+// neither TTL nor Shorten is just the public URL; otherwise the server mints
+// one, which requires CapShareLinks. It waits for the server's reply until
+// ctx is done.
+func (c *Client) ShareLink(ctx context.Context, opts ShareLinkOptions) (ShareLink, error) {
+	c.mu.Lock()
+	publicURL := c.publicURL
+	connected := c.conn != nil && !c.closed
+	supported := c.hasCapability(CapShareLinks)
+	c.mu.Unlock()
+
+	if !connected || publicURL == "" {
+		return ShareLink{}, errNotOpen
+	}
+	if opts.TTL <= 0 && !opts.Shorten {
+		return ShareLink{URL: publicURL}, nil
+	}
+	if !supported {
+		return ShareLink{}, errShareLinksUnsupported
+	}
+
+	id := newRequestID()
+	msg := createShareLink{Type: MsgTypeCreateShareLink, RequestID: id, TTLSeconds: int64(opts.TTL.Round(time.Second) / time.Second), Shorten: opts.Shorten}
+	data, err := c.roundTrip(ctx, id, msg)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	var r shareLinkReply
+	if err := json.Unmarshal(data, &r); err != nil {
+		return ShareLink{}, err
+	}
+	if r.Error != "" {
+		return ShareLink{}, errors.New(r.Error)
+	}
+	link := ShareLink{URL: r.URL, ShortURL: r.ShortURL}
+	if r.ExpiresAt != nil {
+		link.Expires = *r.ExpiresAt
+	}
+	return link, nil
+}
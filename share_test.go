@@ -0,0 +1,73 @@
+package outray
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShareLink(t *testing.T) {
+	ts := newTestServer(t)
+	opened := make(chan string, 1)
+	c := connectTestClient(t, ts, WithOnOpen(func(url string) { opened <- url }))
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev", "capabilities": []string{CapShareLinks}})
+	<-opened
+
+	link, err := c.ShareLink(context.Background(), ShareLinkOptions{})
+	if err != nil || link.String() != "https://a.outray.dev" {
+		t.Fatalf("Expected plain public URL, got %+v, %v", link, err)
+	}
+
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	go func() {
+		var req createShareLink
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&req); err != nil {
+			t.Errorf("Failed to read frame: %v", err)
+			return
+		}
+		if req.Type != MsgTypeCreateShareLink || req.TTLSeconds != 3600 || !req.Shorten {
+			t.Errorf("Unexpected create_share_link: %+v", req)
+		}
+		conn.WriteJSON(map[string]interface{}{
+			"type": MsgTypeShareLink, "requestId": req.RequestID,
+			"url": "https://a.outray.dev/?share=xyz", "shortUrl": "https://ory.sh/xyz", "expiresAt": expires,
+		})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	link, err = c.ShareLink(ctx, ShareLinkOptions{TTL: time.Hour, Shorten: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.String() != "https://ory.sh/xyz" || link.URL != "https://a.outray.dev/?share=xyz" || !link.Expires.Equal(expires) {
+		t.Errorf("Unexpected share link: %+v", link)
+	}
+
+	mailto := link.Mailto("Preview build")
+	if !strings.HasPrefix(mailto, "mailto:?") || !strings.Contains(mailto, "subject=Preview%20build") || !strings.Contains(mailto, "https%3A%2F%2Fory.sh%2Fxyz") {
+		t.Errorf("Unexpected mailto: %s", mailto)
+	}
+}
+
+func TestShareLinkUnsupported(t *testing.T) {
+	c := NewClient()
+	if _, err := c.ShareLink(context.Background(), ShareLinkOptions{}); err != errNotOpen {
+		t.Errorf("Expected errNotOpen before connecting, got %v", err)
+	}
+
+	ts := newTestServer(t)
+	opened := make(chan string, 1)
+	c = connectTestClient(t, ts, WithOnOpen(func(url string) { opened <- url }))
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev"})
+	<-opened
+
+	if _, err := c.ShareLink(context.Background(), ShareLinkOptions{TTL: time.Hour}); err != errShareLinksUnsupported {
+		t.Errorf("Expected errShareLinksUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package outray
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeAckTimeout bounds the close handshake when ctx has no deadline.
+const closeAckTimeout = 5 * time.Second
+
+// CloseWithContext sends a WebSocket close frame and waits for the server to
+// acknowledge it before tearing the connection down, so the tunnel slot is
+// released immediately rather than after a server-side timeout. If ctx ends
+// first the connection is force-closed and ctx.Err() returned. Unlike Close,
+// it also stops Connect from reconnecting.
+func (c *Client) CloseWithContext(ctx context.Context) error {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+
+	c.mu.Lock()
+	conn, w, done, closed := c.conn, c.writer, c.connDone, c.closed
+	c.mu.Unlock()
+	if closed || conn == nil {
+		return c.Close()
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(closeAckTimeout)
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "client closing")
+	if err := w.writeControl(websocket.CloseMessage, msg, deadline); err != nil {
+		c.Close()
+		return err
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return c.Close()
+	case <-timer.C:
+		c.Close()
+		return context.DeadlineExceeded
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until Connect has returned and every goroutine the client
+// started (reader, writer, keepalive, TCP pumps, UDP and HTTP handlers) has
+// exited. Call it after CloseWithContext or after cancelling the context
+// passed to Connect; Close alone lets Connect reconnect.
+func (c *Client) Wait() {
+	c.wg.Wait()
+}
+
+func (c *Client) stopping() bool {
+	return atomic.LoadInt32(&c.shuttingDown) == 1
+}
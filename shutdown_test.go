@@ -0,0 +1,81 @@
+package outray
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCloseWithContext(t *testing.T) {
+	ts := newTestServer(t)
+	opened := make(chan string, 1)
+	c := NewClient(WithServerURL(ts.URL()), WithOnOpen(func(url string) { opened <- url }))
+	exited := make(chan error, 1)
+	go func() { exited <- c.Connect(context.Background()) }()
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev"})
+	<-opened
+
+	codes := make(chan int, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); ok {
+			codes <- ce.Code
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.CloseWithContext(ctx); err != nil {
+		t.Fatalf("Expected clean close, got %v", err)
+	}
+	if code := <-codes; code != websocket.CloseNormalClosure {
+		t.Errorf("Expected normal closure frame, got %d", code)
+	}
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Errorf("Expected Connect to return nil, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connect kept running after CloseWithContext")
+	}
+}
+
+func TestWait(t *testing.T) {
+	ts := newTestServer(t)
+	opened := make(chan string, 1)
+	c := NewClient(
+		WithServerURL(ts.URL()),
+		WithStreamPriorities(map[Priority]int{PriorityHTTP: 2}),
+		WithOnOpen(func(url string) { opened <- url }),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Connect(ctx)
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://a.outray.dev"})
+	<-opened
+	if n := c.Stats().Goroutines; n == 0 {
+		t.Error("Expected background goroutines while connected")
+	}
+
+	cancel()
+	waited := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after cancel")
+	}
+	if n := c.Stats().Goroutines; n != 0 {
+		t.Errorf("Expected no goroutines after Wait, got %d", n)
+	}
+}
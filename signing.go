@@ -0,0 +1,133 @@
+package outray
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	errBadSignature  = errors.New("frame signature missing or invalid")
+	errReplayedFrame = errors.New("frame counter did not increase; replayed frame")
+)
+
+var (
+	ctrField = []byte(`,"ctr":`)
+	sigField = []byte(`,"sig":"`)
+)
+
+func WithFrameSigning(secret []byte) Option {
+	return func(c *Client) {
+		c.config.FrameSecret = secret
+	}
+}
+
+// frameMAC is the HMAC-SHA256 of body on the connection identified by
+// nonce.
+func (c *Client) frameMAC(nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, c.conf().FrameSecret)
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{0})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// newWriter returns the frame writer for conn, signing frames for fs if
+// frame signing is on.
+func (c *Client) newWriter(conn *websocket.Conn, fs *frameSession) *frameWriter {
+	w := newFrameWriter(conn, c.conf().PriorityWeights)
+	if len(c.conf().FrameSecret) > 0 {
+		w.sign = func(data []byte, ctr uint64) []byte { return c.signFrame(data, fs.nonce, ctr) }
+	}
+	return w
+}
+
+// frameSession is one connection's signing state. The client picks nonce
+// when it opens the connection and sends it in the handshake; every MAC on
+// the connection covers it, so a frame captured on one connection doesn't
+// verify on any other. last is the highest inbound counter seen.
+type frameSession struct {
+	nonce string
+	last  uint64
+}
+
+// newFrameSession returns the signing state for a new connection. Its
+// nonce is empty when frame signing is off.
+func (c *Client) newFrameSession() *frameSession {
+	fs := &frameSession{}
+	if len(c.conf().FrameSecret) > 0 {
+		b := make([]byte, 16)
+		rand.Read(b)
+		fs.nonce = hex.EncodeToString(b)
+	}
+	return fs
+}
+
+// signFrame appends a "ctr" field holding ctr, the frame's position on its
+// connection counting from 1, and a "sig" field holding the hex frameMAC,
+// under the connection's nonce, of the frame with ctr but before sig was
+// added. Frames are signed as they are written, so the counter always
+// increases on the wire.
+func (c *Client) signFrame(data []byte, nonce string, ctr uint64) []byte {
+	if len(c.conf().FrameSecret) == 0 || len(data) < 2 || data[len(data)-1] != '}' {
+		return data
+	}
+	body := make([]byte, 0, len(data)+len(ctrField)+20)
+	body = append(body, data[:len(data)-1]...)
+	body = append(body, ctrField...)
+	body = strconv.AppendUint(body, ctr, 10)
+	sig := hex.EncodeToString(c.frameMAC(nonce, append(body, '}')))
+
+	out := make([]byte, 0, len(body)+len(sigField)+len(sig)+2)
+	out = append(out, body...)
+	out = append(out, sigField...)
+	out = append(out, sig...)
+	out = append(out, '"', '}')
+	return out
+}
+
+// verifyFrame checks a frame's signature under fs's nonce and that its
+// counter is above the last one seen on the connection, returning the frame
+// without the ctr and sig fields.
+func (c *Client) verifyFrame(data []byte, fs *frameSession) ([]byte, error) {
+	if len(c.conf().FrameSecret) == 0 {
+		return data, nil
+	}
+
+	data = bytes.TrimSpace(data)
+	idx := bytes.LastIndex(data, sigField)
+	if idx < 0 || !bytes.HasSuffix(data, []byte(`"}`)) {
+		return nil, errBadSignature
+	}
+	sig, err := hex.DecodeString(string(data[idx+len(sigField) : len(data)-2]))
+	if err != nil {
+		return nil, errBadSignature
+	}
+
+	body := make([]byte, 0, idx+1)
+	body = append(body, data[:idx]...)
+	body = append(body, '}')
+	if !hmac.Equal(sig, c.frameMAC(fs.nonce, body)) {
+		return nil, errBadSignature
+	}
+
+	cidx := bytes.LastIndex(body, ctrField)
+	if cidx < 0 {
+		return nil, errBadSignature
+	}
+	ctr, err := strconv.ParseUint(string(body[cidx+len(ctrField):len(body)-1]), 10, 64)
+	if err != nil {
+		return nil, errBadSignature
+	}
+	if ctr <= fs.last {
+		return nil, errReplayedFrame
+	}
+	fs.last = ctr
+	return append(body[:cidx], '}'), nil
+}
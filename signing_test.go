@@ -0,0 +1,144 @@
+package outray
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFrameSigning(t *testing.T) {
+	c := NewClient(WithFrameSigning([]byte("secret")))
+	conn := func() *frameSession { return &frameSession{nonce: "conn-1"} }
+
+	signed := c.signFrame([]byte(`{"type":"tcp_data","data":"aGk="}`), "conn-1", 1)
+	body, err := c.verifyFrame(signed, conn())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"type":"tcp_data","data":"aGk="}` {
+		t.Errorf("Unexpected verified body: %s", body)
+	}
+
+	if _, err := c.verifyFrame([]byte(`{"type":"tcp_data","data":"aGk="}`), conn()); err == nil {
+		t.Error("Expected unsigned frame to be rejected")
+	}
+
+	tampered := append([]byte(`{"type":"tcp_data","data":"aGV5"`), signed[len(`{"type":"tcp_data","data":"aGk="`):]...)
+	if _, err := c.verifyFrame(tampered, conn()); err == nil {
+		t.Error("Expected tampered frame to be rejected")
+	}
+
+	bumped := []byte(strings.Replace(string(signed), `"ctr":1`, `"ctr":2`, 1))
+	if _, err := c.verifyFrame(bumped, conn()); err == nil {
+		t.Error("Expected the counter to be covered by the signature")
+	}
+}
+
+func TestFrameSigningReplay(t *testing.T) {
+	c := NewClient(WithFrameSigning([]byte("secret")))
+	frame := []byte(`{"type":"request_cancel","requestId":"r1"}`)
+
+	fs := &frameSession{nonce: "conn-1"}
+	first, third := c.signFrame(frame, "conn-1", 1), c.signFrame(frame, "conn-1", 3)
+	if _, err := c.verifyFrame(first, fs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.verifyFrame(first, fs); !errors.Is(err, errReplayedFrame) {
+		t.Errorf("Expected a replayed frame to be rejected, got %v", err)
+	}
+	if _, err := c.verifyFrame(third, fs); err != nil {
+		t.Errorf("Expected a later counter to be accepted, got %v", err)
+	}
+	if _, err := c.verifyFrame(c.signFrame(frame, "conn-1", 2), fs); !errors.Is(err, errReplayedFrame) {
+		t.Errorf("Expected an older counter to be rejected, got %v", err)
+	}
+
+	// Another connection counts from 1 again, but under its own nonce.
+	next := &frameSession{nonce: "conn-2"}
+	if _, err := c.verifyFrame(first, next); !errors.Is(err, errBadSignature) {
+		t.Errorf("Expected a frame from another connection to be rejected, got %v", err)
+	}
+	if _, err := c.verifyFrame(c.signFrame(frame, "conn-2", 1), next); err != nil {
+		t.Errorf("Expected the new connection's own frames to verify, got %v", err)
+	}
+}
+
+func TestFrameSigningOnWire(t *testing.T) {
+	ts := newTestServer(t)
+	c := connectTestClient(t, ts, WithFrameSigning([]byte("secret")))
+	conn := <-ts.conns
+	defer conn.Close()
+
+	var handshake OpenTunnelRequest
+	readFrame(t, conn, &handshake)
+	if handshake.FrameNonce == "" {
+		t.Fatal("Expected the handshake to carry a frame nonce")
+	}
+	fs := &frameSession{nonce: handshake.FrameNonce, last: 1}
+	for range 2 {
+		c.writeJSON(PriorityControl, RequestCancel{Type: MsgTypeRequestCancel, ID: "r1"})
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.verifyFrame(data, fs); err != nil {
+			t.Fatalf("Expected frames numbered in order on the wire: %v in %s", err, data)
+		}
+	}
+}
+
+func TestFrameSigningAcrossConnections(t *testing.T) {
+	ts := newTestServer(t)
+	opened := make(chan string, 4)
+	errs := make(chan error, 4)
+	c := connectTestClient(t, ts,
+		WithFrameSigning([]byte("secret")),
+		WithOnOpen(func(url string) { opened <- url }),
+		WithOnError(func(err error) { errs <- err }),
+	)
+	server := NewClient(WithFrameSigning([]byte("secret")))
+
+	conn := <-ts.conns
+	defer conn.Close()
+	var first OpenTunnelRequest
+	readFrame(t, conn, &first)
+	captured := server.signFrame([]byte(`{"type":"tunnel_opened","url":"https://a.outray.dev"}`), first.FrameNonce, 1)
+	conn.WriteMessage(websocket.TextMessage, captured)
+	<-opened
+
+	// A settings change reconnects; the frame captured on the first
+	// connection is then replayed on the second.
+	c.Reconfigure(WithSubdomain("other"))
+	next := <-ts.conns
+	defer next.Close()
+	var second OpenTunnelRequest
+	readFrame(t, next, &second)
+	if second.FrameNonce == "" || second.FrameNonce == first.FrameNonce {
+		t.Fatalf("Expected a fresh frame nonce per connection, got %q then %q", first.FrameNonce, second.FrameNonce)
+	}
+	next.WriteMessage(websocket.TextMessage, captured)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errBadSignature) {
+			t.Errorf("Expected the replayed frame to fail verification, got %v", err)
+		}
+	case url := <-opened:
+		t.Fatalf("Replayed frame from the first connection was accepted: %s", url)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Replayed frame was neither accepted nor rejected")
+	}
+
+	next.WriteMessage(websocket.TextMessage, server.signFrame([]byte(`{"type":"tunnel_opened","url":"https://b.outray.dev"}`), second.FrameNonce, 1))
+	select {
+	case url := <-opened:
+		if url != "https://b.outray.dev" {
+			t.Errorf("Unexpected URL %q", url)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Frame signed for the second connection was not accepted")
+	}
+}
@@ -0,0 +1,55 @@
+package outray
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	WarnSlowRequest  = "SLOW_REQUEST"
+	WarnLargePayload = "LARGE_PAYLOAD"
+)
+
+// WithRequestWarnings raises a warning through OnWarning (and the logger)
+// whenever an exchange takes longer than latency or either body is larger
+// than size bytes. Zero disables a check.
+func WithRequestWarnings(latency time.Duration, size int) Option {
+	return func(c *Client) {
+		c.config.SlowRequest = latency
+		c.config.LargePayload = size
+	}
+}
+
+func (c *Client) checkRequestLimits(req IncomingRequest, resp IncomingResponse, latency time.Duration) {
+	details := func() map[string]interface{} {
+		return map[string]interface{}{
+			"requestId": req.ID,
+			"method":    req.Method,
+			"path":      req.Path,
+			"status":    resp.StatusCode,
+		}
+	}
+
+	if limit := c.conf().SlowRequest; limit > 0 && latency > limit {
+		d := details()
+		d["latencyMs"] = latency.Milliseconds()
+		c.handleWarning(Warning{
+			Type:    MsgTypeWarning,
+			Code:    WarnSlowRequest,
+			Message: fmt.Sprintf("%s %s took %s (limit %s)", req.Method, req.Path, latency.Round(time.Millisecond), limit),
+			Details: d,
+		})
+	}
+
+	if limit := c.conf().LargePayload; limit > 0 && (len(req.Body) > limit || len(resp.Body) > limit) {
+		d := details()
+		d["requestBytes"] = len(req.Body)
+		d["responseBytes"] = len(resp.Body)
+		c.handleWarning(Warning{
+			Type:    MsgTypeWarning,
+			Code:    WarnLargePayload,
+			Message: fmt.Sprintf("%s %s moved %d request / %d response bytes (limit %d)", req.Method, req.Path, len(req.Body), len(resp.Body), limit),
+			Details: d,
+		})
+	}
+}
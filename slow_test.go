@@ -0,0 +1,25 @@
+package outray
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestWarnings(t *testing.T) {
+	var codes []string
+	c := NewClient(
+		WithRequestWarnings(100*time.Millisecond, 10),
+		WithOnWarning(func(w Warning) { codes = append(codes, w.Code) }),
+	)
+
+	req := IncomingRequest{ID: "r1", Method: "POST", Path: "/upload", Body: []byte("small")}
+	c.checkRequestLimits(req, IncomingResponse{StatusCode: 200}, 10*time.Millisecond)
+	if len(codes) != 0 {
+		t.Fatalf("Expected no warnings under limits, got %v", codes)
+	}
+
+	c.checkRequestLimits(req, IncomingResponse{StatusCode: 200, Body: make([]byte, 64)}, 250*time.Millisecond)
+	if len(codes) != 2 || codes[0] != WarnSlowRequest || codes[1] != WarnLargePayload {
+		t.Errorf("Expected slow and large warnings, got %v", codes)
+	}
+}
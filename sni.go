@@ -0,0 +1,137 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clientHelloTimeout bounds how long a routed stream may take to send its
+// ClientHello.
+const clientHelloTimeout = 10 * time.Second
+
+// recordTypeHandshake starts every TLS ClientHello; streams that begin
+// with anything else are routed straight to the default upstream.
+const recordTypeHandshake = 0x16
+
+var errHelloRead = errors.New("client hello read")
+
+// WithSNIRoute sends TCP streams whose TLS ClientHello names host to the
+// local port instead of the default upstream, so one tunnel can front
+// several local HTTPS services. host may be a wildcard such as
+// "*.dev.local". Streams stay encrypted end to end unless
+// WithTLSTermination is also set, in which case the decrypted stream is
+// routed the same way.
+func WithSNIRoute(host string, port int) Option {
+	return func(c *Client) {
+		if c.config.SNIRoutes == nil {
+			c.config.SNIRoutes = make(map[string]int)
+		}
+		c.config.SNIRoutes[strings.ToLower(host)] = port
+	}
+}
+
+// sniPort finds the route for name, preferring an exact match over the
+// closest wildcard.
+func (c *Client) sniPort(name string) (int, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return 0, false
+	}
+	if port, ok := c.conf().SNIRoutes[name]; ok {
+		return port, true
+	}
+	for rest := name; ; {
+		_, parent, ok := strings.Cut(rest, ".")
+		if !ok {
+			return 0, false
+		}
+		if port, ok := c.conf().SNIRoutes["*."+parent]; ok {
+			return port, true
+		}
+		rest = parent
+	}
+}
+
+// dialTLSRoute dials the routed port for info, or the default upstream.
+func (c *Client) dialTLSRoute(info TLSInfo) (net.Conn, error) {
+	port, ok := c.tlsRoute(info)
+	if !ok {
+		return c.dialUpstream("tcp")
+	}
+	return c.dialUpstreamContext(context.Background(), "tcp", c.loopbackAddr(port))
+}
+
+// passthroughTLS returns the tunnel side of a stream that is routed by its
+// ClientHello and then relayed untouched, hello included.
+func (c *Client) passthroughTLS(msg TCPConnection, epoch uint64) net.Conn {
+	connID := msg.ID
+	tunnelSide, inner := net.Pipe()
+	c.spawn(func() {
+		defer inner.Close()
+		inner.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+		info, hello := readClientHello(inner)
+		inner.SetReadDeadline(time.Time{})
+		info.ConnectionID = connID
+		c.reportTLS(info)
+
+		upstream, err := c.dialTCP(msg.RemoteAddr, info)
+		if err != nil {
+			c.reportTCPError(epoch, connID, StreamErrDial, err)
+			c.handleTCPClose(connID)
+			return
+		}
+		defer upstream.Close()
+		if _, err := upstream.Write(hello); err != nil {
+			return
+		}
+		relay(inner, upstream)
+	})
+	return tunnelSide
+}
+
+// readClientHello reads a TLS ClientHello from conn and returns the server
+// name and ALPN protocols it offers along with every byte consumed. Both
+// are empty for anything that isn't TLS.
+func readClientHello(conn net.Conn) (TLSInfo, []byte) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		return TLSInfo{}, nil
+	}
+	if first[0] != recordTypeHandshake {
+		return TLSInfo{}, first
+	}
+
+	var buf bytes.Buffer
+	buf.Write(first)
+	var info TLSInfo
+	r := io.MultiReader(bytes.NewReader(first), io.TeeReader(conn, &buf))
+	tls.Server(helloConn{r: r}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			info.ServerName = hello.ServerName
+			info.ALPN = hello.SupportedProtos
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	return info, buf.Bytes()
+}
+
+// helloConn lets crypto/tls parse a ClientHello without anything it writes
+// (such as the alert after errHelloRead) reaching the peer.
+type helloConn struct {
+	r io.Reader
+}
+
+func (hc helloConn) Read(p []byte) (int, error)         { return hc.r.Read(p) }
+func (hc helloConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (hc helloConn) Close() error                       { return nil }
+func (hc helloConn) LocalAddr() net.Addr                { return tunnelAddr("tunnel") }
+func (hc helloConn) RemoteAddr() net.Addr               { return tunnelAddr("tunnel") }
+func (hc helloConn) SetDeadline(t time.Time) error      { return nil }
+func (hc helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (hc helloConn) SetWriteDeadline(t time.Time) error { return nil }
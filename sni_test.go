@@ -0,0 +1,94 @@
+package outray
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// namedTLSServer answers each TLS connection with its own name.
+func namedTLSServer(t *testing.T, name string, pool *x509.CertPool) int {
+	cert, _ := testCertificate(t, name)
+	pool.AddCert(cert.Leaf)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte(name))
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestSNIRouting(t *testing.T) {
+	pool := x509.NewCertPool()
+	apiPort := namedTLSServer(t, "api.dev.local", pool)
+	webPort := namedTLSServer(t, "web.dev.local", pool)
+	fallback := ackServer(t)
+
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithIPFamily(IPv4Only),
+		WithUpstreamFallback(fallback.Addr().String()),
+		WithSNIRoute("api.dev.local", apiPort),
+		WithSNIRoute("*.dev.local", webPort),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	for i, tt := range []struct{ serverName, want string }{
+		{"api.dev.local", "api.dev.local"},
+		{"WEB.dev.local", "web.dev.local"},
+	} {
+		conn := tls.Client(relay.open(c, "conn-"+strconv.Itoa(i)), &tls.Config{ServerName: tt.serverName, RootCAs: pool})
+		got, err := io.ReadAll(conn)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("SNI %s: expected %s, got %q (%v)", tt.serverName, tt.want, got, err)
+		}
+		conn.Close()
+	}
+
+	// Anything that isn't TLS goes to the default upstream, untouched.
+	conn := relay.open(c, "plain")
+	conn.Write([]byte("ping"))
+	if got, _ := io.ReadAll(conn); string(got) != "ack:ping" {
+		t.Errorf("Expected non-TLS stream on the default upstream, got %q", got)
+	}
+	conn.Close()
+}
+
+func TestSNIPort(t *testing.T) {
+	c := NewClient(WithSNIRoute("api.dev.local", 1), WithSNIRoute("*.dev.local", 2), WithSNIRoute("*.local", 3))
+	tests := []struct {
+		name string
+		port int
+		ok   bool
+	}{
+		{"api.dev.local", 1, true},
+		{"API.dev.local.", 1, true},
+		{"web.dev.local", 2, true},
+		{"a.b.dev.local", 2, true},
+		{"other.local", 3, true},
+		{"dev.local", 3, true},
+		{"example.com", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		if port, ok := c.sniPort(tt.name); port != tt.port || ok != tt.ok {
+			t.Errorf("sniPort(%q) = %d, %v; want %d, %v", tt.name, port, ok, tt.port, tt.ok)
+		}
+	}
+}
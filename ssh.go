@@ -0,0 +1,189 @@
+package outray
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// CapSSHKeyGating is advertised in tunnel_opened by servers that check a
+// visitor's SSH public key against SSHAuth before forwarding the stream.
+const CapSSHKeyGating = "ssh_key_gating"
+
+// WarnSSHKeyGatingUnsupported is raised locally when authorized keys were
+// given but the server did not advertise support for them. SSH streams
+// are refused until a server that does is reached.
+const WarnSSHKeyGatingUnsupported = "SSH_KEY_GATING_UNSUPPORTED"
+
+// SSHOptions configures WithSSH.
+type SSHOptions struct {
+	Port int    // local SSH server port; 22 if zero
+	User string // login shown in the printed command
+
+	// AuthorizedKeys holds authorized_keys lines. When set, the edge only
+	// forwards visitors that authenticate with one of these keys. Options
+	// such as from="..." are not sent and not enforced.
+	AuthorizedKeys []byte
+
+	// Output receives the ssh command each time the tunnel opens at a new
+	// address; os.Stdout if nil. Use io.Discard to silence it.
+	Output io.Writer
+}
+
+// SSHAuth is the SSH key policy sent in the handshake.
+type SSHAuth struct {
+	AuthorizedKeys []string `json:"authorizedKeys"` // "ssh-ed25519 AAAA..."
+}
+
+// WithSSH exposes a local SSH server over a TCP tunnel. It prints a
+// ready-to-paste ssh command when the tunnel opens, applies the
+// Interactive latency profile, and optionally asks the edge to gate
+// visitors by public key. Later WithLatencyProfile, WithKeepAlive or
+// WithPort options still take precedence.
+func WithSSH(opts SSHOptions) Option {
+	return func(c *Client) {
+		if opts.Port == 0 {
+			opts.Port = 22
+		}
+		c.config.Protocol = "tcp"
+		c.config.Port = opts.Port
+		WithLatencyProfile(Interactive)(c)
+		c.config.SSH = &opts
+		c.config.SSHAuth = nil
+		if len(opts.AuthorizedKeys) > 0 {
+			keys, err := parseAuthorizedKeys(opts.AuthorizedKeys)
+			if err != nil {
+				c.configErr = err
+				return
+			}
+			c.config.SSHAuth = &SSHAuth{AuthorizedKeys: keys}
+		}
+	}
+}
+
+// SSHCommand returns the ssh command for the open tunnel, or "" before it
+// opens or when it isn't a TCP tunnel.
+func (c *Client) SSHCommand() string {
+	var user string
+	if c.conf().SSH != nil {
+		user = c.conf().SSH.User
+	}
+	return sshCommand(c.Status().URL, user)
+}
+
+func sshCommand(tunnelURL, user string) string {
+	u, err := url.Parse(tunnelURL)
+	if err != nil || u.Scheme != "tcp" || u.Port() == "" {
+		return ""
+	}
+	host := u.Hostname()
+	if user != "" {
+		host = user + "@" + host
+	}
+	return fmt.Sprintf("ssh -p %s %s", u.Port(), host)
+}
+
+// announceSSH prints the ssh command when the tunnel opens at an address
+// it hasn't printed before.
+func (c *Client) announceSSH(tunnelURL string) {
+	opts := c.conf().SSH
+	if opts == nil {
+		return
+	}
+	cmd := sshCommand(tunnelURL, opts.User)
+	if cmd == "" || cmd == c.sshAnnounced {
+		return
+	}
+	c.sshAnnounced = cmd
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, "SSH ready: %s\n", cmd)
+	if auth := c.conf().SSHAuth; auth != nil {
+		for _, key := range auth.AuthorizedKeys {
+			c.logf("SSH key allowed: %s", sshFingerprint(key))
+		}
+	}
+}
+
+func (c *Client) checkSSHKeyGating() {
+	if c.conf().SSHAuth == nil || c.hasCapability(CapSSHKeyGating) {
+		return
+	}
+	c.handleWarning(Warning{
+		Type:    MsgTypeWarning,
+		Code:    WarnSSHKeyGatingUnsupported,
+		Message: "server did not confirm SSH key gating; refusing SSH connections",
+	})
+}
+
+// sshUngated reports whether a stream must be refused because key gating
+// was asked for but the server can't enforce it. The SSH handshake is
+// encrypted before any key is offered, so the client can't check it.
+func (c *Client) sshUngated() bool {
+	if c.conf().SSHAuth == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.hasCapability(CapSSHKeyGating)
+}
+
+// parseAuthorizedKeys returns each key in authorized_keys format as
+// "type base64", without options or comments.
+func parseAuthorizedKeys(data []byte) ([]string, error) {
+	var keys []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := parseAuthorizedKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("authorized keys line %d: %w", line, err)
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("authorized keys: no keys found")
+	}
+	return keys, nil
+}
+
+func parseAuthorizedKey(line string) (string, error) {
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		typ := fields[i]
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil || len(blob) < 4 {
+			continue
+		}
+		n := binary.BigEndian.Uint32(blob)
+		if uint64(n) > uint64(len(blob)-4) || string(blob[4:4+n]) != typ {
+			continue
+		}
+		return typ + " " + fields[i+1], nil
+	}
+	return "", fmt.Errorf("no public key found")
+}
+
+// sshFingerprint is the SHA256 fingerprint ssh-keygen -l shows for a key
+// returned by parseAuthorizedKeys.
+func sshFingerprint(key string) string {
+	_, b64, _ := strings.Cut(key, " ")
+	blob, _ := base64.StdEncoding.DecodeString(b64)
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,96 @@
+package outray
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func testSSHKey(t *testing.T) string {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := binary.BigEndian.AppendUint32(nil, 11)
+	blob = append(blob, "ssh-ed25519"...)
+	blob = binary.BigEndian.AppendUint32(blob, uint32(len(pub)))
+	blob = append(blob, pub...)
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob)
+}
+
+func TestSSHCommand(t *testing.T) {
+	for _, tc := range []struct{ url, user, want string }{
+		{"tcp://a.outray.dev:20022", "", "ssh -p 20022 a.outray.dev"},
+		{"tcp://a.outray.dev:20022", "deploy", "ssh -p 20022 deploy@a.outray.dev"},
+		{"https://a.outray.dev", "", ""},
+	} {
+		if got := sshCommand(tc.url, tc.user); got != tc.want {
+			t.Errorf("sshCommand(%q, %q) = %q, want %q", tc.url, tc.user, got, tc.want)
+		}
+	}
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	key := testSSHKey(t)
+	data := "# laptop\n" + key + " alice@laptop\n\nfrom=\"10.0.0.0/8\",no-pty " + key + "\n"
+	keys, err := parseAuthorizedKeys([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != key || keys[1] != key {
+		t.Errorf("Expected the key twice without options or comments, got %q", keys)
+	}
+	if fp := sshFingerprint(key); !strings.HasPrefix(fp, "SHA256:") || len(fp) != 50 {
+		t.Errorf("Unexpected fingerprint %q", fp)
+	}
+
+	if _, err := parseAuthorizedKeys([]byte(key + "\nssh-rsa notbase64\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error for line 2, got %v", err)
+	}
+	if _, err := parseAuthorizedKeys([]byte("# empty\n")); err == nil {
+		t.Error("Expected an error for a file without keys")
+	}
+}
+
+func TestSSHMode(t *testing.T) {
+	var out bytes.Buffer
+	var warnings []string
+	key := testSSHKey(t)
+	c := NewClient(
+		WithSSH(SSHOptions{User: "deploy", AuthorizedKeys: []byte(key), Output: &out}),
+		WithOnWarning(func(w Warning) { warnings = append(warnings, w.Code) }),
+	)
+	c.closed = true
+
+	if c.conf().Protocol != "tcp" || c.conf().Port != 22 || c.conf().LatencyProfile != Interactive {
+		t.Errorf("Unexpected SSH config: %+v", *c.conf())
+	}
+	if h := c.openTunnelRequest(MsgTypeOpenTunnel); h.SSHAuth == nil || len(h.SSHAuth.AuthorizedKeys) != 1 {
+		t.Errorf("Expected authorized keys in handshake, got %+v", h.SSHAuth)
+	}
+
+	opened := []byte(`{"type":"tunnel_opened","url":"tcp://a.outray.dev:20022"}`)
+	c.handleMessage(opened, 0)
+	c.handleMessage(opened, 0)
+	if got := out.String(); got != "SSH ready: ssh -p 20022 deploy@a.outray.dev\n" {
+		t.Errorf("Expected the command printed once, got %q", got)
+	}
+	if c.SSHCommand() != "ssh -p 20022 deploy@a.outray.dev" {
+		t.Errorf("Unexpected SSHCommand %q", c.SSHCommand())
+	}
+	if len(warnings) == 0 || warnings[0] != WarnSSHKeyGatingUnsupported || !c.sshUngated() {
+		t.Errorf("Expected streams refused without server support, got warnings %v", warnings)
+	}
+
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"tcp://a.outray.dev:20022","capabilities":["ssh_key_gating"]}`), 0)
+	if c.sshUngated() {
+		t.Error("Expected streams allowed once the server gates keys")
+	}
+
+	if err := NewClient(WithSSH(SSHOptions{AuthorizedKeys: []byte("garbage")})).configErr; err == nil {
+		t.Error("Expected invalid authorized keys to be rejected")
+	}
+}
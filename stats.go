@@ -0,0 +1,127 @@
+package outray
+
+import "sync/atomic"
+
+type Stats struct {
+	Requests       uint64
+	RequestErrors  uint64
+	BytesIn        uint64
+	BytesOut       uint64
+	TCPConnections uint64
+	ActiveTCP      int64
+	UDPPackets     uint64
+	UDPDropped     uint64
+	Reconnects     uint64
+
+	CompressedResponses uint64
+	CompressionSkipped  uint64
+	CompressionSaved    uint64
+
+	Goroutines     int64
+	PendingWrites  int64
+	BufferedBytes  int64
+	UDPSessions    int
+	ActiveRequests int
+	Shed           uint64
+	Banned         uint64
+	Throttled      uint64
+	QueuedRequests int
+
+	ChecksumFailures uint64
+}
+
+type stats struct {
+	requests       uint64
+	requestErrors  uint64
+	bytesIn        uint64
+	bytesOut       uint64
+	tcpConnections uint64
+	udpPackets     uint64
+	udpDropped     uint64
+	reconnects     uint64
+
+	compressed         uint64
+	compressionSkipped uint64
+	compressionSaved   uint64
+
+	shed      uint64
+	banned    uint64
+	throttled uint64
+
+	checksumFailures uint64
+}
+
+func (c *Client) Stats() Stats {
+	udpSessions := c.activeUDPSessions()
+
+	return Stats{
+		Requests:       atomic.LoadUint64(&c.stats.requests),
+		RequestErrors:  atomic.LoadUint64(&c.stats.requestErrors),
+		BytesIn:        atomic.LoadUint64(&c.stats.bytesIn),
+		BytesOut:       atomic.LoadUint64(&c.stats.bytesOut),
+		TCPConnections: atomic.LoadUint64(&c.stats.tcpConnections),
+		ActiveTCP:      atomic.LoadInt64(&c.tcpActive),
+		UDPPackets:     atomic.LoadUint64(&c.stats.udpPackets),
+		UDPDropped:     atomic.LoadUint64(&c.stats.udpDropped),
+		Reconnects:     atomic.LoadUint64(&c.stats.reconnects),
+
+		CompressedResponses: atomic.LoadUint64(&c.stats.compressed),
+		CompressionSkipped:  atomic.LoadUint64(&c.stats.compressionSkipped),
+		CompressionSaved:    atomic.LoadUint64(&c.stats.compressionSaved),
+
+		Goroutines:     atomic.LoadInt64(&c.goroutines),
+		PendingWrites:  atomic.LoadInt64(&c.pendingWrites),
+		BufferedBytes:  atomic.LoadInt64(&c.bufferedBytes),
+		UDPSessions:    udpSessions,
+		ActiveRequests: c.activeRequests(),
+		Shed:           atomic.LoadUint64(&c.stats.shed),
+		Banned:         atomic.LoadUint64(&c.stats.banned),
+		Throttled:      atomic.LoadUint64(&c.stats.throttled),
+		QueuedRequests: c.queuedRequests(),
+
+		ChecksumFailures: atomic.LoadUint64(&c.stats.checksumFailures),
+	}
+}
+
+// add sums o into s, for Group.Stats. Every field is a count or a gauge,
+// so all of them add up.
+func (s *Stats) add(o Stats) {
+	s.Requests += o.Requests
+	s.RequestErrors += o.RequestErrors
+	s.BytesIn += o.BytesIn
+	s.BytesOut += o.BytesOut
+	s.TCPConnections += o.TCPConnections
+	s.ActiveTCP += o.ActiveTCP
+	s.UDPPackets += o.UDPPackets
+	s.UDPDropped += o.UDPDropped
+	s.Reconnects += o.Reconnects
+
+	s.CompressedResponses += o.CompressedResponses
+	s.CompressionSkipped += o.CompressionSkipped
+	s.CompressionSaved += o.CompressionSaved
+
+	s.Goroutines += o.Goroutines
+	s.PendingWrites += o.PendingWrites
+	s.BufferedBytes += o.BufferedBytes
+	s.UDPSessions += o.UDPSessions
+	s.ActiveRequests += o.ActiveRequests
+	s.Shed += o.Shed
+	s.Banned += o.Banned
+	s.Throttled += o.Throttled
+	s.QueuedRequests += o.QueuedRequests
+
+	s.ChecksumFailures += o.ChecksumFailures
+}
+
+func (s *stats) addRequest(resp IncomingResponse, bodyIn int) {
+	atomic.AddUint64(&s.requests, 1)
+	if resp.StatusCode >= 500 {
+		atomic.AddUint64(&s.requestErrors, 1)
+	}
+	atomic.AddUint64(&s.bytesIn, uint64(bodyIn))
+	atomic.AddUint64(&s.bytesOut, uint64(len(resp.Body)))
+}
+
+func (s *stats) addBytesOut(n int) {
+	atomic.AddUint64(&s.bytesOut, uint64(n))
+}
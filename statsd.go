@@ -0,0 +1,86 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const statsdFlushInterval = 10 * time.Second
+
+func WithStatsD(addr string, tags map[string]string) Option {
+	return func(c *Client) {
+		c.config.StatsDAddr = addr
+		c.config.StatsDTags = tags
+	}
+}
+
+func (c *Client) runStatsD(ctx context.Context) {
+	conn, err := net.Dial("udp", c.conf().StatsDAddr)
+	if err != nil {
+		c.logf("StatsD disabled: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	tags := formatStatsDTags(c.conf().StatsDTags)
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+
+	var prev Stats
+	for {
+		select {
+		case <-ctx.Done():
+			c.flushStatsD(conn, &prev, tags)
+			return
+		case <-ticker.C:
+			c.flushStatsD(conn, &prev, tags)
+		}
+	}
+}
+
+func (c *Client) flushStatsD(conn net.Conn, prev *Stats, tags string) {
+	cur := c.Stats()
+
+	var buf bytes.Buffer
+	counter := func(name string, now, before uint64) {
+		if now > before {
+			fmt.Fprintf(&buf, "outray.%s:%d|c%s\n", name, now-before, tags)
+		}
+	}
+	counter("requests", cur.Requests, prev.Requests)
+	counter("request_errors", cur.RequestErrors, prev.RequestErrors)
+	counter("bytes_in", cur.BytesIn, prev.BytesIn)
+	counter("bytes_out", cur.BytesOut, prev.BytesOut)
+	counter("tcp_connections", cur.TCPConnections, prev.TCPConnections)
+	counter("udp_packets", cur.UDPPackets, prev.UDPPackets)
+	counter("udp_dropped", cur.UDPDropped, prev.UDPDropped)
+	counter("reconnects", cur.Reconnects, prev.Reconnects)
+	counter("compression.saved_bytes", cur.CompressionSaved, prev.CompressionSaved)
+	fmt.Fprintf(&buf, "outray.tcp_active:%d|g%s\n", cur.ActiveTCP, tags)
+	*prev = cur
+
+	if _, err := conn.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))); err != nil {
+		c.logf("StatsD write failed: %v", err)
+	}
+}
+
+func formatStatsDTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v == "" {
+			pairs = append(pairs, k)
+		} else {
+			pairs = append(pairs, k+":"+v)
+		}
+	}
+	sort.Strings(pairs)
+	return "|#" + strings.Join(pairs, ",")
+}
@@ -0,0 +1,39 @@
+package outray
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFlushStatsD(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := net.Dial("udp", ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := NewClient()
+	c.stats.addRequest(IncomingResponse{StatusCode: 502}, 10)
+
+	var prev Stats
+	c.flushStatsD(conn, &prev, formatStatsDTags(map[string]string{"env": "dev"}))
+
+	buf := make([]byte, 1024)
+	n, _, err := ln.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	for _, want := range []string{"outray.requests:1|c|#env:dev", "outray.request_errors:1|c|#env:dev", "outray.bytes_in:10|c|#env:dev"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in payload %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package outray
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type ConnState int32
+
+const (
+	StateIdle ConnState = iota
+	StateConnecting
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "idle"
+}
+
+type Status struct {
+	State ConnState
+	URL   string
+	Stats Stats
+
+	// ClockSkew is how far the server's clock is ahead of the local one.
+	ClockSkew time.Duration
+}
+
+// Status reports the connection state, the public URL from the last
+// tunnel_opened, and current counters.
+func (c *Client) Status() Status {
+	c.mu.Lock()
+	url := c.publicURL
+	c.mu.Unlock()
+	return Status{
+		State: ConnState(atomic.LoadInt32(&c.state)),
+		URL:   url,
+		Stats: c.Stats(),
+
+		ClockSkew: c.clockSkew(),
+	}
+}
+
+func (c *Client) setState(s ConnState) {
+	atomic.StoreInt32(&c.state, int32(s))
+}
@@ -0,0 +1,29 @@
+package outray
+
+import "fmt"
+
+// WarnURLChanged is raised locally when the server could not honour
+// WithPreferredURL and assigned a different URL.
+const WarnURLChanged = "URL_CHANGED"
+
+// WithPreferredURL asks the server to reuse a previously assigned URL. It is
+// a preference, not a reservation: if the URL is unavailable the tunnel
+// still opens and a URL_CHANGED warning reports the new one.
+func WithPreferredURL(prev string) Option {
+	return func(c *Client) {
+		c.config.PreferredURL = prev
+	}
+}
+
+func (c *Client) checkPreferredURL(url string) {
+	prev := c.conf().PreferredURL
+	if prev == "" || url == prev {
+		return
+	}
+	c.handleWarning(Warning{
+		Type:    MsgTypeWarning,
+		Code:    WarnURLChanged,
+		Message: fmt.Sprintf("preferred URL %s unavailable; assigned %s", prev, url),
+		Details: map[string]interface{}{"preferred": prev, "url": url},
+	})
+}
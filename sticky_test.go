@@ -0,0 +1,27 @@
+package outray
+
+import "testing"
+
+func TestPreferredURL(t *testing.T) {
+	ts := newTestServer(t)
+	warnings := make(chan Warning, 1)
+	opened := make(chan string, 1)
+	connectTestClient(t, ts,
+		WithPreferredURL("https://old.outray.dev"),
+		WithOnWarning(func(w Warning) { warnings <- w }),
+		WithOnOpen(func(url string) { opened <- url }),
+	)
+
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": "tunnel_opened", "url": "https://new.outray.dev"})
+	<-opened
+
+	select {
+	case w := <-warnings:
+		if w.Code != WarnURLChanged || w.Details["preferred"] != "https://old.outray.dev" {
+			t.Errorf("Expected URL_CHANGED for old URL, got %+v", w)
+		}
+	default:
+		t.Error("Expected a URL_CHANGED warning before OnOpen")
+	}
+}
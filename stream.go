@@ -0,0 +1,170 @@
+package outray
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+const (
+	MsgTypeResponseStart = "response_start"
+	MsgTypeResponseChunk = "response_chunk"
+	MsgTypeRequestCancel = "request_cancel"
+)
+
+const defaultStreamChunkSize = 64 * 1024
+
+type ResponseStart struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"requestId"`
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+}
+
+type ResponseChunk struct {
+	Type  string `json:"type"`
+	ID    string `json:"requestId"`
+	Data  []byte `json:"data,omitempty"`
+	Final bool   `json:"final,omitempty"`
+}
+
+type RequestCancel struct {
+	Type string `json:"type"`
+	ID   string `json:"requestId"`
+}
+
+func WithStreamingResponses(chunkSize int) Option {
+	return func(c *Client) {
+		if chunkSize <= 0 {
+			chunkSize = defaultStreamChunkSize
+		}
+		c.config.StreamChunkSize = chunkSize
+	}
+}
+
+// inflightRequest is what trackRequest registers under a request ID.
+type inflightRequest struct {
+	cancel context.CancelFunc
+}
+
+// trackRequest registers req so the server can cancel it, returning a
+// context derived from req's and a func that unregisters it. If another
+// request has taken the same ID meanwhile, its entry is left alone.
+func (c *Client) trackRequest(req IncomingRequest) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(req.context())
+	r := &inflightRequest{cancel: cancel}
+	c.inflightMu.Lock()
+	c.inflight[req.ID] = r
+	c.inflightMu.Unlock()
+
+	return ctx, func() {
+		c.inflightMu.Lock()
+		if c.inflight[req.ID] == r {
+			delete(c.inflight, req.ID)
+		}
+		c.inflightMu.Unlock()
+		cancel()
+	}
+}
+
+func (c *Client) cancelRequest(id string) {
+	c.inflightMu.Lock()
+	r, ok := c.inflight[id]
+	c.inflightMu.Unlock()
+	if ok {
+		c.logf("Request %s cancelled by server", id)
+		r.cancel()
+	}
+}
+
+func (c *Client) cancelAllRequests() {
+	c.inflightMu.Lock()
+	for _, r := range c.inflight {
+		r.cancel()
+	}
+	c.inflightMu.Unlock()
+}
+
+// untimedUpstream is the HTTP upstream without the pooled client's overall
+// timeout, for streamed requests and responses that may outlive it. They
+// are bounded by their context instead.
+func (c *Client) untimedUpstream() Upstream {
+	u := c.upstream("http")
+	if lu, ok := u.(*localUpstream); ok {
+		u = &localUpstream{c: c, client: &http.Client{Transport: c.upstreamClient().Transport}, port: lu.port}
+	}
+	return u
+}
+
+func (c *Client) streamHTTP(ctx context.Context, req IncomingRequest) {
+	if c.conf().RequestMiddleware != nil {
+		if earlyResp := c.conf().RequestMiddleware(&req); earlyResp != nil {
+			c.respond(req, *earlyResp, "proxy send response error")
+			return
+		}
+	}
+
+	resp, err := c.doUpstream(ctx, c.untimedUpstream(), req)
+	if err != nil {
+		c.respond(req, c.upstreamErrorResponse(req, err), "proxy send response error")
+		return
+	}
+	defer resp.Body.Close()
+
+	head := IncomingResponse{
+		ID:         req.ID,
+		StatusCode: resp.StatusCode,
+		Headers:    flattenHeaders(resp.Header),
+	}
+	if c.conf().ResponseMiddleware != nil {
+		c.conf().ResponseMiddleware(&req, &head)
+	}
+	if c.e2eCipher() != nil {
+		head.Headers[e2eHeader] = "aes-256-gcm"
+	}
+
+	c.pinStream(req.ID)
+	defer c.unpinStream(req.ID)
+	start := ResponseStart{
+		Type:       MsgTypeResponseStart,
+		ID:         req.ID,
+		StatusCode: head.StatusCode,
+		Headers:    head.Headers,
+	}
+	if err := c.writeStreamJSONOn(req.epoch, req.ID, PriorityHTTP, start); err != nil {
+		c.streamError(err)
+		return
+	}
+
+	c.stats.addRequest(head, len(req.Body))
+	c.record(req, head)
+	buf := make([]byte, c.conf().StreamChunkSize)
+	for seq := uint64(1); ; {
+		n, readErr := io.ReadFull(resp.Body, buf)
+		final := readErr != nil
+		chunk := ResponseChunk{Type: MsgTypeResponseChunk, ID: req.ID, Final: final}
+		if n > 0 {
+			c.stats.addBytesOut(n)
+		}
+		if n > 0 || final {
+			chunk.Data = c.seal(buf[:n], e2eFrame{typ: MsgTypeResponseChunk, id: req.ID, seq: seq, final: final})
+			seq++
+			if err := c.writeStreamJSONOn(req.epoch, req.ID, PriorityHTTP, chunk); err != nil {
+				c.streamError(err)
+				return
+			}
+		}
+		if final {
+			if readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+				c.logf("Streaming response %s ended early: %v", req.ID, readErr)
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) streamError(err error) {
+	if c.conf().OnError != nil {
+		c.safeOnError(err)
+	}
+}
@@ -0,0 +1,89 @@
+package outray
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamingRangeResponse(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer local.Close()
+
+	ts := newTestServer(t)
+	connectTestClient(t, ts,
+		WithUpstreamFallback(strings.TrimPrefix(local.URL, "http://")),
+		WithStreamingResponses(16),
+	)
+	conn := ts.accept(t)
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":      MsgTypeRequest,
+		"requestId": "dl-1",
+		"method":    "GET",
+		"path":      "/file.txt",
+		"headers":   map[string]string{"Range": "bytes=50-"},
+	})
+
+	var start ResponseStart
+	readFrame(t, conn, &start)
+	if start.Type != MsgTypeResponseStart || start.StatusCode != 206 {
+		t.Fatalf("Expected 206 response_start, got %+v", start)
+	}
+	if start.Headers["Content-Range"] != "bytes 50-99/100" {
+		t.Errorf("Unexpected Content-Range %q", start.Headers["Content-Range"])
+	}
+
+	var body bytes.Buffer
+	for {
+		var chunk ResponseChunk
+		readFrame(t, conn, &chunk)
+		body.Write(chunk.Data)
+		if chunk.Final {
+			break
+		}
+	}
+	if body.String() != content[50:] {
+		t.Errorf("Unexpected streamed body %q", body.String())
+	}
+}
+
+func TestAsyncResponder(t *testing.T) {
+	ts := newTestServer(t)
+	connectTestClient(t, ts, WithOnRequestAsync(func(req IncomingRequest, w ResponseWriter) {
+		go func() {
+			w.Header()["Content-Type"] = "text/event-stream"
+			w.Write([]byte("event: 1\n"))
+			w.Flush()
+			w.Write([]byte("event: 2\n"))
+			w.Close()
+		}()
+	}))
+	conn := ts.accept(t)
+	conn.WriteJSON(map[string]interface{}{"type": MsgTypeRequest, "requestId": "sse-1", "method": "GET", "path": "/events"})
+
+	var start ResponseStart
+	readFrame(t, conn, &start)
+	if start.StatusCode != 200 || start.Headers["Content-Type"] != "text/event-stream" {
+		t.Fatalf("Unexpected response_start %+v", start)
+	}
+
+	var body bytes.Buffer
+	for {
+		var chunk ResponseChunk
+		readFrame(t, conn, &chunk)
+		body.Write(chunk.Data)
+		if chunk.Final {
+			break
+		}
+	}
+	if body.String() != "event: 1\nevent: 2\n" {
+		t.Errorf("Unexpected streamed body %q", body.String())
+	}
+}
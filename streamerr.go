@@ -0,0 +1,42 @@
+package outray
+
+import "fmt"
+
+const (
+	StreamErrDial      = "dial_failed"
+	StreamErrRead      = "read_failed"
+	StreamErrWrite     = "write_failed"
+	StreamErrOrder     = "out_of_order"
+	StreamErrChecksum  = "checksum_mismatch"
+	StreamErrRestarted = "client_restarted"
+)
+
+type StreamError struct {
+	Protocol     string
+	ConnectionID string
+	Code         string
+	Err          error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("%s stream %s: %s: %v", e.Protocol, e.ConnectionID, e.Code, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+func (c *Client) reportTCPError(epoch uint64, connID, code string, err error) {
+	msg := TCPError{
+		Type:         MsgTypeTCPError,
+		ConnectionID: connID,
+		Code:         code,
+		Message:      err.Error(),
+	}
+	if werr := c.writeStreamJSONOn(epoch, connID, PriorityTCP, msg); werr != nil {
+		c.logf("Failed to report tcp error for %s: %v", connID, werr)
+	}
+	if c.conf().OnError != nil {
+		c.safeOnError(&StreamError{Protocol: "tcp", ConnectionID: connID, Code: code, Err: err})
+	}
+}
@@ -0,0 +1,46 @@
+package outray
+
+import "encoding/json"
+
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "outbound"
+	}
+	return "inbound"
+}
+
+type MessageTap func(direction Direction, msgType string, payload []byte)
+
+func WithMessageTap(fn MessageTap) Option {
+	return func(c *Client) {
+		c.config.MessageTap = fn
+	}
+}
+
+func (c *Client) encodeFrame(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.conf().MessageTap != nil {
+		var env messageEnvelope
+		json.Unmarshal(data, &env)
+		c.tap(Outbound, env.Type, data)
+	}
+	return data, nil
+}
+
+func (c *Client) tap(direction Direction, msgType string, data []byte) {
+	if c.conf().MessageTap == nil {
+		return
+	}
+	payload := append([]byte(nil), data...)
+	c.safeCallback(func() { c.conf().MessageTap(direction, msgType, payload) })
+}
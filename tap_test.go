@@ -0,0 +1,59 @@
+package outray
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMessageTapOrder(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	tap := WithMessageTap(func(dir Direction, msgType string, payload []byte) {
+		mu.Lock()
+		seen = append(seen, fmt.Sprintf("%s %s", dir, msgType))
+		mu.Unlock()
+	})
+
+	ts := newTestServer(t)
+	connectTestClient(t, ts, tap, WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		return IncomingResponse{StatusCode: 204}
+	}))
+	conn := ts.accept(t)
+	conn.WriteJSON(TunnelOpened{Type: MsgTypeTunnelOpened, URL: "https://a.outray.dev"})
+	conn.WriteJSON(map[string]interface{}{"type": MsgTypeRequest, "requestId": "r1", "method": "GET", "path": "/"})
+	var resp IncomingResponse
+	readFrame(t, conn, &resp)
+
+	want := []string{
+		"outbound open_tunnel",
+		"inbound tunnel_opened",
+		"inbound request",
+		"outbound response",
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		got := fmt.Sprint(seen)
+		mu.Unlock()
+		if got == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected tapped frames %v, got %s", want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMessageTapCopiesPayload(t *testing.T) {
+	var payload []byte
+	c := NewClient(WithMessageTap(func(dir Direction, msgType string, p []byte) { payload = p }))
+	data := []byte(`{"type":"ping"}`)
+	c.tap(Inbound, "ping", data)
+	data[2] = 'X'
+	if string(payload) != `{"type":"ping"}` {
+		t.Errorf("Expected the tap to get its own copy, got %s", payload)
+	}
+}
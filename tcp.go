@@ -2,68 +2,288 @@ package outray
 
 import (
 	"encoding/base64"
-	"fmt"
+	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-func (c *Client) handleTCPConnection(connID string) {
-	target := fmt.Sprintf("localhost:%d", c.config.Port)
-	localConn, err := net.Dial("tcp", target)
+type tcpStream struct {
+	conn      net.Conn
+	mu        sync.Mutex
+	localEOF  bool
+	remoteEOF bool
+	once      sync.Once
+	done      chan struct{}
+	epoch     uint64 // connection the stream was opened on
+
+	orderMu sync.Mutex // held while delivering inbound data, in order
+	order   tcpOrder
+	decoder commandDecoder // guarded by orderMu
+
+	remoteAddr string
+}
+
+func WithTCPCoalescing(window time.Duration, maxBytes int) Option {
+	return func(c *Client) {
+		c.config.CoalesceWindow = window
+		c.config.CoalesceMaxBytes = maxBytes
+	}
+}
+
+func (c *Client) handleTCPConnection(msg TCPConnection, epoch uint64) {
+	connID := msg.ID
+	if c.banned(parseHostIP(msg.RemoteAddr)) {
+		c.rejectStream(StreamRejected{Protocol: "tcp", ConnectionID: connID, Reason: "banned"})
+		return
+	}
+	if c.sshUngated() {
+		c.rejectStream(StreamRejected{Protocol: "tcp", ConnectionID: connID, Reason: "ssh key gating unsupported by server"})
+		return
+	}
+	if !c.acquireTCPSlot() {
+		c.rejectStream(StreamRejected{
+			Protocol:     "tcp",
+			ConnectionID: connID,
+			Reason:       limitReason("tcp connection", c.conf().MaxTCPConnections),
+		})
+		return
+	}
+
+	var localConn net.Conn
+	var err error
+	if ln := c.activeListener(); ln != nil {
+		localConn, err = ln.deliver(connID)
+	} else if c.conf().Postgres != nil {
+		localConn = c.postgresStream(msg, epoch)
+	} else if cfg := c.tlsTermination(); cfg != nil {
+		localConn = c.terminateTLS(msg, epoch, cfg)
+	} else if c.inspectsTLS() {
+		localConn = c.passthroughTLS(msg, epoch)
+	} else {
+		localConn, err = c.dialTCP(msg.RemoteAddr, TLSInfo{})
+	}
 	if err != nil {
-		if c.config.OnError != nil {
-			c.safeOnError(fmt.Errorf("failed to dial local tcp %s: %w", target, err))
-		}
+		c.releaseTCPSlot()
+		c.reportTCPError(epoch, connID, StreamErrDial, err)
 		return
 	}
 
+	stream := &tcpStream{conn: localConn, done: make(chan struct{}), epoch: epoch, decoder: c.commandDecoder(), remoteAddr: msg.RemoteAddr}
 	c.tcpConnsMu.Lock()
-	c.tcpConns[connID] = localConn
+	if _, dup := c.tcpConns[connID]; dup {
+		c.tcpConnsMu.Unlock()
+		localConn.Close()
+		c.releaseTCPSlot()
+		c.logf("Ignored duplicate tcp connection %s", connID)
+		return
+	}
+	c.tcpConns[connID] = stream
 	c.tcpConnsMu.Unlock()
+	if epoch&peerEpochBit == 0 {
+		c.pinStream(connID)
+	}
+	atomic.AddUint64(&c.stats.tcpConnections, 1)
 
-	go func() {
-		defer func() {
-			localConn.Close()
-			c.tcpConnsMu.Lock()
-			delete(c.tcpConns, connID)
-			c.tcpConnsMu.Unlock()
-		}()
-
-		buf := make([]byte, 4096)
-		for {
-			n, err := localConn.Read(buf)
-			if err != nil {
-				return
-			}
+	c.spawn(func() { c.pumpTCP(connID, stream) })
+}
 
-			data := base64.StdEncoding.EncodeToString(buf[:n])
-			msg := TCPData{
-				Type:         MsgTypeTCPData,
-				ConnectionID: connID,
-				Data:         data,
-			}
+func (c *Client) pumpTCP(connID string, stream *tcpStream) {
+	localConn := stream.conn
+	buf := make([]byte, c.tcpBufferSize())
+	for {
+		n, err := localConn.Read(buf)
+		if err != nil {
+			c.localTCPDone(connID, stream, err)
+			return
+		}
+		var readErr error
+		if c.conf().CoalesceWindow > 0 {
+			n, readErr = c.coalesce(localConn, buf, n)
+		}
 
-			c.mu.Lock()
-			if !c.closed {
-				c.conn.WriteJSON(msg)
-			}
-			c.mu.Unlock()
+		atomic.AddUint64(&c.stats.bytesOut, uint64(n))
+		seq := stream.order.nextSend()
+		sealed := c.seal(buf[:n], e2eFrame{typ: MsgTypeTCPData, id: connID, seq: seq})
+		msg := TCPData{
+			Type:         MsgTypeTCPData,
+			ConnectionID: connID,
+			Data:         base64.StdEncoding.EncodeToString(sealed),
+			Seq:          seq,
+			CRC32C:       c.checksum(sealed),
+		}
+
+		if err := c.writeStreamJSONOn(stream.epoch, connID, PriorityTCP, msg); errors.Is(err, errStaleConnection) {
+			c.finishTCP(connID, stream)
+			return
+		}
+		if readErr != nil {
+			c.localTCPDone(connID, stream, readErr)
+			return
+		}
+	}
+}
+
+func (c *Client) localTCPDone(connID string, stream *tcpStream, err error) {
+	select {
+	case <-stream.done:
+		return
+	default:
+	}
+
+	if err != io.EOF {
+		if errors.Is(err, net.ErrClosed) {
+			c.sendTCPControl(stream, MsgTypeTCPClose, connID)
+		} else {
+			c.reportTCPError(stream.epoch, connID, StreamErrRead, err)
+		}
+		c.finishTCP(connID, stream)
+		return
+	}
+
+	c.sendTCPControl(stream, MsgTypeTCPHalfClose, connID)
+	stream.mu.Lock()
+	stream.localEOF = true
+	remoteEOF := stream.remoteEOF
+	stream.mu.Unlock()
+
+	if remoteEOF {
+		c.finishTCP(connID, stream)
+		return
+	}
+	<-stream.done
+}
+
+// handleTCPHalfClose applies the server's half-close once every data frame
+// sent before it (up to seq) has been delivered.
+func (c *Client) handleTCPHalfClose(connID string, seq uint64) {
+	stream, ok := c.tcpStream(connID)
+	if !ok {
+		return
+	}
+	stream.orderMu.Lock()
+	deferred := stream.order.deferHalfClose(seq)
+	stream.orderMu.Unlock()
+	if !deferred {
+		c.remoteHalfClose(connID, stream)
+	}
+}
+
+func (c *Client) remoteHalfClose(connID string, stream *tcpStream) {
+	closeWrite(stream.conn)
+	stream.mu.Lock()
+	stream.remoteEOF = true
+	localEOF := stream.localEOF
+	stream.mu.Unlock()
+
+	if localEOF {
+		c.finishTCP(connID, stream)
+	}
+}
+
+func (c *Client) handleTCPClose(connID string) {
+	if stream, ok := c.tcpStream(connID); ok {
+		c.finishTCP(connID, stream)
+	}
+}
+
+func (c *Client) finishTCP(connID string, stream *tcpStream) {
+	stream.once.Do(func() {
+		stream.conn.Close()
+		c.tcpConnsMu.Lock()
+		if c.tcpConns[connID] == stream {
+			delete(c.tcpConns, connID)
 		}
-	}()
+		c.tcpConnsMu.Unlock()
+		c.unpinStream(connID)
+		c.releaseTCPSlot()
+		close(stream.done)
+	})
 }
 
-func (c *Client) handleTCPData(connID string, dataB64 string) {
+func (c *Client) tcpStream(connID string) (*tcpStream, bool) {
 	c.tcpConnsMu.Lock()
-	localConn, ok := c.tcpConns[connID]
-	c.tcpConnsMu.Unlock()
+	defer c.tcpConnsMu.Unlock()
+	stream, ok := c.tcpConns[connID]
+	return stream, ok
+}
+
+func (c *Client) sendTCPControl(stream *tcpStream, msgType, connID string) {
+	msg := TCPClose{Type: msgType, ConnectionID: connID, Seq: stream.order.lastSent()}
+	if err := c.writeStreamJSONOn(stream.epoch, connID, PriorityTCP, msg); err != nil {
+		c.logf("Failed to send %s for %s: %v", msgType, connID, err)
+	}
+}
+
+func (c *Client) tcpBufferSize() int {
+	return max(c.conf().CoalesceMaxBytes, latencyProfiles[c.conf().LatencyProfile].bufferSize)
+}
+
+func (c *Client) coalesce(conn net.Conn, buf []byte, n int) (int, error) {
+	limit := len(buf)
+	if c.conf().CoalesceMaxBytes > 0 && c.conf().CoalesceMaxBytes < limit {
+		limit = c.conf().CoalesceMaxBytes
+	}
+
+	conn.SetReadDeadline(time.Now().Add(c.conf().CoalesceWindow))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for n < limit {
+		m, err := conn.Read(buf[n:limit])
+		n += m
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
 
+// handleTCPData writes a frame from the server to the local connection.
+// Frames with a seq are written in seq order; see tcpOrder.
+func (c *Client) handleTCPData(msg TCPData) {
+	connID := msg.ConnectionID
+	stream, ok := c.tcpStream(connID)
 	if !ok {
 		return
 	}
 
-	data, err := base64.StdEncoding.DecodeString(dataB64)
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
 	if err != nil {
 		return
 	}
+	if err := c.verifyChecksum(data, msg.CRC32C); err != nil {
+		c.reportTCPError(stream.epoch, connID, StreamErrChecksum, err)
+		c.finishTCP(connID, stream)
+		return
+	}
+	if data, err = c.unseal(data, e2eFrame{typ: MsgTypeTCPData, id: connID, seq: msg.Seq}); err != nil {
+		c.logf("Dropped tcp frame for %s: %v", connID, err)
+		return
+	}
 
-	localConn.Write(data)
+	stream.orderMu.Lock()
+	defer stream.orderMu.Unlock()
+	ready, err := stream.order.accept(msg.Seq, data)
+	if err != nil {
+		c.reportTCPError(stream.epoch, connID, StreamErrOrder, err)
+		c.finishTCP(connID, stream)
+		return
+	}
+	for _, data := range ready {
+		atomic.AddUint64(&c.stats.bytesIn, uint64(len(data)))
+		if _, err := stream.conn.Write(data); err != nil {
+			c.reportTCPError(stream.epoch, connID, StreamErrWrite, err)
+			c.finishTCP(connID, stream)
+			return
+		}
+		c.decodeCommands(connID, stream, data)
+	}
+	if stream.order.halfCloseDue() {
+		c.remoteHalfClose(connID, stream)
+	}
 }
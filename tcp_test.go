@@ -0,0 +1,180 @@
+package outray
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSmallWrites(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	go func() {
+		for _, s := range []string{"GET", " key", "\r\n"} {
+			remote.Write([]byte(s))
+		}
+	}()
+
+	c := NewClient(WithTCPCoalescing(50*time.Millisecond, 1024))
+	buf := make([]byte, c.tcpBufferSize())
+	n, err := local.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = c.coalesce(local, buf, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "GET key\r\n" {
+		t.Errorf("Expected writes to be coalesced, got %q", buf[:n])
+	}
+}
+
+func TestTCPHalfClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		body, _ := io.ReadAll(conn)
+		conn.Write([]byte("ack:" + string(body)))
+	}()
+
+	c := NewClient(WithUpstreamFallback(ln.Addr().String()), WithMessageTap(func(d Direction, msgType string, payload []byte) {
+		if d == Outbound && msgType == MsgTypeTCPData {
+			var msg TCPData
+			json.Unmarshal(payload, &msg)
+			data, _ := base64.StdEncoding.DecodeString(msg.Data)
+			got <- string(data)
+		}
+	}))
+	c.closed = true
+
+	c.handleTCPConnection(TCPConnection{ID: "conn-1"}, 0)
+	c.handleTCPData(TCPData{ConnectionID: "conn-1", Data: base64.StdEncoding.EncodeToString([]byte("ping"))})
+	c.handleTCPHalfClose("conn-1", 0)
+
+	select {
+	case data := <-got:
+		if data != "ack:ping" {
+			t.Errorf("Unexpected response %q", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Local service never saw EOF")
+	}
+}
+
+func TestTCPDialErrorReported(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var frame TCPError
+	var reported error
+	c := NewClient(
+		WithUpstreamFallback(addr),
+		WithOnError(func(err error) { reported = err }),
+		WithMessageTap(func(d Direction, msgType string, payload []byte) {
+			if msgType == MsgTypeTCPError {
+				json.Unmarshal(payload, &frame)
+			}
+		}),
+	)
+	c.closed = true
+	c.handleTCPConnection(TCPConnection{ID: "conn-1"}, 0)
+
+	if frame.ConnectionID != "conn-1" || frame.Code != StreamErrDial {
+		t.Errorf("Expected tcp_error frame, got %+v", frame)
+	}
+	var se *StreamError
+	if !errors.As(reported, &se) || se.ConnectionID != "conn-1" {
+		t.Errorf("Expected *StreamError, got %v", reported)
+	}
+}
+
+func TestListenerReceivesTunnelStreams(t *testing.T) {
+	c := NewClient(WithProtocol("tcp"))
+	c.closed = true
+	ln := c.Listen()
+	defer ln.Close()
+
+	go c.handleTCPConnection(TCPConnection{ID: "conn-1"}, 0)
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != "conn-1" {
+		t.Errorf("Unexpected remote addr %v", conn.RemoteAddr())
+	}
+
+	go c.handleTCPData(TCPData{ConnectionID: "conn-1", Data: base64.StdEncoding.EncodeToString([]byte("PRI * HTTP/2.0"))})
+	buf := make([]byte, 32)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "PRI * HTTP/2.0" {
+		t.Errorf("Unexpected data %q %v", buf[:n], err)
+	}
+}
+
+func TestDuplicateTCPConnectionIgnored(t *testing.T) {
+	c := NewClient(WithUpstream(&VirtualBackend{TCP: func(conn net.Conn) { io.Copy(io.Discard, conn) }}))
+	c.closed = true
+	c.handleTCPConnection(TCPConnection{ID: "conn-1"}, 0)
+	first, _ := c.tcpStream("conn-1")
+	c.handleTCPConnection(TCPConnection{ID: "conn-1"}, 0)
+
+	if s, _ := c.tcpStream("conn-1"); s != first {
+		t.Error("Expected the original stream to be kept")
+	}
+	if got := c.Stats(); got.TCPConnections != 1 || got.ActiveTCP != 1 {
+		t.Errorf("Expected one tracked connection, got %d (%d active)", got.TCPConnections, got.ActiveTCP)
+	}
+	c.handleTCPClose("conn-1")
+}
+
+func TestStaleStreamWritesFail(t *testing.T) {
+	c := NewClient()
+	c.writer = newFrameWriter(nil, nil)
+	c.epoch = 2
+
+	if err := c.writeStreamJSONOn(1, "req-1", PriorityHTTP, IncomingResponse{}); !errors.Is(err, errStaleConnection) {
+		t.Errorf("Expected errStaleConnection, got %v", err)
+	}
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	stream := &tcpStream{conn: local, done: make(chan struct{}), epoch: 1}
+	c.tcpConns["conn-1"] = stream
+	c.acquireTCPSlot()
+	c.spawn(func() { c.pumpTCP("conn-1", stream) })
+	remote.Write([]byte("late data"))
+
+	select {
+	case <-stream.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected stream from a replaced connection to be closed")
+	}
+	c.Wait()
+	if _, ok := c.tcpStream("conn-1"); ok {
+		t.Error("Expected stale stream to be removed")
+	}
+	if c.writer.pending != 0 {
+		t.Errorf("Expected nothing queued on the new connection, got %d frames", c.writer.pending)
+	}
+}
@@ -0,0 +1,89 @@
+package outray
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// maxReorderFrames bounds how many early tcp_data frames a stream holds
+// while waiting for a missing one before it is reset.
+const maxReorderFrames = 256
+
+var errTCPReorder = errors.New("tcp frames arrived too far out of order")
+
+// tcpOrder numbers a stream's frames in both directions. Outbound tcp_data
+// frames carry seq 1, 2, 3...; a half-close carries the seq of the last
+// data frame before it. Inbound frames with a seq are delivered in that
+// order even if the server spreads them over pooled connections; frames
+// without one (older servers) are delivered as they arrive.
+type tcpOrder struct {
+	sent uint64 // last outbound seq, updated atomically
+
+	next        uint64 // next inbound seq expected
+	early       map[uint64][]byte
+	halfCloseAt uint64 // remote half-close waiting for this seq, 0 if none
+}
+
+func (o *tcpOrder) nextSend() uint64 {
+	return atomic.AddUint64(&o.sent, 1)
+}
+
+func (o *tcpOrder) lastSent() uint64 {
+	return atomic.LoadUint64(&o.sent)
+}
+
+// accept takes an inbound frame and returns the frames now ready for the
+// local connection, in order. Duplicates are dropped.
+func (o *tcpOrder) accept(seq uint64, data []byte) ([][]byte, error) {
+	if seq == 0 {
+		return [][]byte{data}, nil
+	}
+	if o.next == 0 {
+		o.next = 1
+	}
+	if seq < o.next {
+		return nil, nil
+	}
+	if seq > o.next {
+		if _, dup := o.early[seq]; !dup && len(o.early) >= maxReorderFrames {
+			return nil, errTCPReorder
+		}
+		if o.early == nil {
+			o.early = make(map[uint64][]byte)
+		}
+		o.early[seq] = data
+		return nil, nil
+	}
+
+	ready := [][]byte{data}
+	o.next++
+	for {
+		d, ok := o.early[o.next]
+		if !ok {
+			break
+		}
+		delete(o.early, o.next)
+		ready = append(ready, d)
+		o.next++
+	}
+	return ready, nil
+}
+
+// deferHalfClose reports whether a half-close following frame seq must wait
+// for data that hasn't arrived yet; if so it is remembered.
+func (o *tcpOrder) deferHalfClose(seq uint64) bool {
+	if seq == 0 || seq < max(o.next, 1) {
+		return false
+	}
+	o.halfCloseAt = seq
+	return true
+}
+
+// halfCloseDue reports whether a deferred half-close can now be applied.
+func (o *tcpOrder) halfCloseDue() bool {
+	if o.halfCloseAt == 0 || o.halfCloseAt >= o.next {
+		return false
+	}
+	o.halfCloseAt = 0
+	return true
+}
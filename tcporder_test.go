@@ -0,0 +1,162 @@
+package outray
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPOrderAccept(t *testing.T) {
+	var o tcpOrder
+	var got []string
+	deliver := func(seq uint64, s string) {
+		ready, err := o.accept(seq, []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range ready {
+			got = append(got, string(d))
+		}
+	}
+	deliver(2, "b")
+	deliver(3, "c")
+	deliver(1, "a")
+	deliver(2, "b")
+	deliver(4, "d")
+	if strings.Join(got, "") != "abcd" {
+		t.Errorf("Expected abcd, got %v", got)
+	}
+
+	for seq := uint64(6); seq < 6+maxReorderFrames; seq++ {
+		if _, err := o.accept(seq, nil); err != nil {
+			t.Fatalf("Unexpected error at %d: %v", seq, err)
+		}
+	}
+	if _, err := o.accept(6+maxReorderFrames, nil); err != errTCPReorder {
+		t.Errorf("Expected errTCPReorder past the window, got %v", err)
+	}
+}
+
+func TestTCPInboundOrderUnderInterleaving(t *testing.T) {
+	const frames = 500
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	local, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	c := NewClient()
+	c.closed = true
+	stream := &tcpStream{conn: local, done: make(chan struct{})}
+	c.tcpConns["conn-1"] = stream
+	c.acquireTCPSlot()
+
+	received := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(remote)
+		received <- string(data)
+	}()
+
+	// Frames arrive shuffled within windows of 16, from several readers at
+	// once as they would over a connection pool, with the half-close early.
+	var want strings.Builder
+	seqs := make([]uint64, frames)
+	for i := range seqs {
+		seqs[i] = uint64(i + 1)
+		fmt.Fprintf(&want, "%04d", i+1)
+	}
+	for i := 0; i < frames; i += 16 {
+		w := seqs[i:min(i+16, frames)]
+		rand.Shuffle(len(w), func(a, b int) { w[a], w[b] = w[b], w[a] })
+	}
+	c.handleTCPHalfClose("conn-1", frames)
+
+	var wg sync.WaitGroup
+	ch := make(chan uint64)
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range ch {
+				c.handleTCPData(TCPData{ConnectionID: "conn-1", Data: base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%04d", seq)), Seq: seq})
+			}
+		}()
+	}
+	for _, seq := range seqs {
+		ch <- seq
+	}
+	close(ch)
+	wg.Wait()
+
+	select {
+	case got := <-received:
+		if got != want.String() {
+			t.Errorf("Data delivered out of order")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Half-close was never applied")
+	}
+	c.handleTCPClose("conn-1")
+}
+
+func TestTCPOutboundSequence(t *testing.T) {
+	local, remote := net.Pipe()
+
+	var mu sync.Mutex
+	var seqs []uint64
+	var halfClose uint64
+	c := NewClient(WithMessageTap(func(d Direction, msgType string, payload []byte) {
+		if d != Outbound {
+			return
+		}
+		var msg TCPData
+		json.Unmarshal(payload, &msg)
+		mu.Lock()
+		defer mu.Unlock()
+		switch msgType {
+		case MsgTypeTCPData:
+			seqs = append(seqs, msg.Seq)
+		case MsgTypeTCPHalfClose:
+			halfClose = msg.Seq
+		}
+	}))
+	c.closed = true
+	stream := &tcpStream{conn: local, done: make(chan struct{})}
+	c.tcpConns["conn-1"] = stream
+	c.acquireTCPSlot()
+	c.spawn(func() { c.pumpTCP("conn-1", stream) })
+
+	for i := 0; i < 100; i++ {
+		remote.Write([]byte("chunk"))
+	}
+	remote.Close()
+	c.handleTCPHalfClose("conn-1", 0)
+	c.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, seq := range seqs {
+		if seq != uint64(i+1) {
+			t.Fatalf("Expected seq %d, got %d", i+1, seq)
+		}
+	}
+	if halfClose != uint64(len(seqs)) {
+		t.Errorf("Expected half-close to follow seq %d, got %d", len(seqs), halfClose)
+	}
+}
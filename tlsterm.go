@@ -0,0 +1,107 @@
+package outray
+
+import (
+	"crypto/tls"
+	"io"
+	"maps"
+	"net"
+	"slices"
+)
+
+// WithTLSTermination decrypts TLS on TCP tunnel streams in the client, so
+// the local service (or Listen) sees plaintext while the relay only ever
+// forwards ciphertext. cfg needs Certificates or GetCertificate unless
+// WithACME supplies them.
+func WithTLSTermination(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.config.TLSTermination = cfg
+	}
+}
+
+// tlsTermination returns the server config for terminated streams, or nil
+// when streams pass through untouched.
+func (c *Client) tlsTermination() *tls.Config {
+	cfg := c.conf().TLSTermination
+	if cfg == nil && c.conf().ACME == nil {
+		return nil
+	}
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg = cfg.Clone()
+	if c.conf().ACME != nil && cfg.GetCertificate == nil && len(cfg.Certificates) == 0 {
+		cfg.GetCertificate = c.acmeCertificate
+	}
+	// Offer the routed protocols unless the config already picks some.
+	if len(cfg.NextProtos) == 0 && len(c.conf().ALPNRoutes) > 0 {
+		cfg.NextProtos = slices.Sorted(maps.Keys(c.conf().ALPNRoutes))
+	}
+	return cfg
+}
+
+// terminateTLS returns the tunnel side of a stream whose TLS is ended
+// here. After the handshake the plaintext is relayed to the upstream for
+// the negotiated server name and protocol (see WithSNIRoute and
+// WithALPNRoute).
+func (c *Client) terminateTLS(msg TCPConnection, epoch uint64, cfg *tls.Config) net.Conn {
+	connID := msg.ID
+	tunnelSide, inner := net.Pipe()
+	info := TLSInfo{ConnectionID: connID}
+	cfg = cfg.Clone()
+	getConfig := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		info.ALPN = hello.SupportedProtos
+		if getConfig != nil {
+			return getConfig(hello)
+		}
+		return nil, nil
+	}
+	tc := tls.Server(inner, cfg)
+	c.spawn(func() {
+		defer tc.Close()
+		if err := tc.Handshake(); err != nil {
+			c.logf("TLS handshake failed: %v", err)
+			return
+		}
+		state := tc.ConnectionState()
+		info.ServerName, info.Negotiated = state.ServerName, state.NegotiatedProtocol
+		c.reportTLS(info)
+
+		upstream, err := c.dialTCP(msg.RemoteAddr, info)
+		if err != nil {
+			c.reportTCPError(epoch, connID, StreamErrDial, err)
+			c.handleTCPClose(connID)
+			return
+		}
+		defer upstream.Close()
+		relay(tc, upstream)
+	})
+	return tunnelSide
+}
+
+// relay copies in both directions, passing each EOF on as a half-close,
+// until both sides are done. A conn that can't half-close is closed
+// outright, which also ends the copy in the other direction.
+func relay(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(b, a)
+		if !closeWrite(b) {
+			b.Close()
+		}
+		close(done)
+	}()
+	io.Copy(a, b)
+	if !closeWrite(a) {
+		a.Close()
+	}
+	<-done
+}
+
+func closeWrite(conn net.Conn) bool {
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if ok {
+		cw.CloseWrite()
+	}
+	return ok
+}
@@ -0,0 +1,150 @@
+package outray
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tunnelRelay stands in for the server end of TCP streams: tcp_data the
+// client sends can be read from the conn open returns, and writes to that
+// conn arrive at the client as inbound tcp_data.
+type tunnelRelay struct {
+	mu      sync.Mutex
+	streams map[string]*io.PipeWriter
+}
+
+func (r *tunnelRelay) tap(d Direction, msgType string, payload []byte) {
+	if d != Outbound {
+		return
+	}
+	var msg TCPData
+	json.Unmarshal(payload, &msg)
+	r.mu.Lock()
+	pw := r.streams[msg.ConnectionID]
+	r.mu.Unlock()
+	if pw == nil {
+		return
+	}
+	switch msgType {
+	case MsgTypeTCPData:
+		data, _ := base64.StdEncoding.DecodeString(msg.Data)
+		pw.Write(data)
+	case MsgTypeTCPHalfClose, MsgTypeTCPClose, MsgTypeTCPError:
+		pw.Close()
+	}
+}
+
+func (r *tunnelRelay) open(c *Client, connID string) net.Conn {
+	pr, pw := io.Pipe()
+	r.mu.Lock()
+	if r.streams == nil {
+		r.streams = make(map[string]*io.PipeWriter)
+	}
+	r.streams[connID] = pw
+	r.mu.Unlock()
+	c.handleTCPConnection(TCPConnection{ID: connID}, 0)
+	return &relayConn{c: c, id: connID, pr: pr}
+}
+
+type relayConn struct {
+	net.Conn // unused methods
+	c        *Client
+	id       string
+	pr       *io.PipeReader
+}
+
+func (rc *relayConn) Read(p []byte) (int, error) { return rc.pr.Read(p) }
+
+func (rc *relayConn) Write(p []byte) (int, error) {
+	rc.c.handleTCPData(TCPData{ConnectionID: rc.id, Data: base64.StdEncoding.EncodeToString(p)})
+	return len(p), nil
+}
+
+func (rc *relayConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (rc *relayConn) Close() error {
+	rc.c.handleTCPClose(rc.id)
+	return rc.pr.Close()
+}
+
+// testCertificate returns a certificate for host and a pool that trusts it.
+func testCertificate(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// ackServer reads a 4-byte message on each connection and answers with
+// "ack:" and the message.
+func ackServer(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4)
+				n, _ := io.ReadFull(conn, buf)
+				conn.Write(append([]byte("ack:"), buf[:n]...))
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestTLSTermination(t *testing.T) {
+	upstream := ackServer(t)
+	cert, pool := testCertificate(t, "db.outray.dev")
+	relay := &tunnelRelay{}
+	c := NewClient(
+		WithUpstreamFallback(upstream.Addr().String()),
+		WithTLSTermination(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		WithMessageTap(relay.tap),
+	)
+	c.closed = true
+
+	conn := tls.Client(relay.open(c, "conn-1"), &tls.Config{ServerName: "db.outray.dev", RootCAs: pool})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("TLS through the tunnel failed: %v", err)
+	}
+	got, _ := io.ReadAll(conn)
+	if string(got) != "ack:ping" {
+		t.Errorf("Expected plaintext to reach the local service, got %q", got)
+	}
+}
@@ -0,0 +1,147 @@
+package outray
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tuiLogLines    = 12
+	tuiGraphPoints = 40
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+type tui struct {
+	out io.Writer
+
+	mu        sync.Mutex
+	log       []tuiLine
+	rates     []uint64
+	lastBytes uint64
+	sampled   bool
+}
+
+type tuiLine struct {
+	at      time.Time
+	method  string
+	path    string
+	status  int
+	latency time.Duration
+}
+
+// WithTUI renders a live status screen on stdout: public URL, connection
+// state, recent requests with status and latency, and a throughput graph.
+// Log output from WithLogger shares the terminal and is best disabled.
+func WithTUI() Option {
+	return func(c *Client) {
+		c.tui = &tui{out: os.Stdout}
+	}
+}
+
+func (c *Client) runTUI(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		c.tui.sample(c.Stats())
+		c.tui.render(c.Status())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *tui) add(req IncomingRequest, resp IncomingResponse, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.log = append(t.log, tuiLine{at: time.Now(), method: req.Method, path: req.Path, status: resp.StatusCode, latency: latency})
+	if len(t.log) > tuiLogLines {
+		t.log = t.log[len(t.log)-tuiLogLines:]
+	}
+}
+
+func (t *tui) sample(s Stats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := s.BytesIn + s.BytesOut
+	if t.sampled {
+		t.rates = append(t.rates, total-t.lastBytes)
+		if len(t.rates) > tuiGraphPoints {
+			t.rates = t.rates[len(t.rates)-tuiGraphPoints:]
+		}
+	}
+	t.lastBytes = total
+	t.sampled = true
+}
+
+func (t *tui) render(st Status) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "outray  %s\n\n", st.State)
+	url := st.URL
+	if url == "" {
+		url = "-"
+	}
+	fmt.Fprintf(&b, "Forwarding   %s\n", url)
+	fmt.Fprintf(&b, "Requests     %d (%d errors)\n", st.Stats.Requests, st.Stats.RequestErrors)
+	fmt.Fprintf(&b, "Traffic      %s in / %s out\n", formatBytes(st.Stats.BytesIn), formatBytes(st.Stats.BytesOut))
+
+	var current uint64
+	if len(t.rates) > 0 {
+		current = t.rates[len(t.rates)-1]
+	}
+	fmt.Fprintf(&b, "Throughput   %s %s/s\n\n", sparkline(t.rates), formatBytes(current))
+
+	b.WriteString("Recent requests\n")
+	for i := len(t.log) - 1; i >= 0; i-- {
+		l := t.log[i]
+		fmt.Fprintf(&b, "%s  %-7s %-40s %d  %s\n", l.at.Format("15:04:05"), l.method, truncate(l.path, 40), l.status, l.latency.Round(time.Millisecond))
+	}
+	io.WriteString(t.out, b.String())
+}
+
+func sparkline(values []uint64) string {
+	var peak uint64
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		idx := 0
+		if peak > 0 {
+			idx = int(v * uint64(len(sparkBlocks)-1) / peak)
+		}
+		b.WriteRune(sparkBlocks[idx])
+	}
+	return b.String()
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
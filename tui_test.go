@@ -0,0 +1,32 @@
+package outray
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTUIRender(t *testing.T) {
+	var out bytes.Buffer
+	c := NewClient(WithTUI(), WithOnRequest(func(req IncomingRequest) IncomingResponse {
+		return IncomingResponse{StatusCode: 201, Body: []byte("created")}
+	}))
+	c.tui.out = &out
+	c.closed = true
+
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://demo.outray.dev"}`), 0)
+	c.tui.sample(c.Stats())
+	c.handleMessage([]byte(`{"type":"request","requestId":"r1","method":"POST","path":"/orders"}`), 0)
+	c.tui.sample(c.Stats())
+	c.tui.render(c.Status())
+
+	screen := out.String()
+	for _, want := range []string{"connected", "https://demo.outray.dev", "Requests     1 (0 errors)", "POST", "/orders", "201", "Throughput   █ 7 B/s"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("Expected %q on screen:\n%s", want, screen)
+		}
+	}
+	if st := c.Status(); st.State != StateConnected || st.URL != "https://demo.outray.dev" {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+}
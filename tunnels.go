@@ -0,0 +1,126 @@
+package outray
+
+// TunnelSpec describes one protocol handler registered with Client.HTTP,
+// Client.TCP, or Client.UDP.
+type TunnelSpec struct {
+	Protocol     string   `json:"protocol"`
+	Port         int      `json:"-"`
+	RemotePort   int      `json:"remotePort,omitempty"`
+	Subdomain    string   `json:"subdomain,omitempty"`
+	CustomDomain string   `json:"customDomain,omitempty"`
+	Upstream     Upstream `json:"-"`
+}
+
+type TunnelOption func(*TunnelSpec)
+
+var tunnelProtocols = []string{"http", "tcp", "udp"}
+
+func WithTunnelRemotePort(p int) TunnelOption {
+	return func(s *TunnelSpec) {
+		s.RemotePort = p
+	}
+}
+
+func WithTunnelSubdomain(subdomain string) TunnelOption {
+	return func(s *TunnelSpec) {
+		s.Subdomain = subdomain
+	}
+}
+
+func WithTunnelCustomDomain(domain string) TunnelOption {
+	return func(s *TunnelSpec) {
+		s.CustomDomain = domain
+	}
+}
+
+func WithTunnelUpstream(u Upstream) TunnelOption {
+	return func(s *TunnelSpec) {
+		s.Upstream = u
+	}
+}
+
+// HTTP forwards HTTP requests to the local port. Registering any protocol
+// handler replaces the single Protocol/Port pair from the client options.
+func (c *Client) HTTP(port int, opts ...TunnelOption) *Client {
+	return c.register("http", port, opts)
+}
+
+// TCP forwards TCP streams to the local port.
+func (c *Client) TCP(port int, opts ...TunnelOption) *Client {
+	return c.register("tcp", port, opts)
+}
+
+// UDP forwards UDP packets to the local port.
+func (c *Client) UDP(port int, opts ...TunnelOption) *Client {
+	return c.register("udp", port, opts)
+}
+
+func (c *Client) register(protocol string, port int, opts []TunnelOption) *Client {
+	spec := &TunnelSpec{Protocol: protocol, Port: port}
+	for _, opt := range opts {
+		opt(spec)
+	}
+
+	c.tunnelsMu.Lock()
+	if c.tunnels == nil {
+		c.tunnels = make(map[string]*TunnelSpec)
+	}
+	c.tunnels[protocol] = spec
+	c.tunnelsMu.Unlock()
+	return c
+}
+
+func (c *Client) tunnel(protocol string) (*TunnelSpec, bool) {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+	spec, ok := c.tunnels[protocol]
+	return spec, ok
+}
+
+func (c *Client) tunnelSpecs() []TunnelSpec {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+
+	var specs []TunnelSpec
+	for _, p := range tunnelProtocols {
+		if spec, ok := c.tunnels[p]; ok {
+			specs = append(specs, *spec)
+		}
+	}
+	return specs
+}
+
+// serves reports whether inbound traffic of the given protocol should be
+// handled at all.
+func (c *Client) serves(protocol string) bool {
+	c.tunnelsMu.Lock()
+	defer c.tunnelsMu.Unlock()
+	if len(c.tunnels) > 0 {
+		_, ok := c.tunnels[protocol]
+		return ok
+	}
+	return c.conf().Protocol == protocol
+}
+
+// applyTunnels fills the handshake from the registered protocol handlers.
+// The first handler keeps the single-tunnel fields so servers that predate
+// multi-tunnel sessions still open it; the full list goes in "tunnels".
+func (c *Client) applyTunnels(handshake *OpenTunnelRequest) {
+	specs := c.tunnelSpecs()
+	if len(specs) == 0 {
+		return
+	}
+
+	first := specs[0]
+	handshake.Protocol = first.Protocol
+	handshake.Port = first.RemotePort
+	if first.Subdomain != "" {
+		handshake.Subdomain = first.Subdomain
+	}
+	if first.CustomDomain != "" {
+		handshake.CustomDomain = first.CustomDomain
+	}
+	if len(specs) > 1 {
+		handshake.Tunnels = specs
+	}
+}
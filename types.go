@@ -1,6 +1,11 @@
 package outray
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
 
 const (
 	MsgTypeOpenTunnel    = "open_tunnel"
@@ -10,58 +15,146 @@ const (
 	MsgTypeError         = "error"
 	MsgTypeTCPConnection = "tcp_connection"
 	MsgTypeTCPData       = "tcp_data"
+	MsgTypeTCPClose      = "tcp_close"
+	MsgTypeTCPHalfClose  = "tcp_half_close"
+	MsgTypeTCPError      = "tcp_error"
 	MsgTypeUDPData       = "udp_data"
 	MsgTypeUDPResponse   = "udp_response"
 )
 
-type TCPConnection struct {
-	ID   string `json:"connectionId"`
+type messageEnvelope struct {
 	Type string `json:"type"`
 }
 
+type TunnelOpened struct {
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	TunnelID string `json:"tunnelId,omitempty"`
+	Quota    *Quota `json:"quota,omitempty"`
+
+	// ServerTime is the server's clock in Unix milliseconds.
+	ServerTime int64 `json:"serverTime,omitempty"`
+
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// DNSRecords lists records a custom domain still needs before it
+	// validates.
+	DNSRecords []DNSRecord `json:"dnsRecords,omitempty"`
+
+	// SessionToken lets a restarted client resume this tunnel.
+	SessionToken string `json:"sessionToken,omitempty"`
+
+	// Update announces the latest release of the SDK named in the
+	// handshake's client info.
+	Update *UpdateNotice `json:"update,omitempty"`
+}
+
+type TCPConnection struct {
+	ID         string `json:"connectionId"`
+	Type       string `json:"type"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+}
+
 type TCPData struct {
+	Type         string  `json:"type"`
+	ConnectionID string  `json:"connectionId"`
+	Data         string  `json:"data"`
+	Seq          uint64  `json:"seq,omitempty"`
+	CRC32C       *uint32 `json:"crc32c,omitempty"`
+}
+
+type TCPClose struct {
 	Type         string `json:"type"`
 	ConnectionID string `json:"connectionId"`
-	Data         string `json:"data"`
+	Seq          uint64 `json:"seq,omitempty"`
+}
+
+type TCPError struct {
+	Type         string `json:"type"`
+	ConnectionID string `json:"connectionId"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
 }
 
 type UDPData struct {
-	Type          string `json:"type"`
-	PacketID      string `json:"packetId"`
-	Data          string `json:"data"`
-	SourceAddress string `json:"sourceAddress"`
-	SourcePort    int    `json:"sourcePort"`
+	Type          string  `json:"type"`
+	PacketID      string  `json:"packetId"`
+	Data          string  `json:"data"`
+	SourceAddress string  `json:"sourceAddress"`
+	SourcePort    int     `json:"sourcePort"`
+	CRC32C        *uint32 `json:"crc32c,omitempty"`
+
+	epoch uint64
 }
 
 type UDPResponse struct {
-	Type     string `json:"type"`
-	PacketID string `json:"packetId"`
-	Data     string `json:"data"`
+	Type     string  `json:"type"`
+	PacketID string  `json:"packetId"`
+	Data     string  `json:"data"`
+	CRC32C   *uint32 `json:"crc32c,omitempty"`
 }
 
 type OpenTunnelRequest struct {
-	Type          string `json:"type"`
-	APIKey        string `json:"apiKey,omitempty"`
-	Protocol      string `json:"protocol,omitempty"`
-	Port          int    `json:"remotePort,omitempty"`
-	Subdomain     string `json:"subdomain,omitempty"`
-	CustomDomain  string `json:"customDomain,omitempty"`
-	ForceTakeover bool   `json:"forceTakeover,omitempty"`
+	Type          string         `json:"type"`
+	APIKey        string         `json:"apiKey,omitempty"`
+	KeyID         string         `json:"keyId,omitempty"`
+	Protocol      string         `json:"protocol,omitempty"`
+	Port          int            `json:"remotePort,omitempty"`
+	Subdomain     string         `json:"subdomain,omitempty"`
+	CustomDomain  string         `json:"customDomain,omitempty"`
+	ForceTakeover bool           `json:"forceTakeover,omitempty"`
+	Client        *ClientInfo    `json:"client,omitempty"`
+	Tunnels       []TunnelSpec   `json:"tunnels,omitempty"`
+	Nonce         string         `json:"nonce,omitempty"`
+	Timestamp     int64          `json:"timestamp,omitempty"`
+	Proof         string         `json:"proof,omitempty"`
+	PreferredURL  string         `json:"preferredUrl,omitempty"`
+	Scope         *TokenScope    `json:"scope,omitempty"`
+	ClientAuth    *ClientAuth    `json:"clientAuth,omitempty"`
+	SSHAuth       *SSHAuth       `json:"sshAuth,omitempty"`
+	WebRTC        bool           `json:"webrtc,omitempty"`
+	Checksums     bool           `json:"checksums,omitempty"`
+	Resume        *SessionResume `json:"resume,omitempty"`
+	FrameNonce    string         `json:"frameNonce,omitempty"`
 }
 
 type ServerMessage struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload,omitempty"`
-	ID      string          `json:"id,omitempty"`
-	Message string          `json:"message,omitempty"`
+	Type      string                 `json:"type"`
+	Payload   json.RawMessage        `json:"payload,omitempty"`
+	ID        string                 `json:"id,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Code      string                 `json:"code,omitempty"`
+	Retryable bool                   `json:"retryable,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
 }
 
 type IncomingRequest struct {
-	ID      string            `json:"requestId"`
-	Method  string            `json:"method"`
-	Path    string            `json:"path"`
-	Headers map[string]string `json:"headers"`
-	Body    []byte            `json:"body"`
+	ID         string            `json:"requestId"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Headers    map[string]string `json:"headers"`
+	Body       []byte            `json:"body"`
+	Streaming  bool              `json:"streaming,omitempty"`
+	RemoteAddr string            `json:"remoteAddr,omitempty"`
+	Country    string            `json:"country,omitempty"`
+	ClientCert *ClientCert       `json:"clientCert,omitempty"`
+
+	// Claims holds the verified token claims when WithJWTAuth is set.
+	Claims map[string]interface{} `json:"-"`
+
+	stream   io.Reader
+	received time.Time
+	epoch    uint64
+	ctx      context.Context // the caller's, for requests run through RoundTrip
+}
+
+// context is the parent of the request's context: the caller's for
+// requests run locally, Background for requests from the server.
+func (r *IncomingRequest) context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
 }
 
 type IncomingResponse struct {
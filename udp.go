@@ -1,49 +1,103 @@
 package outray
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 func (c *Client) handleUDPData(packet UDPData) {
-	target := fmt.Sprintf("localhost:%d", c.config.Port)
-	conn, err := net.Dial("udp", target)
-	if err != nil {
-		if c.config.OnError != nil {
-			c.safeOnError(fmt.Errorf("failed to dial local udp %s: %w", target, err))
-		}
+	if c.banned(net.ParseIP(packet.SourceAddress)) {
+		return
+	}
+	source := fmt.Sprintf("%s:%d", packet.SourceAddress, packet.SourcePort)
+	if !c.acquireUDPSession(source, packet.epoch) {
+		c.rejectStream(StreamRejected{
+			Protocol: "udp",
+			PacketID: packet.PacketID,
+			Reason:   limitReason("udp session", c.conf().MaxUDPSessions),
+		})
 		return
 	}
-	defer conn.Close()
 
 	data, err := base64.StdEncoding.DecodeString(packet.Data)
 	if err != nil {
 		return
 	}
-
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
-	if _, err := conn.Write(data); err != nil {
+	if err := c.verifyChecksum(data, packet.CRC32C); err != nil {
+		c.logf("Dropped udp packet %s: %v", packet.PacketID, err)
+		return
+	}
+	if data, err = c.unseal(data, e2eFrame{typ: MsgTypeUDPData, id: packet.PacketID}); err != nil {
+		c.logf("Dropped udp packet %s: %v", packet.PacketID, err)
+		return
+	}
+	atomic.AddUint64(&c.stats.udpPackets, 1)
+	atomic.AddUint64(&c.stats.bytesIn, uint64(len(data)))
+	if c.gameUDP != nil {
+		c.deliverGameUDP(source, packet, data)
 		return
 	}
 
-	respBuf := make([]byte, 4096)
-	n, err := conn.Read(respBuf)
+	exchange := c.exchangeUDP
+	if c.conf().DNSServer != nil {
+		exchange = c.exchangeDNS
+	}
+	resp, err := exchange(data)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return
+	}
 	if err != nil {
+		if c.conf().OnError != nil {
+			c.safeOnError(err)
+		}
 		return
 	}
 
-	respData := base64.StdEncoding.EncodeToString(respBuf[:n])
+	atomic.AddUint64(&c.stats.bytesOut, uint64(len(resp)))
+	sealed := c.seal(resp, e2eFrame{typ: MsgTypeUDPResponse, id: packet.PacketID})
 	respMsg := UDPResponse{
 		Type:     MsgTypeUDPResponse,
 		PacketID: packet.PacketID,
-		Data:     respData,
+		Data:     base64.StdEncoding.EncodeToString(sealed),
+		CRC32C:   c.checksum(sealed),
 	}
 
-	c.mu.Lock()
-	if !c.closed {
-		c.conn.WriteJSON(respMsg)
+	c.writeStreamJSONOn(packet.epoch, source, PriorityUDP, respMsg)
+}
+
+func (c *Client) exchangeUDP(data []byte) ([]byte, error) {
+	ctx := context.Background()
+	u := c.upstream("udp")
+	if lu, ok := u.(*localUpstream); ok {
+		return lu.exchangeUDP(ctx, data)
+	}
+
+	conn, err := u.DialUDP(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial upstream udp: %w", err)
+	}
+	return exchangePacket(conn, data)
+}
+
+// maxUDPPayload fits any UDP datagram, such as large EDNS answers.
+const maxUDPPayload = 65535
+
+func exchangePacket(conn net.Conn, data []byte) ([]byte, error) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	respBuf := make([]byte, maxUDPPayload)
+	n, err := conn.Read(respBuf)
+	if err != nil {
+		return nil, err
 	}
-	c.mu.Unlock()
+	return respBuf[:n], nil
 }
@@ -0,0 +1,292 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxManifestSize = 1 << 20
+	maxBinarySize   = 256 << 20
+)
+
+// ErrUpToDate is returned by SelfUpdate when the running version is the
+// latest release or newer.
+var ErrUpToDate = errors.New("already up to date")
+
+// UpdateNotice is the server's announcement of the latest SDK release in
+// tunnel_opened.
+type UpdateNotice struct {
+	Current string `json:"-"` // the version this client reported
+	Latest  string `json:"latestVersion"`
+	// ManifestURL is the release manifest SelfUpdate reads, if the server
+	// publishes one.
+	ManifestURL string `json:"manifestUrl,omitempty"`
+	NotesURL    string `json:"notesUrl,omitempty"`
+}
+
+// WithOnUpdateAvailable calls fn when the server reports a release newer
+// than the version in the client info, once per release.
+func WithOnUpdateAvailable(fn func(u UpdateNotice)) Option {
+	return func(c *Client) {
+		c.config.OnUpdateAvailable = fn
+	}
+}
+
+// UpdateAvailable reports the newer release the server announced, if any.
+func (c *Client) UpdateAvailable() (UpdateNotice, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.update, c.update.Latest != ""
+}
+
+func (c *Client) checkUpdate(notice *UpdateNotice) {
+	if notice == nil {
+		return
+	}
+	u := *notice
+	u.Current = c.clientInfo().Version
+	if CompareVersions(u.Latest, u.Current) <= 0 {
+		return
+	}
+	c.mu.Lock()
+	seen := c.update.Latest == u.Latest
+	c.update = u
+	c.mu.Unlock()
+	if seen {
+		return
+	}
+	c.logf("%s %s is available (running %s)", SDKName, u.Latest, u.Current)
+	if c.conf().OnUpdateAvailable != nil {
+		c.safeCallback(func() { c.conf().OnUpdateAvailable(u) })
+	}
+}
+
+// CompareVersions compares two semantic versions such as "1.4.0" or
+// "v1.5.0-rc.1", returning -1, 0 or +1. A pre-release sorts before its
+// release; versions that don't parse compare equal to everything, so they
+// never look like updates.
+func CompareVersions(a, b string) int {
+	va, oka := parseVersion(a)
+	vb, okb := parseVersion(b)
+	if !oka || !okb {
+		return 0
+	}
+	for i := range 3 {
+		if va.nums[i] != vb.nums[i] {
+			if va.nums[i] < vb.nums[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return 0
+	case va.pre == "":
+		return 1
+	case vb.pre == "":
+		return -1
+	}
+	return strings.Compare(va.pre, vb.pre)
+}
+
+type semver struct {
+	nums [3]int
+	pre  string
+}
+
+func parseVersion(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.nums[i] = n
+	}
+	return v, true
+}
+
+// Release is a release manifest: the binaries published for one version.
+type Release struct {
+	Version string         `json:"version"`
+	Assets  []ReleaseAsset `json:"assets"`
+}
+
+type ReleaseAsset struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"` // hex
+}
+
+// Asset returns the binary for this platform.
+func (r *Release) Asset() (ReleaseAsset, error) {
+	for _, a := range r.Assets {
+		if a.OS == runtime.GOOS && a.Arch == runtime.GOARCH {
+			return a, nil
+		}
+	}
+	return ReleaseAsset{}, fmt.Errorf("release %s has no build for %s/%s", r.Version, runtime.GOOS, runtime.GOARCH)
+}
+
+// UpdateOptions configures SelfUpdate.
+type UpdateOptions struct {
+	// ManifestURL serves the Release as JSON. The base64 Ed25519
+	// signature of those exact bytes is served at ManifestURL + ".sig".
+	ManifestURL string
+	PublicKey   ed25519.PublicKey
+	Current     string       // Version if empty
+	Executable  string       // the running executable if empty
+	HTTPClient  *http.Client // http.DefaultClient if nil
+}
+
+// LatestRelease fetches the release manifest and checks its signature.
+func LatestRelease(ctx context.Context, opts UpdateOptions) (*Release, error) {
+	if len(opts.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("update: no release signing key")
+	}
+	client := httpClientOr(opts.HTTPClient)
+	manifest, err := fetchLimited(ctx, client, opts.ManifestURL, maxManifestSize)
+	if err != nil {
+		return nil, fmt.Errorf("update: manifest: %w", err)
+	}
+	sigData, err := fetchLimited(ctx, client, opts.ManifestURL+".sig", 1<<10)
+	if err != nil {
+		return nil, fmt.Errorf("update: signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil || !ed25519.Verify(opts.PublicKey, manifest, sig) {
+		return nil, errors.New("update: manifest signature is invalid")
+	}
+	var r Release
+	if err := json.Unmarshal(manifest, &r); err != nil {
+		return nil, fmt.Errorf("update: manifest: %w", err)
+	}
+	if _, ok := parseVersion(r.Version); !ok {
+		return nil, fmt.Errorf("update: manifest has invalid version %q", r.Version)
+	}
+	return &r, nil
+}
+
+// SelfUpdate replaces the executable with the latest release when it is
+// newer than the running version. The manifest must carry a valid
+// signature from PublicKey, and the binary must match the manifest's
+// SHA-256, before anything on disk changes. It returns the installed
+// release, or ErrUpToDate.
+func SelfUpdate(ctx context.Context, opts UpdateOptions) (*Release, error) {
+	current := opts.Current
+	if current == "" {
+		current = Version
+	}
+	exe := opts.Executable
+	if exe == "" {
+		var err error
+		if exe, err = os.Executable(); err != nil {
+			return nil, fmt.Errorf("update: %w", err)
+		}
+		if exe, err = filepath.EvalSymlinks(exe); err != nil {
+			return nil, fmt.Errorf("update: %w", err)
+		}
+	}
+
+	r, err := LatestRelease(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if CompareVersions(r.Version, current) <= 0 {
+		return r, ErrUpToDate
+	}
+	asset, err := r.Asset()
+	if err != nil {
+		return nil, fmt.Errorf("update: %w", err)
+	}
+	want, err := hex.DecodeString(asset.SHA256)
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("update: manifest has invalid checksum for %s/%s", asset.OS, asset.Arch)
+	}
+	binary, err := fetchLimited(ctx, httpClientOr(opts.HTTPClient), asset.URL, maxBinarySize)
+	if err != nil {
+		return nil, fmt.Errorf("update: download: %w", err)
+	}
+	if sum := sha256.Sum256(binary); !bytes.Equal(sum[:], want) {
+		return nil, errors.New("update: downloaded binary does not match the manifest checksum")
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		return nil, fmt.Errorf("update: %w", err)
+	}
+	return r, nil
+}
+
+// replaceExecutable swaps in the new binary with renames in the same
+// directory. The old one is moved aside first, as Windows can't replace a
+// running executable, and restored if the swap fails.
+func replaceExecutable(exe string, binary []byte) error {
+	mode := os.FileMode(0o755)
+	if fi, err := os.Stat(exe); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp := exe + ".new"
+	if err := os.WriteFile(tmp, binary, mode); err != nil {
+		return err
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Rename(old, exe)
+		os.Remove(tmp)
+		return err
+	}
+	// Fails on Windows while the old binary runs; it is removed next time.
+	os.Remove(old)
+	return nil
+}
+
+func fetchLimited(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("GET %s: response larger than %d bytes", url, limit)
+	}
+	return data, nil
+}
@@ -0,0 +1,156 @@
+package outray
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0.1.0", "0.1.0", 0},
+		{"0.2.0", "0.1.9", 1},
+		{"v1.0.0", "0.9.0", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.1", 1},
+		{"1.0.0+build", "1.0.0", 0},
+		{"nightly", "0.1.0", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateNotice(t *testing.T) {
+	var notices []UpdateNotice
+	c := NewClient(
+		WithClientInfo(ClientInfo{Version: "1.2.0"}),
+		WithOnUpdateAvailable(func(u UpdateNotice) { notices = append(notices, u) }),
+	)
+	c.closed = true
+
+	c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://a.outray.dev","update":{"latestVersion":"1.1.0"}}`), 0)
+	if _, ok := c.UpdateAvailable(); ok || len(notices) != 0 {
+		t.Errorf("Expected an older release to be ignored, got %v", notices)
+	}
+
+	for range 2 {
+		c.handleMessage([]byte(`{"type":"tunnel_opened","url":"https://a.outray.dev","update":{"latestVersion":"1.3.0","notesUrl":"https://example.com/1.3.0"}}`), 0)
+	}
+	u, ok := c.UpdateAvailable()
+	if !ok || u.Latest != "1.3.0" || u.Current != "1.2.0" || u.NotesURL == "" {
+		t.Errorf("Unexpected update %+v", u)
+	}
+	if len(notices) != 1 {
+		t.Errorf("Expected one notice per release, got %d", len(notices))
+	}
+}
+
+type releaseServer struct {
+	*httptest.Server
+	manifest []byte
+	sig      string
+	binary   []byte
+}
+
+func newReleaseServer(t *testing.T, key ed25519.PrivateKey, version string, binary []byte) *releaseServer {
+	rs := &releaseServer{binary: binary}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest.json":
+			w.Write(rs.manifest)
+		case "/latest.json.sig":
+			w.Write([]byte(rs.sig))
+		case "/outray":
+			w.Write(rs.binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(rs.Close)
+
+	sum := sha256.Sum256(binary)
+	rs.manifest, _ = json.Marshal(Release{Version: version, Assets: []ReleaseAsset{
+		{OS: runtime.GOOS, Arch: runtime.GOARCH, URL: rs.URL + "/outray", SHA256: hex.EncodeToString(sum[:])},
+	}})
+	rs.sig = base64.StdEncoding.EncodeToString(ed25519.Sign(key, rs.manifest))
+	return rs
+}
+
+func TestSelfUpdate(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	exe := filepath.Join(t.TempDir(), "outray")
+	os.WriteFile(exe, []byte("old"), 0o755)
+	rs := newReleaseServer(t, key, "0.2.0", []byte("new"))
+	opts := UpdateOptions{ManifestURL: rs.URL + "/latest.json", PublicKey: pub, Current: "0.1.0", Executable: exe}
+
+	r, err := SelfUpdate(t.Context(), opts)
+	if err != nil || r.Version != "0.2.0" {
+		t.Fatalf("SelfUpdate: %v, %v", r, err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "new" {
+		t.Errorf("Executable not replaced, got %q", data)
+	}
+	if fi, _ := os.Stat(exe); fi.Mode().Perm() != 0o755 {
+		t.Errorf("Expected the mode to be kept, got %v", fi.Mode())
+	}
+	if _, err := os.Stat(exe + ".old"); !os.IsNotExist(err) {
+		t.Errorf("Expected the old binary to be removed, got %v", err)
+	}
+
+	opts.Current = "0.2.0"
+	if _, err := SelfUpdate(t.Context(), opts); !errors.Is(err, ErrUpToDate) {
+		t.Errorf("Expected ErrUpToDate, got %v", err)
+	}
+}
+
+func TestSelfUpdateRejected(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name   string
+		tamper func(rs *releaseServer)
+	}{
+		{"wrong key", func(rs *releaseServer) {
+			rs.sig = base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, rs.manifest))
+		}},
+		{"modified manifest", func(rs *releaseServer) {
+			rs.manifest = append(rs.manifest, ' ')
+		}},
+		{"modified binary", func(rs *releaseServer) {
+			rs.binary = []byte("evil")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exe := filepath.Join(t.TempDir(), "outray")
+			os.WriteFile(exe, []byte("old"), 0o755)
+			rs := newReleaseServer(t, key, "0.2.0", []byte("new"))
+			tt.tamper(rs)
+
+			_, err := SelfUpdate(t.Context(), UpdateOptions{ManifestURL: rs.URL + "/latest.json", PublicKey: pub, Current: "0.1.0", Executable: exe})
+			if err == nil {
+				t.Fatal("Expected the update to be rejected")
+			}
+			if data, _ := os.ReadFile(exe); string(data) != "old" {
+				t.Errorf("Executable changed to %q", data)
+			}
+		})
+	}
+}
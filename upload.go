@@ -0,0 +1,229 @@
+package outray
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const MsgTypeRequestChunk = "request_chunk"
+
+// uploadQueueChunks is how many chunks of a streamed upload may wait for
+// the local service before the upload is aborted.
+const uploadQueueChunks = 256
+
+var errUploadBacklog = errors.New("upload aborted: local service is not reading the body fast enough")
+
+type RequestChunk struct {
+	Type  string `json:"type"`
+	ID    string `json:"requestId"`
+	Data  []byte `json:"data,omitempty"`
+	Final bool   `json:"final,omitempty"`
+}
+
+type UploadProgress struct {
+	RequestID string
+	Path      string
+	Bytes     int64
+	Total     int64
+	Done      bool
+}
+
+type upload struct {
+	req      IncomingRequest
+	pw       *io.PipeWriter
+	body     []byte
+	received int64
+	total    int64
+	seq      uint64 // chunks received
+
+	// Streamed uploads are fed to pw by their own goroutine, so a slow
+	// local service doesn't hold up the read loop.
+	chunks chan []byte
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func WithUploadProgress(fn func(p UploadProgress)) Option {
+	return func(c *Client) {
+		c.config.OnUploadProgress = fn
+	}
+}
+
+func (c *Client) startUpload(req IncomingRequest) {
+	u := &upload{req: req, total: contentLength(req.Headers)}
+
+	if c.conf().OnRequest == nil && c.conf().OnRequestAsync == nil {
+		if !c.serves("http") || !c.hasUpstream("http") {
+			err := errors.New("no local HTTP service")
+			c.respond(req, c.errorResponse(req, http.StatusBadGateway, "Proxy Error: "+err.Error(), err), "proxy send response error")
+			return
+		}
+		if !c.admit(&req) {
+			return
+		}
+		pr, pw := io.Pipe()
+		u.pw = pw
+		u.chunks = make(chan []byte, uploadQueueChunks)
+		u.stop = make(chan struct{})
+		req.stream = pr
+		c.spawn(func() { u.feed() })
+		c.spawn(func() {
+			ctx, done := c.trackRequest(req)
+			defer done()
+			// Uploads can take far longer than the pooled client's timeout.
+			if c.conf().StreamChunkSize > 0 {
+				c.streamHTTP(ctx, req)
+				pr.CloseWithError(io.ErrClosedPipe)
+				return
+			}
+			resp := c.proxyHTTPVia(ctx, c.untimedUpstream(), req)
+			pr.CloseWithError(io.ErrClosedPipe)
+			c.respond(req, resp, "proxy send response error")
+		})
+	}
+
+	c.uploadsMu.Lock()
+	c.uploads[req.ID] = u
+	c.uploadsMu.Unlock()
+}
+
+// feed writes queued chunks to the pipe until the final one (a nil
+// chunk) or until the upload is aborted.
+func (u *upload) feed() {
+	for {
+		select {
+		case data := <-u.chunks:
+			if data == nil {
+				u.pw.Close()
+				return
+			}
+			if _, err := u.pw.Write(data); err != nil {
+				return
+			}
+		case <-u.stop:
+			return
+		}
+	}
+}
+
+func (u *upload) abort(err error) {
+	u.once.Do(func() {
+		close(u.stop)
+		u.pw.CloseWithError(err)
+	})
+}
+
+func (c *Client) handleRequestChunk(chunk RequestChunk) {
+	c.uploadsMu.Lock()
+	u, ok := c.uploads[chunk.ID]
+	if ok && chunk.Final {
+		delete(c.uploads, chunk.ID)
+	}
+	c.uploadsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	seq := atomic.AddUint64(&u.seq, 1)
+	data, err := c.unseal(chunk.Data, e2eFrame{typ: MsgTypeRequestChunk, id: chunk.ID, seq: seq, final: chunk.Final})
+	if err != nil {
+		c.logf("Dropped upload chunk for %s: %v", chunk.ID, err)
+		c.dropUpload(u, err)
+		return
+	}
+
+	if u.pw != nil {
+		if len(data) > 0 && !u.enqueue(data) {
+			c.logf("Aborted upload %s: %v", chunk.ID, errUploadBacklog)
+			c.dropUpload(u, errUploadBacklog)
+			return
+		}
+	} else {
+		u.body = append(u.body, data...)
+	}
+	u.received += int64(len(data))
+	c.reportUpload(u, chunk.Final)
+
+	if !chunk.Final {
+		return
+	}
+	if u.pw != nil {
+		u.enqueue(nil)
+		return
+	}
+
+	req := u.req
+	req.Body = u.body
+	req.Streaming = false
+	c.dispatchRequest(req)
+}
+
+// enqueue queues data for the pipe without blocking, reporting false if
+// the queue is full. A nil chunk marks the end of the body.
+func (u *upload) enqueue(data []byte) bool {
+	select {
+	case u.chunks <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) reportUpload(u *upload, done bool) {
+	if c.conf().OnUploadProgress == nil {
+		return
+	}
+	p := UploadProgress{
+		RequestID: u.req.ID,
+		Path:      u.req.Path,
+		Bytes:     u.received,
+		Total:     u.total,
+		Done:      done,
+	}
+	c.safeCallback(func() { c.conf().OnUploadProgress(p) })
+}
+
+func (c *Client) abortUpload(id string, err error) {
+	c.uploadsMu.Lock()
+	u, ok := c.uploads[id]
+	c.uploadsMu.Unlock()
+	if ok {
+		c.dropUpload(u, err)
+	}
+}
+
+func (c *Client) dropUpload(u *upload, err error) {
+	c.uploadsMu.Lock()
+	if c.uploads[u.req.ID] == u {
+		delete(c.uploads, u.req.ID)
+	}
+	c.uploadsMu.Unlock()
+	if u.pw != nil {
+		u.abort(err)
+	}
+}
+
+func (c *Client) abortUploads(err error) {
+	c.uploadsMu.Lock()
+	uploads := c.uploads
+	c.uploads = make(map[string]*upload)
+	c.uploadsMu.Unlock()
+
+	for _, u := range uploads {
+		if u.pw != nil {
+			u.abort(err)
+		}
+	}
+}
+
+func contentLength(headers map[string]string) int64 {
+	n, err := strconv.ParseInt(headerValue(headers, "Content-Length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
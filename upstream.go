@@ -0,0 +1,236 @@
+package outray
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+type IPFamily int
+
+const (
+	DualStack IPFamily = iota
+	IPv4Only
+	IPv6Only
+)
+
+func WithIPFamily(f IPFamily) Option {
+	return func(c *Client) {
+		c.config.IPFamily = f
+	}
+}
+
+func WithUpstreamDialer(d *net.Dialer) Option {
+	return func(c *Client) {
+		c.config.UpstreamDialer = d
+	}
+}
+
+func (c *Client) upstreamDialer() *net.Dialer {
+	if c.conf().UpstreamDialer != nil {
+		return c.conf().UpstreamDialer
+	}
+	return &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: 300 * time.Millisecond,
+	}
+}
+
+func (c *Client) upstreamNetwork(network string) string {
+	switch c.conf().IPFamily {
+	case IPv4Only:
+		return network + "4"
+	case IPv6Only:
+		return network + "6"
+	}
+	return network
+}
+
+func (c *Client) dialUpstreamContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.customResolution() {
+		return c.dialResolved(ctx, network, addr)
+	}
+	return c.upstreamDialer().DialContext(ctx, c.upstreamNetwork(network), addr)
+}
+
+// udpTargets expands hostnames into one address per IP so that a dual-stack
+// "localhost" reaches services bound only to ::1 as well as 127.0.0.1.
+func (c *Client) udpTargets(addr string) []string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return []string{addr}
+	}
+	ips, err := c.resolveHost(context.Background(), host)
+	if err != nil {
+		return []string{addr}
+	}
+
+	var targets []string
+	for _, ip := range c.filterFamily(ips) {
+		targets = append(targets, net.JoinHostPort(ip.String(), port))
+	}
+	if len(targets) == 0 {
+		return []string{addr}
+	}
+	return targets
+}
+
+// Upstream is where tunneled traffic is delivered. The default implementation
+// dials the configured local port (or fallback addresses); WithUpstream swaps
+// in anything else, such as a Unix socket, a container, or a VirtualBackend.
+type Upstream interface {
+	RoundTrip(req *http.Request) (*http.Response, error)
+	DialTCP(ctx context.Context) (net.Conn, error)
+	DialUDP(ctx context.Context) (net.Conn, error)
+}
+
+func WithUpstream(u Upstream) Option {
+	return func(c *Client) {
+		c.config.Upstream = u
+	}
+}
+
+func (c *Client) upstream(protocol string) Upstream {
+	if spec, ok := c.tunnel(protocol); ok {
+		if spec.Upstream != nil {
+			return spec.Upstream
+		}
+		return &localUpstream{c: c, client: c.upstreamClient(), port: spec.Port}
+	}
+	if c.conf().Upstream != nil {
+		return c.conf().Upstream
+	}
+	return &localUpstream{c: c, client: c.upstreamClient()}
+}
+
+func (c *Client) hasUpstream(protocol string) bool {
+	if spec, ok := c.tunnel(protocol); ok {
+		return spec.Upstream != nil || spec.Port > 0
+	}
+	return c.conf().Upstream != nil || c.conf().Port > 0 || len(c.conf().UpstreamFallback) > 0
+}
+
+func (c *Client) upstreamAddrs() []string {
+	if len(c.conf().UpstreamFallback) > 0 {
+		return c.conf().UpstreamFallback
+	}
+	return []string{c.loopbackAddr(c.conf().Port)}
+}
+
+func (c *Client) loopbackAddr(port int) string {
+	switch c.conf().IPFamily {
+	case IPv4Only:
+		return fmt.Sprintf("127.0.0.1:%d", port)
+	case IPv6Only:
+		return fmt.Sprintf("[::1]:%d", port)
+	}
+	return fmt.Sprintf("localhost:%d", port)
+}
+
+func (c *Client) dialUpstream(network string) (net.Conn, error) {
+	u := c.upstream(network)
+	dial := u.DialTCP
+	if network == "udp" {
+		dial = u.DialUDP
+	}
+	return dial(context.Background())
+}
+
+// localUpstream is the default Upstream: the local port or fallback
+// addresses, tried in order until one accepts the connection.
+type localUpstream struct {
+	c      *Client
+	client *http.Client
+	port   int
+}
+
+func (u *localUpstream) addrs() []string {
+	if u.port > 0 {
+		return []string{u.c.loopbackAddr(u.port)}
+	}
+	return u.c.upstreamAddrs()
+}
+
+func (u *localUpstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	var lastErr error
+	for i, addr := range u.addrs() {
+		attempt := req.Clone(req.Context())
+		attempt.URL.Host = addr
+		attempt.Host = ""
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+
+		resp, err := u.client.Do(attempt)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if !isDialError(err) || (req.Body != nil && req.GetBody == nil) {
+			break
+		}
+		u.c.logf("Upstream %s unavailable: %v", addr, err)
+	}
+	return nil, lastErr
+}
+
+func (u *localUpstream) DialTCP(ctx context.Context) (net.Conn, error) {
+	return u.dial(ctx, "tcp")
+}
+
+func (u *localUpstream) DialUDP(ctx context.Context) (net.Conn, error) {
+	return u.dial(ctx, "udp")
+}
+
+func (u *localUpstream) dial(ctx context.Context, network string) (net.Conn, error) {
+	var lastErr error
+	for _, addr := range u.addrs() {
+		conn, err := u.c.dialUpstreamContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = fmt.Errorf("failed to dial local %s %s: %w", network, addr, err)
+	}
+	return nil, lastErr
+}
+
+// exchangeUDP sends one packet and waits for the reply. Unlike DialUDP it
+// tries every resolved target, since a UDP "dial" succeeds even when nothing
+// is listening and the failure only shows up on read.
+func (u *localUpstream) exchangeUDP(ctx context.Context, data []byte) ([]byte, error) {
+	var targets []string
+	for _, addr := range u.addrs() {
+		targets = append(targets, u.c.udpTargets(addr)...)
+	}
+
+	var lastErr error
+	for _, addr := range targets {
+		conn, err := u.c.dialUpstreamContext(ctx, "udp", addr)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to dial local udp %s: %w", addr, err)
+			continue
+		}
+		resp, err := exchangePacket(conn, data)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
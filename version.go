@@ -0,0 +1,73 @@
+package outray
+
+import "runtime"
+
+const (
+	SDKName = "outray-go"
+	Version = "0.1.0"
+)
+
+const MsgTypeDeprecation = "deprecation"
+
+type ClientInfo struct {
+	SDK       string `json:"sdk,omitempty"`
+	Version   string `json:"version,omitempty"`
+	OS        string `json:"os,omitempty"`
+	Arch      string `json:"arch,omitempty"`
+	GoVersion string `json:"goVersion,omitempty"`
+}
+
+type DeprecationNotice struct {
+	Type           string `json:"type"`
+	Message        string `json:"message"`
+	MinVersion     string `json:"minVersion,omitempty"`
+	LatestVersion  string `json:"latestVersion,omitempty"`
+	SunsetAt       string `json:"sunsetAt,omitempty"`
+	UpgradeInfoURL string `json:"url,omitempty"`
+}
+
+func WithClientInfo(info ClientInfo) Option {
+	return func(c *Client) {
+		c.config.ClientInfo = info
+	}
+}
+
+func WithOnDeprecation(fn func(notice DeprecationNotice)) Option {
+	return func(c *Client) {
+		c.config.OnDeprecation = fn
+	}
+}
+
+func (c *Client) clientInfo() *ClientInfo {
+	info := ClientInfo{
+		SDK:       SDKName,
+		Version:   Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		GoVersion: runtime.Version(),
+	}
+	o := c.conf().ClientInfo
+	if o.SDK != "" {
+		info.SDK = o.SDK
+	}
+	if o.Version != "" {
+		info.Version = o.Version
+	}
+	if o.OS != "" {
+		info.OS = o.OS
+	}
+	if o.Arch != "" {
+		info.Arch = o.Arch
+	}
+	if o.GoVersion != "" {
+		info.GoVersion = o.GoVersion
+	}
+	return &info
+}
+
+func (c *Client) handleDeprecation(notice DeprecationNotice) {
+	c.logf("Server deprecation notice: %s", notice.Message)
+	if c.conf().OnDeprecation != nil {
+		c.safeCallback(func() { c.conf().OnDeprecation(notice) })
+	}
+}
@@ -0,0 +1,41 @@
+package outray
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestClientInfo(t *testing.T) {
+	info := NewClient(WithAPIKey("key")).openTunnelRequest(MsgTypeOpenTunnel).Client
+	want := ClientInfo{SDK: SDKName, Version: Version, OS: runtime.GOOS, Arch: runtime.GOARCH, GoVersion: runtime.Version()}
+	if info == nil || *info != want {
+		t.Errorf("Expected handshake client info %+v, got %+v", want, info)
+	}
+
+	info = NewClient(WithClientInfo(ClientInfo{SDK: "outray-cli", Version: "2.0.0"})).openTunnelRequest(MsgTypeOpenTunnel).Client
+	want.SDK, want.Version = "outray-cli", "2.0.0"
+	if *info != want {
+		t.Errorf("Expected overrides merged over the defaults, got %+v", info)
+	}
+}
+
+func TestDeprecationNotice(t *testing.T) {
+	notices := make(chan DeprecationNotice, 1)
+	c := NewClient(WithOnDeprecation(func(n DeprecationNotice) { notices <- n }))
+	c.closed = true
+
+	c.handleMessage([]byte(`{"type":"deprecation","message":"upgrade soon","minVersion":"0.2.0","latestVersion":"0.3.0","sunsetAt":"2027-01-01","url":"https://outray.dev/upgrade"}`), 0)
+	select {
+	case n := <-notices:
+		if n.Message != "upgrade soon" || n.MinVersion != "0.2.0" || n.LatestVersion != "0.3.0" || n.SunsetAt != "2027-01-01" || n.UpgradeInfoURL != "https://outray.dev/upgrade" {
+			t.Errorf("Unexpected notice %+v", n)
+		}
+	default:
+		t.Fatal("Expected OnDeprecation to be called")
+	}
+
+	// A panicking callback doesn't take the read loop down.
+	c = NewClient(WithOnDeprecation(func(DeprecationNotice) { panic("boom") }))
+	c.closed = true
+	c.handleMessage([]byte(`{"type":"deprecation","message":"upgrade soon"}`), 0)
+}
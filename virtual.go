@@ -0,0 +1,152 @@
+package outray
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errNoVirtualHandler = errors.New("virtual backend: no handler for protocol")
+
+type VirtualBackend struct {
+	HTTP http.Handler
+	TCP  func(conn net.Conn)
+	UDP  func(packet []byte) []byte
+}
+
+func (v *VirtualBackend) RoundTrip(req *http.Request) (*http.Response, error) {
+	if v.HTTP == nil {
+		return nil, errNoVirtualHandler
+	}
+
+	rec := &virtualRecorder{header: make(http.Header), status: http.StatusOK}
+	v.HTTP.ServeHTTP(rec, req)
+
+	return &http.Response{
+		Status:        strconv.Itoa(rec.status) + " " + http.StatusText(rec.status),
+		StatusCode:    rec.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.header,
+		Body:          io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+func (v *VirtualBackend) DialTCP(ctx context.Context) (net.Conn, error) {
+	if v.TCP == nil {
+		return nil, errNoVirtualHandler
+	}
+	client, server := net.Pipe()
+	go v.TCP(server)
+	return client, nil
+}
+
+func (v *VirtualBackend) DialUDP(ctx context.Context) (net.Conn, error) {
+	if v.UDP == nil {
+		return nil, errNoVirtualHandler
+	}
+	return &virtualPacketConn{handler: v.UDP, responses: make(chan []byte, 1), closed: make(chan struct{})}, nil
+}
+
+type virtualRecorder struct {
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *virtualRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *virtualRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.status = statusCode
+		r.wroteHeader = true
+	}
+}
+
+func (r *virtualRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+type virtualPacketConn struct {
+	handler   func(packet []byte) []byte
+	responses chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (p *virtualPacketConn) Write(b []byte) (int, error) {
+	if resp := p.handler(append([]byte(nil), b...)); resp != nil {
+		select {
+		case p.responses <- resp:
+		default:
+		}
+	}
+	return len(b), nil
+}
+
+func (p *virtualPacketConn) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	deadline := p.deadline
+	p.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case resp := <-p.responses:
+		return copy(b, resp), nil
+	case <-timeout:
+		return 0, virtualTimeout{}
+	case <-p.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (p *virtualPacketConn) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *virtualPacketConn) LocalAddr() net.Addr  { return tunnelAddr("virtual") }
+func (p *virtualPacketConn) RemoteAddr() net.Addr { return tunnelAddr("virtual") }
+
+func (p *virtualPacketConn) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *virtualPacketConn) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.deadline = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *virtualPacketConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type virtualTimeout struct{}
+
+func (virtualTimeout) Error() string   { return "virtual backend: i/o timeout" }
+func (virtualTimeout) Timeout() bool   { return true }
+func (virtualTimeout) Temporary() bool { return true }
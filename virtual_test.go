@@ -0,0 +1,48 @@
+package outray
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestVirtualBackend(t *testing.T) {
+	backend := &VirtualBackend{
+		HTTP: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Path", r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+			io.Copy(w, r.Body)
+		}),
+		TCP: func(conn net.Conn) {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		},
+		UDP: func(packet []byte) []byte {
+			return append([]byte("pong:"), packet...)
+		},
+	}
+	c := NewClient(WithUpstream(backend))
+
+	resp := c.proxyHTTP(context.Background(), IncomingRequest{Method: "POST", Path: "/echo", Body: []byte("hi")})
+	if resp.StatusCode != http.StatusCreated || string(resp.Body) != "hi" || resp.Headers["X-Path"] != "/echo" {
+		t.Errorf("Unexpected virtual HTTP response: %d %q %v", resp.StatusCode, resp.Body, resp.Headers)
+	}
+
+	conn, err := c.dialUpstream("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected TCP echo, got %q (%v)", buf, err)
+	}
+	conn.Close()
+
+	got, err := c.exchangeUDP([]byte("x"))
+	if err != nil || string(got) != "pong:x" {
+		t.Errorf("Expected UDP reply, got %q (%v)", got, err)
+	}
+}
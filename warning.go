@@ -0,0 +1,32 @@
+package outray
+
+const MsgTypeWarning = "warning"
+
+const (
+	WarnQuotaNearing = "QUOTA_NEARING"
+	WarnMaintenance  = "MAINTENANCE"
+	WarnDeprecation  = "DEPRECATION"
+)
+
+// Warning is an advisory notice, either from the server or raised locally
+// (clock skew, slow requests). Unlike errors it never affects the tunnel.
+type Warning struct {
+	Type    string                 `json:"type"`
+	Code    string                 `json:"code,omitempty"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func WithOnWarning(fn func(w Warning)) Option {
+	return func(c *Client) {
+		c.config.OnWarning = fn
+	}
+}
+
+func (c *Client) handleWarning(w Warning) {
+	c.logf("Warning: %s", w.Message)
+	c.notify(Event{Type: EventWarning, Warning: &w})
+	if c.conf().OnWarning != nil {
+		c.safeCallback(func() { c.conf().OnWarning(w) })
+	}
+}
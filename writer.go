@@ -0,0 +1,255 @@
+package outray
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type Priority int
+
+const (
+	PriorityControl Priority = iota
+	PriorityHTTP
+	PriorityUDP
+	PriorityTCP
+	numPriorities
+)
+
+const writerQuantum = 16 * 1024
+
+// controlWriteWait bounds pong and close replies, as gorilla's default
+// handlers do.
+const controlWriteWait = time.Second
+
+// outboxSize is how many control frames may wait for the writer.
+const outboxSize = 8
+
+var errWriteTimeout = errors.New("write timed out")
+
+var defaultPriorityWeights = [numPriorities]int{
+	PriorityControl: 16,
+	PriorityHTTP:    8,
+	PriorityUDP:     4,
+	PriorityTCP:     1,
+}
+
+func WithStreamPriorities(weights map[Priority]int) Option {
+	return func(c *Client) {
+		if weights == nil {
+			weights = make(map[Priority]int)
+		}
+		c.config.PriorityWeights = weights
+	}
+}
+
+func priorityWeights(weights map[Priority]int) [numPriorities]int {
+	w := defaultPriorityWeights
+	for p, weight := range weights {
+		if p >= 0 && p < numPriorities && weight > 0 {
+			w[p] = weight
+		}
+	}
+	return w
+}
+
+type outFrame struct {
+	msgType  int
+	data     []byte
+	deadline time.Time
+	errc     chan error
+}
+
+// frameWriter is the only goroutine that writes to its connection. Data
+// frames wait in per-priority queues served by deficit round robin; control
+// frames (ping, pong, close) go through the outbox channel and are written
+// ahead of any queued data.
+type frameWriter struct {
+	conn    *websocket.Conn
+	weights [numPriorities]int
+	fifo    bool
+
+	// sign, if set, signs each data frame with its position on conn as it
+	// is written. ctr is only touched by run.
+	sign func(data []byte, ctr uint64) []byte
+	ctr  uint64
+
+	outbox chan outFrame
+	wake   chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	queues  [numPriorities][]outFrame
+	deficit [numPriorities]int
+	cur     Priority
+	pending int
+	closed  bool
+}
+
+// newFrameWriter returns a writer for conn; the caller starts run. With nil
+// weights frames are written in the order they are queued.
+func newFrameWriter(conn *websocket.Conn, weights map[Priority]int) *frameWriter {
+	return &frameWriter{
+		conn:    conn,
+		weights: priorityWeights(weights),
+		fifo:    weights == nil,
+		outbox:  make(chan outFrame, outboxSize),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *frameWriter) writeMessage(prio Priority, data []byte) error {
+	if prio < 0 || prio >= numPriorities || w.fifo {
+		prio = PriorityControl
+	}
+
+	errc := make(chan error, 1)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errClientClosed
+	}
+	w.queues[prio] = append(w.queues[prio], outFrame{msgType: websocket.TextMessage, data: data, errc: errc})
+	w.pending++
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return <-errc
+}
+
+// writeControl writes a control frame ahead of queued data, giving up at
+// deadline.
+func (w *frameWriter) writeControl(msgType int, data []byte, deadline time.Time) error {
+	errc := make(chan error, 1)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case w.outbox <- outFrame{msgType: msgType, data: data, deadline: deadline, errc: errc}:
+	case <-w.done:
+		return errClientClosed
+	case <-timer.C:
+		return errWriteTimeout
+	}
+	select {
+	case err := <-errc:
+		return err
+	case <-w.done:
+		return errClientClosed
+	case <-timer.C:
+		return errWriteTimeout
+	}
+}
+
+// postControl hands a control frame to the writer without waiting, for
+// replies sent from the read loop. It is dropped if the outbox is full.
+func (w *frameWriter) postControl(msgType int, data []byte) {
+	f := outFrame{msgType: msgType, data: data, deadline: time.Now().Add(controlWriteWait), errc: make(chan error, 1)}
+	select {
+	case w.outbox <- f:
+	default:
+	}
+}
+
+func (w *frameWriter) run() {
+	for {
+		select {
+		case f := <-w.outbox:
+			w.write(f)
+			continue
+		default:
+		}
+
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+		if w.pending > 0 {
+			f := w.next()
+			w.mu.Unlock()
+			w.write(f)
+			continue
+		}
+		w.mu.Unlock()
+
+		select {
+		case f := <-w.outbox:
+			w.write(f)
+		case <-w.wake:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *frameWriter) write(f outFrame) {
+	if f.msgType == websocket.TextMessage {
+		data := f.data
+		if w.sign != nil {
+			w.ctr++
+			data = w.sign(data, w.ctr)
+		}
+		f.errc <- w.conn.WriteMessage(websocket.TextMessage, data)
+		return
+	}
+	f.errc <- w.conn.WriteControl(f.msgType, f.data, f.deadline)
+}
+
+func (w *frameWriter) next() outFrame {
+	for {
+		q := w.queues[w.cur]
+		if len(q) == 0 {
+			w.deficit[w.cur] = 0
+		} else if w.deficit[w.cur] >= len(q[0].data) {
+			f := q[0]
+			w.queues[w.cur] = q[1:]
+			w.deficit[w.cur] -= len(f.data)
+			if len(w.queues[w.cur]) == 0 {
+				w.deficit[w.cur] = 0
+			}
+			w.pending--
+			return f
+		}
+
+		w.cur = (w.cur + 1) % numPriorities
+		if len(w.queues[w.cur]) > 0 {
+			w.deficit[w.cur] += w.weights[w.cur] * writerQuantum
+		}
+	}
+}
+
+func (w *frameWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	for p := range w.queues {
+		for _, f := range w.queues[p] {
+			f.errc <- errClientClosed
+		}
+		w.queues[p] = nil
+	}
+	w.pending = 0
+	close(w.done)
+}
+
+// routeControlFrames sends the replies to the server's pings and close
+// frame through w instead of writing them from the read loop.
+func routeControlFrames(conn *websocket.Conn, w *frameWriter) {
+	conn.SetPingHandler(func(data string) error {
+		w.postControl(websocket.PongMessage, []byte(data))
+		return nil
+	})
+	conn.SetCloseHandler(func(code int, text string) error {
+		w.postControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""))
+		return nil
+	})
+}
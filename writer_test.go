@@ -0,0 +1,67 @@
+package outray
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFrameWriterFairQueue(t *testing.T) {
+	w := &frameWriter{weights: priorityWeights(nil)}
+	for i := 0; i < 64; i++ {
+		w.queues[PriorityTCP] = append(w.queues[PriorityTCP], outFrame{data: make([]byte, writerQuantum)})
+		w.pending++
+	}
+	w.queues[PriorityHTTP] = append(w.queues[PriorityHTTP], outFrame{data: []byte("http")})
+	w.pending++
+
+	for i := 0; i < 3; i++ {
+		if f := w.next(); string(f.data) == "http" {
+			return
+		}
+	}
+	t.Error("Expected HTTP frame to be scheduled ahead of queued TCP data")
+}
+
+func TestFrameWriterFIFOWithoutWeights(t *testing.T) {
+	w := newFrameWriter(nil, nil)
+	errs := make(chan error, 2)
+	for i, f := range []struct {
+		prio Priority
+		data string
+	}{{PriorityTCP, "tcp"}, {PriorityHTTP, "http"}} {
+		go func() { errs <- w.writeMessage(f.prio, []byte(f.data)) }()
+		for {
+			w.mu.Lock()
+			n := w.pending
+			w.mu.Unlock()
+			if n == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	w.mu.Lock()
+	first, second := w.next(), w.next()
+	w.mu.Unlock()
+	if string(first.data) != "tcp" || string(second.data) != "http" {
+		t.Errorf("Expected frames in queue order, got %q then %q", first.data, second.data)
+	}
+	first.errc <- nil
+	second.errc <- nil
+	<-errs
+	<-errs
+}
+
+func TestFrameWriterControlAfterClose(t *testing.T) {
+	w := newFrameWriter(nil, nil)
+	w.close()
+	if err := w.writeControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != errClientClosed {
+		t.Errorf("Expected errClientClosed, got %v", err)
+	}
+	if err := w.writeMessage(PriorityHTTP, []byte("x")); err != errClientClosed {
+		t.Errorf("Expected errClientClosed, got %v", err)
+	}
+}